@@ -0,0 +1,123 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/kumina/openvpn_exporter/config"
+	"github.com/kumina/openvpn_exporter/exporters"
+	"github.com/kumina/openvpn_exporter/pkg/status"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// A status source serving a status file from memory.
+type memorySource struct {
+	name string
+	data []byte
+}
+
+func (s *memorySource) Open(ctx context.Context) (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewReader(s.data)), nil
+}
+
+func (s *memorySource) Name() string {
+	return s.name
+}
+
+func (s *memorySource) Labels() map[string]string {
+	return nil
+}
+
+// Implements the "bench" subcommand, which measures the time and memory
+// needed to parse and collect a status file, either an existing one or
+// one generated like the "simulate" subcommand does.
+func runBenchCommand(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	file := fs.String("file", "", "Status file to benchmark. If unset, a status file is simulated.")
+	format := fs.String("format", config.FormatServerV2, "Format of the simulated status file. One of: client, server_v1, server_v2, server_v3.")
+	clients := fs.Int("clients", 1000, "Number of clients of the simulated status file.")
+	routes := fs.Int("routes", 0, "Number of routing table entries of the simulated status file. Defaults to one per client.")
+	fs.Parse(args)
+
+	var data []byte
+	if *file != "" {
+		var err error
+		if data, err = os.ReadFile(*file); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	} else {
+		if *routes == 0 {
+			*routes = *clients
+		}
+		s := &simulation{
+			rng:            rand.New(rand.NewSource(1)),
+			format:         *format,
+			routes:         *routes,
+			clientCounters: map[string]uint64{},
+		}
+		now := time.Now()
+		for i := 0; i < *clients; i++ {
+			s.connect(now.Add(-time.Duration(s.rng.Int63n(int64(24 * time.Hour)))))
+		}
+		data = s.render(now)
+	}
+
+	exporter, err := exporters.NewOpenVPNExporter(exporters.WithSources(&memorySource{name: "bench", data: data}))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	benchmarks := []struct {
+		name string
+		run  func(b *testing.B)
+	}{
+		{"parse", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := status.Parse(bytes.NewReader(data)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}},
+		{"collect", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				ch := make(chan prometheus.Metric)
+				go func() {
+					exporter.Collect(ch)
+					close(ch)
+				}()
+				for range ch {
+				}
+			}
+		}},
+	}
+	fmt.Printf("status file: %d bytes\n", len(data))
+	for _, benchmark := range benchmarks {
+		run := benchmark.run
+		result := testing.Benchmark(func(b *testing.B) {
+			b.ReportAllocs()
+			run(b)
+		})
+		fmt.Printf("%-8s %s %s\n", benchmark.name, result.String(), result.MemString())
+	}
+}
@@ -0,0 +1,427 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/kumina/openvpn_exporter/config"
+	"github.com/kumina/openvpn_exporter/exporters"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	accountingUpDesc = prometheus.NewDesc(
+		"openvpn_accounting_up",
+		"Whether the counters of a firewall chain or set could be read.",
+		[]string{"accounting"}, nil)
+	accountingLabels            = []string{"accounting", "status_path", "common_name", "virtual_address"}
+	accountingReceivedBytesDesc = prometheus.NewDesc(
+		"openvpn_server_client_accounting_received_bytes_total",
+		"Amount of data received from a client, in bytes, as counted by the firewall.",
+		accountingLabels, nil)
+	accountingReceivedPacketsDesc = prometheus.NewDesc(
+		"openvpn_server_client_accounting_received_packets_total",
+		"Number of packets received from a client, as counted by the firewall.",
+		accountingLabels, nil)
+	accountingSentBytesDesc = prometheus.NewDesc(
+		"openvpn_server_client_accounting_sent_bytes_total",
+		"Amount of data sent to a client, in bytes, as counted by the firewall.",
+		accountingLabels, nil)
+	accountingSentPacketsDesc = prometheus.NewDesc(
+		"openvpn_server_client_accounting_sent_packets_total",
+		"Number of packets sent to a client, as counted by the firewall.",
+		accountingLabels, nil)
+)
+
+// AccountingCollector exports the traffic of each connected client as
+// counted by the rules of a firewall chain or the elements of an nftables
+// set, keyed by virtual address. Unlike the byte counters of OpenVPN,
+// these count the packets after decompression and NAT.
+//
+// Rules of a chain count the traffic received from the client when they
+// match its address as the source, and the traffic sent to the client when
+// they match it as the destination. Rules matching neither may name the
+// direction and address in their comment instead, e.g. "sent 10.8.0.6".
+// Counters of rules with the same direction and address are added up.
+//
+// Counters are read using iptables-save, ip6tables-save or nft, which
+// require CAP_NET_ADMIN.
+type AccountingCollector struct {
+	accounting []config.Accounting
+	clients    *exporters.ClientTracker
+	logger     *slog.Logger
+	timeout    time.Duration
+}
+
+// NewAccountingCollector creates a collector for the counters of the
+// clients of a tracker, giving up on reading them after the timeout, if
+// non-zero.
+func NewAccountingCollector(accounting []config.Accounting, clients *exporters.ClientTracker, logger *slog.Logger, timeout time.Duration) *AccountingCollector {
+	return &AccountingCollector{accounting: accounting, clients: clients, logger: logger, timeout: timeout}
+}
+
+// Counters of the traffic of an address in one direction.
+type accountingCounter struct {
+	Packets float64
+	Bytes   float64
+}
+
+// Counters of the traffic of addresses, by direction and address.
+type accountingCounters map[string]map[string]*accountingCounter
+
+func (c accountingCounters) add(direction, address string, packets, bytes float64) {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return
+	}
+	if c[direction] == nil {
+		c[direction] = map[string]*accountingCounter{}
+	}
+	counter := c[direction][ip.String()]
+	if counter == nil {
+		counter = &accountingCounter{}
+		c[direction][ip.String()] = counter
+	}
+	counter.Packets += packets
+	counter.Bytes += bytes
+}
+
+func (c *AccountingCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- accountingUpDesc
+	ch <- accountingReceivedBytesDesc
+	ch <- accountingReceivedPacketsDesc
+	ch <- accountingSentBytesDesc
+	ch <- accountingSentPacketsDesc
+}
+
+func (c *AccountingCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx := context.Background()
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	clients := c.clients.Clients()
+	for _, accounting := range c.accounting {
+		name := accounting.Name
+		if name == "" {
+			name = accounting.DefaultName()
+		}
+		counters, err := readAccounting(ctx, &accounting)
+		if err != nil {
+			c.logger.Error("Failed to read accounting counters", "accounting", name, "err", err)
+			ch <- prometheus.MustNewConstMetric(accountingUpDesc, prometheus.GaugeValue, 0, name)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(accountingUpDesc, prometheus.GaugeValue, 1, name)
+		for _, client := range clients {
+			ip := net.ParseIP(client.VirtualAddress)
+			if ip == nil {
+				continue
+			}
+			labels := []string{name, client.StatusPath, client.CommonName, client.VirtualAddress}
+			if counter, ok := counters[config.DirectionReceived][ip.String()]; ok {
+				ch <- prometheus.MustNewConstMetric(accountingReceivedBytesDesc, prometheus.CounterValue, counter.Bytes, labels...)
+				ch <- prometheus.MustNewConstMetric(accountingReceivedPacketsDesc, prometheus.CounterValue, counter.Packets, labels...)
+			}
+			if counter, ok := counters[config.DirectionSent][ip.String()]; ok {
+				ch <- prometheus.MustNewConstMetric(accountingSentBytesDesc, prometheus.CounterValue, counter.Bytes, labels...)
+				ch <- prometheus.MustNewConstMetric(accountingSentPacketsDesc, prometheus.CounterValue, counter.Packets, labels...)
+			}
+		}
+	}
+}
+
+// Reads the counters of a firewall chain or set.
+func readAccounting(ctx context.Context, accounting *config.Accounting) (accountingCounters, error) {
+	table := accounting.Table
+	if table == "" {
+		table = "filter"
+	}
+	if accounting.Firewall != config.FirewallNFTables {
+		out, err := runCommand(ctx, accounting.Firewall+"-save", "-c", "-t", table)
+		if err != nil {
+			return nil, err
+		}
+		return parseIPTablesSave(out, accounting.Chain)
+	}
+	family := accounting.Family
+	if family == "" {
+		family = "inet"
+	}
+	if accounting.Set != "" {
+		out, err := runCommand(ctx, "nft", "-j", "list", "set", family, table, accounting.Set)
+		if err != nil {
+			return nil, err
+		}
+		return parseNFTablesSet(out, accounting.Direction)
+	}
+	out, err := runCommand(ctx, "nft", "-j", "list", "chain", family, table, accounting.Chain)
+	if err != nil {
+		return nil, err
+	}
+	return parseNFTablesChain(out)
+}
+
+// Returns the direction and address named by the comment of a rule, of
+// the form "<direction> <address>".
+func parseAccountingComment(comment string) (string, string, bool) {
+	direction, address, ok := strings.Cut(strings.TrimSpace(comment), " ")
+	if !ok || (direction != config.DirectionReceived && direction != config.DirectionSent) {
+		return "", "", false
+	}
+	address = strings.TrimSpace(address)
+	return direction, address, net.ParseIP(address) != nil
+}
+
+// Returns the address of a host given by iptables, which adds the prefix
+// length of a single address.
+func hostAddress(address string) (string, bool) {
+	if ip := net.ParseIP(address); ip != nil {
+		return address, true
+	}
+	ip, network, err := net.ParseCIDR(address)
+	if err != nil {
+		return "", false
+	}
+	if ones, bits := network.Mask.Size(); ones != bits {
+		return "", false
+	}
+	return ip.String(), true
+}
+
+// Parses the counters of the rules of a chain from the output of
+// "iptables-save -c", of which the rules look like:
+//
+//	[packets:bytes] -A chain -s 10.8.0.6/32 -m comment --comment "..." -j RETURN
+func parseIPTablesSave(out []byte, chain string) (accountingCounters, error) {
+	counters := accountingCounters{}
+	found := false
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := scanner.Text()
+		if strings.HasPrefix(line, ":"+chain+" ") {
+			found = true
+			continue
+		}
+		if !strings.HasPrefix(line, "[") {
+			continue
+		}
+		args, err := splitIPTablesArgs(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		if len(args) < 3 || args[1] != "-A" || args[2] != chain {
+			continue
+		}
+		var packets, bytes float64
+		if _, err := fmt.Sscanf(args[0], "[%f:%f]", &packets, &bytes); err != nil {
+			return nil, fmt.Errorf("line %d: invalid counters %q", lineNumber, args[0])
+		}
+		var source, destination, comment string
+		for i := 3; i+1 < len(args); i++ {
+			if i > 3 && args[i-1] == "!" {
+				continue
+			}
+			switch args[i] {
+			case "-s", "--source":
+				source = args[i+1]
+			case "-d", "--destination":
+				destination = args[i+1]
+			case "--comment":
+				comment = args[i+1]
+			}
+		}
+		if direction, address, ok := parseAccountingComment(comment); ok {
+			counters.add(direction, address, packets, bytes)
+		} else if address, ok := hostAddress(source); ok {
+			counters.add(config.DirectionReceived, address, packets, bytes)
+		} else if address, ok := hostAddress(destination); ok {
+			counters.add(config.DirectionSent, address, packets, bytes)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("chain %q not found", chain)
+	}
+	return counters, nil
+}
+
+// Splits a line of iptables-save into its arguments, removing the double
+// quotes around arguments containing spaces.
+func splitIPTablesArgs(line string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg, quoted := false, false
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case c == '\\' && quoted && i+1 < len(line):
+			i++
+			arg.WriteByte(line[i])
+		case c == '"':
+			quoted = !quoted
+			inArg = true
+		case c == ' ' && !quoted:
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteByte(c)
+			inArg = true
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// Output of "nft -j list", of which only the objects used for accounting
+// are decoded.
+type nftablesOutput struct {
+	NFTables []struct {
+		Rule *struct {
+			Comment string            `json:"comment"`
+			Expr    []json.RawMessage `json:"expr"`
+		} `json:"rule"`
+		Set *struct {
+			Elem []json.RawMessage `json:"elem"`
+		} `json:"set"`
+	} `json:"nftables"`
+}
+
+type nftablesCounter struct {
+	Packets float64 `json:"packets"`
+	Bytes   float64 `json:"bytes"`
+}
+
+// Parses the counters of the rules of a chain from the output of
+// "nft -j list chain". Rules match addresses with expressions like:
+//
+//	{"match": {"op": "==", "left": {"payload": {"protocol": "ip", "field": "saddr"}}, "right": "10.8.0.6"}}
+func parseNFTablesChain(out []byte) (accountingCounters, error) {
+	var output nftablesOutput
+	if err := json.Unmarshal(out, &output); err != nil {
+		return nil, err
+	}
+	counters := accountingCounters{}
+	for _, object := range output.NFTables {
+		if object.Rule == nil {
+			continue
+		}
+		var counter *nftablesCounter
+		var source, destination string
+		for _, raw := range object.Rule.Expr {
+			var expr struct {
+				Counter *nftablesCounter `json:"counter"`
+				Match   *struct {
+					Op   string `json:"op"`
+					Left struct {
+						Payload *struct {
+							Field string `json:"field"`
+						} `json:"payload"`
+					} `json:"left"`
+					Right json.RawMessage `json:"right"`
+				} `json:"match"`
+			}
+			if err := json.Unmarshal(raw, &expr); err != nil {
+				return nil, err
+			}
+			if expr.Counter != nil {
+				counter = expr.Counter
+			}
+			match := expr.Match
+			if match == nil || match.Left.Payload == nil || match.Op != "==" {
+				continue
+			}
+			var address string
+			if json.Unmarshal(match.Right, &address) != nil {
+				continue
+			}
+			switch match.Left.Payload.Field {
+			case "saddr":
+				source = address
+			case "daddr":
+				destination = address
+			}
+		}
+		if counter == nil {
+			continue
+		}
+		if direction, address, ok := parseAccountingComment(object.Rule.Comment); ok {
+			counters.add(direction, address, counter.Packets, counter.Bytes)
+		} else if source != "" {
+			counters.add(config.DirectionReceived, source, counter.Packets, counter.Bytes)
+		} else if destination != "" {
+			counters.add(config.DirectionSent, destination, counter.Packets, counter.Bytes)
+		}
+	}
+	return counters, nil
+}
+
+// Parses the counters of the elements of a set from the output of
+// "nft -j list set". Elements with counters look like:
+//
+//	{"elem": {"val": "10.8.0.6", "counter": {"packets": 1, "bytes": 84}}}
+func parseNFTablesSet(out []byte, direction string) (accountingCounters, error) {
+	var output nftablesOutput
+	if err := json.Unmarshal(out, &output); err != nil {
+		return nil, err
+	}
+	counters := accountingCounters{}
+	found := false
+	for _, object := range output.NFTables {
+		if object.Set == nil {
+			continue
+		}
+		found = true
+		for _, raw := range object.Set.Elem {
+			var elem struct {
+				Elem *struct {
+					Val     json.RawMessage  `json:"val"`
+					Counter *nftablesCounter `json:"counter"`
+				} `json:"elem"`
+			}
+			// Elements without counters are plain values.
+			if json.Unmarshal(raw, &elem) != nil || elem.Elem == nil || elem.Elem.Counter == nil {
+				continue
+			}
+			var address string
+			if json.Unmarshal(elem.Elem.Val, &address) != nil {
+				continue
+			}
+			counters.add(direction, address, elem.Elem.Counter.Packets, elem.Elem.Counter.Bytes)
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("no set in output of nft")
+	}
+	return counters, nil
+}
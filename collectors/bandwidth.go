@@ -0,0 +1,188 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/kumina/openvpn_exporter/config"
+	"github.com/kumina/openvpn_exporter/exporters"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Duration after which the rates of status paths that weren't read anew
+// are ignored.
+const bandwidthRateExpiry = 10 * time.Minute
+
+// Duration after which clients without traffic are forgotten, along with
+// their number of breaches.
+const bandwidthClientExpiry = 24 * time.Hour
+
+var (
+	bandwidthThresholdDesc = prometheus.NewDesc(
+		"openvpn_bandwidth_threshold_bits_per_second",
+		"Rate above which the traffic of a client exceeds a bandwidth threshold.",
+		[]string{"threshold"}, nil)
+	bandwidthLabels       = []string{"threshold", "common_name"}
+	bandwidthExceededDesc = prometheus.NewDesc(
+		"openvpn_server_client_bandwidth_threshold_exceeded",
+		"Whether the traffic rate of a client exceeded a bandwidth threshold for its duration.",
+		bandwidthLabels, nil)
+	bandwidthBreachesDesc = prometheus.NewDesc(
+		"openvpn_server_client_bandwidth_threshold_breaches_total",
+		"Number of times the traffic rate of a client started exceeding a bandwidth threshold.",
+		bandwidthLabels, nil)
+)
+
+// BandwidthCollector evaluates bandwidth thresholds against the traffic
+// rates of clients, for sites that alert on the exporter's metrics without
+// evaluating rates in PromQL. Add it to the relabelers of the pipeline of
+// an exporter, so that it sees the client list entries that pass the
+// filters.
+//
+// Rates are computed from the changes of the byte counters of client
+// sessions between reads in which they changed.
+type BandwidthCollector struct {
+	thresholds []config.BandwidthThreshold
+	logger     *slog.Logger
+
+	mu    sync.Mutex
+	rates rateTracker
+	// State of each threshold, by common name.
+	states []map[string]*thresholdState
+}
+
+// The state of a threshold for a client.
+type thresholdState struct {
+	// Start of the period during which the rate was above the limit, if
+	// it is.
+	above    time.Time
+	exceeded bool
+	breaches float64
+	lastSeen time.Time
+}
+
+// NewBandwidthCollector creates a collector for bandwidth thresholds.
+func NewBandwidthCollector(thresholds []config.BandwidthThreshold, logger *slog.Logger) *BandwidthCollector {
+	c := &BandwidthCollector{
+		thresholds: thresholds,
+		logger:     logger,
+		rates:      newRateTracker(),
+		states:     make([]map[string]*thresholdState, len(thresholds)),
+	}
+	for i := range c.states {
+		c.states[i] = map[string]*thresholdState{}
+	}
+	return c
+}
+
+func (c *BandwidthCollector) BeginRead(statusPath string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rates.begin(statusPath)
+}
+
+// Relabel records the traffic of the session of a client list entry. The
+// entry is left unchanged.
+func (c *BandwidthCollector) Relabel(entry *exporters.Entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rates.record(entry)
+}
+
+// EndRead computes the rates of the clients of a status path, if its
+// counters changed since the previous read, and evaluates the thresholds
+// with them.
+func (c *BandwidthCollector) EndRead(statusPath string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if start, ok := c.rates.end(statusPath, now); ok {
+		c.evaluate(start, now)
+	}
+}
+
+// Evaluates the thresholds with the current rates of all status paths,
+// of which the latest cover the time since start.
+func (c *BandwidthCollector) evaluate(start, now time.Time) {
+	for i, threshold := range c.thresholds {
+		// Clients connected to several servers may exceed the threshold
+		// with their combined traffic.
+		rates := map[string]float64{}
+		for _, path := range c.rates.paths {
+			if now.Sub(path.read) > bandwidthRateExpiry {
+				continue
+			}
+			for commonName, rate := range path.rates {
+				if threshold.CommonNames.Matches(commonName) {
+					rates[commonName] += 8 * rate.direction(threshold.Direction)
+				}
+			}
+		}
+		for commonName, rate := range rates {
+			state, ok := c.states[i][commonName]
+			if !ok {
+				state = &thresholdState{}
+				c.states[i][commonName] = state
+			}
+			state.lastSeen = now
+			if rate <= threshold.BitsPerSecond {
+				state.above, state.exceeded = time.Time{}, false
+				continue
+			}
+			if state.above.IsZero() {
+				state.above = start
+			}
+			if !state.exceeded && now.Sub(state.above) >= time.Duration(threshold.For) {
+				state.exceeded = true
+				state.breaches++
+				c.logger.Warn("Client exceeds bandwidth threshold", "threshold", threshold.Name, "common_name", commonName, "bits_per_second", rate)
+			}
+		}
+		for commonName, state := range c.states[i] {
+			if _, ok := rates[commonName]; ok {
+				continue
+			}
+			// Clients that disconnected no longer exceed the threshold.
+			state.above, state.exceeded = time.Time{}, false
+			if now.Sub(state.lastSeen) > bandwidthClientExpiry {
+				delete(c.states[i], commonName)
+			}
+		}
+	}
+}
+
+func (c *BandwidthCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- bandwidthThresholdDesc
+	ch <- bandwidthExceededDesc
+	ch <- bandwidthBreachesDesc
+}
+
+func (c *BandwidthCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, threshold := range c.thresholds {
+		ch <- prometheus.MustNewConstMetric(bandwidthThresholdDesc, prometheus.GaugeValue, threshold.BitsPerSecond, threshold.Name)
+		for commonName, state := range c.states[i] {
+			exceeded := 0.0
+			if state.exceeded {
+				exceeded = 1
+			}
+			ch <- prometheus.MustNewConstMetric(bandwidthExceededDesc, prometheus.GaugeValue, exceeded, threshold.Name, commonName)
+			ch <- prometheus.MustNewConstMetric(bandwidthBreachesDesc, prometheus.CounterValue, state.breaches, threshold.Name, commonName)
+		}
+	}
+}
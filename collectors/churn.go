@@ -0,0 +1,190 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/kumina/openvpn_exporter/exporters"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	churnConnectsDesc = prometheus.NewDesc(
+		"openvpn_server_client_connects_total",
+		"Number of client sessions that appeared between reads of the status path.",
+		[]string{"status_path"}, nil)
+	churnDisconnectsDesc = prometheus.NewDesc(
+		"openvpn_server_client_disconnects_total",
+		"Number of client sessions that disappeared between reads of the status path.",
+		[]string{"status_path"}, nil)
+)
+
+// ChurnCollector counts the clients that connect and disconnect, by
+// comparing the sessions of each read of a status path with those of the
+// previous read. Add it to the relabelers of the pipeline of an exporter,
+// so that it sees the client list entries that pass the filters.
+//
+// Sessions are identified by the common name and real address of the
+// client. Sessions present at the first read of a status path aren't
+// counted as connects, unless the sessions of a previous run of the
+// exporter were restored, and clients that connect and disconnect between
+// two reads aren't seen at all.
+type ChurnCollector struct {
+	key string
+
+	mu      sync.Mutex
+	paths   map[string]*churnPath
+	pending map[string]map[churnSession]bool
+}
+
+// A session of a client, as far as churn is concerned.
+type churnSession struct {
+	CommonName  string `json:"common_name"`
+	RealAddress string `json:"real_address"`
+}
+
+// The sessions of a status path as last read, and its counters.
+type churnPath struct {
+	sessions    map[churnSession]bool
+	connects    float64
+	disconnects float64
+}
+
+// NewChurnCollector creates a collector counting the clients that
+// connect and disconnect. Its state is persisted under the given key.
+func NewChurnCollector(key string) *ChurnCollector {
+	return &ChurnCollector{
+		key:     key,
+		paths:   map[string]*churnPath{},
+		pending: map[string]map[churnSession]bool{},
+	}
+}
+
+func (c *ChurnCollector) BeginRead(statusPath string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending[statusPath] = map[churnSession]bool{}
+}
+
+// Relabel records the session of a client list entry. The entry is left
+// unchanged.
+func (c *ChurnCollector) Relabel(entry *exporters.Entry) {
+	if entry.Type != "CLIENT_LIST" {
+		return
+	}
+	session := churnSession{
+		CommonName:  entry.Columns.Value("Common Name"),
+		RealAddress: entry.Columns.Value("Real Address"),
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if pending, ok := c.pending[entry.StatusPath]; ok {
+		pending[session] = true
+	}
+}
+
+// EndRead counts the sessions that appeared or disappeared since the
+// previous read of a status path.
+func (c *ChurnCollector) EndRead(statusPath string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sessions := c.pending[statusPath]
+	delete(c.pending, statusPath)
+	path, ok := c.paths[statusPath]
+	if !ok {
+		c.paths[statusPath] = &churnPath{sessions: sessions}
+		return
+	}
+	for session := range sessions {
+		if !path.sessions[session] {
+			path.connects++
+		}
+	}
+	for session := range path.sessions {
+		if !sessions[session] {
+			path.disconnects++
+		}
+	}
+	path.sessions = sessions
+}
+
+func (c *ChurnCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- churnConnectsDesc
+	ch <- churnDisconnectsDesc
+}
+
+func (c *ChurnCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for statusPath, path := range c.paths {
+		ch <- prometheus.MustNewConstMetric(churnConnectsDesc, prometheus.CounterValue, path.connects, statusPath)
+		ch <- prometheus.MustNewConstMetric(churnDisconnectsDesc, prometheus.CounterValue, path.disconnects, statusPath)
+	}
+}
+
+// The persisted state of a status path.
+type churnSavedPath struct {
+	StatusPath  string         `json:"status_path"`
+	Sessions    []churnSession `json:"sessions"`
+	Connects    float64        `json:"connects"`
+	Disconnects float64        `json:"disconnects"`
+}
+
+// StateKey returns the key under which the state of the collector is
+// persisted.
+func (c *ChurnCollector) StateKey() string {
+	return c.key
+}
+
+// MarshalState returns the counters and the sessions as last read of the
+// status paths.
+func (c *ChurnCollector) MarshalState() ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var s []churnSavedPath
+	for statusPath, path := range c.paths {
+		saved := churnSavedPath{StatusPath: statusPath, Connects: path.connects, Disconnects: path.disconnects}
+		for session := range path.sessions {
+			saved.Sessions = append(saved.Sessions, session)
+		}
+		s = append(s, saved)
+	}
+	return json.Marshal(s)
+}
+
+// UnmarshalState restores the counters and the sessions as last read of
+// the status paths, so that sessions that appeared or disappeared while
+// the exporter wasn't running are counted as well.
+func (c *ChurnCollector) UnmarshalState(data []byte) error {
+	var s []churnSavedPath
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, saved := range s {
+		path := &churnPath{
+			sessions:    map[churnSession]bool{},
+			connects:    saved.Connects,
+			disconnects: saved.Disconnects,
+		}
+		for _, session := range saved.Sessions {
+			path.sessions[session] = true
+		}
+		c.paths[saved.StatusPath] = path
+	}
+	return nil
+}
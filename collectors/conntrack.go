@@ -0,0 +1,76 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"log/slog"
+	"net"
+
+	"github.com/kumina/openvpn_exporter/exporters"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	conntrackUpDesc = prometheus.NewDesc(
+		"openvpn_conntrack_up",
+		"Whether the connection tracking table could be read.",
+		nil, nil)
+	conntrackConnectionsDesc = prometheus.NewDesc(
+		"openvpn_server_client_connections",
+		"Number of tracked connections originating from the virtual address of a client.",
+		[]string{"status_path", "common_name", "virtual_address"}, nil)
+)
+
+// ConntrackCollector exports the number of connections of each connected
+// client, counting the entries of the kernel's connection tracking table
+// that originate from its virtual address. Clients running scanners or
+// file sharing stand out by their large number of connections.
+//
+// Reading the table requires Linux and CAP_NET_ADMIN.
+type ConntrackCollector struct {
+	clients *exporters.ClientTracker
+	logger  *slog.Logger
+}
+
+// NewConntrackCollector creates a collector for the connections of the
+// clients of a tracker.
+func NewConntrackCollector(clients *exporters.ClientTracker, logger *slog.Logger) *ConntrackCollector {
+	return &ConntrackCollector{clients: clients, logger: logger}
+}
+
+func (c *ConntrackCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- conntrackUpDesc
+	ch <- conntrackConnectionsDesc
+}
+
+func (c *ConntrackCollector) Collect(ch chan<- prometheus.Metric) {
+	connections, err := conntrackSources()
+	if err != nil {
+		c.logger.Error("Failed to read connection tracking table", "err", err)
+		ch <- prometheus.MustNewConstMetric(conntrackUpDesc, prometheus.GaugeValue, 0)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(conntrackUpDesc, prometheus.GaugeValue, 1)
+	for _, client := range c.clients.Clients() {
+		ip := net.ParseIP(client.VirtualAddress)
+		if ip == nil {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			conntrackConnectionsDesc,
+			prometheus.GaugeValue,
+			float64(connections[ip.String()]),
+			client.StatusPath, client.CommonName, client.VirtualAddress)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package collectors
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// Constants of the netlink interface of the connection tracking table.
+const (
+	netlinkNetfilter     = 12
+	nfnlSubsysCTNetlink  = 1
+	ipctnlMsgCTGet       = 1
+	ctaTupleOrig         = 1
+	ctaTupleIP           = 1
+	ctaIPv4Src           = 1
+	ctaIPv6Src           = 3
+	nlaTypeMask          = 0x3fff
+	nfgenmsgLen          = 4
+	conntrackRecvBufSize = 1 << 16
+)
+
+// Dumps the connection tracking table over netlink, returning the number
+// of connections by the source address of their original direction.
+func conntrackSources() (map[string]int, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, netlinkNetfilter)
+	if err != nil {
+		return nil, err
+	}
+	defer syscall.Close(fd)
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return nil, err
+	}
+
+	// A dump of all address families: a netlink header followed by a
+	// netfilter header with family AF_UNSPEC.
+	request := make([]byte, syscall.NLMSG_HDRLEN+nfgenmsgLen)
+	binary.NativeEndian.PutUint32(request[0:4], uint32(len(request)))
+	binary.NativeEndian.PutUint16(request[4:6], nfnlSubsysCTNetlink<<8|ipctnlMsgCTGet)
+	binary.NativeEndian.PutUint16(request[6:8], syscall.NLM_F_REQUEST|syscall.NLM_F_DUMP)
+	binary.NativeEndian.PutUint32(request[8:12], 1)
+	if err := syscall.Sendto(fd, request, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return nil, err
+	}
+
+	sources := map[string]int{}
+	buf := make([]byte, conntrackRecvBufSize)
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return nil, err
+		}
+		messages, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range messages {
+			switch m.Header.Type {
+			case syscall.NLMSG_DONE:
+				return sources, nil
+			case syscall.NLMSG_ERROR:
+				if len(m.Data) >= 4 {
+					if errno := -int32(binary.NativeEndian.Uint32(m.Data[0:4])); errno != 0 {
+						return nil, syscall.Errno(errno)
+					}
+				}
+				return nil, fmt.Errorf("netlink error")
+			}
+			if len(m.Data) < nfgenmsgLen {
+				continue
+			}
+			if source := conntrackSource(m.Data[nfgenmsgLen:]); source != nil {
+				sources[source.String()]++
+			}
+		}
+	}
+}
+
+// Returns the source address of the original direction of a connection,
+// given the attributes of its message.
+func conntrackSource(attributes []byte) net.IP {
+	tuple := netlinkAttribute(attributes, ctaTupleOrig)
+	ip := netlinkAttribute(tuple, ctaTupleIP)
+	if source := netlinkAttribute(ip, ctaIPv4Src); len(source) == net.IPv4len {
+		return net.IP(source)
+	}
+	if source := netlinkAttribute(ip, ctaIPv6Src); len(source) == net.IPv6len {
+		return net.IP(source)
+	}
+	return nil
+}
+
+// Returns the payload of the first netlink attribute of a type, or nil.
+func netlinkAttribute(attributes []byte, attributeType uint16) []byte {
+	for len(attributes) >= syscall.NLA_HDRLEN {
+		length := int(binary.NativeEndian.Uint16(attributes[0:2]))
+		if length < syscall.NLA_HDRLEN || length > len(attributes) {
+			return nil
+		}
+		if binary.NativeEndian.Uint16(attributes[2:4])&nlaTypeMask == attributeType {
+			return attributes[syscall.NLA_HDRLEN:length]
+		}
+		aligned := (length + syscall.NLA_ALIGNTO - 1) &^ (syscall.NLA_ALIGNTO - 1)
+		if aligned > len(attributes) {
+			return nil
+		}
+		attributes = attributes[aligned:]
+	}
+	return nil
+}
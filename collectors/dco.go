@@ -0,0 +1,96 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"log/slog"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Traffic of a network device as counted by the kernel.
+type dcoDevice struct {
+	name            string
+	receivedBytes   uint64
+	sentBytes       uint64
+	receivedPackets uint64
+	sentPackets     uint64
+}
+
+var (
+	dcoUpDesc = prometheus.NewDesc(
+		"openvpn_dco_up",
+		"Whether the network devices could be listed.",
+		nil, nil)
+	dcoDeviceLabels            = []string{"device"}
+	dcoDeviceReceivedBytesDesc = prometheus.NewDesc(
+		"openvpn_dco_device_received_bytes_total",
+		"Amount of data received from clients through a data channel offload device, in bytes.",
+		dcoDeviceLabels, nil)
+	dcoDeviceSentBytesDesc = prometheus.NewDesc(
+		"openvpn_dco_device_sent_bytes_total",
+		"Amount of data sent to clients through a data channel offload device, in bytes.",
+		dcoDeviceLabels, nil)
+	dcoDeviceReceivedPacketsDesc = prometheus.NewDesc(
+		"openvpn_dco_device_received_packets_total",
+		"Number of packets received from clients through a data channel offload device.",
+		dcoDeviceLabels, nil)
+	dcoDeviceSentPacketsDesc = prometheus.NewDesc(
+		"openvpn_dco_device_sent_packets_total",
+		"Number of packets sent to clients through a data channel offload device.",
+		dcoDeviceLabels, nil)
+)
+
+// DCOCollector exports the traffic of the devices of OpenVPN's data
+// channel offload (DCO) kernel module, as used by OpenVPN 2.6 and later.
+// With DCO, the kernel encrypts and forwards the traffic of clients
+// itself, so the TUN/TAP counters of client status files miss it. The
+// devices are listed over rtnetlink, and recognized by their kind,
+// ovpn-dco for the out-of-tree module, or ovpn for the module of
+// mainline Linux.
+//
+// Listing the devices requires Linux, but no privileges.
+type DCOCollector struct {
+	logger *slog.Logger
+}
+
+// NewDCOCollector creates a collector for the devices of the data channel
+// offload kernel module.
+func NewDCOCollector(logger *slog.Logger) *DCOCollector {
+	return &DCOCollector{logger: logger}
+}
+
+func (c *DCOCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- dcoUpDesc
+	ch <- dcoDeviceReceivedBytesDesc
+	ch <- dcoDeviceSentBytesDesc
+	ch <- dcoDeviceReceivedPacketsDesc
+	ch <- dcoDeviceSentPacketsDesc
+}
+
+func (c *DCOCollector) Collect(ch chan<- prometheus.Metric) {
+	devices, err := dcoDevices()
+	if err != nil {
+		c.logger.Error("Failed to list data channel offload devices", "err", err)
+		ch <- prometheus.MustNewConstMetric(dcoUpDesc, prometheus.GaugeValue, 0)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(dcoUpDesc, prometheus.GaugeValue, 1)
+	for _, device := range devices {
+		ch <- prometheus.MustNewConstMetric(dcoDeviceReceivedBytesDesc, prometheus.CounterValue, float64(device.receivedBytes), device.name)
+		ch <- prometheus.MustNewConstMetric(dcoDeviceSentBytesDesc, prometheus.CounterValue, float64(device.sentBytes), device.name)
+		ch <- prometheus.MustNewConstMetric(dcoDeviceReceivedPacketsDesc, prometheus.CounterValue, float64(device.receivedPackets), device.name)
+		ch <- prometheus.MustNewConstMetric(dcoDeviceSentPacketsDesc, prometheus.CounterValue, float64(device.sentPackets), device.name)
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package collectors
+
+import (
+	"bytes"
+	"encoding/binary"
+	"syscall"
+)
+
+// Constants of the rtnetlink interface of network devices.
+const (
+	iflaLinkInfo = 18
+	iflaStats64  = 23
+	iflaInfoKind = 1
+	// Length of the fields of struct rtnl_link_stats64 up to the
+	// transmitted bytes.
+	rtnlLinkStats64Len = 32
+)
+
+// Kinds of the devices of the data channel offload kernel modules.
+var dcoKinds = map[string]bool{"ovpn-dco": true, "ovpn": true}
+
+// Lists the network devices of the data channel offload kernel modules
+// over rtnetlink, along with their traffic.
+func dcoDevices() ([]dcoDevice, error) {
+	rib, err := syscall.NetlinkRIB(syscall.RTM_GETLINK, syscall.AF_UNSPEC)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := syscall.ParseNetlinkMessage(rib)
+	if err != nil {
+		return nil, err
+	}
+	var devices []dcoDevice
+	for _, m := range messages {
+		if m.Header.Type != syscall.RTM_NEWLINK {
+			continue
+		}
+		attributes, err := syscall.ParseNetlinkRouteAttr(&m)
+		if err != nil {
+			return nil, err
+		}
+		var device dcoDevice
+		var kind string
+		var stats []byte
+		for _, attribute := range attributes {
+			switch attribute.Attr.Type {
+			case syscall.IFLA_IFNAME:
+				device.name = string(bytes.TrimRight(attribute.Value, "\x00"))
+			case iflaLinkInfo:
+				kind = string(bytes.TrimRight(netlinkAttribute(attribute.Value, iflaInfoKind), "\x00"))
+			case iflaStats64:
+				stats = attribute.Value
+			}
+		}
+		if !dcoKinds[kind] || len(stats) < rtnlLinkStats64Len {
+			continue
+		}
+		device.receivedPackets = binary.NativeEndian.Uint64(stats[0:8])
+		device.sentPackets = binary.NativeEndian.Uint64(stats[8:16])
+		device.receivedBytes = binary.NativeEndian.Uint64(stats[16:24])
+		device.sentBytes = binary.NativeEndian.Uint64(stats[24:32])
+		devices = append(devices, device)
+	}
+	return devices, nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"bytes"
+	"log/slog"
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	ebpfUpDesc = prometheus.NewDesc(
+		"openvpn_ebpf_up",
+		"Whether the traffic of a tun device could be counted.",
+		[]string{"device"}, nil)
+	ebpfReceivedPacketsDesc = prometheus.NewDesc(
+		"openvpn_ebpf_client_received_packets_total",
+		"Number of packets received from a virtual address on a tun device.",
+		[]string{"device", "virtual_address"}, nil)
+	ebpfReceivedBytesDesc = prometheus.NewDesc(
+		"openvpn_ebpf_client_received_bytes_total",
+		"Amount of data received from a virtual address on a tun device, in bytes.",
+		[]string{"device", "virtual_address"}, nil)
+	ebpfSentPacketsDesc = prometheus.NewDesc(
+		"openvpn_ebpf_client_sent_packets_total",
+		"Number of packets sent to a virtual address on a tun device.",
+		[]string{"device", "virtual_address"}, nil)
+	ebpfSentBytesDesc = prometheus.NewDesc(
+		"openvpn_ebpf_client_sent_bytes_total",
+		"Amount of data sent to a virtual address on a tun device, in bytes.",
+		[]string{"device", "virtual_address"}, nil)
+)
+
+// EBPFCollector counts the IPv4 packets passing the tun devices of
+// OpenVPN servers per virtual address, using an eBPF program in the
+// kernel. Unlike the byte counters of status files, which are only
+// updated every status interval and are measured before compression and
+// encryption, these counters are exact at the time of the scrape.
+//
+// The program is attached to a packet socket bound to each device. When
+// a device is recreated, such as when OpenVPN restarts, the program is
+// attached again and the counters of the device start at zero.
+type EBPFCollector struct {
+	logger *slog.Logger
+
+	mu       sync.Mutex
+	counters []*tunCounter
+}
+
+// Packets and bytes passing a device in one direction.
+type tunTraffic struct {
+	packets uint64
+	bytes   uint64
+}
+
+// Traffic of a virtual address: received from it, and sent to it.
+type tunAddressTraffic struct {
+	address  net.IP
+	received tunTraffic
+	sent     tunTraffic
+}
+
+// NewEBPFCollector creates a collector counting the traffic of the given
+// tun devices. It fails if eBPF isn't supported on this platform.
+func NewEBPFCollector(devices []string, logger *slog.Logger) (*EBPFCollector, error) {
+	if err := ebpfSupported(); err != nil {
+		return nil, err
+	}
+	c := &EBPFCollector{logger: logger}
+	for _, device := range devices {
+		c.counters = append(c.counters, &tunCounter{device: device})
+	}
+	return c, nil
+}
+
+func (c *EBPFCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- ebpfUpDesc
+	ch <- ebpfReceivedPacketsDesc
+	ch <- ebpfReceivedBytesDesc
+	ch <- ebpfSentPacketsDesc
+	ch <- ebpfSentBytesDesc
+}
+
+func (c *EBPFCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, counter := range c.counters {
+		traffic, err := counter.read()
+		if err != nil {
+			c.logger.Error("Failed to count traffic of tun device", "device", counter.device, "err", err)
+			ch <- prometheus.MustNewConstMetric(ebpfUpDesc, prometheus.GaugeValue, 0, counter.device)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(ebpfUpDesc, prometheus.GaugeValue, 1, counter.device)
+		sort.Slice(traffic, func(i, j int) bool {
+			return bytes.Compare(traffic[i].address, traffic[j].address) < 0
+		})
+		for _, t := range traffic {
+			address := t.address.String()
+			for _, m := range []struct {
+				desc  *prometheus.Desc
+				value uint64
+			}{
+				{ebpfReceivedPacketsDesc, t.received.packets},
+				{ebpfReceivedBytesDesc, t.received.bytes},
+				{ebpfSentPacketsDesc, t.sent.packets},
+				{ebpfSentBytesDesc, t.sent.bytes},
+			} {
+				ch <- prometheus.MustNewConstMetric(m.desc, prometheus.CounterValue, float64(m.value), counter.device, address)
+			}
+		}
+	}
+}
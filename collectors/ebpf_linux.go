@@ -0,0 +1,370 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package collectors
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// Number of the bpf system call on the supported architectures, all of
+// which are little endian, as the syscall package doesn't define it for
+// all of them.
+var sysBPF = map[string]uintptr{
+	"386":     357,
+	"amd64":   321,
+	"arm":     386,
+	"arm64":   280,
+	"loong64": 280,
+	"ppc64le": 361,
+	"riscv64": 280,
+}[runtime.GOARCH]
+
+// Commands of the bpf system call, and other constants of the kernel's
+// eBPF interface.
+const (
+	bpfCmdMapCreate     = 0
+	bpfCmdMapLookupElem = 1
+	bpfCmdMapGetNextKey = 4
+	bpfCmdProgLoad      = 5
+
+	bpfMapTypeLRUHash       = 9
+	bpfProgTypeSocketFilter = 1
+	bpfFuncMapLookupElem    = 1
+	bpfFuncMapUpdateElem    = 2
+	bpfNoExist              = 1
+	bpfPseudoMapFD          = 1
+	soAttachBPF             = 50
+	packetOutgoing          = 4
+)
+
+// Size of the map of a device, and of its keys and values.
+const (
+	ebpfMaxAddresses = 65536
+	ebpfKeySize      = 8
+	ebpfValueSize    = 16
+)
+
+// A counter of the traffic of a tun device, consisting of a map of
+// virtual addresses to their traffic, a program updating it and the
+// packet socket the program is attached to.
+type tunCounter struct {
+	device  string
+	ifindex int
+	mapFD   int
+	progFD  int
+	sockFD  int
+}
+
+func ebpfSupported() error {
+	if sysBPF == 0 {
+		return fmt.Errorf("eBPF is not supported on %s", runtime.GOARCH)
+	}
+	return nil
+}
+
+// Reads the traffic of the device, attaching the program to it first if
+// it isn't attached yet or the device was recreated.
+func (c *tunCounter) read() ([]tunAddressTraffic, error) {
+	iface, err := net.InterfaceByName(c.device)
+	if err != nil {
+		c.close()
+		return nil, err
+	}
+	if iface.Index != c.ifindex {
+		c.close()
+		if err := c.attach(iface.Index); err != nil {
+			c.close()
+			return nil, err
+		}
+	}
+	return c.dump()
+}
+
+func (c *tunCounter) attach(ifindex int) error {
+	var err error
+	c.mapFD, err = bpfMapCreateLRUHash(ebpfKeySize, ebpfValueSize, ebpfMaxAddresses)
+	if err != nil {
+		return fmt.Errorf("creating map: %w", err)
+	}
+	c.progFD, err = bpfLoadSocketFilter(tunCounterProgram(c.mapFD))
+	if err != nil {
+		return fmt.Errorf("loading program: %w", err)
+	}
+	// The socket doesn't receive any packets until it is bound, by
+	// which time the program is attached to it. The program accepts no
+	// packets, so that none are queued on the socket.
+	c.sockFD, err = syscall.Socket(syscall.AF_PACKET, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("creating packet socket: %w", err)
+	}
+	if err := syscall.SetsockoptInt(c.sockFD, syscall.SOL_SOCKET, soAttachBPF, c.progFD); err != nil {
+		return fmt.Errorf("attaching program: %w", err)
+	}
+	protocol := htons(syscall.ETH_P_ALL)
+	if err := syscall.Bind(c.sockFD, &syscall.SockaddrLinklayer{Protocol: protocol, Ifindex: ifindex}); err != nil {
+		return fmt.Errorf("binding packet socket: %w", err)
+	}
+	c.ifindex = ifindex
+	return nil
+}
+
+func (c *tunCounter) close() {
+	for _, fd := range []*int{&c.sockFD, &c.progFD, &c.mapFD} {
+		if *fd > 0 {
+			syscall.Close(*fd)
+		}
+		*fd = 0
+	}
+	c.ifindex = 0
+}
+
+// Reads the entries of the map. Keys consist of an IPv4 address in host
+// byte order and the direction, 0 for received and 1 for sent packets.
+// Values consist of the number of packets and bytes.
+func (c *tunCounter) dump() ([]tunAddressTraffic, error) {
+	byAddress := map[uint32]*tunAddressTraffic{}
+	key := make([]byte, ebpfKeySize)
+	value := make([]byte, ebpfValueSize)
+	var prev []byte
+	for {
+		if err := bpfMapGetNextKey(c.mapFD, prev, key); err != nil {
+			if errors.Is(err, syscall.ENOENT) {
+				break
+			}
+			return nil, err
+		}
+		prev = append(prev[:0], key...)
+		if err := bpfMapLookupElem(c.mapFD, key, value); err != nil {
+			if errors.Is(err, syscall.ENOENT) {
+				// Evicted in the meantime.
+				continue
+			}
+			return nil, err
+		}
+		address := binary.LittleEndian.Uint32(key[0:4])
+		t, ok := byAddress[address]
+		if !ok {
+			ip := make(net.IP, 4)
+			binary.BigEndian.PutUint32(ip, address)
+			t = &tunAddressTraffic{address: ip}
+			byAddress[address] = t
+		}
+		traffic := tunTraffic{
+			packets: binary.LittleEndian.Uint64(value[0:8]),
+			bytes:   binary.LittleEndian.Uint64(value[8:16]),
+		}
+		if binary.LittleEndian.Uint32(key[4:8]) == 0 {
+			t.received = traffic
+		} else {
+			t.sent = traffic
+		}
+	}
+	result := make([]tunAddressTraffic, 0, len(byAddress))
+	for _, t := range byAddress {
+		result = append(result, *t)
+	}
+	return result, nil
+}
+
+// An eBPF instruction.
+type bpfInsn struct {
+	code uint8
+	regs uint8 // Destination register in the lower, source in the upper bits.
+	off  int16
+	imm  int32
+}
+
+func insn(code uint8, dst, src uint8, off int16, imm int32) bpfInsn {
+	return bpfInsn{code: code, regs: dst | src<<4, off: off, imm: imm}
+}
+
+// Returns the program counting the packets of a device in the map. For
+// every IPv4 packet, it determines the virtual address of the client
+// from the source address of received and the destination address of
+// sent packets, and adds the packet and its length to the counters of
+// the address and direction:
+//
+//	if (ip->version != 4)
+//		return 0
+//	key.address = pkt_type == PACKET_OUTGOING ? ip->daddr : ip->saddr
+//	key.direction = pkt_type == PACKET_OUTGOING
+//	if (value = map_lookup_elem(map, &key)) {
+//		atomic_add(&value->packets, 1)
+//		atomic_add(&value->bytes, skb->len)
+//	} else {
+//		map_update_elem(map, &key, &{1, skb->len}, BPF_NOEXIST)
+//	}
+//	return 0
+//
+// Packet sockets of type SOCK_DGRAM see packets from their network
+// header on, so addresses are at fixed offsets of the IPv4 header. Only
+// sockets receiving all protocols see sent packets, so the version is
+// checked by the program.
+func tunCounterProgram(mapFD int) []bpfInsn {
+	const (
+		r0, r1, r2, r3, r4, r6, r7, r8, r9, fp = 0, 1, 2, 3, 4, 6, 7, 8, 9, 10
+
+		alu64MovImm = 0xb7
+		alu64MovReg = 0xbf
+		alu64AddImm = 0x07
+		alu64RshImm = 0x77
+		ldxW        = 0x61
+		stxW        = 0x63
+		stxDW       = 0x7b
+		stDW        = 0x7a
+		ldAbsW      = 0x20
+		ldAbsB      = 0x30
+		ldImmDW     = 0x18
+		atomicDW    = 0xdb
+		jeqImm      = 0x15
+		jneImm      = 0x55
+		ja          = 0x05
+		call        = 0x85
+		exit        = 0x95
+	)
+	return []bpfInsn{
+		/* 0 */ insn(alu64MovReg, r6, r1, 0, 0), // LD_ABS expects the context in r6.
+		/* 1 */ insn(ldxW, r7, r6, 0, 0), // r7 = skb->len
+		/* 2 */ insn(ldxW, r8, r6, 4, 0), // r8 = skb->pkt_type
+		/* 3 */ insn(ldAbsB, 0, 0, 0, 0), // r0 = ip->version
+		/* 4 */ insn(alu64RshImm, r0, 0, 0, 4),
+		/* 5 */ insn(jneImm, r0, 0, 29, 4),
+		/* 6 */ insn(jeqImm, r8, 0, 2, packetOutgoing),
+		/* 7 */ insn(ldAbsW, 0, 0, 0, 12), // r0 = ip->saddr
+		/* 8 */ insn(ja, 0, 0, 1, 0),
+		/* 9 */ insn(ldAbsW, 0, 0, 0, 16), // r0 = ip->daddr
+		/* 10 */ insn(stxW, fp, r0, -8, 0),
+		/* 11 */ insn(alu64MovImm, r9, 0, 0, 0),
+		/* 12 */ insn(jneImm, r8, 0, 1, packetOutgoing),
+		/* 13 */ insn(alu64MovImm, r9, 0, 0, 1),
+		/* 14 */ insn(stxW, fp, r9, -4, 0),
+		/* 15 */ insn(ldImmDW, r1, bpfPseudoMapFD, 0, int32(mapFD)),
+		/* 16 */ insn(0, 0, 0, 0, 0),
+		/* 17 */ insn(alu64MovReg, r2, fp, 0, 0),
+		/* 18 */ insn(alu64AddImm, r2, 0, 0, -8),
+		/* 19 */ insn(call, 0, 0, 0, bpfFuncMapLookupElem),
+		/* 20 */ insn(jeqImm, r0, 0, 4, 0),
+		/* 21 */ insn(alu64MovImm, r1, 0, 0, 1),
+		/* 22 */ insn(atomicDW, r0, r1, 0, 0), // BPF_ADD
+		/* 23 */ insn(atomicDW, r0, r7, 8, 0),
+		/* 24 */ insn(ja, 0, 0, 10, 0),
+		/* 25 */ insn(stDW, fp, 0, -24, 1),
+		/* 26 */ insn(stxDW, fp, r7, -16, 0),
+		/* 27 */ insn(ldImmDW, r1, bpfPseudoMapFD, 0, int32(mapFD)),
+		/* 28 */ insn(0, 0, 0, 0, 0),
+		/* 29 */ insn(alu64MovReg, r2, fp, 0, 0),
+		/* 30 */ insn(alu64AddImm, r2, 0, 0, -8),
+		/* 31 */ insn(alu64MovReg, r3, fp, 0, 0),
+		/* 32 */ insn(alu64AddImm, r3, 0, 0, -24),
+		/* 33 */ insn(alu64MovImm, r4, 0, 0, bpfNoExist),
+		/* 34 */ insn(call, 0, 0, 0, bpfFuncMapUpdateElem),
+		/* 35 */ insn(alu64MovImm, r0, 0, 0, 0),
+		/* 36 */ insn(exit, 0, 0, 0, 0),
+	}
+}
+
+func bpf(cmd uintptr, attr unsafe.Pointer, size uintptr) (int, error) {
+	fd, _, errno := syscall.Syscall(sysBPF, cmd, uintptr(attr), size)
+	runtime.KeepAlive(attr)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(fd), nil
+}
+
+func bpfMapCreateLRUHash(keySize, valueSize, maxEntries uint32) (int, error) {
+	attr := struct {
+		mapType    uint32
+		keySize    uint32
+		valueSize  uint32
+		maxEntries uint32
+		mapFlags   uint32
+	}{bpfMapTypeLRUHash, keySize, valueSize, maxEntries, 0}
+	return bpf(bpfCmdMapCreate, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+}
+
+// Loads a socket filter program, returning the verifier's log if it
+// rejects the program.
+func bpfLoadSocketFilter(insns []bpfInsn) (int, error) {
+	license := []byte("Apache-2.0\x00")
+	log := make([]byte, 4096)
+	attr := struct {
+		progType    uint32
+		insnCount   uint32
+		insns       uint64
+		license     uint64
+		logLevel    uint32
+		logSize     uint32
+		logBuf      uint64
+		kernVersion uint32
+		progFlags   uint32
+	}{
+		progType:  bpfProgTypeSocketFilter,
+		insnCount: uint32(len(insns)),
+		insns:     uint64(uintptr(unsafe.Pointer(&insns[0]))),
+		license:   uint64(uintptr(unsafe.Pointer(&license[0]))),
+		logLevel:  1,
+		logSize:   uint32(len(log)),
+		logBuf:    uint64(uintptr(unsafe.Pointer(&log[0]))),
+	}
+	fd, err := bpf(bpfCmdProgLoad, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(insns)
+	runtime.KeepAlive(license)
+	if err != nil {
+		if n := bytes.IndexByte(log, 0); n > 0 {
+			return 0, fmt.Errorf("%w: %s", err, log[:n])
+		}
+		return 0, err
+	}
+	return fd, nil
+}
+
+// Operations on map elements. key is nil to get the first key.
+func bpfMapGetNextKey(mapFD int, key, nextKey []byte) error {
+	return bpfMapElem(bpfCmdMapGetNextKey, mapFD, key, nextKey)
+}
+
+func bpfMapLookupElem(mapFD int, key, value []byte) error {
+	return bpfMapElem(bpfCmdMapLookupElem, mapFD, key, value)
+}
+
+func bpfMapElem(cmd uintptr, mapFD int, key, value []byte) error {
+	attr := struct {
+		mapFD uint32
+		_     uint32
+		key   uint64
+		value uint64
+		flags uint64
+	}{mapFD: uint32(mapFD), value: uint64(uintptr(unsafe.Pointer(&value[0])))}
+	if key != nil {
+		attr.key = uint64(uintptr(unsafe.Pointer(&key[0])))
+	}
+	_, err := bpf(cmd, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(key)
+	runtime.KeepAlive(value)
+	return err
+}
+
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
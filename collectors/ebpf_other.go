@@ -0,0 +1,31 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+
+package collectors
+
+import "errors"
+
+// Counters of tun devices are only supported on Linux.
+type tunCounter struct {
+	device string
+}
+
+func ebpfSupported() error {
+	return errors.New("eBPF is only supported on Linux")
+}
+
+func (c *tunCounter) read() ([]tunAddressTraffic, error) {
+	return nil, ebpfSupported()
+}
@@ -0,0 +1,174 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"sync"
+	"time"
+
+	"github.com/kumina/openvpn_exporter/exporters"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Duration after which the clients of status paths that weren't read
+// anew are forgotten.
+const idleReadExpiry = 10 * time.Minute
+
+var (
+	idleLabels = []string{"status_path", "common_name"}
+	idleDesc   = prometheus.NewDesc(
+		"openvpn_server_client_idle",
+		"Whether none of the sessions of a client passed traffic for the configured number of reads.",
+		idleLabels, nil)
+	idleLastActivityDesc = prometheus.NewDesc(
+		"openvpn_server_client_last_activity_timestamp_seconds",
+		"UNIX timestamp of the read in which the traffic of a client last changed, or in which it was first seen.",
+		idleLabels, nil)
+)
+
+// IdleCollector detects clients that remain connected without passing
+// any traffic, such as dead site-to-site peers. Add it to the relabelers
+// of the pipeline of an exporter, so that it sees the client list entries
+// that pass the filters.
+//
+// A session is idle once its byte counters didn't change for a number of
+// reads. Scrapes that reuse the metrics of an unchanged source don't
+// count as reads. For sources that are read on every scrape, the number
+// needs to cover more than the interval at which OpenVPN rewrites its
+// status.
+type IdleCollector struct {
+	reads int
+
+	mu      sync.Mutex
+	paths   map[string]*idlePath
+	pending map[string]map[clientSession]clientTraffic
+}
+
+// The sessions of a status path as last read.
+type idlePath struct {
+	sessions map[clientSession]*idleSession
+	read     time.Time
+}
+
+// The activity of a session.
+type idleSession struct {
+	traffic      clientTraffic
+	lastActivity time.Time
+	// Number of consecutive reads in which the traffic didn't change.
+	idleReads int
+}
+
+// NewIdleCollector creates a collector that considers sessions idle once
+// their traffic didn't change for the given number of reads.
+func NewIdleCollector(reads int) *IdleCollector {
+	return &IdleCollector{
+		reads:   reads,
+		paths:   map[string]*idlePath{},
+		pending: map[string]map[clientSession]clientTraffic{},
+	}
+}
+
+func (c *IdleCollector) BeginRead(statusPath string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending[statusPath] = map[clientSession]clientTraffic{}
+}
+
+// Relabel records the traffic of the session of a client list entry. The
+// entry is left unchanged.
+func (c *IdleCollector) Relabel(entry *exporters.Entry) {
+	session, traffic, ok := entrySession(entry)
+	if !ok {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if pending, ok := c.pending[entry.StatusPath]; ok {
+		pending[session] = traffic
+	}
+}
+
+// EndRead compares the traffic of the sessions of a status path with that
+// of the previous read. Sessions that disconnected are forgotten.
+func (c *IdleCollector) EndRead(statusPath string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	traffic := c.pending[statusPath]
+	delete(c.pending, statusPath)
+	path, ok := c.paths[statusPath]
+	if !ok {
+		path = &idlePath{sessions: map[clientSession]*idleSession{}}
+		c.paths[statusPath] = path
+	}
+	path.read = now
+	sessions := make(map[clientSession]*idleSession, len(traffic))
+	for session, current := range traffic {
+		state, ok := path.sessions[session]
+		switch {
+		case !ok:
+			state = &idleSession{traffic: current, lastActivity: now}
+		case current != state.traffic:
+			state.traffic, state.lastActivity, state.idleReads = current, now, 0
+		default:
+			state.idleReads++
+		}
+		sessions[session] = state
+	}
+	path.sessions = sessions
+}
+
+func (c *IdleCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- idleDesc
+	ch <- idleLastActivityDesc
+}
+
+func (c *IdleCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for statusPath, path := range c.paths {
+		if now.Sub(path.read) > idleReadExpiry {
+			delete(c.paths, statusPath)
+			continue
+		}
+		// Clients with several sessions are idle if all of them are.
+		type clientActivity struct {
+			idle         bool
+			lastActivity time.Time
+		}
+		clients := map[string]*clientActivity{}
+		for session, state := range path.sessions {
+			client, ok := clients[session.CommonName]
+			if !ok {
+				client = &clientActivity{idle: true}
+				clients[session.CommonName] = client
+			}
+			if state.idleReads < c.reads {
+				client.idle = false
+			}
+			if state.lastActivity.After(client.lastActivity) {
+				client.lastActivity = state.lastActivity
+			}
+		}
+		for commonName, client := range clients {
+			idle := 0.0
+			if client.idle {
+				idle = 1
+			}
+			ch <- prometheus.MustNewConstMetric(idleDesc, prometheus.GaugeValue, idle, statusPath, commonName)
+			ch <- prometheus.MustNewConstMetric(idleLastActivityDesc, prometheus.GaugeValue, float64(client.lastActivity.UnixNano())/1e9, statusPath, commonName)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Names of the D-Bus service of the openvpn3-linux session manager.
+const (
+	openvpn3Service   = "net.openvpn.v3.sessions"
+	openvpn3Root      = "/net/openvpn/v3/sessions"
+	openvpn3Interface = "net.openvpn.v3.sessions"
+)
+
+var (
+	openvpn3UpDesc = prometheus.NewDesc(
+		"openvpn3_up",
+		"Whether the sessions of the openvpn3-linux session manager could be listed.",
+		nil, nil)
+	openvpn3SessionsDesc = prometheus.NewDesc(
+		"openvpn3_sessions",
+		"Number of openvpn3-linux sessions.",
+		nil, nil)
+	openvpn3SessionInfoDesc = prometheus.NewDesc(
+		"openvpn3_session_info",
+		"Information about an openvpn3-linux session.",
+		[]string{"session", "session_name", "config_name", "device_name"}, nil)
+	openvpn3SessionCreatedDesc = prometheus.NewDesc(
+		"openvpn3_session_created_timestamp_seconds",
+		"Time at which an openvpn3-linux session was created, in seconds since the epoch.",
+		[]string{"session"}, nil)
+
+	// Statistics of sessions that are exported, by their name in
+	// openvpn3-linux.
+	openvpn3StatisticDescs = map[string]*prometheus.Desc{
+		"BYTES_IN":        newOpenVPN3StatisticDesc("bytes_in_total", "BYTES_IN"),
+		"BYTES_OUT":       newOpenVPN3StatisticDesc("bytes_out_total", "BYTES_OUT"),
+		"PACKETS_IN":      newOpenVPN3StatisticDesc("packets_in_total", "PACKETS_IN"),
+		"PACKETS_OUT":     newOpenVPN3StatisticDesc("packets_out_total", "PACKETS_OUT"),
+		"TUN_BYTES_IN":    newOpenVPN3StatisticDesc("tun_bytes_in_total", "TUN_BYTES_IN"),
+		"TUN_BYTES_OUT":   newOpenVPN3StatisticDesc("tun_bytes_out_total", "TUN_BYTES_OUT"),
+		"TUN_PACKETS_IN":  newOpenVPN3StatisticDesc("tun_packets_in_total", "TUN_PACKETS_IN"),
+		"TUN_PACKETS_OUT": newOpenVPN3StatisticDesc("tun_packets_out_total", "TUN_PACKETS_OUT"),
+	}
+)
+
+func newOpenVPN3StatisticDesc(name string, statistic string) *prometheus.Desc {
+	return prometheus.NewDesc(
+		"openvpn3_session_"+name,
+		fmt.Sprintf("Value of the %s statistic of an openvpn3-linux session.", statistic),
+		[]string{"session"}, nil)
+}
+
+// OpenVPN3Collector exports the sessions of the openvpn3-linux client,
+// which doesn't write status files. Sessions and their statistics are
+// obtained from the session manager on the system D-Bus, using busctl.
+type OpenVPN3Collector struct {
+	logger  *slog.Logger
+	timeout time.Duration
+}
+
+// NewOpenVPN3Collector creates a collector for openvpn3-linux sessions,
+// giving up on querying D-Bus after the timeout, if non-zero.
+func NewOpenVPN3Collector(logger *slog.Logger, timeout time.Duration) *OpenVPN3Collector {
+	return &OpenVPN3Collector{logger: logger, timeout: timeout}
+}
+
+// An openvpn3-linux session, along with the properties that are exported.
+type openvpn3Session struct {
+	Path        string
+	SessionName string
+	ConfigName  string
+	DeviceName  string
+	Created     uint64
+	Statistics  map[string]int64
+}
+
+func (c *OpenVPN3Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- openvpn3UpDesc
+	ch <- openvpn3SessionsDesc
+	ch <- openvpn3SessionInfoDesc
+	ch <- openvpn3SessionCreatedDesc
+	for _, desc := range openvpn3StatisticDescs {
+		ch <- desc
+	}
+}
+
+func (c *OpenVPN3Collector) Collect(ch chan<- prometheus.Metric) {
+	ctx := context.Background()
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	sessions, err := c.sessions(ctx)
+	if err != nil {
+		c.logger.Error("Failed to list openvpn3 sessions", "err", err)
+		ch <- prometheus.MustNewConstMetric(openvpn3UpDesc, prometheus.GaugeValue, 0)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(openvpn3UpDesc, prometheus.GaugeValue, 1)
+	ch <- prometheus.MustNewConstMetric(openvpn3SessionsDesc, prometheus.GaugeValue, float64(len(sessions)))
+	for _, session := range sessions {
+		id := path.Base(session.Path)
+		ch <- prometheus.MustNewConstMetric(
+			openvpn3SessionInfoDesc,
+			prometheus.GaugeValue,
+			1,
+			id, session.SessionName, session.ConfigName, session.DeviceName)
+		ch <- prometheus.MustNewConstMetric(
+			openvpn3SessionCreatedDesc,
+			prometheus.GaugeValue,
+			float64(session.Created),
+			id)
+		names := make([]string, 0, len(session.Statistics))
+		for name := range session.Statistics {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if desc, ok := openvpn3StatisticDescs[name]; ok {
+				ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(session.Statistics[name]), id)
+			}
+		}
+	}
+}
+
+// Lists the sessions of the session manager, along with their
+// properties. Sessions that disappear while they are listed are skipped.
+func (c *OpenVPN3Collector) sessions(ctx context.Context) ([]openvpn3Session, error) {
+	values, err := busctl(ctx, "call", openvpn3Service, openvpn3Root, openvpn3Interface, "FetchAvailableSessions")
+	if err != nil {
+		return nil, err
+	}
+	// The method returns a single array of object paths.
+	var paths [][]string
+	if len(values) != 1 {
+		return nil, fmt.Errorf("unexpected reply to FetchAvailableSessions: %d values", len(values))
+	}
+	if err := json.Unmarshal(values[0].Data, &paths); err != nil || len(paths) != 1 {
+		return nil, fmt.Errorf("unexpected reply to FetchAvailableSessions: %s", values[0].Data)
+	}
+
+	var sessions []openvpn3Session
+	for _, sessionPath := range paths[0] {
+		session := openvpn3Session{Path: sessionPath}
+		properties := []interface{}{
+			&session.SessionName,
+			&session.ConfigName,
+			&session.DeviceName,
+			&session.Created,
+			&session.Statistics,
+		}
+		values, err := busctl(ctx, "get-property", openvpn3Service, sessionPath, openvpn3Interface,
+			"session_name", "config_name", "device_name", "session_created", "statistics")
+		if err == nil && len(values) != len(properties) {
+			err = fmt.Errorf("expected %d properties, got %d", len(properties), len(values))
+		}
+		for i := 0; err == nil && i < len(values); i++ {
+			err = json.Unmarshal(values[i].Data, properties[i])
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			c.logger.Debug("Skipping openvpn3 session", "session", sessionPath, "err", err)
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+// A value in the JSON output of busctl.
+type busctlValue struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Runs busctl on the system bus, returning the values it prints.
+func busctl(ctx context.Context, args ...string) ([]busctlValue, error) {
+	out, err := runCommand(ctx, append([]string{"busctl", "--system", "--json=short"}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	var values []busctlValue
+	decoder := json.NewDecoder(bytes.NewReader(out))
+	for {
+		var value busctlValue
+		if err := decoder.Decode(&value); err == io.EOF {
+			return values, nil
+		} else if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+}
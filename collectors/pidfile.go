@@ -0,0 +1,83 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	instanceProcessUpDesc = prometheus.NewDesc(
+		"openvpn_instance_process_up",
+		"Whether the OpenVPN process of the pidfile of an instance is running.",
+		[]string{"instance"}, nil)
+	instanceProcessStartTimeDesc = prometheus.NewDesc(
+		"openvpn_instance_process_start_time_seconds",
+		"Start time of the OpenVPN process of an instance, in seconds since the epoch.",
+		[]string{"instance"}, nil)
+)
+
+// PidfileCollector exports whether the OpenVPN processes of instances are
+// running, according to the process IDs that OpenVPN writes to the
+// pidfiles given by its --writepid option. Processes are looked up in
+// /proc, so this requires Linux.
+type PidfileCollector struct {
+	pidfiles map[string]string
+	logger   *slog.Logger
+}
+
+// NewPidfileCollector creates a collector for the pidfiles of instances,
+// given by instance name.
+func NewPidfileCollector(pidfiles map[string]string, logger *slog.Logger) *PidfileCollector {
+	return &PidfileCollector{pidfiles: pidfiles, logger: logger}
+}
+
+func (c *PidfileCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- instanceProcessUpDesc
+	ch <- instanceProcessStartTimeDesc
+}
+
+func (c *PidfileCollector) Collect(ch chan<- prometheus.Metric) {
+	for instance, pidfile := range c.pidfiles {
+		startTime, err := pidfileStartTime(pidfile)
+		if err != nil {
+			c.logger.Debug("OpenVPN process of instance not running", "instance", instance, "err", err)
+			ch <- prometheus.MustNewConstMetric(instanceProcessUpDesc, prometheus.GaugeValue, 0, instance)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(instanceProcessUpDesc, prometheus.GaugeValue, 1, instance)
+		ch <- prometheus.MustNewConstMetric(instanceProcessStartTimeDesc, prometheus.GaugeValue, startTime, instance)
+	}
+}
+
+// Returns the start time of the OpenVPN process of a pidfile. Processes
+// of which the pidfile was left behind are recognized by their ID having
+// been reused by another program.
+func pidfileStartTime(pidfile string) (float64, error) {
+	data, err := os.ReadFile(pidfile)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("invalid process ID in %s", pidfile)
+	}
+	return processStartTime(pid)
+}
@@ -0,0 +1,309 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/kumina/openvpn_exporter/config"
+	"github.com/kumina/openvpn_exporter/exporters"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Number of buckets into which the window of a quota is divided. Traffic
+// leaves the window one bucket at a time.
+const quotaBuckets = 100
+
+// Duration after which the clients of status paths that weren't read
+// anew are no longer considered connected.
+const quotaSessionExpiry = 10 * time.Minute
+
+var (
+	quotaLimitDesc = prometheus.NewDesc(
+		"openvpn_quota_limit_bytes",
+		"Number of bytes a client may transfer within the window of a quota.",
+		[]string{"quota"}, nil)
+	quotaWindowDesc = prometheus.NewDesc(
+		"openvpn_quota_window_seconds",
+		"Length of the rolling window of a quota.",
+		[]string{"quota"}, nil)
+	quotaLabels   = []string{"quota", "common_name"}
+	quotaUsedDesc = prometheus.NewDesc(
+		"openvpn_server_client_quota_used_bytes",
+		"Amount of data transferred by a client within the window of a quota, in bytes.",
+		quotaLabels, nil)
+	quotaRemainingDesc = prometheus.NewDesc(
+		"openvpn_server_client_quota_remaining_bytes",
+		"Amount of data a client may still transfer within the window of a quota, in bytes.",
+		quotaLabels, nil)
+	quotaOverDesc = prometheus.NewDesc(
+		"openvpn_server_client_over_quota",
+		"Whether a client transferred more data than its quota allows within the window.",
+		quotaLabels, nil)
+)
+
+// QuotaCollector keeps track of the traffic of clients within the rolling
+// windows of quotas, exporting how much of their quota clients used and
+// whether they exceeded it. Add it to the relabelers of the pipeline of
+// an exporter, so that it sees the client list entries that pass the
+// filters.
+//
+// Traffic is computed from the changes of the byte counters of client
+// sessions between reads. The traffic of sessions that were already
+// connected when a status path was first read is counted from then on.
+// Clients connected to several servers share their quota.
+type QuotaCollector struct {
+	quotas []config.Quota
+	key    string
+	logger *slog.Logger
+
+	mu sync.Mutex
+	// Traffic of the sessions of the last successful read of each status
+	// path, and of the read in progress.
+	sessions map[string]quotaSessions
+	pending  map[string]map[clientSession]clientTraffic
+	// Traffic within the window of each quota, by common name.
+	usage []map[string][]quotaBucket
+}
+
+// The sessions of a status path as last read.
+type quotaSessions struct {
+	traffic map[clientSession]clientTraffic
+	read    time.Time
+}
+
+// Traffic of a client that started within a part of the window.
+type quotaBucket struct {
+	Start time.Time
+	clientTraffic
+}
+
+// NewQuotaCollector creates a collector for quotas. Its state is persisted
+// under the given key.
+func NewQuotaCollector(quotas []config.Quota, key string, logger *slog.Logger) *QuotaCollector {
+	c := &QuotaCollector{
+		quotas:   quotas,
+		key:      key,
+		logger:   logger,
+		sessions: map[string]quotaSessions{},
+		pending:  map[string]map[clientSession]clientTraffic{},
+		usage:    make([]map[string][]quotaBucket, len(quotas)),
+	}
+	for i := range c.usage {
+		c.usage[i] = map[string][]quotaBucket{}
+	}
+	return c
+}
+
+func (c *QuotaCollector) BeginRead(statusPath string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending[statusPath] = map[clientSession]clientTraffic{}
+}
+
+// Relabel records the traffic of the session of a client list entry. The
+// entry is left unchanged.
+func (c *QuotaCollector) Relabel(entry *exporters.Entry) {
+	session, traffic, ok := entrySession(entry)
+	if !ok {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if pending, ok := c.pending[entry.StatusPath]; ok {
+		pending[session] = traffic
+	}
+}
+
+// EndRead adds the traffic of the sessions of a status path since its
+// previous read to the usage of their clients.
+func (c *QuotaCollector) EndRead(statusPath string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	previous, readBefore := c.sessions[statusPath]
+	for session, traffic := range c.pending[statusPath] {
+		var delta clientTraffic
+		if last, ok := previous.traffic[session]; ok {
+			delta = traffic.since(last)
+		} else if readBefore {
+			// The session started since the previous read.
+			delta = traffic
+		}
+		if delta.Received > 0 || delta.Sent > 0 {
+			c.addUsage(session.CommonName, delta, now)
+		}
+	}
+	c.sessions[statusPath] = quotaSessions{traffic: c.pending[statusPath], read: now}
+	delete(c.pending, statusPath)
+}
+
+// Adds traffic of a client to the usage of the quotas that apply to it.
+func (c *QuotaCollector) addUsage(commonName string, traffic clientTraffic, now time.Time) {
+	for i, quota := range c.quotas {
+		if !quota.CommonNames.Matches(commonName) {
+			continue
+		}
+		start := now.Truncate(time.Duration(quota.Window) / quotaBuckets)
+		buckets := c.usage[i][commonName]
+		if n := len(buckets); n > 0 && buckets[n-1].Start.Equal(start) {
+			buckets[n-1].Received += traffic.Received
+			buckets[n-1].Sent += traffic.Sent
+			continue
+		}
+		c.usage[i][commonName] = append(buckets, quotaBucket{Start: start, clientTraffic: traffic})
+	}
+}
+
+// Returns the common names of the clients of the sessions of status paths
+// that were read recently. The sessions of other status paths are kept,
+// so that their traffic is counted once they are read again.
+func (c *QuotaCollector) connectedClients(now time.Time) map[string]bool {
+	clients := map[string]bool{}
+	for _, sessions := range c.sessions {
+		if now.Sub(sessions.read) > quotaSessionExpiry {
+			continue
+		}
+		for session := range sessions.traffic {
+			clients[session.CommonName] = true
+		}
+	}
+	return clients
+}
+
+func (c *QuotaCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- quotaLimitDesc
+	ch <- quotaWindowDesc
+	ch <- quotaUsedDesc
+	ch <- quotaRemainingDesc
+	ch <- quotaOverDesc
+}
+
+func (c *QuotaCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	connected := c.connectedClients(now)
+	for i, quota := range c.quotas {
+		window := time.Duration(quota.Window)
+		ch <- prometheus.MustNewConstMetric(quotaLimitDesc, prometheus.GaugeValue, float64(quota.Bytes), quota.Name)
+		ch <- prometheus.MustNewConstMetric(quotaWindowDesc, prometheus.GaugeValue, window.Seconds(), quota.Name)
+
+		// Clients are exported while they are connected or have traffic
+		// within the window.
+		used := map[string]float64{}
+		for commonName := range connected {
+			if quota.CommonNames.Matches(commonName) {
+				used[commonName] = 0
+			}
+		}
+		for commonName, buckets := range c.usage[i] {
+			// Drop the buckets that left the window.
+			first := 0
+			for first < len(buckets) && now.Sub(buckets[first].Start) >= window {
+				first++
+			}
+			buckets = buckets[first:]
+			if len(buckets) == 0 {
+				delete(c.usage[i], commonName)
+				continue
+			}
+			c.usage[i][commonName] = buckets
+			for _, bucket := range buckets {
+				used[commonName] += bucket.direction(quota.Direction)
+			}
+		}
+
+		for commonName, bytes := range used {
+			remaining, over := float64(quota.Bytes)-bytes, 0.0
+			if remaining < 0 {
+				remaining, over = 0, 1
+			}
+			ch <- prometheus.MustNewConstMetric(quotaUsedDesc, prometheus.GaugeValue, bytes, quota.Name, commonName)
+			ch <- prometheus.MustNewConstMetric(quotaRemainingDesc, prometheus.GaugeValue, remaining, quota.Name, commonName)
+			ch <- prometheus.MustNewConstMetric(quotaOverDesc, prometheus.GaugeValue, over, quota.Name, commonName)
+		}
+	}
+}
+
+// The persisted state of quotas.
+type quotaState struct {
+	Sessions []quotaSavedSessions `json:"sessions"`
+	// Buckets by quota name and common name.
+	Usage map[string]map[string][]quotaBucket `json:"usage"`
+}
+
+type quotaSavedSessions struct {
+	StatusPath string                `json:"status_path"`
+	Read       time.Time             `json:"read"`
+	Sessions   []quotaSessionTraffic `json:"sessions"`
+}
+
+type quotaSessionTraffic struct {
+	clientSession
+	clientTraffic
+}
+
+// StateKey returns the key under which the state of the quotas is
+// persisted.
+func (c *QuotaCollector) StateKey() string {
+	return c.key
+}
+
+// MarshalState returns the usage of the quotas and the traffic of the
+// sessions as last read.
+func (c *QuotaCollector) MarshalState() ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := quotaState{Usage: map[string]map[string][]quotaBucket{}}
+	for path, sessions := range c.sessions {
+		saved := quotaSavedSessions{StatusPath: path, Read: sessions.read}
+		for session, traffic := range sessions.traffic {
+			saved.Sessions = append(saved.Sessions, quotaSessionTraffic{session, traffic})
+		}
+		s.Sessions = append(s.Sessions, saved)
+	}
+	for i, quota := range c.quotas {
+		s.Usage[quota.Name] = c.usage[i]
+	}
+	return json.Marshal(s)
+}
+
+// UnmarshalState restores the usage of the quotas that are still
+// configured, and the traffic of the sessions as last read, so that
+// traffic while the exporter wasn't running is counted as well.
+func (c *QuotaCollector) UnmarshalState(data []byte) error {
+	var s quotaState
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, saved := range s.Sessions {
+		sessions := quotaSessions{traffic: map[clientSession]clientTraffic{}, read: saved.Read}
+		for _, session := range saved.Sessions {
+			sessions.traffic[session.clientSession] = session.clientTraffic
+		}
+		c.sessions[saved.StatusPath] = sessions
+	}
+	for i, quota := range c.quotas {
+		if usage, ok := s.Usage[quota.Name]; ok {
+			c.usage[i] = usage
+		}
+	}
+	return nil
+}
@@ -0,0 +1,479 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/kumina/openvpn_exporter/config"
+	"github.com/kumina/openvpn_exporter/exporters"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Codes of RADIUS packets and the attributes of accounting requests that
+// are used, as defined by RFC 2865, RFC 2866 and RFC 2869.
+const (
+	radiusAccountingRequest  = 4
+	radiusAccountingResponse = 5
+
+	radiusUserName            = 1
+	radiusNASIPAddress        = 4
+	radiusFramedIPAddress     = 8
+	radiusNASIdentifier       = 32
+	radiusAcctStatusType      = 40
+	radiusAcctInputOctets     = 42
+	radiusAcctOutputOctets    = 43
+	radiusAcctSessionID       = 44
+	radiusAcctSessionTime     = 46
+	radiusAcctInputPackets    = 47
+	radiusAcctOutputPackets   = 48
+	radiusAcctInputGigawords  = 52
+	radiusAcctOutputGigawords = 53
+)
+
+// Values of the Acct-Status-Type attribute.
+var radiusStatusTypes = map[uint32]string{
+	1: "start",
+	2: "stop",
+	3: "interim_update",
+	7: "accounting_on",
+	8: "accounting_off",
+}
+
+// Duration after which sessions without accounting are forgotten, such
+// as those of which the stop was lost.
+const radiusSessionExpiry = 24 * time.Hour
+
+var (
+	radiusRequestsDesc = prometheus.NewDesc(
+		"openvpn_radius_requests_total",
+		"Number of valid RADIUS accounting requests received, by status type.",
+		[]string{"status_type"}, nil)
+	radiusInvalidRequestsDesc = prometheus.NewDesc(
+		"openvpn_radius_invalid_requests_total",
+		"Number of RADIUS packets received that weren't valid accounting requests, such as those signed with another secret.",
+		nil, nil)
+	radiusSessionsDesc = prometheus.NewDesc(
+		"openvpn_radius_sessions",
+		"Number of sessions started according to RADIUS accounting.",
+		nil, nil)
+	radiusStoppedSessionsDesc = prometheus.NewDesc(
+		"openvpn_radius_stopped_sessions_total",
+		"Number of sessions stopped according to RADIUS accounting.",
+		nil, nil)
+	radiusStoppedReceivedDesc = prometheus.NewDesc(
+		"openvpn_radius_stopped_sessions_received_bytes_total",
+		"Amount of data received from clients in sessions that were stopped, in bytes.",
+		nil, nil)
+	radiusStoppedSentDesc = prometheus.NewDesc(
+		"openvpn_radius_stopped_sessions_sent_bytes_total",
+		"Amount of data sent to clients in sessions that were stopped, in bytes.",
+		nil, nil)
+
+	radiusClientLabels       = []string{"status_path", "common_name", "virtual_address", "user_name", "session_id"}
+	radiusClientReceivedDesc = prometheus.NewDesc(
+		"openvpn_server_client_radius_received_bytes_total",
+		"Amount of data received from a client in its current session, in bytes, according to RADIUS accounting.",
+		radiusClientLabels, nil)
+	radiusClientSentDesc = prometheus.NewDesc(
+		"openvpn_server_client_radius_sent_bytes_total",
+		"Amount of data sent to a client in its current session, in bytes, according to RADIUS accounting.",
+		radiusClientLabels, nil)
+	radiusClientReceivedPacketsDesc = prometheus.NewDesc(
+		"openvpn_server_client_radius_received_packets_total",
+		"Number of packets received from a client in its current session, according to RADIUS accounting.",
+		radiusClientLabels, nil)
+	radiusClientSentPacketsDesc = prometheus.NewDesc(
+		"openvpn_server_client_radius_sent_packets_total",
+		"Number of packets sent to a client in its current session, according to RADIUS accounting.",
+		radiusClientLabels, nil)
+	radiusClientSessionTimeDesc = prometheus.NewDesc(
+		"openvpn_server_client_radius_session_seconds",
+		"Duration of the current session of a client at its latest accounting, in seconds.",
+		radiusClientLabels, nil)
+)
+
+// RADIUSAccounting receives the RADIUS accounting requests that OpenVPN's
+// RADIUS plugin sends when sessions start and stop and in between,
+// keeping track of the counters of the sessions that are running. Its
+// own metrics summarize the requests and sessions; the counters of
+// connected clients are exported by a RADIUSCollector.
+type RADIUSAccounting struct {
+	address string
+	secret  []byte
+	logger  *slog.Logger
+
+	mu              sync.Mutex
+	sessions        map[radiusSessionKey]*radiusSession
+	requests        map[string]float64
+	invalidRequests float64
+	stopped         float64
+	stoppedReceived float64
+	stoppedSent     float64
+}
+
+// A session is identified by its NAS and its Acct-Session-Id.
+type radiusSessionKey struct {
+	NAS       string
+	SessionID string
+}
+
+type radiusSession struct {
+	UserName        string
+	FramedIPAddress string
+	ReceivedBytes   uint64
+	SentBytes       uint64
+	ReceivedPackets uint32
+	SentPackets     uint32
+	SessionTime     uint32
+	updated         time.Time
+}
+
+// NewRADIUSAccounting creates a receiver of RADIUS accounting requests,
+// resolving the shared secret.
+func NewRADIUSAccounting(c *config.RADIUS, logger *slog.Logger) (*RADIUSAccounting, error) {
+	secret, err := c.Secret.Resolve()
+	if err != nil {
+		return nil, fmt.Errorf("resolving radius secret: %w", err)
+	}
+	address := c.ListenAddress
+	if address == "" {
+		address = ":1813"
+	}
+	return &RADIUSAccounting{
+		address:  address,
+		secret:   []byte(secret),
+		logger:   logger,
+		sessions: map[radiusSessionKey]*radiusSession{},
+		requests: map[string]float64{},
+	}, nil
+}
+
+// ListenAndServe receives accounting requests until the context is done.
+func (a *RADIUSAccounting) ListenAndServe(ctx context.Context) error {
+	conn, err := net.ListenPacket("udp", a.address)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	buf := make([]byte, 4096)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		response, err := a.handle(buf[:n], addr)
+		if err != nil {
+			a.logger.Debug("Ignoring invalid RADIUS packet", "address", addr, "err", err)
+			a.mu.Lock()
+			a.invalidRequests++
+			a.mu.Unlock()
+			continue
+		}
+		if _, err := conn.WriteTo(response, addr); err != nil {
+			a.logger.Warn("Failed to respond to RADIUS accounting request", "address", addr, "err", err)
+		}
+	}
+}
+
+// Handles an accounting request, returning the response.
+func (a *RADIUSAccounting) handle(packet []byte, addr net.Addr) ([]byte, error) {
+	if len(packet) < 20 {
+		return nil, errors.New("packet too short")
+	}
+	length := int(binary.BigEndian.Uint16(packet[2:4]))
+	if length < 20 || length > len(packet) {
+		return nil, fmt.Errorf("invalid length %d", length)
+	}
+	packet = packet[:length]
+	if packet[0] != radiusAccountingRequest {
+		return nil, fmt.Errorf("unexpected code %d", packet[0])
+	}
+	// The request authenticator is the MD5 hash of the packet with a
+	// zeroed authenticator, followed by the secret.
+	h := md5.New()
+	h.Write(packet[:4])
+	h.Write(make([]byte, 16))
+	h.Write(packet[20:])
+	h.Write(a.secret)
+	if !bytes.Equal(h.Sum(nil), packet[4:20]) {
+		return nil, errors.New("invalid request authenticator")
+	}
+	attributes, err := parseRADIUSAttributes(packet[20:])
+	if err != nil {
+		return nil, err
+	}
+	a.account(attributes, addr)
+
+	// The response authenticator is the MD5 hash of the response with
+	// the request authenticator, followed by the secret.
+	response := make([]byte, 20)
+	response[0] = radiusAccountingResponse
+	response[1] = packet[1]
+	binary.BigEndian.PutUint16(response[2:4], 20)
+	h = md5.New()
+	h.Write(response[:4])
+	h.Write(packet[4:20])
+	h.Write(a.secret)
+	copy(response[4:20], h.Sum(nil))
+	return response, nil
+}
+
+// Returns the attributes of a packet by type. Of attributes occurring
+// multiple times, the last one is kept.
+func parseRADIUSAttributes(data []byte) (map[byte][]byte, error) {
+	attributes := map[byte][]byte{}
+	for len(data) > 0 {
+		if len(data) < 2 || data[1] < 2 || int(data[1]) > len(data) {
+			return nil, errors.New("truncated attribute")
+		}
+		attributes[data[0]] = data[2:data[1]]
+		data = data[data[1]:]
+	}
+	return attributes, nil
+}
+
+// Returns the value of an integer attribute, or 0 if it's missing.
+func radiusInteger(attributes map[byte][]byte, t byte) uint32 {
+	if value := attributes[t]; len(value) == 4 {
+		return binary.BigEndian.Uint32(value)
+	}
+	return 0
+}
+
+// Updates the sessions with an accounting request.
+func (a *RADIUSAccounting) account(attributes map[byte][]byte, addr net.Addr) {
+	// Sessions are identified by the NAS, which is the sender unless the
+	// request says otherwise.
+	nas := string(attributes[radiusNASIdentifier])
+	if value := attributes[radiusNASIPAddress]; len(value) == 4 {
+		nas = net.IP(value).String()
+	}
+	if nas == "" {
+		if udp, ok := addr.(*net.UDPAddr); ok {
+			nas = udp.IP.String()
+		}
+	}
+	statusType := radiusStatusTypes[radiusInteger(attributes, radiusAcctStatusType)]
+	if statusType == "" {
+		statusType = "other"
+	}
+	key := radiusSessionKey{NAS: nas, SessionID: string(attributes[radiusAcctSessionID])}
+	session := &radiusSession{
+		UserName:        string(attributes[radiusUserName]),
+		ReceivedBytes:   uint64(radiusInteger(attributes, radiusAcctInputGigawords))<<32 | uint64(radiusInteger(attributes, radiusAcctInputOctets)),
+		SentBytes:       uint64(radiusInteger(attributes, radiusAcctOutputGigawords))<<32 | uint64(radiusInteger(attributes, radiusAcctOutputOctets)),
+		ReceivedPackets: radiusInteger(attributes, radiusAcctInputPackets),
+		SentPackets:     radiusInteger(attributes, radiusAcctOutputPackets),
+		SessionTime:     radiusInteger(attributes, radiusAcctSessionTime),
+		updated:         time.Now(),
+	}
+	if value := attributes[radiusFramedIPAddress]; len(value) == 4 {
+		session.FramedIPAddress = net.IP(value).String()
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.requests[statusType]++
+	switch statusType {
+	case "start", "interim_update":
+		a.sessions[key] = session
+	case "stop":
+		// Stops carry the final counters of sessions, even of those that
+		// started before the exporter.
+		delete(a.sessions, key)
+		a.stopped++
+		a.stoppedReceived += float64(session.ReceivedBytes)
+		a.stoppedSent += float64(session.SentBytes)
+	case "accounting_on", "accounting_off":
+		// The NAS restarted, ending all of its sessions.
+		for k := range a.sessions {
+			if k.NAS == nas {
+				delete(a.sessions, k)
+			}
+		}
+	}
+}
+
+// Returns the running sessions by framed IP address, forgetting expired
+// sessions. Of sessions with the same address, the latest one is kept.
+func (a *RADIUSAccounting) sessionsByAddress() map[string]radiusSessionWithID {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	result := map[string]radiusSessionWithID{}
+	now := time.Now()
+	for key, session := range a.sessions {
+		if now.Sub(session.updated) > radiusSessionExpiry {
+			delete(a.sessions, key)
+			continue
+		}
+		if session.FramedIPAddress == "" {
+			continue
+		}
+		if other, ok := result[session.FramedIPAddress]; ok && other.updated.After(session.updated) {
+			continue
+		}
+		result[session.FramedIPAddress] = radiusSessionWithID{*session, key.SessionID}
+	}
+	return result
+}
+
+// State of the accounting that is persisted across restarts.
+type radiusState struct {
+	Sessions        []radiusSavedSession `json:"sessions"`
+	Requests        map[string]float64   `json:"requests"`
+	InvalidRequests float64              `json:"invalid_requests"`
+	Stopped         float64              `json:"stopped"`
+	StoppedReceived float64              `json:"stopped_received"`
+	StoppedSent     float64              `json:"stopped_sent"`
+}
+
+type radiusSavedSession struct {
+	radiusSessionKey
+	radiusSession
+	Updated time.Time
+}
+
+// StateKey returns the key under which the state of the accounting is
+// persisted.
+func (a *RADIUSAccounting) StateKey() string {
+	return "radius"
+}
+
+// MarshalState returns the counters and running sessions of the
+// accounting.
+func (a *RADIUSAccounting) MarshalState() ([]byte, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s := radiusState{
+		Requests:        a.requests,
+		InvalidRequests: a.invalidRequests,
+		Stopped:         a.stopped,
+		StoppedReceived: a.stoppedReceived,
+		StoppedSent:     a.stoppedSent,
+	}
+	for key, session := range a.sessions {
+		s.Sessions = append(s.Sessions, radiusSavedSession{key, *session, session.updated})
+	}
+	return json.Marshal(s)
+}
+
+// UnmarshalState restores the counters and running sessions of the
+// accounting.
+func (a *RADIUSAccounting) UnmarshalState(data []byte) error {
+	var s radiusState
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for statusType, count := range s.Requests {
+		a.requests[statusType] += count
+	}
+	a.invalidRequests += s.InvalidRequests
+	a.stopped += s.Stopped
+	a.stoppedReceived += s.StoppedReceived
+	a.stoppedSent += s.StoppedSent
+	for _, saved := range s.Sessions {
+		session := saved.radiusSession
+		session.updated = saved.Updated
+		a.sessions[saved.radiusSessionKey] = &session
+	}
+	return nil
+}
+
+type radiusSessionWithID struct {
+	radiusSession
+	SessionID string
+}
+
+func (a *RADIUSAccounting) Describe(ch chan<- *prometheus.Desc) {
+	ch <- radiusRequestsDesc
+	ch <- radiusInvalidRequestsDesc
+	ch <- radiusSessionsDesc
+	ch <- radiusStoppedSessionsDesc
+	ch <- radiusStoppedReceivedDesc
+	ch <- radiusStoppedSentDesc
+}
+
+func (a *RADIUSAccounting) Collect(ch chan<- prometheus.Metric) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for statusType, count := range a.requests {
+		ch <- prometheus.MustNewConstMetric(radiusRequestsDesc, prometheus.CounterValue, count, statusType)
+	}
+	ch <- prometheus.MustNewConstMetric(radiusInvalidRequestsDesc, prometheus.CounterValue, a.invalidRequests)
+	ch <- prometheus.MustNewConstMetric(radiusSessionsDesc, prometheus.GaugeValue, float64(len(a.sessions)))
+	ch <- prometheus.MustNewConstMetric(radiusStoppedSessionsDesc, prometheus.CounterValue, a.stopped)
+	ch <- prometheus.MustNewConstMetric(radiusStoppedReceivedDesc, prometheus.CounterValue, a.stoppedReceived)
+	ch <- prometheus.MustNewConstMetric(radiusStoppedSentDesc, prometheus.CounterValue, a.stoppedSent)
+}
+
+// RADIUSCollector exports the counters of the RADIUS accounting sessions
+// of connected clients, matching sessions to clients by their framed IP
+// address and virtual address.
+type RADIUSCollector struct {
+	accounting *RADIUSAccounting
+	clients    *exporters.ClientTracker
+}
+
+// NewRADIUSCollector creates a collector for the sessions of the clients
+// of a tracker.
+func NewRADIUSCollector(accounting *RADIUSAccounting, clients *exporters.ClientTracker) *RADIUSCollector {
+	return &RADIUSCollector{accounting: accounting, clients: clients}
+}
+
+func (c *RADIUSCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- radiusClientReceivedDesc
+	ch <- radiusClientSentDesc
+	ch <- radiusClientReceivedPacketsDesc
+	ch <- radiusClientSentPacketsDesc
+	ch <- radiusClientSessionTimeDesc
+}
+
+func (c *RADIUSCollector) Collect(ch chan<- prometheus.Metric) {
+	sessions := c.accounting.sessionsByAddress()
+	for _, client := range c.clients.Clients() {
+		ip := net.ParseIP(client.VirtualAddress)
+		if ip == nil {
+			continue
+		}
+		session, ok := sessions[ip.String()]
+		if !ok {
+			continue
+		}
+		labels := []string{client.StatusPath, client.CommonName, client.VirtualAddress, session.UserName, session.SessionID}
+		ch <- prometheus.MustNewConstMetric(radiusClientReceivedDesc, prometheus.CounterValue, float64(session.ReceivedBytes), labels...)
+		ch <- prometheus.MustNewConstMetric(radiusClientSentDesc, prometheus.CounterValue, float64(session.SentBytes), labels...)
+		ch <- prometheus.MustNewConstMetric(radiusClientReceivedPacketsDesc, prometheus.CounterValue, float64(session.ReceivedPackets), labels...)
+		ch <- prometheus.MustNewConstMetric(radiusClientSentPacketsDesc, prometheus.CounterValue, float64(session.SentPackets), labels...)
+		ch <- prometheus.MustNewConstMetric(radiusClientSessionTimeDesc, prometheus.GaugeValue, float64(session.SessionTime), labels...)
+	}
+}
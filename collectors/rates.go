@@ -0,0 +1,109 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"time"
+
+	"github.com/kumina/openvpn_exporter/exporters"
+)
+
+// Computes the traffic rates of clients from the changes of the byte
+// counters of their sessions between reads. As OpenVPN rewrites status
+// files periodically, reads in which no counter changed are taken to be
+// of the same contents, and rates span the time between reads in which
+// counters did change. Not safe for concurrent use.
+type rateTracker struct {
+	paths   map[string]*ratePath
+	pending map[string]map[clientSession]clientTraffic
+}
+
+// The sessions of a status path as last read.
+type ratePath struct {
+	traffic map[clientSession]clientTraffic
+	// Time of the last read, and of the last read in which counters
+	// changed.
+	read    time.Time
+	changed time.Time
+	// Traffic rates of the clients, in bytes per second, by common name.
+	rates map[string]clientTraffic
+}
+
+func newRateTracker() rateTracker {
+	return rateTracker{
+		paths:   map[string]*ratePath{},
+		pending: map[string]map[clientSession]clientTraffic{},
+	}
+}
+
+// Starts recording the sessions of a read of a status path.
+func (r *rateTracker) begin(statusPath string) {
+	r.pending[statusPath] = map[clientSession]clientTraffic{}
+}
+
+// Records the traffic of the session of a client list entry.
+func (r *rateTracker) record(entry *exporters.Entry) {
+	session, traffic, ok := entrySession(entry)
+	if !ok {
+		return
+	}
+	if pending, ok := r.pending[entry.StatusPath]; ok {
+		pending[session] = traffic
+	}
+}
+
+// Finishes a read of a status path, computing the rates of its clients if
+// its counters changed since the previous read. Returns the start of the
+// period the new rates cover, and whether there are new rates.
+func (r *rateTracker) end(statusPath string, now time.Time) (time.Time, bool) {
+	traffic := r.pending[statusPath]
+	delete(r.pending, statusPath)
+	path, ok := r.paths[statusPath]
+	if !ok {
+		r.paths[statusPath] = &ratePath{traffic: traffic, read: now, changed: now}
+		return time.Time{}, false
+	}
+	path.read = now
+	if sameTraffic(path.traffic, traffic) {
+		return time.Time{}, false
+	}
+	start := path.changed
+	seconds := now.Sub(start).Seconds()
+	path.rates = map[string]clientTraffic{}
+	for session, current := range traffic {
+		// Sessions that started since the previous read transferred all
+		// of their traffic in between.
+		delta := current.since(path.traffic[session])
+		rate := path.rates[session.CommonName]
+		rate.Received += delta.Received / seconds
+		rate.Sent += delta.Sent / seconds
+		path.rates[session.CommonName] = rate
+	}
+	path.traffic, path.changed = traffic, now
+	return start, true
+}
+
+// Returns whether two reads of a status path have the same sessions and
+// counters.
+func sameTraffic(a, b map[clientSession]clientTraffic) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for session, traffic := range a {
+		if other, ok := b[session]; !ok || other != traffic {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,433 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kumina/openvpn_exporter/pkg/status"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	serverConfigUpDesc = prometheus.NewDesc(
+		"openvpn_server_config_up",
+		"Whether the OpenVPN server configuration could be read.",
+		[]string{"config_path", "instance"}, nil)
+	serverConfigInfoDesc = prometheus.NewDesc(
+		"openvpn_server_config_info",
+		"Options of an OpenVPN server configuration. Options that aren't set are empty, except for the default port and protocol.",
+		[]string{"config_path", "instance", "proto", "port", "cipher", "data_ciphers", "topology", "dev"}, nil)
+	serverConfigKeepaliveIntervalDesc = prometheus.NewDesc(
+		"openvpn_server_config_keepalive_interval_seconds",
+		"Interval of the pings of the keepalive option, in seconds.",
+		[]string{"config_path", "instance"}, nil)
+	serverConfigKeepaliveTimeoutDesc = prometheus.NewDesc(
+		"openvpn_server_config_keepalive_timeout_seconds",
+		"Timeout of the keepalive option, in seconds.",
+		[]string{"config_path", "instance"}, nil)
+	serverConfigMaxClientsDesc = prometheus.NewDesc(
+		"openvpn_server_config_max_clients",
+		"Maximum number of concurrently connected clients given by the max-clients option.",
+		[]string{"config_path", "instance"}, nil)
+	serverConfigRenegDesc = prometheus.NewDesc(
+		"openvpn_server_config_reneg_seconds",
+		"Interval of data channel key renegotiation given by the reneg-sec option, in seconds.",
+		[]string{"config_path", "instance"}, nil)
+	serverPoolLeasesDesc = prometheus.NewDesc(
+		"openvpn_server_pool_persisted_leases",
+		"Number of leases of virtual addresses in the file of the ifconfig-pool-persist option.",
+		[]string{"config_path", "instance"}, nil)
+	serverPoolLeaseAgeDesc = prometheus.NewDesc(
+		"openvpn_server_pool_lease_age_seconds",
+		"Time since the client holding a persisted lease was last seen connected by the exporter, or since the lease was first seen if the client wasn't.",
+		[]string{"config_path", "instance", "common_name", "virtual_address"}, nil)
+	serverCCDFilesDesc = prometheus.NewDesc(
+		"openvpn_server_ccd_files",
+		"Number of client specific configuration files in the directory of the client-config-dir option, besides DEFAULT.",
+		[]string{"config_path", "instance"}, nil)
+	serverCCDStaticAddressesDesc = prometheus.NewDesc(
+		"openvpn_server_ccd_static_addresses",
+		"Number of client specific configuration files assigning a static address using ifconfig-push.",
+		[]string{"config_path", "instance"}, nil)
+	serverClientHasCCDDesc = prometheus.NewDesc(
+		"openvpn_server_client_has_ccd",
+		"Whether a client has a client specific configuration file, for clients that are connected, hold a persisted lease or have such a file.",
+		[]string{"config_path", "instance", "common_name"}, nil)
+)
+
+// ServerConfigFile is an OpenVPN server configuration file, optionally of
+// a named instance.
+type ServerConfigFile struct {
+	Path     string
+	Instance string
+}
+
+// ServerConfigCollector exports the options of OpenVPN server
+// configuration files, making differences in configuration across servers
+// visible.
+//
+// Servers using the ifconfig-pool-persist option keep the virtual
+// addresses of clients reserved between connections. The leases don't
+// say when they were last used, so the collector remembers when it last
+// saw each client connected in the status file of the status option.
+type ServerConfigCollector struct {
+	files  []ServerConfigFile
+	logger *slog.Logger
+
+	mu sync.Mutex
+	// Time at which the client holding a lease was last connected, by
+	// configuration path and common name.
+	lastSeen map[string]map[string]time.Time
+}
+
+// NewServerConfigCollector creates a collector for the configuration
+// files.
+func NewServerConfigCollector(files []ServerConfigFile, logger *slog.Logger) *ServerConfigCollector {
+	return &ServerConfigCollector{files: files, logger: logger, lastSeen: map[string]map[string]time.Time{}}
+}
+
+func (c *ServerConfigCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- serverConfigUpDesc
+	ch <- serverConfigInfoDesc
+	ch <- serverConfigKeepaliveIntervalDesc
+	ch <- serverConfigKeepaliveTimeoutDesc
+	ch <- serverConfigMaxClientsDesc
+	ch <- serverConfigRenegDesc
+	ch <- serverPoolLeasesDesc
+	ch <- serverPoolLeaseAgeDesc
+	ch <- serverCCDFilesDesc
+	ch <- serverCCDStaticAddressesDesc
+	ch <- serverClientHasCCDDesc
+}
+
+func (c *ServerConfigCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, file := range c.files {
+		path, labels := file.Path, []string{file.Path, file.Instance}
+		options, err := readOpenVPNConfig(path)
+		if err != nil {
+			c.logger.Error("Failed to read OpenVPN server configuration", "config_path", path, "err", err)
+			ch <- prometheus.MustNewConstMetric(serverConfigUpDesc, prometheus.GaugeValue, 0, labels...)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(serverConfigUpDesc, prometheus.GaugeValue, 1, labels...)
+		proto, port := options.arg("proto", 0), options.arg("port", 0)
+		if proto == "" {
+			proto = "udp"
+		}
+		if port == "" {
+			port = "1194"
+		}
+		ch <- prometheus.MustNewConstMetric(
+			serverConfigInfoDesc,
+			prometheus.GaugeValue,
+			1,
+			append(labels, proto, port, options.arg("cipher", 0), options.arg("data-ciphers", 0), options.arg("topology", 0), options.arg("dev", 0))...)
+		for _, knob := range []struct {
+			desc     *prometheus.Desc
+			name     string
+			argument int
+		}{
+			{serverConfigKeepaliveIntervalDesc, "keepalive", 0},
+			{serverConfigKeepaliveTimeoutDesc, "keepalive", 1},
+			{serverConfigMaxClientsDesc, "max-clients", 0},
+			{serverConfigRenegDesc, "reneg-sec", 0},
+		} {
+			value := options.arg(knob.name, knob.argument)
+			if value == "" {
+				continue
+			}
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				c.logger.Warn("Invalid numeric option in OpenVPN server configuration", "config_path", path, "option", knob.name, "value", value)
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(knob.desc, prometheus.GaugeValue, n, labels...)
+		}
+		if options.arg("ifconfig-pool-persist", 0) == "" && options.arg("client-config-dir", 0) == "" {
+			continue
+		}
+		connected := c.connectedClients(path, options)
+		if options.arg("ifconfig-pool-persist", 0) != "" {
+			c.collectLeases(ch, file, options, connected)
+		}
+		if options.arg("client-config-dir", 0) != "" {
+			c.collectClientConfigs(ch, file, options, connected)
+		}
+	}
+}
+
+// Returns the common names of the clients connected to a server,
+// according to the status file of its status option. Clients of an
+// unreadable status file are taken to be disconnected.
+func (c *ServerConfigCollector) connectedClients(path string, options openvpnConfig) map[string]bool {
+	connected := map[string]bool{}
+	statusPath := options.path("status", path)
+	if statusPath == "" {
+		return connected
+	}
+	f, err := os.Open(statusPath)
+	if err != nil {
+		c.logger.Warn("Failed to read status file of server configuration", "config_path", path, "err", err)
+		return connected
+	}
+	defer f.Close()
+	s, err := status.Parse(f)
+	if err != nil {
+		c.logger.Warn("Failed to parse status file of server configuration", "config_path", path, "err", err)
+		return connected
+	}
+	if s.Server != nil {
+		for _, client := range s.Server.ClientList {
+			connected[client.CommonName] = true
+		}
+	}
+	return connected
+}
+
+// A lease of a virtual address in the file of ifconfig-pool-persist.
+type poolLease struct {
+	CommonName     string
+	VirtualAddress string
+}
+
+func (c *ServerConfigCollector) collectLeases(ch chan<- prometheus.Metric, file ServerConfigFile, options openvpnConfig, connected map[string]bool) {
+	path, labels := file.Path, []string{file.Path, file.Instance}
+	leases, err := readPoolLeases(options.path("ifconfig-pool-persist", path))
+	if err != nil {
+		c.logger.Error("Failed to read persisted pool leases", "config_path", path, "err", err)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	lastSeen := map[string]time.Time{}
+	for _, lease := range leases {
+		seen, ok := c.lastSeen[path][lease.CommonName]
+		if !ok || connected[lease.CommonName] {
+			seen = now
+		}
+		lastSeen[lease.CommonName] = seen
+		ch <- prometheus.MustNewConstMetric(
+			serverPoolLeaseAgeDesc,
+			prometheus.GaugeValue,
+			now.Sub(seen).Seconds(),
+			append(labels, lease.CommonName, lease.VirtualAddress)...)
+	}
+	c.lastSeen[path] = lastSeen
+	ch <- prometheus.MustNewConstMetric(serverPoolLeasesDesc, prometheus.GaugeValue, float64(len(leases)), labels...)
+}
+
+// Exports the client specific configuration files of a server, named by
+// the common names of the clients they apply to.
+func (c *ServerConfigCollector) collectClientConfigs(ch chan<- prometheus.Metric, file ServerConfigFile, options openvpnConfig, connected map[string]bool) {
+	path, labels := file.Path, []string{file.Path, file.Instance}
+	dir := options.path("client-config-dir", path)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		c.logger.Error("Failed to read client config directory", "config_path", path, "err", err)
+		return
+	}
+	hasCCD := map[string]bool{}
+	staticAddresses := 0
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || name == "DEFAULT" || strings.HasPrefix(name, ".") {
+			continue
+		}
+		hasCCD[name] = true
+		ccd, err := readOpenVPNConfig(filepath.Join(dir, name))
+		if err != nil {
+			c.logger.Warn("Failed to read client config file", "config_path", path, "file", name, "err", err)
+			continue
+		}
+		if _, ok := ccd["ifconfig-push"]; ok {
+			staticAddresses++
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(serverCCDFilesDesc, prometheus.GaugeValue, float64(len(hasCCD)), labels...)
+	ch <- prometheus.MustNewConstMetric(serverCCDStaticAddressesDesc, prometheus.GaugeValue, float64(staticAddresses), labels...)
+
+	commonNames := map[string]bool{}
+	for name := range hasCCD {
+		commonNames[name] = true
+	}
+	for name := range connected {
+		commonNames[name] = true
+	}
+	c.mu.Lock()
+	for name := range c.lastSeen[path] {
+		commonNames[name] = true
+	}
+	c.mu.Unlock()
+	for name := range commonNames {
+		value := 0.0
+		if hasCCD[name] {
+			value = 1
+		}
+		ch <- prometheus.MustNewConstMetric(serverClientHasCCDDesc, prometheus.GaugeValue, value, append(labels, name)...)
+	}
+}
+
+// Reads the file of ifconfig-pool-persist, of which each line holds the
+// common name of a client and its IPv4 address, optionally followed by
+// its IPv6 address. OpenVPN only creates the file after a while, so a
+// missing file has no leases.
+func readPoolLeases(path string) ([]poolLease, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var leases []poolLease
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Split(strings.TrimSpace(line), ",")
+		if len(fields) < 2 || fields[0] == "" {
+			continue
+		}
+		leases = append(leases, poolLease{CommonName: fields[0], VirtualAddress: fields[1]})
+	}
+	return leases, nil
+}
+
+// Options of an OpenVPN configuration file by name. Of options given
+// multiple times, the last one is kept, as OpenVPN does for most options.
+type openvpnConfig map[string][]string
+
+// Returns an argument of an option, or the empty string if the option or
+// argument is missing.
+func (c openvpnConfig) arg(name string, i int) string {
+	if args := c[name]; i < len(args) {
+		return args[i]
+	}
+	return ""
+}
+
+// Returns the path given by an option, made absolute relative to the
+// directory OpenVPN runs in: that of its cd option, or else that of the
+// configuration file, as OpenVPN is usually started from there.
+func (c openvpnConfig) path(name, configPath string) string {
+	path := c.arg(name, 0)
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	dir := c.arg("cd", 0)
+	if dir == "" {
+		dir = filepath.Dir(configPath)
+	}
+	return filepath.Join(dir, path)
+}
+
+// Reads an OpenVPN configuration file.
+func readOpenVPNConfig(path string) (openvpnConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseOpenVPNConfig(data)
+}
+
+// Parses an OpenVPN configuration file, of which each line holds an
+// option and its arguments, as given on the command line without the
+// leading dashes. Inline files such as <ca>...</ca> are skipped.
+func parseOpenVPNConfig(data []byte) (openvpnConfig, error) {
+	options := openvpnConfig{}
+	inline := ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if inline != "" {
+			if line == "</"+inline+">" {
+				inline = ""
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "<") && strings.HasSuffix(line, ">") && !strings.HasPrefix(line, "</") {
+			inline = line[1 : len(line)-1]
+			continue
+		}
+		args, err := splitOpenVPNArgs(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		if len(args) == 0 {
+			continue
+		}
+		options[strings.TrimPrefix(args[0], "--")] = args[1:]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if inline != "" {
+		return nil, fmt.Errorf("unterminated inline file <%s>", inline)
+	}
+	return options, nil
+}
+
+// Splits a line of an OpenVPN configuration file into its arguments.
+// Arguments are separated by whitespace and may be quoted with single or
+// double quotes; within double quotes and unquoted arguments, a backslash
+// escapes the next character. Comments start with # or ; at the start of
+// an argument.
+func splitOpenVPNArgs(line string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote == '\'' && c == '\'', quote == '"' && c == '"':
+			quote = 0
+		case quote != '\'' && c == '\\' && i+1 < len(line):
+			i++
+			arg.WriteByte(line[i])
+		case quote != 0:
+			arg.WriteByte(c)
+		case c == '\'' || c == '"':
+			quote = c
+			inArg = true
+		case c == ' ' || c == '\t':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		case (c == '#' || c == ';') && !inArg:
+			i = len(line)
+		default:
+			arg.WriteByte(c)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
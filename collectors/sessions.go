@@ -0,0 +1,83 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"strconv"
+
+	"github.com/kumina/openvpn_exporter/config"
+
+	"github.com/kumina/openvpn_exporter/exporters"
+)
+
+// A session of a client, identified by its client list entry.
+type clientSession struct {
+	CommonName     string
+	RealAddress    string
+	ConnectedSince string
+}
+
+// Traffic of a client session, in bytes, from the server's point of view.
+type clientTraffic struct {
+	Received float64
+	Sent     float64
+}
+
+// Returns the increase of traffic since a previous value. Counters that
+// went backwards restarted from zero.
+func (t clientTraffic) since(previous clientTraffic) clientTraffic {
+	return clientTraffic{Received: increase(previous.Received, t.Received), Sent: increase(previous.Sent, t.Sent)}
+}
+
+// Returns the traffic in a direction, or in both if direction is empty.
+func (t clientTraffic) direction(direction string) float64 {
+	switch direction {
+	case config.DirectionReceived:
+		return t.Received
+	case config.DirectionSent:
+		return t.Sent
+	default:
+		return t.Received + t.Sent
+	}
+}
+
+// Returns the increase of a counter, which restarts from zero if it went
+// backwards.
+func increase(previous, current float64) float64 {
+	if current < previous {
+		return current
+	}
+	return current - previous
+}
+
+// Returns the session and traffic of a client list entry.
+func entrySession(entry *exporters.Entry) (clientSession, clientTraffic, bool) {
+	if entry.Type != "CLIENT_LIST" {
+		return clientSession{}, clientTraffic{}, false
+	}
+	session := clientSession{
+		CommonName:     entry.Columns.Value("Common Name"),
+		RealAddress:    entry.Columns.Value("Real Address"),
+		ConnectedSince: entry.Columns.Value("Connected Since"),
+	}
+	received, err := strconv.ParseFloat(entry.Columns.Value("Bytes Received"), 64)
+	if err != nil {
+		return clientSession{}, clientTraffic{}, false
+	}
+	sent, err := strconv.ParseFloat(entry.Columns.Value("Bytes Sent"), 64)
+	if err != nil {
+		return clientSession{}, clientTraffic{}, false
+	}
+	return session, clientTraffic{Received: received, Sent: sent}, true
+}
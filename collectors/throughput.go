@@ -0,0 +1,121 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"sync"
+	"time"
+
+	"github.com/kumina/openvpn_exporter/exporters"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Duration after which the rates of status paths that weren't read anew
+// are no longer exported.
+const throughputRateExpiry = 10 * time.Minute
+
+var (
+	throughputClientLabels = []string{"status_path", "common_name"}
+	throughputReceivedDesc = prometheus.NewDesc(
+		"openvpn_server_client_received_bytes_per_second",
+		"Rate at which a client received bytes between the last reads in which its counters changed.",
+		throughputClientLabels, nil)
+	throughputSentDesc = prometheus.NewDesc(
+		"openvpn_server_client_sent_bytes_per_second",
+		"Rate at which a client sent bytes between the last reads in which its counters changed.",
+		throughputClientLabels, nil)
+	throughputTotalReceivedDesc = prometheus.NewDesc(
+		"openvpn_server_connected_clients_received_bytes_per_second",
+		"Rate at which all clients of a status path received bytes between the last reads in which their counters changed.",
+		[]string{"status_path"}, nil)
+	throughputTotalSentDesc = prometheus.NewDesc(
+		"openvpn_server_connected_clients_sent_bytes_per_second",
+		"Rate at which all clients of a status path sent bytes between the last reads in which their counters changed.",
+		[]string{"status_path"}, nil)
+)
+
+// ThroughputCollector exports the traffic rates of clients as gauges, for
+// consumers of the exporter's metrics that can't compute rates from the
+// byte counters themselves. Add it to the relabelers of the pipeline of
+// an exporter, so that it sees the client list entries that pass the
+// filters.
+//
+// Rates are computed from the changes of the byte counters of client
+// sessions between reads in which they changed, so they are as fine
+// grained as the interval at which OpenVPN updates its statistics.
+type ThroughputCollector struct {
+	mu    sync.Mutex
+	rates rateTracker
+}
+
+// NewThroughputCollector creates a collector exporting the traffic rates
+// of clients.
+func NewThroughputCollector() *ThroughputCollector {
+	return &ThroughputCollector{rates: newRateTracker()}
+}
+
+func (c *ThroughputCollector) BeginRead(statusPath string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rates.begin(statusPath)
+}
+
+// Relabel records the traffic of the session of a client list entry. The
+// entry is left unchanged.
+func (c *ThroughputCollector) Relabel(entry *exporters.Entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rates.record(entry)
+}
+
+// EndRead computes the rates of the clients of a status path, if its
+// counters changed since the previous read.
+func (c *ThroughputCollector) EndRead(statusPath string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rates.end(statusPath, time.Now())
+}
+
+func (c *ThroughputCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- throughputReceivedDesc
+	ch <- throughputSentDesc
+	ch <- throughputTotalReceivedDesc
+	ch <- throughputTotalSentDesc
+}
+
+func (c *ThroughputCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for statusPath, path := range c.rates.paths {
+		if now.Sub(path.read) > throughputRateExpiry {
+			delete(c.rates.paths, statusPath)
+			continue
+		}
+		// Status paths are only exported once their counters changed,
+		// as there is no rate before.
+		if path.rates == nil {
+			continue
+		}
+		var total clientTraffic
+		for commonName, rate := range path.rates {
+			ch <- prometheus.MustNewConstMetric(throughputReceivedDesc, prometheus.GaugeValue, rate.Received, statusPath, commonName)
+			ch <- prometheus.MustNewConstMetric(throughputSentDesc, prometheus.GaugeValue, rate.Sent, statusPath, commonName)
+			total.Received += rate.Received
+			total.Sent += rate.Sent
+		}
+		ch <- prometheus.MustNewConstMetric(throughputTotalReceivedDesc, prometheus.GaugeValue, total.Received, statusPath)
+		ch <- prometheus.MustNewConstMetric(throughputTotalSentDesc, prometheus.GaugeValue, total.Sent, statusPath)
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/kumina/openvpn_exporter/config"
+	"github.com/kumina/openvpn_exporter/exporters"
+	"github.com/kumina/openvpn_exporter/pkg/status"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Maximum time since the routes of a tunnel were last referenced, unless
+// configured otherwise.
+const defaultMaxRouteAge = 5 * time.Minute
+
+// Duration after which the entries of status paths that weren't read anew
+// are ignored.
+const tunnelReadExpiry = 10 * time.Minute
+
+var tunnelHealthyDesc = prometheus.NewDesc(
+	"openvpn_server_tunnel_healthy",
+	"Whether the gateway of a site-to-site tunnel is connected, with its routes present and recently referenced.",
+	[]string{"common_name"}, nil)
+
+// TunnelCollector exports the health of site-to-site tunnels. A tunnel is
+// healthy if its gateway is connected to a server, and the server's
+// routing table has the routes of the networks behind the gateway, which
+// were referenced recently. Add it to the relabelers of the pipeline of an
+// exporter, so that it sees the client list and routing table entries
+// that pass the filters.
+type TunnelCollector struct {
+	tunnels []config.Tunnel
+	// Whether a common name is that of a tunnel.
+	gateways map[string]bool
+
+	mu sync.Mutex
+	// Entries of the gateways of the last successful read of each status
+	// path, and of the read in progress.
+	reads   map[string]*tunnelRead
+	pending map[string]*tunnelRead
+}
+
+// The client list and routing table entries of the gateways of a read.
+type tunnelRead struct {
+	// Virtual addresses of the connected gateways, by common name.
+	connected map[string]string
+	// Times at which the routes of the gateways were last referenced, by
+	// common name and network.
+	routes map[string]map[string]time.Time
+	read   time.Time
+}
+
+// NewTunnelCollector creates a collector for the health of tunnels.
+func NewTunnelCollector(tunnels []config.Tunnel) *TunnelCollector {
+	c := &TunnelCollector{
+		tunnels:  tunnels,
+		gateways: map[string]bool{},
+		reads:    map[string]*tunnelRead{},
+		pending:  map[string]*tunnelRead{},
+	}
+	for _, tunnel := range tunnels {
+		c.gateways[tunnel.CommonName] = true
+	}
+	return c
+}
+
+func (c *TunnelCollector) BeginRead(statusPath string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending[statusPath] = &tunnelRead{
+		connected: map[string]string{},
+		routes:    map[string]map[string]time.Time{},
+	}
+}
+
+// Relabel records the client list and routing table entries of gateways.
+// The entry is left unchanged.
+func (c *TunnelCollector) Relabel(entry *exporters.Entry) {
+	commonName := entry.Columns.Value("Common Name")
+	if !c.gateways[commonName] {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	read, ok := c.pending[entry.StatusPath]
+	if !ok {
+		return
+	}
+	switch entry.Type {
+	case "CLIENT_LIST":
+		read.connected[commonName] = entry.Columns.Value("Virtual Address")
+	case "ROUTING_TABLE":
+		route := status.NewRoute(entry.Columns)
+		if read.routes[commonName] == nil {
+			read.routes[commonName] = map[string]time.Time{}
+		}
+		network := normalizeNetwork(route.VirtualAddress)
+		if route.LastRef.After(read.routes[commonName][network]) {
+			read.routes[commonName][network] = route.LastRef
+		}
+	}
+}
+
+func (c *TunnelCollector) EndRead(statusPath string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	read := c.pending[statusPath]
+	read.read = time.Now()
+	c.reads[statusPath] = read
+	delete(c.pending, statusPath)
+}
+
+// Returns a network in its canonical form, so that routes are found
+// regardless of how they are written.
+func normalizeNetwork(network string) string {
+	if prefix, err := netip.ParsePrefix(network); err == nil {
+		return prefix.Masked().String()
+	}
+	if addr, err := netip.ParseAddr(network); err == nil {
+		return addr.String()
+	}
+	return network
+}
+
+// Returns whether a tunnel is healthy according to any of the recent reads
+// of status paths.
+func (c *TunnelCollector) healthy(tunnel config.Tunnel, now time.Time) bool {
+	maxAge := time.Duration(tunnel.MaxRouteAge)
+	if maxAge == 0 {
+		maxAge = defaultMaxRouteAge
+	}
+	fresh := func(lastRef time.Time) bool {
+		return !lastRef.IsZero() && now.Sub(lastRef) <= maxAge
+	}
+	for _, read := range c.reads {
+		if now.Sub(read.read) > tunnelReadExpiry {
+			continue
+		}
+		virtualAddress, ok := read.connected[tunnel.CommonName]
+		if !ok {
+			continue
+		}
+		routes := read.routes[tunnel.CommonName]
+		if len(tunnel.Routes) == 0 {
+			for network, lastRef := range routes {
+				if network != normalizeNetwork(virtualAddress) && fresh(lastRef) {
+					return true
+				}
+			}
+			continue
+		}
+		healthy := true
+		for _, network := range tunnel.Routes {
+			if !fresh(routes[normalizeNetwork(network)]) {
+				healthy = false
+				break
+			}
+		}
+		if healthy {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *TunnelCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- tunnelHealthyDesc
+}
+
+func (c *TunnelCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for _, tunnel := range c.tunnels {
+		healthy := 0.0
+		if c.healthy(tunnel, now) {
+			healthy = 1
+		}
+		ch <- prometheus.MustNewConstMetric(tunnelHealthyDesc, prometheus.GaugeValue, healthy, tunnel.CommonName)
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collectors
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	wireguardUpDesc = prometheus.NewDesc(
+		"wireguard_up",
+		"Whether the WireGuard interfaces could be listed.",
+		nil, nil)
+	wireguardInterfaceInfoDesc = prometheus.NewDesc(
+		"wireguard_interface_info",
+		"Information about a WireGuard interface.",
+		[]string{"interface", "public_key", "listen_port"}, nil)
+	wireguardPeersDesc = prometheus.NewDesc(
+		"wireguard_peers",
+		"Number of peers of a WireGuard interface.",
+		[]string{"interface"}, nil)
+	wireguardPeerInfoDesc = prometheus.NewDesc(
+		"wireguard_peer_info",
+		"Information about a peer of a WireGuard interface.",
+		[]string{"interface", "public_key", "endpoint", "allowed_ips"}, nil)
+	wireguardPeerHandshakeDesc = prometheus.NewDesc(
+		"wireguard_peer_latest_handshake_timestamp_seconds",
+		"Time of the latest handshake with a peer, in seconds since the epoch, or 0 if there was none.",
+		[]string{"interface", "public_key"}, nil)
+	wireguardPeerReceivedDesc = prometheus.NewDesc(
+		"wireguard_peer_received_bytes_total",
+		"Amount of data received from a peer, in bytes.",
+		[]string{"interface", "public_key"}, nil)
+	wireguardPeerSentDesc = prometheus.NewDesc(
+		"wireguard_peer_sent_bytes_total",
+		"Amount of data sent to a peer, in bytes.",
+		[]string{"interface", "public_key"}, nil)
+)
+
+// WireGuardCollector exports the peers of the WireGuard interfaces of the
+// host, for gateways running WireGuard alongside OpenVPN. Peers are listed
+// using "wg show all dump", which requires the privileges to administer
+// network interfaces.
+type WireGuardCollector struct {
+	logger  *slog.Logger
+	timeout time.Duration
+}
+
+// NewWireGuardCollector creates a collector for WireGuard peers, giving up
+// on running wg after the timeout, if non-zero.
+func NewWireGuardCollector(logger *slog.Logger, timeout time.Duration) *WireGuardCollector {
+	return &WireGuardCollector{logger: logger, timeout: timeout}
+}
+
+// A WireGuard interface and its peers, along with the properties that are
+// exported. Private and preshared keys are never retained.
+type wireguardInterface struct {
+	Name       string
+	PublicKey  string
+	ListenPort string
+	Peers      []wireguardPeer
+}
+
+type wireguardPeer struct {
+	PublicKey       string
+	Endpoint        string
+	AllowedIPs      string
+	LatestHandshake int64
+	Received        int64
+	Sent            int64
+}
+
+func (c *WireGuardCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- wireguardUpDesc
+	ch <- wireguardInterfaceInfoDesc
+	ch <- wireguardPeersDesc
+	ch <- wireguardPeerInfoDesc
+	ch <- wireguardPeerHandshakeDesc
+	ch <- wireguardPeerReceivedDesc
+	ch <- wireguardPeerSentDesc
+}
+
+func (c *WireGuardCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx := context.Background()
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	out, err := runCommand(ctx, "wg", "show", "all", "dump")
+	var interfaces []wireguardInterface
+	if err == nil {
+		interfaces, err = parseWireGuardDump(out)
+	}
+	if err != nil {
+		c.logger.Error("Failed to list WireGuard interfaces", "err", err)
+		ch <- prometheus.MustNewConstMetric(wireguardUpDesc, prometheus.GaugeValue, 0)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(wireguardUpDesc, prometheus.GaugeValue, 1)
+	for _, i := range interfaces {
+		ch <- prometheus.MustNewConstMetric(
+			wireguardInterfaceInfoDesc,
+			prometheus.GaugeValue,
+			1,
+			i.Name, i.PublicKey, i.ListenPort)
+		ch <- prometheus.MustNewConstMetric(wireguardPeersDesc, prometheus.GaugeValue, float64(len(i.Peers)), i.Name)
+		for _, p := range i.Peers {
+			ch <- prometheus.MustNewConstMetric(
+				wireguardPeerInfoDesc,
+				prometheus.GaugeValue,
+				1,
+				i.Name, p.PublicKey, p.Endpoint, p.AllowedIPs)
+			ch <- prometheus.MustNewConstMetric(
+				wireguardPeerHandshakeDesc,
+				prometheus.GaugeValue,
+				float64(p.LatestHandshake),
+				i.Name, p.PublicKey)
+			ch <- prometheus.MustNewConstMetric(
+				wireguardPeerReceivedDesc,
+				prometheus.CounterValue,
+				float64(p.Received),
+				i.Name, p.PublicKey)
+			ch <- prometheus.MustNewConstMetric(
+				wireguardPeerSentDesc,
+				prometheus.CounterValue,
+				float64(p.Sent),
+				i.Name, p.PublicKey)
+		}
+	}
+}
+
+// Parses the output of "wg show all dump". Its tab separated lines either
+// describe an interface:
+//
+//	interface private-key public-key listen-port fwmark
+//
+// or one of the peers of the interface preceding it:
+//
+//	interface public-key preshared-key endpoint allowed-ips latest-handshake transfer-rx transfer-tx persistent-keepalive
+func parseWireGuardDump(out []byte) ([]wireguardInterface, error) {
+	var interfaces []wireguardInterface
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		fields := strings.Split(scanner.Text(), "\t")
+		switch len(fields) {
+		case 5:
+			interfaces = append(interfaces, wireguardInterface{
+				Name:       fields[0],
+				PublicKey:  fields[2],
+				ListenPort: fields[3],
+			})
+		case 9:
+			if len(interfaces) == 0 || interfaces[len(interfaces)-1].Name != fields[0] {
+				return nil, fmt.Errorf("line %d: peer of unknown interface %q", lineNumber, fields[0])
+			}
+			peer := wireguardPeer{
+				PublicKey:  fields[1],
+				Endpoint:   noneAsEmpty(fields[3]),
+				AllowedIPs: noneAsEmpty(fields[4]),
+			}
+			for i, value := range []*int64{&peer.LatestHandshake, &peer.Received, &peer.Sent} {
+				n, err := strconv.ParseInt(fields[5+i], 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+				}
+				*value = n
+			}
+			last := &interfaces[len(interfaces)-1]
+			last.Peers = append(last.Peers, peer)
+		default:
+			return nil, fmt.Errorf("line %d: unexpected number of fields %d", lineNumber, len(fields))
+		}
+	}
+	return interfaces, scanner.Err()
+}
+
+// Returns the empty string for values that wg prints as "(none)".
+func noneAsEmpty(value string) string {
+	if value == "(none)" {
+		return ""
+	}
+	return value
+}
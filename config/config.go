@@ -0,0 +1,818 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/kumina/openvpn_exporter/pkg/status"
+)
+
+// Supported values for the format of a status path.
+const (
+	FormatAuto     = "auto"
+	FormatClient   = string(status.FormatClient)
+	FormatServerV1 = string(status.FormatServerV1)
+	FormatServerV2 = string(status.FormatServerV2)
+	FormatServerV3 = string(status.FormatServerV3)
+)
+
+// Supported values for the handling of clients that haven't authenticated
+// yet, of which the common name is UNDEF.
+const (
+	UndefClientsExclude = "exclude"
+	UndefClientsCount   = "count"
+	UndefClientsKeep    = "keep"
+)
+
+var (
+	labelNameRE  = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+	tenantNameRE = regexp.MustCompile("^[a-zA-Z0-9_.-]+$")
+)
+
+// Config is the contents of the configuration file passed to the exporter
+// through the -config.file flag.
+type Config struct {
+	StatusPaths []StatusPath `json:"status_paths"`
+	Plugins     []Plugin     `json:"plugins,omitempty"`
+	Tenants     []Tenant     `json:"tenants,omitempty"`
+	Probes      []Probe      `json:"probes,omitempty"`
+	Accounting  []Accounting `json:"accounting,omitempty"`
+	RADIUS      *RADIUS      `json:"radius,omitempty"`
+	Instances   []Instance   `json:"instances,omitempty"`
+	Gateways    []Gateway    `json:"gateways,omitempty"`
+	Quotas      []Quota      `json:"quotas,omitempty"`
+
+	BandwidthThresholds []BandwidthThreshold `json:"bandwidth_thresholds,omitempty"`
+	Tunnels             []Tunnel             `json:"tunnels,omitempty"`
+	// Names or abbreviations of months, mapped to their number, in
+	// addition to those recognized in the timestamps of localized OpenVPN
+	// builds.
+	MonthNames map[string]int `json:"month_names,omitempty"`
+	// Columns of client list and routing table entries exported as
+	// labels, by section, "CLIENT_LIST" or "ROUTING_TABLE", replacing the
+	// default labels of the section.
+	LabelColumns map[string][]LabelColumn `json:"label_columns,omitempty"`
+	// Numeric columns of client list and routing table entries exported
+	// as metrics, by section, in addition to those known to the exporter.
+	MetricColumns map[string][]MetricColumn `json:"metric_columns,omitempty"`
+}
+
+// Types of the metrics of MetricColumn.
+const (
+	MetricTypeCounter = "counter"
+	MetricTypeGauge   = "gauge"
+)
+
+// MetricColumn is a numeric column of client list or routing table entries
+// exported as a metric.
+type MetricColumn struct {
+	Column string `json:"column"`
+	// Name of the metric, following the namespace and the "server"
+	// subsystem, such as "client_dropped_packets_total".
+	Name string `json:"name"`
+	// MetricTypeCounter or MetricTypeGauge, the latter if unset.
+	Type string `json:"type,omitempty"`
+	Help string `json:"help,omitempty"`
+}
+
+// LabelColumn is a column of client list or routing table entries exported
+// as a label.
+type LabelColumn struct {
+	Label  string `json:"label"`
+	Column string `json:"column"`
+}
+
+// Tunnel is a site-to-site tunnel: a gateway client through which the
+// networks behind it are routed.
+type Tunnel struct {
+	// Common name of the gateway.
+	CommonName string `json:"common_name"`
+	// Networks routed through the gateway with iroute, as listed in the
+	// routing table. If unset, any route besides the gateway's virtual
+	// address.
+	Routes []string `json:"routes,omitempty"`
+	// Maximum time since the routes were last referenced, 5 minutes by
+	// default.
+	MaxRouteAge Duration `json:"max_route_age,omitempty"`
+}
+
+// BandwidthThreshold flags clients of which the traffic rate exceeds a
+// limit for a sustained duration.
+type BandwidthThreshold struct {
+	Name string `json:"name"`
+	// Common names of the clients to which the threshold applies. All
+	// clients if unset.
+	CommonNames Filter `json:"common_names,omitempty"`
+	// Rate above which the threshold is exceeded, in bits per second.
+	BitsPerSecond float64 `json:"bits_per_second"`
+	// Duration for which the rate needs to stay above the limit. The
+	// threshold is breached right away if unset.
+	For Duration `json:"for,omitempty"`
+	// Traffic of which the rate is compared, "received" or "sent" from
+	// the server's point of view. Both if unset.
+	Direction string `json:"direction,omitempty"`
+}
+
+// Quota limits the traffic of clients within a rolling window. Every
+// client of which the common name matches has a quota of its own.
+type Quota struct {
+	Name string `json:"name"`
+	// Common names of the clients to which the quota applies. All
+	// clients if unset.
+	CommonNames Filter `json:"common_names,omitempty"`
+	// Number of bytes a client may transfer within the window.
+	Bytes int64 `json:"bytes"`
+	// Length of the rolling window.
+	Window Duration `json:"window"`
+	// Traffic counted against the quota, "received" or "sent" from the
+	// server's point of view. Both if unset.
+	Direction string `json:"direction,omitempty"`
+}
+
+// Gateway is a downstream exporter of which the metrics are scraped and
+// served again, labeled by the gateway's name.
+type Gateway struct {
+	Name string `json:"name"`
+	// URL of the metrics endpoint of the exporter.
+	URL string `json:"url"`
+	// Credentials of the endpoint.
+	Username    string `json:"username,omitempty"`
+	Password    Secret `json:"password,omitempty"`
+	BearerToken Secret `json:"bearer_token,omitempty"`
+	// Maximum duration of a scrape, 10 seconds by default.
+	Timeout Duration `json:"timeout,omitempty"`
+}
+
+// Instance is an OpenVPN daemon of which the metrics are labeled by the
+// instance's name. Its status is read from its status file, or from its
+// management interface if it has none.
+type Instance struct {
+	Name string `json:"name"`
+	// Path of the status file, as for status paths.
+	StatusPath string `json:"status_path,omitempty"`
+	// URL of the management interface, tcp://host:port or
+	// unix:///path/to/socket, and its password.
+	Management         string `json:"management,omitempty"`
+	ManagementPassword Secret `json:"management_password,omitempty"`
+	// Path of the file to which OpenVPN writes its process ID, as given
+	// by --writepid.
+	Pidfile string `json:"pidfile,omitempty"`
+	// Path of the configuration file of the instance.
+	Config string `json:"config,omitempty"`
+	// Name of the tenant whose endpoint serves the metrics of the status
+	// of the instance.
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// InstanceLabel is the label added to the metrics of instances.
+const InstanceLabel = "instance"
+
+// Returns the status paths of the instances.
+func (c *Config) instanceStatusPaths() []StatusPath {
+	var result []StatusPath
+	for _, instance := range c.Instances {
+		sp := StatusPath{
+			Path:    instance.StatusPath,
+			Labels:  map[string]string{InstanceLabel: instance.Name},
+			Pidfile: instance.Pidfile,
+			Tenant:  instance.Tenant,
+		}
+		if sp.Path == "" {
+			sp.Path = instance.Management
+			sp.Password = instance.ManagementPassword
+		}
+		result = append(result, sp)
+	}
+	return result
+}
+
+// RADIUS configures the reception of RADIUS accounting requests sent by
+// OpenVPN's RADIUS plugin, for the session counters of connected clients.
+type RADIUS struct {
+	// Address on which accounting requests are received, ":1813" by
+	// default.
+	ListenAddress string `json:"listen_address,omitempty"`
+	// Secret shared with the RADIUS clients.
+	Secret Secret `json:"secret"`
+}
+
+// Tenant is a named group of status paths, of which the metrics are
+// served at a separate endpoint, /metrics/<name>. Tenants with credentials
+// only accept requests carrying them, instead of those of the web
+// interface.
+type Tenant struct {
+	Name              string `json:"name"`
+	BearerToken       Secret `json:"bearer_token,omitempty"`
+	BasicUsername     string `json:"basic_username,omitempty"`
+	BasicPasswordHash Secret `json:"basic_password_hash,omitempty"`
+}
+
+// Plugin configures an exec plugin: a command that is run on every scrape,
+// writing additional metrics to its standard output.
+type Plugin struct {
+	Name    string   `json:"name"`
+	Command []string `json:"command"`
+	// Output format of the command, "text" (default) or "json".
+	Format string `json:"format,omitempty"`
+	// Maximum duration of the command, 5 seconds by default.
+	Timeout Duration `json:"timeout,omitempty"`
+}
+
+// Supported protocols of probes.
+const (
+	ProbeTCP = "tcp"
+	ProbeUDP = "udp"
+)
+
+// Probe configures an active probe of an OpenVPN server, checking on every
+// scrape whether it can be reached.
+type Probe struct {
+	// Name of the probe, used as the probe label. Defaults to the
+	// address.
+	Name string `json:"name,omitempty"`
+	// Protocol of the probe: "tcp" connects to the server's port, "udp"
+	// starts a handshake.
+	Protocol string `json:"protocol"`
+	// Address of the server, as host:port.
+	Address string `json:"address"`
+	// Maximum duration of the probe, 5 seconds by default.
+	Timeout Duration `json:"timeout,omitempty"`
+	// Static key file of the server's --tls-auth or --tls-crypt option,
+	// used to sign the handshake of UDP probes.
+	TLSAuth  string `json:"tls_auth,omitempty"`
+	TLSCrypt string `json:"tls_crypt,omitempty"`
+	// Key direction of the client for tls-auth, as given by
+	// --key-direction. The key is used in both directions if unset.
+	KeyDirection *int `json:"key_direction,omitempty"`
+	// Digest of the tls-auth HMAC, as given by --auth. Defaults to SHA1.
+	Auth string `json:"auth,omitempty"`
+}
+
+// Supported firewalls and directions of accounting.
+const (
+	FirewallIPTables  = "iptables"
+	FirewallIP6Tables = "ip6tables"
+	FirewallNFTables  = "nftables"
+
+	DirectionReceived = "received"
+	DirectionSent     = "sent"
+)
+
+// Accounting configures a firewall chain or set of which the counters
+// hold the traffic of clients, keyed by their virtual addresses.
+type Accounting struct {
+	// Name of the accounting, used as the accounting label. Defaults to
+	// the firewall, table and chain or set.
+	Name string `json:"name,omitempty"`
+	// Firewall holding the counters: "iptables", "ip6tables" or
+	// "nftables".
+	Firewall string `json:"firewall"`
+	// Address family of the nftables table, "inet" by default.
+	Family string `json:"family,omitempty"`
+	// Table holding the chain or set, "filter" by default.
+	Table string `json:"table,omitempty"`
+	// Chain of which the rules count the traffic of clients.
+	Chain string `json:"chain,omitempty"`
+	// Set of nftables of which the elements are virtual addresses with
+	// counters, counting the traffic in the given direction: "received"
+	// from clients or "sent" to clients.
+	Set       string `json:"set,omitempty"`
+	Direction string `json:"direction,omitempty"`
+}
+
+// DefaultName returns the name of the accounting if none is configured.
+func (a *Accounting) DefaultName() string {
+	table := a.Table
+	if table == "" {
+		table = "filter"
+	}
+	if a.Firewall == FirewallNFTables {
+		family := a.Family
+		if family == "" {
+			family = "inet"
+		}
+		table = family + " " + table
+	}
+	if a.Set != "" {
+		return a.Firewall + " " + table + " @" + a.Set
+	}
+	return a.Firewall + " " + table + " " + a.Chain
+}
+
+// StatusPath holds the options of a single status source. Options that
+// are left unset fall back to the values of the corresponding command
+// line flags.
+type StatusPath struct {
+	// Path of the status file. Besides local paths, this may be a glob
+	// pattern or a URL of a remote source. See package sources.
+	Path string `json:"path"`
+	// Format of the status file. Detected automatically if unset.
+	Format string `json:"format,omitempty"`
+	// Static labels added to all metrics of this status path.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Filter for the common names of which metrics are exported.
+	CommonNames Filter `json:"common_names,omitempty"`
+	// Whether to omit per-connection labels from client metrics.
+	IgnoreIndividuals *bool `json:"ignore_individuals,omitempty"`
+	// Normalization of common names before they are filtered and used as
+	// label values.
+	NormalizeCommonNames *CommonNameNormalization `json:"normalize_common_names,omitempty"`
+	// Handling of clients that haven't authenticated yet, of which the
+	// common name is UNDEF: one of UndefClientsExclude, UndefClientsCount
+	// or UndefClientsKeep.
+	UndefClients string `json:"undef_clients,omitempty"`
+	// Maximum age of the statistics before the status path is
+	// considered to be down.
+	MaxAge Duration `json:"max_age,omitempty"`
+	// Time zone of human readable timestamps, as an IANA name such as
+	// Europe/Amsterdam, for status files written on servers in another
+	// time zone than the exporter's, and layouts of these timestamps as
+	// taken by time.Parse, tried before those written by OpenVPN.
+	TimeZone    string   `json:"time_zone,omitempty"`
+	TimeLayouts []string `json:"time_layouts,omitempty"`
+	// Minimum interval between reads of the status file. Scrapes in
+	// between reuse the metrics of the previous read.
+	RefreshInterval Duration `json:"refresh_interval,omitempty"`
+	// Maximum number of client list and routing table entries collected
+	// per read. Further entries are ignored.
+	MaxRows int `json:"max_rows,omitempty"`
+	// Maximum number of bytes read per read. Larger sources are reported
+	// as down.
+	MaxBytes int64 `json:"max_bytes,omitempty"`
+	// Number of times a read failing with a transient error is retried.
+	// Defaults to the number of retries for the type of the source.
+	Retries *int `json:"retries,omitempty"`
+	// Maximum duration of a read, including its retries, after which the
+	// status path is reported as down.
+	Timeout Duration `json:"timeout,omitempty"`
+	// Whether to take a shared advisory lock on local status files while
+	// reading them, waiting for writers holding an exclusive lock.
+	Lock *bool `json:"lock,omitempty"`
+	// Credentials for HTTP and RouterOS sources. Password is also used
+	// for the management interface.
+	Username    string `json:"username,omitempty"`
+	Password    Secret `json:"password,omitempty"`
+	BearerToken Secret `json:"bearer_token,omitempty"`
+	// SSH private key used for ssh:// sources.
+	SSHIdentityFile string `json:"ssh_identity_file,omitempty"`
+	// Interval at which the DNS SRV records of srv+ status paths are
+	// looked up again, a minute by default.
+	DiscoveryInterval Duration `json:"discovery_interval,omitempty"`
+	// Path of the pidfile of the OpenVPN daemon writing the status, of
+	// which the modification time is taken as the daemon's start time.
+	Pidfile string `json:"pidfile,omitempty"`
+	// Whether a missing status file means that the server is on standby,
+	// such as the passive node of a high availability pair, instead of
+	// down.
+	Standby bool `json:"standby,omitempty"`
+	// Name of the tenant whose endpoint serves the metrics of this status
+	// path, instead of the default one.
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// ParseStatusPath parses a status path as given on the command line. The
+// path may be followed by static labels as ":name=value" suffixes, such as
+// "/run/openvpn/s1.status:name=office:region=eu". Suffixes of which the
+// part before "=" isn't a valid label name, such as the port of a URL, are
+// part of the path.
+func ParseStatusPath(s string) StatusPath {
+	sp := StatusPath{Path: s}
+	for {
+		i := strings.LastIndexByte(sp.Path, ':')
+		if i < 0 {
+			return sp
+		}
+		name, value, ok := strings.Cut(sp.Path[i+1:], "=")
+		if !ok || !labelNameRE.MatchString(name) {
+			return sp
+		}
+		if sp.Labels == nil {
+			sp.Labels = map[string]string{}
+		}
+		// Labels given more than once keep their last value.
+		if _, ok := sp.Labels[name]; !ok {
+			sp.Labels[name] = value
+		}
+		sp.Path = sp.Path[:i]
+	}
+}
+
+// CommonNameNormalization rewrites common names, so that a client keeps
+// its series when its certificate is reissued with a common name that
+// differs only in case, whitespace or domain.
+type CommonNameNormalization struct {
+	// Whether to remove leading and trailing whitespace.
+	Trim bool `json:"trim,omitempty"`
+	// Whether to convert common names to lower case.
+	Lowercase bool `json:"lowercase,omitempty"`
+	// Suffixes removed from common names, such as ".example.com". The
+	// first matching suffix is removed, unless nothing would remain.
+	// Suffixes are matched regardless of case if Lowercase is set.
+	StripSuffixes []string `json:"strip_suffixes,omitempty"`
+}
+
+// IsZero returns whether the normalization leaves common names alone.
+func (n CommonNameNormalization) IsZero() bool {
+	return !n.Trim && !n.Lowercase && len(n.StripSuffixes) == 0
+}
+
+// Apply returns the normalized form of a common name.
+func (n CommonNameNormalization) Apply(commonName string) string {
+	if n.Trim {
+		commonName = strings.TrimSpace(commonName)
+	}
+	if n.Lowercase {
+		commonName = strings.ToLower(commonName)
+	}
+	for _, suffix := range n.StripSuffixes {
+		if n.Lowercase {
+			suffix = strings.ToLower(suffix)
+		}
+		if len(commonName) > len(suffix) && strings.HasSuffix(commonName, suffix) {
+			return commonName[:len(commonName)-len(suffix)]
+		}
+	}
+	return commonName
+}
+
+// Filter selects values using regular expressions. A value matches if it
+// matches any of the Include expressions (or if there are none) and none
+// of the Exclude expressions. Expressions are anchored at both ends.
+type Filter struct {
+	Include []Regexp `json:"include,omitempty"`
+	Exclude []Regexp `json:"exclude,omitempty"`
+}
+
+// Matches returns whether a value passes the filter.
+func (f Filter) Matches(value string) bool {
+	for _, re := range f.Exclude {
+		if re.MatchString(value) {
+			return false
+		}
+	}
+	if len(f.Include) == 0 {
+		return true
+	}
+	for _, re := range f.Include {
+		if re.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Regexp is an anchored regular expression that can be read from JSON.
+type Regexp struct {
+	*regexp.Regexp
+	original string
+}
+
+// NewRegexp compiles an anchored regular expression.
+func NewRegexp(expr string) (Regexp, error) {
+	re, err := regexp.Compile("^(?:" + expr + ")$")
+	if err != nil {
+		return Regexp{}, err
+	}
+	return Regexp{Regexp: re, original: expr}, nil
+}
+
+// UnmarshalJSON compiles the regular expression stored in a JSON string.
+func (r *Regexp) UnmarshalJSON(data []byte) error {
+	var expr string
+	if err := json.Unmarshal(data, &expr); err != nil {
+		return err
+	}
+	re, err := NewRegexp(expr)
+	if err != nil {
+		return err
+	}
+	*r = re
+	return nil
+}
+
+// MarshalJSON returns the regular expression as it was originally given.
+func (r Regexp) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.original)
+}
+
+// Duration is a time.Duration that is stored in JSON as a string, such as
+// "30s" or "5m".
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+// MarshalJSON formats the duration as a string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Load reads and validates a configuration file.
+func Load(filename string) (*Config, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var c Config
+	if err := decoder.Decode(&c); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", filename, err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("validating %s: %w", filename, err)
+	}
+	c.StatusPaths = append(c.StatusPaths, c.instanceStatusPaths()...)
+	return &c, nil
+}
+
+// Validate checks the configuration for consistency.
+func (c *Config) Validate() error {
+	tenantsSeen := map[string]bool{}
+	for _, tenant := range c.Tenants {
+		if !tenantNameRE.MatchString(tenant.Name) {
+			return fmt.Errorf("tenant has invalid name %q", tenant.Name)
+		}
+		if tenantsSeen[tenant.Name] {
+			return fmt.Errorf("tenant %q configured multiple times", tenant.Name)
+		}
+		tenantsSeen[tenant.Name] = true
+	}
+	gatewaysSeen := map[string]bool{}
+	for _, gateway := range c.Gateways {
+		if gateway.Name == "" {
+			return fmt.Errorf("gateway without name")
+		}
+		if gatewaysSeen[gateway.Name] {
+			return fmt.Errorf("gateway %q configured multiple times", gateway.Name)
+		}
+		gatewaysSeen[gateway.Name] = true
+		if u, err := url.Parse(gateway.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("gateway %q has invalid URL %q", gateway.Name, gateway.URL)
+		}
+		if gateway.Timeout < 0 {
+			return fmt.Errorf("gateway %q has a negative timeout", gateway.Name)
+		}
+	}
+	instancesSeen := map[string]bool{}
+	for _, instance := range c.Instances {
+		if instance.Name == "" {
+			return fmt.Errorf("instance without name")
+		}
+		if instancesSeen[instance.Name] {
+			return fmt.Errorf("instance %q configured multiple times", instance.Name)
+		}
+		instancesSeen[instance.Name] = true
+		if instance.StatusPath == "" && instance.Management == "" {
+			return fmt.Errorf("instance %q has neither a status path nor a management interface", instance.Name)
+		}
+	}
+	seen := map[string]bool{}
+	for _, sp := range append(c.StatusPaths[:len(c.StatusPaths):len(c.StatusPaths)], c.instanceStatusPaths()...) {
+		if sp.Path == "" {
+			return fmt.Errorf("status path without path")
+		}
+		if seen[sp.Path] {
+			return fmt.Errorf("status path %q configured multiple times", sp.Path)
+		}
+		seen[sp.Path] = true
+		switch sp.Format {
+		case "", FormatAuto, FormatClient, FormatServerV1, FormatServerV2, FormatServerV3:
+		default:
+			return fmt.Errorf("status path %q has unknown format %q", sp.Path, sp.Format)
+		}
+		switch sp.UndefClients {
+		case "", UndefClientsExclude, UndefClientsCount, UndefClientsKeep:
+		default:
+			return fmt.Errorf("status path %q has unknown handling of UNDEF clients %q", sp.Path, sp.UndefClients)
+		}
+		for name := range sp.Labels {
+			if !labelNameRE.MatchString(name) {
+				return fmt.Errorf("status path %q has invalid label name %q", sp.Path, name)
+			}
+		}
+		if sp.MaxAge < 0 || sp.RefreshInterval < 0 || sp.DiscoveryInterval < 0 || sp.Timeout < 0 {
+			return fmt.Errorf("status path %q has a negative duration", sp.Path)
+		}
+		if sp.MaxRows < 0 {
+			return fmt.Errorf("status path %q has a negative row limit", sp.Path)
+		}
+		if _, err := time.LoadLocation(sp.TimeZone); err != nil {
+			return fmt.Errorf("status path %q has invalid time zone: %w", sp.Path, err)
+		}
+		if sp.MaxBytes < 0 {
+			return fmt.Errorf("status path %q has a negative size limit", sp.Path)
+		}
+		if sp.Retries != nil && *sp.Retries < 0 {
+			return fmt.Errorf("status path %q has a negative number of retries", sp.Path)
+		}
+		if sp.Tenant != "" && !tenantsSeen[sp.Tenant] {
+			return fmt.Errorf("status path %q has unknown tenant %q", sp.Path, sp.Tenant)
+		}
+	}
+	pluginsSeen := map[string]bool{}
+	for _, plugin := range c.Plugins {
+		if plugin.Name == "" || len(plugin.Command) == 0 {
+			return fmt.Errorf("plugin without name or command")
+		}
+		if pluginsSeen[plugin.Name] {
+			return fmt.Errorf("plugin %q configured multiple times", plugin.Name)
+		}
+		pluginsSeen[plugin.Name] = true
+	}
+	probesSeen := map[string]bool{}
+	for _, probe := range c.Probes {
+		name := probe.Name
+		if name == "" {
+			name = probe.Address
+		}
+		if _, _, err := net.SplitHostPort(probe.Address); err != nil {
+			return fmt.Errorf("probe %q has invalid address: %w", name, err)
+		}
+		if probesSeen[name] {
+			return fmt.Errorf("probe %q configured multiple times", name)
+		}
+		probesSeen[name] = true
+		switch probe.Protocol {
+		case ProbeTCP:
+			if probe.TLSAuth != "" || probe.TLSCrypt != "" {
+				return fmt.Errorf("probe %q uses a static key, which is only supported for UDP", name)
+			}
+		case ProbeUDP:
+			if probe.TLSAuth != "" && probe.TLSCrypt != "" {
+				return fmt.Errorf("probe %q uses both tls_auth and tls_crypt", name)
+			}
+		default:
+			return fmt.Errorf("probe %q has unknown protocol %q", name, probe.Protocol)
+		}
+		if probe.KeyDirection != nil && *probe.KeyDirection != 0 && *probe.KeyDirection != 1 {
+			return fmt.Errorf("probe %q has invalid key direction %d", name, *probe.KeyDirection)
+		}
+		if probe.Timeout < 0 {
+			return fmt.Errorf("probe %q has a negative timeout", name)
+		}
+	}
+	if c.RADIUS != nil {
+		if c.RADIUS.Secret.IsEmpty() {
+			return fmt.Errorf("radius has no secret")
+		}
+		if c.RADIUS.ListenAddress != "" {
+			if _, _, err := net.SplitHostPort(c.RADIUS.ListenAddress); err != nil {
+				return fmt.Errorf("radius has invalid listen address: %w", err)
+			}
+		}
+	}
+	quotasSeen := map[string]bool{}
+	for _, quota := range c.Quotas {
+		if quota.Name == "" {
+			return fmt.Errorf("quota without name")
+		}
+		if quotasSeen[quota.Name] {
+			return fmt.Errorf("quota %q configured multiple times", quota.Name)
+		}
+		quotasSeen[quota.Name] = true
+		if quota.Bytes <= 0 {
+			return fmt.Errorf("quota %q has no positive number of bytes", quota.Name)
+		}
+		if quota.Window <= 0 {
+			return fmt.Errorf("quota %q has no positive window", quota.Name)
+		}
+		if quota.Direction != "" && quota.Direction != DirectionReceived && quota.Direction != DirectionSent {
+			return fmt.Errorf("quota %q has invalid direction %q", quota.Name, quota.Direction)
+		}
+	}
+	thresholdsSeen := map[string]bool{}
+	for _, threshold := range c.BandwidthThresholds {
+		if threshold.Name == "" {
+			return fmt.Errorf("bandwidth threshold without name")
+		}
+		if thresholdsSeen[threshold.Name] {
+			return fmt.Errorf("bandwidth threshold %q configured multiple times", threshold.Name)
+		}
+		thresholdsSeen[threshold.Name] = true
+		if threshold.BitsPerSecond <= 0 {
+			return fmt.Errorf("bandwidth threshold %q has no positive rate", threshold.Name)
+		}
+		if threshold.For < 0 {
+			return fmt.Errorf("bandwidth threshold %q has a negative duration", threshold.Name)
+		}
+		if threshold.Direction != "" && threshold.Direction != DirectionReceived && threshold.Direction != DirectionSent {
+			return fmt.Errorf("bandwidth threshold %q has invalid direction %q", threshold.Name, threshold.Direction)
+		}
+	}
+	tunnelsSeen := map[string]bool{}
+	for _, tunnel := range c.Tunnels {
+		if tunnel.CommonName == "" {
+			return fmt.Errorf("tunnel without common name")
+		}
+		if tunnelsSeen[tunnel.CommonName] {
+			return fmt.Errorf("tunnel %q configured multiple times", tunnel.CommonName)
+		}
+		tunnelsSeen[tunnel.CommonName] = true
+		if tunnel.MaxRouteAge < 0 {
+			return fmt.Errorf("tunnel %q has a negative maximum route age", tunnel.CommonName)
+		}
+	}
+	accountingSeen := map[string]bool{}
+	for _, accounting := range c.Accounting {
+		name := accounting.Name
+		if name == "" {
+			name = accounting.DefaultName()
+		}
+		if accountingSeen[name] {
+			return fmt.Errorf("accounting %q configured multiple times", name)
+		}
+		accountingSeen[name] = true
+		switch accounting.Firewall {
+		case FirewallIPTables, FirewallIP6Tables:
+			if accounting.Set != "" || accounting.Family != "" {
+				return fmt.Errorf("accounting %q uses a set or family, which is only supported for nftables", name)
+			}
+		case FirewallNFTables:
+		default:
+			return fmt.Errorf("accounting %q has unknown firewall %q", name, accounting.Firewall)
+		}
+		if (accounting.Chain == "") == (accounting.Set == "") {
+			return fmt.Errorf("accounting %q needs either a chain or a set", name)
+		}
+		switch {
+		case accounting.Set != "" && accounting.Direction != DirectionReceived && accounting.Direction != DirectionSent:
+			return fmt.Errorf("accounting %q has invalid direction %q", name, accounting.Direction)
+		case accounting.Chain != "" && accounting.Direction != "":
+			return fmt.Errorf("accounting %q has a direction, which is only supported for sets", name)
+		}
+	}
+	for name, month := range c.MonthNames {
+		if name == "" || month < 1 || month > 12 {
+			return fmt.Errorf("month name %q has invalid month %d", name, month)
+		}
+	}
+	for section, columns := range c.LabelColumns {
+		if section != "CLIENT_LIST" && section != "ROUTING_TABLE" {
+			return fmt.Errorf("label columns of unknown section %q", section)
+		}
+		labelsSeen := map[string]bool{}
+		for _, column := range columns {
+			if !labelNameRE.MatchString(column.Label) || column.Label == "status_path" {
+				return fmt.Errorf("label columns of %s have invalid label name %q", section, column.Label)
+			}
+			if labelsSeen[column.Label] {
+				return fmt.Errorf("label columns of %s have label %q multiple times", section, column.Label)
+			}
+			labelsSeen[column.Label] = true
+			if column.Column == "" {
+				return fmt.Errorf("label %q of %s has no column", column.Label, section)
+			}
+		}
+	}
+	for section, columns := range c.MetricColumns {
+		if section != "CLIENT_LIST" && section != "ROUTING_TABLE" {
+			return fmt.Errorf("metric columns of unknown section %q", section)
+		}
+		for _, column := range columns {
+			if !labelNameRE.MatchString(column.Name) {
+				return fmt.Errorf("metric columns of %s have invalid metric name %q", section, column.Name)
+			}
+			if column.Column == "" {
+				return fmt.Errorf("metric %q of %s has no column", column.Name, section)
+			}
+			switch column.Type {
+			case "", MetricTypeCounter, MetricTypeGauge:
+			default:
+				return fmt.Errorf("metric %q of %s has unknown type %q", column.Name, section, column.Type)
+			}
+		}
+	}
+	return nil
+}
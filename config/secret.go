@@ -0,0 +1,88 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Secret is a reference to a credential, such as a management interface
+// password, a basic authentication hash or a bearer token. The reference
+// is interpreted based on its prefix:
+//
+//	file:/path/to/file   contents of the file, without trailing newlines
+//	env:NAME             value of the environment variable NAME
+//	exec:command args    standard output of the command
+//	inline:value         the literal value
+//
+// References without one of these prefixes are used literally. This allows
+// credentials to be kept out of the command line, where they would be
+// visible to other users of the system.
+type Secret string
+
+// Resolve returns the value the secret refers to.
+func (s Secret) Resolve() (string, error) {
+	ref := string(s)
+	switch {
+	case strings.HasPrefix(ref, "file:"):
+		data, err := os.ReadFile(strings.TrimPrefix(ref, "file:"))
+		if err != nil {
+			return "", fmt.Errorf("reading secret file: %w", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case strings.HasPrefix(ref, "env:"):
+		name := strings.TrimPrefix(ref, "env:")
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("secret environment variable %s is not set", name)
+		}
+		return value, nil
+	case strings.HasPrefix(ref, "exec:"):
+		args := strings.Fields(strings.TrimPrefix(ref, "exec:"))
+		if len(args) == 0 {
+			return "", fmt.Errorf("secret command is empty")
+		}
+		out, err := exec.Command(args[0], args[1:]...).Output()
+		if err != nil {
+			return "", fmt.Errorf("running secret command %q: %w", args[0], err)
+		}
+		return strings.TrimRight(string(out), "\r\n"), nil
+	case strings.HasPrefix(ref, "inline:"):
+		return strings.TrimPrefix(ref, "inline:"), nil
+	default:
+		return ref, nil
+	}
+}
+
+// IsEmpty returns whether no secret has been configured.
+func (s Secret) IsEmpty() bool {
+	return s == ""
+}
+
+// String hides the secret, so that it doesn't end up in logs by accident.
+func (s Secret) String() string {
+	if s.IsEmpty() {
+		return ""
+	}
+	return "<secret>"
+}
+
+// MarshalJSON hides the secret when a configuration is serialized.
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
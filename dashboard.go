@@ -0,0 +1,179 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/kumina/openvpn_exporter/exporters"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Labels that are only present on per-client or per-route metrics and are
+// therefore not suitable as dashboard variables.
+var dashboardEntryLabels = map[string]bool{
+	"common_name":     true,
+	"connection_time": true,
+	"real_address":    true,
+	"virtual_address": true,
+	"username":        true,
+}
+
+// Implements the "dashboard" subcommand, which writes a Grafana dashboard
+// for the metrics of the exporter as configured by the given flags.
+func runDashboardCommand(args []string) {
+	fs := flag.NewFlagSet("dashboard", flag.ExitOnError)
+	exporterFlags := registerExporterFlags(fs)
+	out := fs.String("out", "-", "File to which the dashboard is written, or - for stdout.")
+	title := fs.String("title", "OpenVPN", "Title of the dashboard.")
+	fs.Parse(args)
+
+	logger := exporterFlags.newLogger()
+	defer closeLogger(logger)
+	exporter, err := exporterFlags.newExporter(logger)
+	if err != nil {
+		fatal(logger, "Failed to create exporter", "err", err)
+	}
+	data, err := json.MarshalIndent(buildDashboard(*title, exporter.MetricInfos()), "", "  ")
+	if err != nil {
+		fatal(logger, "Failed to encode dashboard", "err", err)
+	}
+	if err := writeOutput(*out, append(data, '\n')); err != nil {
+		fatal(logger, "Failed to write dashboard", "err", err)
+	}
+}
+
+type dashboardObject map[string]interface{}
+
+// Builds a Grafana dashboard with a panel for every metric. Dashboard
+// variables are created for the status path and for all custom labels.
+func buildDashboard(title string, metrics []exporters.MetricInfo) dashboardObject {
+	variables := []string{"status_path"}
+	for _, metric := range metrics {
+		if metric.Name != "openvpn_up" {
+			continue
+		}
+		for _, label := range metric.Labels {
+			if label != "status_path" && !dashboardEntryLabels[label] {
+				variables = append(variables, label)
+			}
+		}
+	}
+
+	templating := []dashboardObject{{
+		"name":  "datasource",
+		"label": "Data source",
+		"type":  "datasource",
+		"query": "prometheus",
+	}}
+	for _, variable := range variables {
+		templating = append(templating, dashboardObject{
+			"name":       variable,
+			"type":       "query",
+			"datasource": dashboardObject{"type": "prometheus", "uid": "${datasource}"},
+			"query":      fmt.Sprintf("label_values(openvpn_up, %s)", variable),
+			"refresh":    2,
+			"multi":      true,
+			"includeAll": true,
+			"allValue":   ".*",
+		})
+	}
+	var panels []dashboardObject
+	for i, metric := range metrics {
+		panels = append(panels, dashboardPanel(i, metric, variables))
+	}
+
+	return dashboardObject{
+		"title":         title,
+		"uid":           "openvpn-exporter",
+		"tags":          []string{"openvpn"},
+		"timezone":      "browser",
+		"schemaVersion": 39,
+		"refresh":       "1m",
+		"time":          dashboardObject{"from": "now-6h", "to": "now"},
+		"templating":    dashboardObject{"list": templating},
+		"panels":        panels,
+	}
+}
+
+// Builds a time series panel for a single metric. Counters are displayed
+// as rates, summed by the labels that identify a status path and entry.
+func dashboardPanel(index int, metric exporters.MetricInfo, variables []string) dashboardObject {
+	// Metrics are only selected and grouped by the labels they have, as
+	// those of the exporter itself have no status path.
+	labels := map[string]bool{}
+	for _, label := range metric.Labels {
+		labels[label] = true
+	}
+	var selectors []string
+	for _, variable := range variables {
+		if labels[variable] {
+			selectors = append(selectors, fmt.Sprintf("%s=~\"$%s\"", variable, variable))
+		}
+	}
+	var groupBy, legend []string
+	for _, label := range []string{"status_path", "common_name"} {
+		if labels[label] {
+			groupBy = append(groupBy, label)
+			legend = append(legend, "{{"+label+"}}")
+		}
+	}
+
+	expr := metric.Name
+	if len(selectors) > 0 {
+		expr += "{" + strings.Join(selectors, ",") + "}"
+	}
+	unit := "short"
+	if metric.ValueType == prometheus.CounterValue {
+		expr = fmt.Sprintf("rate(%s[$__rate_interval])", expr)
+		if len(groupBy) > 0 {
+			expr = fmt.Sprintf("sum by (%s) (%s)", strings.Join(groupBy, ", "), expr)
+		} else {
+			expr = fmt.Sprintf("sum(%s)", expr)
+		}
+		if strings.HasSuffix(metric.Name, "_bytes_total") {
+			unit = "Bps"
+		}
+	} else if strings.HasSuffix(metric.Name, "_time_seconds") || strings.HasSuffix(metric.Name, "_timestamp_seconds") {
+		// Timestamps are more useful as an age.
+		expr = "time() - " + expr
+		unit = "s"
+	}
+
+	return dashboardObject{
+		"id":          index + 1,
+		"type":        "timeseries",
+		"title":       metric.Name,
+		"description": metric.Help,
+		"datasource":  dashboardObject{"type": "prometheus", "uid": "${datasource}"},
+		"gridPos": dashboardObject{
+			"h": 8,
+			"w": 12,
+			"x": (index % 2) * 12,
+			"y": (index / 2) * 8,
+		},
+		"fieldConfig": dashboardObject{
+			"defaults":  dashboardObject{"unit": unit},
+			"overrides": []dashboardObject{},
+		},
+		"targets": []dashboardObject{{
+			"refId":        "A",
+			"expr":         expr,
+			"legendFormat": strings.Join(legend, " "),
+		}},
+	}
+}
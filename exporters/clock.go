@@ -0,0 +1,18 @@
+package exporters
+
+import "time"
+
+// Clock provides the current time to the exporter, which uses it to judge
+// the age of statistics and to schedule reads. Replacing it with a fixed
+// clock makes the output of the exporter reproducible, e.g. to compare
+// it against a golden file.
+type Clock interface {
+	Now() time.Time
+}
+
+// The clock of the system.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
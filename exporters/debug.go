@@ -0,0 +1,235 @@
+package exporters
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/kumina/openvpn_exporter/pkg/status"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrUnknownStatusPath is returned by Debug for status paths the exporter
+// doesn't collect.
+var ErrUnknownStatusPath = errors.New("unknown status path")
+
+// Outcomes of the entries of a DebugReport.
+const (
+	// DebugExported entries are exported as metrics.
+	DebugExported = "exported"
+	// DebugFiltered entries are removed by a filter of the pipeline,
+	// such as the common name filter.
+	DebugFiltered = "filtered"
+	// DebugDisabled entries are only counted in the totals, as the
+	// metrics of their type are disabled.
+	DebugDisabled = "disabled"
+	// DebugDropped entries exceed the row limit of the status path.
+	DebugDropped = "dropped"
+	// DebugDuplicate entries have the same label values as an earlier
+	// entry, of which the metrics are exported instead.
+	DebugDuplicate = "duplicate"
+	// DebugSummed entries have the same label values as an earlier
+	// entry, and are added to its metrics.
+	DebugSummed = "summed"
+	// DebugQuarantined entries are malformed, and skipped in hardened
+	// mode.
+	DebugQuarantined = "quarantined"
+	// DebugUndef entries belong to clients that haven't authenticated
+	// yet, and are excluded or only counted.
+	DebugUndef = "undef"
+)
+
+// DebugReport tells how a read of a status path is turned into metrics,
+// for finding out why clients are missing from the metrics.
+type DebugReport struct {
+	StatusPath string
+	// Contents of the status path as read, up to its size limit, and the
+	// format they were parsed as.
+	Contents []byte
+	Format   status.Format
+	// Sections of a server status file with entries, in the order of
+	// their headers.
+	Sections []DebugSection
+	// Client list and routing table entries, in the order they were
+	// read, along with what became of them.
+	Entries []DebugEntry
+	// Malformed lines and entries skipped in hardened mode, and lines
+	// skipped because of their unsupported key.
+	Quarantined []string
+	Unsupported []string
+	// Number of metrics resulting from the read, and the error failing
+	// it.
+	Metrics int
+	Err     error
+
+	contents bytes.Buffer
+}
+
+// DebugSection tells how the columns of a section of a server status file
+// map to metrics.
+type DebugSection struct {
+	Name    string
+	Columns []string
+	// Names of the labels and the columns their values are taken from,
+	// the columns exported as metrics, and the columns of either kind
+	// the header lacks.
+	LabelNames     []string
+	LabelColumns   []string
+	MetricColumns  []string
+	MissingColumns []string
+}
+
+// DebugEntry is a client list or routing table entry of a DebugReport.
+type DebugEntry struct {
+	Type string
+	// Line number of the entry in the status file, if known.
+	Line    int
+	Columns status.Columns
+	// Names of the labels, and their values after the pipeline. Entries
+	// skipped before the pipeline have the values of their columns.
+	LabelNames  []string
+	LabelValues []string
+	// One of the Debug outcome constants, and the error causing it.
+	Outcome string
+	Err     error
+}
+
+// Adds a section of a server status file with the given column names.
+func (r *DebugReport) addSection(header OpenvpnServerHeader, name string, columns []string) {
+	section := DebugSection{
+		Name:         name,
+		Columns:      columns,
+		LabelNames:   header.labelNames,
+		LabelColumns: header.LabelColumns,
+	}
+	present := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		present[column] = true
+	}
+	for _, metric := range header.Metrics {
+		section.MetricColumns = append(section.MetricColumns, metric.Column)
+	}
+	for _, columns := range [][]string{section.LabelColumns, section.MetricColumns} {
+		for _, column := range columns {
+			if !present[column] && !slices.Contains(section.MissingColumns, column) {
+				section.MissingColumns = append(section.MissingColumns, column)
+			}
+		}
+	}
+	r.Sections = append(r.Sections, section)
+}
+
+// Adds an entry with its outcome. Does nothing unless the read is
+// debugged.
+func (r *DebugReport) addEntry(header OpenvpnServerHeader, entry *Entry, outcome string, err error) {
+	if r == nil {
+		return
+	}
+	values := entry.LabelValues
+	if values == nil {
+		values = make([]string, len(entry.LabelNames))
+		for i, name := range entry.LabelNames {
+			values[i] = entry.Columns.Value(name)
+		}
+	}
+	r.Entries = append(r.Entries, DebugEntry{
+		Type:        entry.Type,
+		Line:        entry.Line,
+		Columns:     entry.Columns,
+		LabelNames:  header.labelNames,
+		LabelValues: values,
+		Outcome:     outcome,
+		Err:         err,
+	})
+}
+
+// Adds a malformed line or entry. Does nothing unless the read is
+// debugged.
+func (r *DebugReport) addQuarantined(err error) {
+	if r == nil {
+		return
+	}
+	r.Quarantined = append(r.Quarantined, err.Error())
+}
+
+// Adds a line skipped because of its unsupported key. Does nothing unless
+// the read is debugged.
+func (r *DebugReport) addUnsupported(line int, key string) {
+	if r == nil {
+		return
+	}
+	if line > 0 {
+		r.Unsupported = append(r.Unsupported, fmt.Sprintf("line %d: %q", line, key))
+	} else {
+		r.Unsupported = append(r.Unsupported, fmt.Sprintf("%q", key))
+	}
+}
+
+// Debug reads a status path like a scrape, and reports how its contents
+// are turned into metrics. The read leaves the state of the status path
+// alone, such as its cached metrics and circuit breaker, and isn't seen by
+// the stages of the pipeline that observe reads, as they keep track of the
+// clients of the scrapes. Sources that a glob pattern expanded to are
+// found by their own names.
+func (e *OpenVPNExporter) Debug(ctx context.Context, statusPath string) (*DebugReport, error) {
+	if e.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.timeout)
+		defer cancel()
+	}
+	t := e.findTarget(ctx, statusPath)
+	if t == nil {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownStatusPath, statusPath)
+	}
+	if timeout := e.targetTimeout(t); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	debugged := &target{
+		StatusPath:  t.StatusPath,
+		source:      t.source,
+		labelValues: t.labelValues,
+		pipeline:    t.pipeline.withoutReadObservers(),
+		undefPolicy: t.undefPolicy,
+		location:    t.location,
+		timeLayouts: t.timeLayouts,
+		maxRows:     t.maxRows,
+		maxBytes:    t.maxBytes,
+	}
+	report := &DebugReport{StatusPath: statusPath}
+	ch := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	go func() {
+		for range ch {
+			report.Metrics++
+		}
+		close(done)
+	}()
+	s := &scrape{target: debugged, ch: ch, ctx: ctx, debug: report}
+	report.Err = e.collectStatusFromSourceSafely(ctx, s)
+	close(ch)
+	<-done
+	report.Contents = report.contents.Bytes()
+	return report, nil
+}
+
+// Returns the target of a status path, or of a source that a glob pattern
+// expanded to, or nil if there is none.
+func (e *OpenVPNExporter) findTarget(ctx context.Context, statusPath string) *target {
+	for _, t := range e.targets {
+		if t.Path == statusPath {
+			return t
+		}
+	}
+	for _, t := range e.targets {
+		for _, child := range e.expandTarget(ctx, t) {
+			if child.Path == statusPath {
+				return child
+			}
+		}
+	}
+	return nil
+}
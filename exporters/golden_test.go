@@ -0,0 +1,118 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporters
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+var update = flag.Bool("update", false, "Rewrite the golden files with the current output.")
+
+// A clock that always returns the same time.
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+// Reads an example status file. Unlike a FileSource, it has no version, so
+// that the output doesn't depend on the modification time of the file.
+type exampleSource string
+
+func (s exampleSource) Open(ctx context.Context) (io.ReadCloser, error) {
+	return os.Open(string(s))
+}
+
+// Returns the path relative to the root of the repository.
+func (s exampleSource) Name() string {
+	return filepath.ToSlash(strings.TrimPrefix(string(s), ".."+string(filepath.Separator)))
+}
+
+func (s exampleSource) Labels() map[string]string {
+	return nil
+}
+
+// Compares the metrics exported for each example status file with those in
+// testdata/<name>.golden. Run with -update to accept changes.
+func TestExamplesGolden(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("..", "examples", "*.status"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no example status files found")
+	}
+	clock := fixedClock(time.Date(2024, time.October, 21, 9, 30, 0, 0, time.UTC))
+	for _, path := range paths {
+		path := path
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			exporter, err := NewOpenVPNExporter(
+				WithSources(exampleSource(path)),
+				WithClock(clock),
+				WithTimeFormat(time.UTC))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := gatherText(t, exporter)
+
+			golden := filepath.Join("testdata", filepath.Base(path)+".golden")
+			if *update {
+				if err := os.MkdirAll("testdata", 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v (run with -update to create it)", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("metrics of %s differ from %s:\n%s", path, golden, got)
+			}
+		})
+	}
+}
+
+// Collects the metrics of a collector once, in the text exposition format.
+func gatherText(t *testing.T, collector prometheus.Collector) []byte {
+	t.Helper()
+	registry := prometheus.NewPedanticRegistry()
+	if err := registry.Register(collector); err != nil {
+		t.Fatal(err)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	for _, family := range families {
+		if _, err := expfmt.MetricFamilyToText(&buf, family); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
@@ -3,20 +3,36 @@ package exporters
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"io/fs"
+	"log/slog"
+	"math"
+	"net"
 	"os"
+	"runtime/debug"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
+	"github.com/kumina/openvpn_exporter/config"
+	"github.com/kumina/openvpn_exporter/pkg/status"
+	"github.com/kumina/openvpn_exporter/sources"
 	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 )
 
 type OpenvpnServerHeader struct {
 	LabelColumns []string
 	Metrics      []OpenvpnServerHeaderField
+	// Names of the labels of the label columns.
+	labelNames []string
 }
 
 type OpenvpnServerHeaderField struct {
@@ -26,502 +42,2304 @@ type OpenvpnServerHeaderField struct {
 }
 
 type OpenVPNExporter struct {
-	statusPaths                 []string
-	openvpnUpDesc               *prometheus.Desc
-	openvpnStatusUpdateTimeDesc *prometheus.Desc
-	openvpnConnectedClientsDesc *prometheus.Desc
-	openvpnClientDescs          map[string]*prometheus.Desc
-	openvpnServerHeaders        map[string]OpenvpnServerHeader
+	logger                       *slog.Logger
+	duplicatePolicy              DuplicatePolicy
+	timeout                      time.Duration
+	pathTimeout                  time.Duration
+	maxAge                       time.Duration
+	targets                      []*target
+	labelNames                   []string
+	openvpnUpDesc                *prometheus.Desc
+	openvpnCollectorPanicsDesc   *prometheus.Desc
+	openvpnDroppedRowsDesc       *prometheus.Desc
+	openvpnOversizedDesc         *prometheus.Desc
+	openvpnQuarantinedDesc       *prometheus.Desc
+	openvpnCircuitOpenDesc       *prometheus.Desc
+	openvpnStandbyDesc           *prometheus.Desc
+	openvpnLastSuccessDesc       *prometheus.Desc
+	openvpnUnsupportedKeysDesc   *prometheus.Desc
+	openvpnSourceInfoDesc        *prometheus.Desc
+	openvpnScrapeErrorDesc       *prometheus.Desc
+	openvpnScrapeTimeoutDesc     *prometheus.Desc
+	openvpnScrapeDurationDesc    *prometheus.Desc
+	openvpnScrapeErrorsDesc      *prometheus.Desc
+	openvpnStatusUpdateTimeDesc  *prometheus.Desc
+	openvpnStatusFileMtimeDesc   *prometheus.Desc
+	openvpnStartTimeDesc         *prometheus.Desc
+	openvpnRestartsDesc          *prometheus.Desc
+	openvpnClientDurationDesc    *prometheus.Desc
+	openvpnClientReconnectsDesc  *prometheus.Desc
+	openvpnConnectedClientsDescs []*prometheus.Desc
+	openvpnClientsReceivedDesc   *prometheus.Desc
+	openvpnClientsSentDesc       *prometheus.Desc
+	openvpnAuthenticatingDesc    *prometheus.Desc
+	openvpnRoutesDesc            *prometheus.Desc
+	openvpnClientSessionsDesc    *prometheus.Desc
+	openvpnServerInfoDesc        *prometheus.Desc
+	openvpnClientInfoDesc        *prometheus.Desc
+	openvpnClientRoutesDesc      *prometheus.Desc
+	openvpnClientDescs           map[string]*prometheus.Desc
+	openvpnCompressionRatioDescs []compressionRatio
+	openvpnServerHeaders         map[string]OpenvpnServerHeader
+	// Entry types of which no per entry metrics are exported.
+	disabledEntries map[string]bool
+	globalStatDescs *statDescs
+	// Descriptors of unknown client statistics, if they are exported.
+	clientCounterDescs *statDescs
+	metricInfos        []MetricInfo
+	descs              []*prometheus.Desc
+	// Maximum number of per client series sent per collection, the
+	// descriptors of these series, and the number of series dropped
+	// because of the maximum.
+	maxClientSeries         int
+	clientSeriesDescs       map[*prometheus.Desc]bool
+	droppedClientSeries     atomic.Uint64
+	droppedClientSeriesDesc *prometheus.Desc
+
+	// Limits the number of status paths collected in parallel.
+	workers chan struct{}
+	// Whether metrics are refreshed in the background by Run, and the
+	// metrics of all status paths as last refreshed.
+	background  atomic.Bool
+	snapshotMtx sync.Mutex
+	snapshot    []prometheus.Metric
+	// Source of the current time.
+	clock Clock
+	// Whether malformed lines and entries are skipped instead of failing
+	// the read.
+	hardened bool
+	// Number of consecutive failed reads after which a status path is no
+	// longer read for the backoff period. Zero disables this.
+	circuitFailures int
+	circuitBackoff  time.Duration
+	// Delay before the first retry of a failed read, doubling for every
+	// further retry.
+	retryBackoff time.Duration
+	// Receiver of the raw contents of reads, if any.
+	recorder Recorder
+}
+
+// A status path along with the state needed to collect it.
+type target struct {
+	config.StatusPath
+	source sources.StatusSource
+	// Values of the custom labels, ordered like OpenVPNExporter.labelNames.
+	labelValues []string
+	// Stages applied to the entries of the status path, and whether the
+	// labels describing individual connections are exported.
+	pipeline    Pipeline
+	individuals bool
+	// Handling of clients with the common name UNDEF.
+	undefPolicy UndefPolicy
+	// Time zone and layouts of human readable timestamps.
+	location    *time.Location
+	timeLayouts []string
+
+	// Metrics of the last read, reused until the refresh interval expires.
+	mtx         sync.Mutex
+	lastRead    time.Time
+	lastMetrics []prometheus.Metric
+	// Number of panics that occurred while collecting the status path.
+	panics uint64
+	// Maximum number of entries collected per read, and the number of
+	// entries ignored because of it.
+	maxRows     int
+	droppedRows uint64
+	// Number of consecutive failed reads, and the time until which the
+	// target is not read because of them.
+	failures         int
+	circuitOpenUntil time.Time
+	// Number of times a read failing with a transient error is retried.
+	retries int
+	// Duration of the last read, and the number of failed reads by
+	// reason.
+	scrapeDuration time.Duration
+	scrapeErrors   map[string]uint64
+	// Maximum number of bytes read per read, and the number of reads
+	// aborted because of it.
+	maxBytes  int64
+	oversized uint64
+	// Number of malformed lines and entries skipped in hardened mode.
+	quarantined uint64
+	// Targets of the sources a glob pattern expanded to, by name.
+	children map[string]*target
+	// Metrics of the target and its children, as last refreshed in the
+	// background.
+	snapshot []prometheus.Metric
+	// Result of the last successful read of the source, if it can tell
+	// whether it changed since.
+	cache *parseCache
+	// Start time of the OpenVPN daemon, if known, the values of the
+	// traffic counters of client statistics as last read, and the number
+	// of restarts detected by changes of these.
+	startTime time.Time
+	counters  map[string]float64
+	restarts  uint64
+	// Whether the status file was missing on the last read of a status
+	// path configured as standby.
+	standby bool
+	// Time of the last successful collection, and the time at which its
+	// statistics were updated, or the modification time of the source if
+	// they don't tell.
+	lastSuccess time.Time
+	updateTime  time.Time
+	// Number of lines skipped because of their unsupported key, and the
+	// time at which each key was last logged.
+	unsupportedKeys       map[string]uint64
+	unsupportedKeysLogged map[string]time.Time
+	// Whether the status path holds client statistics, the time at which
+	// the client's tunnel was last connected, if known, and the number of
+	// reconnects detected.
+	client         bool
+	connectedSince time.Time
+	reconnects     uint64
+}
+
+// Result of parsing a read of a source implementing sources.Versioner,
+// reused for as long as the source reports the same version. Only the
+// parsing is skipped: the entries pass through the pipeline on every read,
+// so that its stages see the clients of unchanged sources as well.
+type parseCache struct {
+	version string
+	status  *status.Status
+	entries []cachedEntry
+}
+
+// A client list or routing table entry of a cached read.
+type cachedEntry struct {
+	entryType string
+	line      int
+	columns   status.Columns
 }
 
-func NewOpenVPNExporter(statusPaths []string, ignoreIndividuals bool) (*OpenVPNExporter, error) {
+// Returns the label values for a metric of this target: the status path,
+// followed by the metric specific values and the custom labels.
+func (t *target) labels(values ...string) []string {
+	labels := make([]string, 0, 1+len(values)+len(t.labelValues))
+	labels = append(labels, t.Path)
+	labels = append(labels, values...)
+	return append(labels, t.labelValues...)
+}
+
+// ErrStale is reported for status paths with statistics older than their
+// maximum age.
+var ErrStale = errors.New("statistics are stale")
+
+// ErrTooLarge is reported for status paths whose contents exceed the
+// maximum size.
+var ErrTooLarge = errors.New("status exceeds the size limit")
+
+// Columns that only carry information about individual connections.
+var individualColumns = map[string]bool{
+	"Connected Since":          true,
+	"Connected Since (time_t)": true,
+	"Real Address":             true,
+	"Virtual Address":          true,
+	"Virtual IPv6 Address":     true,
+}
+
+// NewOpenVPNExporter creates an exporter for the status paths and sources
+// given through the options.
+func NewOpenVPNExporter(opts ...Option) (*OpenVPNExporter, error) {
+	o := options{namespace: "openvpn", concurrency: 1}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	logger := o.logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if o.clock == nil {
+		o.clock = systemClock{}
+	}
+	// Custom sources are collected like status paths with default options.
+	type sourcedPath struct {
+		config.StatusPath
+		source sources.StatusSource
+	}
+	var statusPaths []sourcedPath
+	for _, sp := range o.statusPaths {
+		if sp.Lock == nil {
+			sp.Lock = &o.lockFiles
+		}
+		source, err := sources.New(sp)
+		if err != nil {
+			return nil, err
+		}
+		statusPaths = append(statusPaths, sourcedPath{sp, source})
+	}
+	for _, source := range o.sources {
+		statusPaths = append(statusPaths, sourcedPath{config.StatusPath{Path: source.Name(), Labels: source.Labels()}, source})
+	}
+
+	// Gather the custom labels of all status paths, as all metrics of
+	// the same name need to have the same label names.
+	var labelNames []string
+	labelsSeen := map[string]bool{}
+	builtinLabels := map[string]bool{
+		"status_path": true, "common_name": true, "connection_time": true,
+		"real_address": true, "virtual_address": true, "username": true,
+		"reason": true, "version": true, "arch": true, "key": true,
+	}
+	for _, columns := range o.labelColumns {
+		for _, column := range columns {
+			builtinLabels[column.Label] = true
+		}
+	}
+	if o.clientInfo {
+		builtinLabels["cipher"] = true
+		builtinLabels["peer_id"] = true
+	}
+	var targets []*target
+	allIgnoreIndividuals := true
+	for _, sp := range statusPaths {
+		t := &target{StatusPath: sp.StatusPath, source: sp.source, maxRows: o.maxRows}
+		if sp.MaxRows > 0 {
+			t.maxRows = sp.MaxRows
+		}
+		t.maxBytes = o.maxBytes
+		if sp.MaxBytes > 0 {
+			t.maxBytes = sp.MaxBytes
+		}
+		t.retries = o.retries[sources.Type(sp.source)]
+		if sp.Retries != nil {
+			t.retries = *sp.Retries
+		}
+		t.location, t.timeLayouts = o.location, o.timeLayouts
+		if sp.TimeZone != "" {
+			location, err := time.LoadLocation(sp.TimeZone)
+			if err != nil {
+				return nil, fmt.Errorf("status path %q: %w", sp.Path, err)
+			}
+			t.location = location
+		}
+		if len(sp.TimeLayouts) > 0 {
+			t.timeLayouts = sp.TimeLayouts
+		}
+		normalization := o.normalization
+		if sp.NormalizeCommonNames != nil {
+			normalization = *sp.NormalizeCommonNames
+		}
+		if !normalization.IsZero() {
+			t.pipeline.Enrichers = append(t.pipeline.Enrichers, commonNameNormalizer(normalization))
+		}
+		if len(t.CommonNames.Include) == 0 && len(t.CommonNames.Exclude) == 0 {
+			t.CommonNames = o.commonNames
+		}
+		if len(t.CommonNames.Include) > 0 || len(t.CommonNames.Exclude) > 0 {
+			t.pipeline.Filters = append(t.pipeline.Filters, commonNameFilter(t.CommonNames))
+		}
+		ignoreIndividuals := o.ignoreIndividuals
+		if sp.IgnoreIndividuals != nil {
+			ignoreIndividuals = *sp.IgnoreIndividuals
+		}
+		if ignoreIndividuals {
+			t.pipeline.Relabelers = append(t.pipeline.Relabelers, individualsRelabeler{})
+		} else {
+			allIgnoreIndividuals = false
+		}
+		// Without per entry metrics, no labels of individual
+		// connections are exported either.
+		t.individuals = !ignoreIndividuals && !(o.disabledEntries["CLIENT_LIST"] && o.disabledEntries["ROUTING_TABLE"])
+		t.undefPolicy = o.undefPolicy
+		if sp.UndefClients != "" {
+			t.undefPolicy = undefPolicies[sp.UndefClients]
+		}
+		t.pipeline = t.pipeline.then(o.pipeline)
+		targets = append(targets, t)
+		// Sources may add labels of their own to those configured.
+		for name := range sp.source.Labels() {
+			if builtinLabels[name] {
+				return nil, fmt.Errorf("status path %q uses reserved label name %q", sp.Path, name)
+			}
+			if !labelsSeen[name] {
+				labelsSeen[name] = true
+				labelNames = append(labelNames, name)
+			}
+		}
+	}
+	sort.Strings(labelNames)
+	for _, t := range targets {
+		for _, name := range labelNames {
+			t.labelValues = append(t.labelValues, t.source.Labels()[name])
+		}
+	}
+	withLabels := func(labels ...string) []string {
+		return append(labels, labelNames...)
+	}
+	descs := &descBuilder{namespace: o.namespace, constLabels: o.constLabels}
+
 	// Metrics exported both for client and server statistics.
-	openvpnUpDesc := prometheus.NewDesc(
-		prometheus.BuildFQName("openvpn", "", "up"),
+	openvpnUpDesc := descs.new(
+		"", "up",
 		"Whether scraping OpenVPN's metrics was successful.",
-		[]string{"status_path"}, nil)
-	openvpnStatusUpdateTimeDesc := prometheus.NewDesc(
-		prometheus.BuildFQName("openvpn", "", "status_update_time_seconds"),
+		prometheus.GaugeValue, withLabels("status_path"))
+	openvpnCollectorPanicsDesc := descs.new(
+		"collector", "panics_total",
+		"Number of panics recovered from while collecting OpenVPN's metrics.",
+		prometheus.CounterValue, withLabels("status_path"))
+	openvpnDroppedRowsDesc := descs.new(
+		"collector", "dropped_rows_total",
+		"Number of client list and routing table entries ignored because a status file exceeded the row limit.",
+		prometheus.CounterValue, withLabels("status_path"))
+	openvpnOversizedDesc := descs.new(
+		"collector", "oversized_reads_total",
+		"Number of reads aborted because the status path exceeded the size limit.",
+		prometheus.CounterValue, withLabels("status_path"))
+	openvpnQuarantinedDesc := descs.new(
+		"collector", "quarantined_rows_total",
+		"Number of malformed lines and entries of the status path skipped in hardened mode.",
+		prometheus.CounterValue, withLabels("status_path"))
+	openvpnCircuitOpenDesc := descs.new(
+		"collector", "circuit_open",
+		"Whether reading the status path is suspended after repeated failures.",
+		prometheus.GaugeValue, withLabels("status_path"))
+	openvpnScrapeErrorDesc := descs.new(
+		"", "scrape_error",
+		"Whether the last scrape of the status path failed for the given reason.",
+		prometheus.GaugeValue, withLabels("status_path", "reason"))
+	openvpnScrapeTimeoutDesc := descs.new(
+		"", "scrape_timeout",
+		"Whether the last scrape of the status path was aborted because it exceeded its timeout.",
+		prometheus.GaugeValue, withLabels("status_path"))
+	openvpnScrapeDurationDesc := descs.new(
+		"", "scrape_duration_seconds",
+		"Duration of the last scrape of the status path, including retries.",
+		prometheus.GaugeValue, withLabels("status_path"))
+	openvpnScrapeErrorsDesc := descs.new(
+		"", "scrape_errors_total",
+		"Number of failed scrapes of the status path, by reason.",
+		prometheus.CounterValue, withLabels("status_path", "reason"))
+	openvpnStandbyDesc := descs.new(
+		"", "standby",
+		"Whether the status file is missing because the server is on standby.",
+		prometheus.GaugeValue, withLabels("status_path"))
+	openvpnLastSuccessDesc := descs.new(
+		"collector", "last_success_timestamp_seconds",
+		"UNIX timestamp of the last successful collection of the status path, or 0 if it didn't succeed since the exporter started.",
+		prometheus.GaugeValue, withLabels("status_path"))
+	openvpnUnsupportedKeysDesc := descs.new(
+		"parse", "unsupported_keys_total",
+		"Number of lines of the status path skipped because their key is unknown to the exporter.",
+		prometheus.CounterValue, withLabels("status_path", "key"))
+	// The configuration of status paths is told apart by their name
+	// alone, as custom labels may clash with the names of its labels.
+	openvpnSourceInfoDesc := descs.new(
+		"source", "info",
+		"Configuration of a status path as collected, with the value 1.",
+		prometheus.GaugeValue, []string{"name", "type", "format", "individuals"})
+	openvpnStatusUpdateTimeDesc := descs.new(
+		"", "status_update_time_seconds",
 		"UNIX timestamp at which the OpenVPN statistics were updated.",
-		[]string{"status_path"}, nil)
+		prometheus.GaugeValue, withLabels("status_path"))
+	openvpnStatusFileMtimeDesc := descs.new(
+		"", "status_file_mtime_seconds",
+		"UNIX timestamp at which the status file was last modified, if known.",
+		prometheus.GaugeValue, withLabels("status_path"))
+	openvpnStartTimeDesc := descs.new(
+		"server", "start_time_seconds",
+		"UNIX timestamp at which the OpenVPN daemon started, if known.",
+		prometheus.GaugeValue, withLabels("status_path"))
+	openvpnRestartsDesc := descs.new(
+		"server", "restarts_total",
+		"Number of restarts of the OpenVPN daemon detected by the exporter.",
+		prometheus.CounterValue, withLabels("status_path"))
+	openvpnClientDurationDesc := descs.new(
+		"client", "connection_duration_seconds",
+		"Time since the tunnel of the client was last connected, if known.",
+		prometheus.GaugeValue, withLabels("status_path"))
+	openvpnClientReconnectsDesc := descs.new(
+		"client", "reconnects_total",
+		"Number of reconnects of the tunnel of the client detected by the exporter.",
+		prometheus.CounterValue, withLabels("status_path"))
+
+	// Metrics specific to OpenVPN servers. kumina/openvpn_exporter
+	// prefixed the number of connected clients twice.
+	var openvpnConnectedClientsDescs []*prometheus.Desc
+	if o.metricNames != MetricNamesKumina {
+		openvpnConnectedClientsDescs = append(openvpnConnectedClientsDescs, descs.new(
+			"", "server_connected_clients",
+			"Number Of Connected Clients",
+			prometheus.GaugeValue, withLabels("status_path")))
+	}
+	if o.metricNames != MetricNamesCurrent {
+		openvpnConnectedClientsDescs = append(openvpnConnectedClientsDescs, descs.new(
+			"", "openvpn_server_connected_clients",
+			"Number Of Connected Clients",
+			prometheus.GaugeValue, withLabels("status_path")))
+	}
 
-	// Metrics specific to OpenVPN servers.
-	openvpnConnectedClientsDesc := prometheus.NewDesc(
-		prometheus.BuildFQName("openvpn", "", "server_connected_clients"),
-		"Number Of Connected Clients",
-		[]string{"status_path"}, nil)
+	openvpnClientsReceivedDesc := descs.new(
+		"server", "connected_clients_received_bytes",
+		"Amount of data received from all connected clients over their current connections, in bytes.",
+		prometheus.GaugeValue, withLabels("status_path"))
+	openvpnClientsSentDesc := descs.new(
+		"server", "connected_clients_sent_bytes",
+		"Amount of data sent to all connected clients over their current connections, in bytes.",
+		prometheus.GaugeValue, withLabels("status_path"))
+	openvpnAuthenticatingDesc := descs.new(
+		"server", "clients_authenticating",
+		"Number of connected clients that haven't authenticated yet, listed with the common name UNDEF.",
+		prometheus.GaugeValue, withLabels("status_path"))
+	openvpnRoutesDesc := descs.new(
+		"server", "routes",
+		"Number of entries of the routing table.",
+		prometheus.GaugeValue, withLabels("status_path"))
+	openvpnClientSessionsDesc := descs.new(
+		"server", "client_sessions",
+		"Number of concurrent sessions of a client, which exceeds 1 for common names shared with duplicate-cn.",
+		prometheus.GaugeValue, withLabels("status_path", "common_name"))
+	openvpnServerInfoDesc := descs.new(
+		"server", "info",
+		"Version of OpenVPN and the platform it was built for, as given by the title of the status, with the value 1.",
+		prometheus.GaugeValue, withLabels("status_path", "version", "arch"))
 
 	// Metrics specific to OpenVPN clients.
 	openvpnClientDescs := map[string]*prometheus.Desc{
-		"TUN/TAP read bytes": prometheus.NewDesc(
-			prometheus.BuildFQName("openvpn", "client", "tun_tap_read_bytes_total"),
+		"TUN/TAP read bytes": descs.new(
+			"client", "tun_tap_read_bytes_total",
 			"Total amount of TUN/TAP traffic read, in bytes.",
-			[]string{"status_path"}, nil),
-		"TUN/TAP write bytes": prometheus.NewDesc(
-			prometheus.BuildFQName("openvpn", "client", "tun_tap_write_bytes_total"),
+			prometheus.CounterValue, withLabels("status_path")),
+		"TUN/TAP write bytes": descs.new(
+			"client", "tun_tap_write_bytes_total",
 			"Total amount of TUN/TAP traffic written, in bytes.",
-			[]string{"status_path"}, nil),
-		"TCP/UDP read bytes": prometheus.NewDesc(
-			prometheus.BuildFQName("openvpn", "client", "tcp_udp_read_bytes_total"),
+			prometheus.CounterValue, withLabels("status_path")),
+		"TCP/UDP read bytes": descs.new(
+			"client", "tcp_udp_read_bytes_total",
 			"Total amount of TCP/UDP traffic read, in bytes.",
-			[]string{"status_path"}, nil),
-		"TCP/UDP write bytes": prometheus.NewDesc(
-			prometheus.BuildFQName("openvpn", "client", "tcp_udp_write_bytes_total"),
+			prometheus.CounterValue, withLabels("status_path")),
+		"TCP/UDP write bytes": descs.new(
+			"client", "tcp_udp_write_bytes_total",
 			"Total amount of TCP/UDP traffic written, in bytes.",
-			[]string{"status_path"}, nil),
-		"Auth read bytes": prometheus.NewDesc(
-			prometheus.BuildFQName("openvpn", "client", "auth_read_bytes_total"),
+			prometheus.CounterValue, withLabels("status_path")),
+		"Auth read bytes": descs.new(
+			"client", "auth_read_bytes_total",
 			"Total amount of authentication traffic read, in bytes.",
-			[]string{"status_path"}, nil),
-		"pre-compress bytes": prometheus.NewDesc(
-			prometheus.BuildFQName("openvpn", "client", "pre_compress_bytes_total"),
+			prometheus.CounterValue, withLabels("status_path")),
+		"pre-compress bytes": descs.new(
+			"client", "pre_compress_bytes_total",
 			"Total amount of data before compression, in bytes.",
-			[]string{"status_path"}, nil),
-		"post-compress bytes": prometheus.NewDesc(
-			prometheus.BuildFQName("openvpn", "client", "post_compress_bytes_total"),
+			prometheus.CounterValue, withLabels("status_path")),
+		"post-compress bytes": descs.new(
+			"client", "post_compress_bytes_total",
 			"Total amount of data after compression, in bytes.",
-			[]string{"status_path"}, nil),
-		"pre-decompress bytes": prometheus.NewDesc(
-			prometheus.BuildFQName("openvpn", "client", "pre_decompress_bytes_total"),
+			prometheus.CounterValue, withLabels("status_path")),
+		"pre-decompress bytes": descs.new(
+			"client", "pre_decompress_bytes_total",
 			"Total amount of data before decompression, in bytes.",
-			[]string{"status_path"}, nil),
-		"post-decompress bytes": prometheus.NewDesc(
-			prometheus.BuildFQName("openvpn", "client", "post_decompress_bytes_total"),
+			prometheus.CounterValue, withLabels("status_path")),
+		"post-decompress bytes": descs.new(
+			"client", "post_decompress_bytes_total",
 			"Total amount of data after decompression, in bytes.",
-			[]string{"status_path"}, nil),
+			prometheus.CounterValue, withLabels("status_path")),
+	}
+
+	// Ratios derived from the compression counters of clients.
+	openvpnCompressionRatioDescs := []compressionRatio{
+		{
+			uncompressed: "pre-compress bytes",
+			compressed:   "post-compress bytes",
+			desc: descs.new(
+				"client", "compression_ratio",
+				"Ratio of the amount of data before and after compression.",
+				prometheus.GaugeValue, withLabels("status_path")),
+		},
+		{
+			uncompressed: "post-decompress bytes",
+			compressed:   "pre-decompress bytes",
+			desc: descs.new(
+				"client", "decompression_ratio",
+				"Ratio of the amount of data after and before decompression.",
+				prometheus.GaugeValue, withLabels("status_path")),
+		},
+	}
+
+	// Global statistics of servers, such as the maximum length of the
+	// broadcast/multicast queue. Statistics unknown to the exporter are
+	// exported under a name derived from theirs.
+	globalStats := &statDescs{
+		namespace:   o.namespace,
+		subsystem:   "server",
+		constLabels: o.constLabels,
+		labels:      withLabels("status_path"),
+		help:        "Global statistic %q of the server.",
+		descs: map[string]*prometheus.Desc{
+			"Max bcast/mcast queue length": descs.new(
+				"server", "max_bcast_mcast_queue_length",
+				"Maximum length of the broadcast/multicast queue of the server.",
+				prometheus.GaugeValue, withLabels("status_path")),
+		},
 	}
 
 	var serverHeaderClientLabels []string
 	var serverHeaderClientLabelColumns []string
 	var serverHeaderRoutingLabels []string
 	var serverHeaderRoutingLabelColumns []string
-	if ignoreIndividuals {
-		serverHeaderClientLabels = []string{"status_path", "common_name"}
+	if allIgnoreIndividuals {
+		serverHeaderClientLabels = withLabels("status_path", "common_name")
 		serverHeaderClientLabelColumns = []string{"Common Name"}
-		serverHeaderRoutingLabels = []string{"status_path", "common_name"}
+		serverHeaderRoutingLabels = withLabels("status_path", "common_name")
 		serverHeaderRoutingLabelColumns = []string{"Common Name"}
 	} else {
-		serverHeaderClientLabels = []string{"status_path", "common_name", "connection_time", "real_address", "virtual_address", "username"}
+		serverHeaderClientLabels = withLabels("status_path", "common_name", "connection_time", "real_address", "virtual_address", "username")
 		serverHeaderClientLabelColumns = []string{"Common Name", "Connected Since", "Real Address", "Virtual Address", "Common Name"}
-		serverHeaderRoutingLabels = []string{"status_path", "common_name", "real_address", "virtual_address"}
+		// The client list metrics kept their names, so they only carry
+		// the label values of kumina/openvpn_exporter if its names
+		// replace the current ones.
+		if o.metricNames == MetricNamesKumina {
+			serverHeaderClientLabelColumns = []string{"Common Name", "Connected Since (time_t)", "Real Address", "Virtual Address", "Username"}
+		}
+		serverHeaderRoutingLabels = withLabels("status_path", "common_name", "real_address", "virtual_address")
 		serverHeaderRoutingLabelColumns = []string{"Common Name", "Real Address", "Virtual Address"}
 	}
+	// Configured label columns replace those of either section.
+	configuredLabels := func(section string, labels, labelColumns *[]string) {
+		columns, ok := o.labelColumns[section]
+		if !ok {
+			return
+		}
+		names := []string{"status_path"}
+		*labelColumns = nil
+		for _, column := range columns {
+			names = append(names, column.Label)
+			*labelColumns = append(*labelColumns, column.Column)
+		}
+		*labels = withLabels(names...)
+	}
+	configuredLabels("CLIENT_LIST", &serverHeaderClientLabels, &serverHeaderClientLabelColumns)
+	configuredLabels("ROUTING_TABLE", &serverHeaderRoutingLabels, &serverHeaderRoutingLabelColumns)
 
 	openvpnServerHeaders := map[string]OpenvpnServerHeader{
 		"CLIENT_LIST": {
 			LabelColumns: serverHeaderClientLabelColumns,
+			labelNames:   serverHeaderClientLabels[1 : 1+len(serverHeaderClientLabelColumns)],
 			Metrics: []OpenvpnServerHeaderField{
 				{
 					Column: "Bytes Received",
-					Desc: prometheus.NewDesc(
-						prometheus.BuildFQName("openvpn", "server", "client_received_bytes_total"),
+					Desc: descs.new(
+						"server", "client_received_bytes_total",
 						"Amount of data received over a connection on the VPN server, in bytes.",
-						serverHeaderClientLabels, nil),
+						prometheus.CounterValue, serverHeaderClientLabels),
 					ValueType: prometheus.CounterValue,
 				},
 				{
 					Column: "Bytes Sent",
-					Desc: prometheus.NewDesc(
-						prometheus.BuildFQName("openvpn", "server", "client_sent_bytes_total"),
+					Desc: descs.new(
+						"server", "client_sent_bytes_total",
 						"Amount of data sent over a connection on the VPN server, in bytes.",
-						serverHeaderClientLabels, nil),
+						prometheus.CounterValue, serverHeaderClientLabels),
 					ValueType: prometheus.CounterValue,
 				},
+				{
+					Column: "Connected Since (time_t)",
+					Desc: descs.new(
+						"server", "client_connected_since_timestamp_seconds",
+						"Time at which a connection to the VPN server was established, in seconds.",
+						prometheus.GaugeValue, serverHeaderClientLabels),
+					ValueType: prometheus.GaugeValue,
+				},
 			},
 		},
 		"ROUTING_TABLE": {
 			LabelColumns: serverHeaderRoutingLabelColumns,
+			labelNames:   serverHeaderRoutingLabels[1 : 1+len(serverHeaderRoutingLabelColumns)],
 			Metrics: []OpenvpnServerHeaderField{
 				{
 					Column: "Last Ref (time_t)",
-					Desc: prometheus.NewDesc(
-						prometheus.BuildFQName("openvpn", "server", "route_last_reference_time_seconds"),
+					Desc: descs.new(
+						"server", "route_last_reference_time_seconds",
 						"Time at which a route was last referenced, in seconds.",
-						serverHeaderRoutingLabels, nil),
+						prometheus.GaugeValue, serverHeaderRoutingLabels),
 					ValueType: prometheus.GaugeValue,
 				},
 			},
 		},
 	}
 
+	// Details of connected clients that are of little use as labels of
+	// their traffic, if enabled.
+	var openvpnClientInfoDesc *prometheus.Desc
+	if o.clientInfo {
+		openvpnClientInfoDesc = descs.new(
+			"server", "client_info",
+			"Data channel cipher, peer ID and username of a connection to the VPN server, with the value 1.",
+			prometheus.GaugeValue, withLabels("status_path", "common_name", "cipher", "peer_id", "username"))
+	}
+	var openvpnClientRoutesDesc *prometheus.Desc
+	if o.clientRoutes {
+		openvpnClientRoutesDesc = descs.new(
+			"server", "client_routes",
+			"Number of entries of the routing table routing to a client.",
+			prometheus.GaugeValue, withLabels("status_path", "common_name"))
+	}
+
+	// Configured columns are exported along with those known to the
+	// exporter, with the labels of their section.
+	metricsSeen := map[string]bool{}
+	for _, info := range descs.infos {
+		metricsSeen[info.Name] = true
+	}
+	for _, section := range []string{"CLIENT_LIST", "ROUTING_TABLE"} {
+		header := openvpnServerHeaders[section]
+		labels := serverHeaderClientLabels
+		if section == "ROUTING_TABLE" {
+			labels = serverHeaderRoutingLabels
+		}
+		for _, column := range o.metricColumns[section] {
+			fqName := prometheus.BuildFQName(o.namespace, "server", column.Name)
+			if metricsSeen[fqName] {
+				return nil, fmt.Errorf("metric %q of column %q is already exported", fqName, column.Column)
+			}
+			metricsSeen[fqName] = true
+			valueType := prometheus.GaugeValue
+			if column.Type == config.MetricTypeCounter {
+				valueType = prometheus.CounterValue
+			}
+			help := column.Help
+			if help == "" {
+				help = fmt.Sprintf("Value of the %q column of %s entries.", column.Column, section)
+			}
+			header.Metrics = append(header.Metrics, OpenvpnServerHeaderField{
+				Column:    column.Column,
+				Desc:      descs.new("server", column.Name, help, valueType, labels),
+				ValueType: valueType,
+			})
+		}
+		openvpnServerHeaders[section] = header
+	}
+
+	// Series of individual clients, which are limited in number across
+	// all status paths.
+	clientSeriesDescs := map[*prometheus.Desc]bool{openvpnClientSessionsDesc: true}
+	for _, desc := range []*prometheus.Desc{openvpnClientInfoDesc, openvpnClientRoutesDesc} {
+		if desc != nil {
+			clientSeriesDescs[desc] = true
+		}
+	}
+	for _, header := range openvpnServerHeaders {
+		for _, metric := range header.Metrics {
+			clientSeriesDescs[metric.Desc] = true
+		}
+	}
+	droppedClientSeriesDesc := descs.new(
+		"", "dropped_client_series_total",
+		"Number of per client series not exported because a collection exceeded the maximum number of these series.",
+		prometheus.CounterValue, nil)
+
+	// Traffic counters of clients unknown to the exporter, if enabled.
+	var clientCounters *statDescs
+	if o.unknownCounters {
+		clientCounters = &statDescs{
+			namespace:   o.namespace,
+			subsystem:   "client",
+			constLabels: o.constLabels,
+			labels:      withLabels("status_path"),
+			help:        "Statistic %q of the client.",
+			descs:       map[string]*prometheus.Desc{},
+		}
+	}
+
+	reserved := map[string]bool{}
+	for _, info := range descs.infos {
+		reserved[info.Name] = true
+	}
+	globalStats.reserved = reserved
+	if clientCounters != nil {
+		clientCounters.reserved = reserved
+	}
+
 	return &OpenVPNExporter{
-		statusPaths:                 statusPaths,
-		openvpnUpDesc:               openvpnUpDesc,
-		openvpnStatusUpdateTimeDesc: openvpnStatusUpdateTimeDesc,
-		openvpnConnectedClientsDesc: openvpnConnectedClientsDesc,
-		openvpnClientDescs:          openvpnClientDescs,
-		openvpnServerHeaders:        openvpnServerHeaders,
+		logger:                       logger,
+		duplicatePolicy:              o.duplicatePolicy,
+		timeout:                      o.timeout,
+		pathTimeout:                  o.pathTimeout,
+		maxAge:                       o.maxAge,
+		workers:                      make(chan struct{}, o.concurrency),
+		clock:                        o.clock,
+		hardened:                     o.hardened,
+		circuitFailures:              o.circuitFailures,
+		circuitBackoff:               o.circuitBackoff,
+		retryBackoff:                 o.retryBackoff,
+		recorder:                     o.recorder,
+		targets:                      targets,
+		labelNames:                   labelNames,
+		openvpnUpDesc:                openvpnUpDesc,
+		openvpnCollectorPanicsDesc:   openvpnCollectorPanicsDesc,
+		openvpnDroppedRowsDesc:       openvpnDroppedRowsDesc,
+		openvpnOversizedDesc:         openvpnOversizedDesc,
+		openvpnQuarantinedDesc:       openvpnQuarantinedDesc,
+		openvpnCircuitOpenDesc:       openvpnCircuitOpenDesc,
+		openvpnStandbyDesc:           openvpnStandbyDesc,
+		openvpnLastSuccessDesc:       openvpnLastSuccessDesc,
+		openvpnUnsupportedKeysDesc:   openvpnUnsupportedKeysDesc,
+		openvpnSourceInfoDesc:        openvpnSourceInfoDesc,
+		openvpnScrapeErrorDesc:       openvpnScrapeErrorDesc,
+		openvpnScrapeTimeoutDesc:     openvpnScrapeTimeoutDesc,
+		openvpnScrapeDurationDesc:    openvpnScrapeDurationDesc,
+		openvpnScrapeErrorsDesc:      openvpnScrapeErrorsDesc,
+		openvpnStatusUpdateTimeDesc:  openvpnStatusUpdateTimeDesc,
+		openvpnStatusFileMtimeDesc:   openvpnStatusFileMtimeDesc,
+		openvpnStartTimeDesc:         openvpnStartTimeDesc,
+		openvpnRestartsDesc:          openvpnRestartsDesc,
+		openvpnClientDurationDesc:    openvpnClientDurationDesc,
+		openvpnClientReconnectsDesc:  openvpnClientReconnectsDesc,
+		openvpnConnectedClientsDescs: openvpnConnectedClientsDescs,
+		openvpnClientsReceivedDesc:   openvpnClientsReceivedDesc,
+		openvpnClientsSentDesc:       openvpnClientsSentDesc,
+		openvpnAuthenticatingDesc:    openvpnAuthenticatingDesc,
+		openvpnRoutesDesc:            openvpnRoutesDesc,
+		openvpnClientSessionsDesc:    openvpnClientSessionsDesc,
+		openvpnServerInfoDesc:        openvpnServerInfoDesc,
+		openvpnClientInfoDesc:        openvpnClientInfoDesc,
+		openvpnClientRoutesDesc:      openvpnClientRoutesDesc,
+		openvpnClientDescs:           openvpnClientDescs,
+		openvpnCompressionRatioDescs: openvpnCompressionRatioDescs,
+		openvpnServerHeaders:         openvpnServerHeaders,
+		disabledEntries:              o.disabledEntries,
+		globalStatDescs:              globalStats,
+		clientCounterDescs:           clientCounters,
+		metricInfos:                  descs.infos,
+		descs:                        descs.descs,
+		maxClientSeries:              o.maxClientSeries,
+		clientSeriesDescs:            clientSeriesDescs,
+		droppedClientSeriesDesc:      droppedClientSeriesDesc,
 	}, nil
 }
 
-// Converts OpenVPN status information into Prometheus metrics. This
-// function automatically detects whether the file contains server or
-// client metrics. For server metrics, it also distinguishes between the
-// version 2 and 3 file formats.
-func (e *OpenVPNExporter) collectStatusFromReader(statusPath string, file io.Reader, ch chan<- prometheus.Metric) error {
+// MetricInfo describes a metric exported by OpenVPNExporter.
+type MetricInfo struct {
+	Name      string
+	Help      string
+	ValueType prometheus.ValueType
+	Labels    []string
+}
+
+// Creates metric descriptors, keeping track of the metrics they describe.
+type descBuilder struct {
+	namespace   string
+	constLabels prometheus.Labels
+	infos       []MetricInfo
+	descs       []*prometheus.Desc
+}
+
+func (b *descBuilder) new(subsystem string, name string, help string, valueType prometheus.ValueType, labels []string) *prometheus.Desc {
+	fqName := prometheus.BuildFQName(b.namespace, subsystem, name)
+	b.infos = append(b.infos, MetricInfo{
+		Name:      fqName,
+		Help:      help,
+		ValueType: valueType,
+		Labels:    labels,
+	})
+	desc := prometheus.NewDesc(fqName, help, labels, b.constLabels)
+	b.descs = append(b.descs, desc)
+	return desc
+}
+
+// A compression ratio of clients, derived from the counters of the amount
+// of data before and after compression.
+type compressionRatio struct {
+	uncompressed string
+	compressed   string
+	desc         *prometheus.Desc
+}
+
+// Creates the descriptors of statistics of status files on demand, named
+// after the statistic, for statistics unknown to the exporter.
+type statDescs struct {
+	namespace   string
+	subsystem   string
+	constLabels prometheus.Labels
+	labels      []string
+	// Help of the metrics, formatted with the name of the statistic.
+	help string
+	// Names of the other metrics of the exporter, which statistics
+	// can't be exported as.
+	reserved map[string]bool
+
+	mu    sync.Mutex
+	descs map[string]*prometheus.Desc
+}
+
+// Returns the descriptor of a statistic, or nil if its name can't be
+// turned into a metric name of its own.
+func (g *statDescs) get(stat string) *prometheus.Desc {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if desc, ok := g.descs[stat]; ok {
+		return desc
+	}
+	var name []byte
+	for _, c := range []byte(strings.ToLower(stat)) {
+		if c >= 'a' && c <= 'z' || c >= '0' && c <= '9' {
+			name = append(name, c)
+		} else if len(name) > 0 && name[len(name)-1] != '_' {
+			name = append(name, '_')
+		}
+	}
+	fqName := prometheus.BuildFQName(g.namespace, g.subsystem, strings.TrimSuffix(string(name), "_"))
+	var desc *prometheus.Desc
+	if len(name) > 0 && !g.reserved[fqName] {
+		desc = prometheus.NewDesc(fqName, fmt.Sprintf(g.help, stat), g.labels, g.constLabels)
+	}
+	g.descs[stat] = desc
+	return desc
+}
+
+// MetricInfos returns the metrics exported by the exporter, in the order
+// in which they were defined.
+func (e *OpenVPNExporter) MetricInfos() []MetricInfo {
+	return e.metricInfos
+}
+
+// State of a single read of a status path.
+type scrape struct {
+	*target
+	ch chan<- prometheus.Metric
+	// Context of the read. A read that outlives its context has been
+	// abandoned, and its entries are no longer passed to the pipeline so
+	// that they don't mix with those of later reads.
+	ctx context.Context
+	// Time at which the statistics were updated, if the status file
+	// contains it.
+	updateTime time.Time
+	// Values of client list and routing table metrics by label values,
+	// if duplicate entries are summed.
+	sums     map[entryKey]*entrySum
+	sumOrder []*entrySum
+	// Version and modification time of the source, the result of the
+	// last read of it, and the status and entries of this read, which are
+	// cached if the source has a version.
+	version string
+	modTime time.Time
+	cached  *parseCache
+	status  *status.Status
+	entries []cachedEntry
+	// Keys of the entry metrics sent so far, used to skip duplicate
+	// entries.
+	recordedMetrics map[entryKey]struct{}
+	// Number of connected clients and their total traffic, and the
+	// number of entries that were collected or ignored because of the
+	// row limit.
+	connectedClients int
+	receivedBytes    float64
+	sentBytes        float64
+	rows             int
+	droppedRows      int
+	// Number of sessions by common name, told apart by their real
+	// address, and of routing table entries, in total and by common name
+	// if exported.
+	clientSessions map[string]int
+	sessionsSeen   map[string]bool
+	routes         int
+	clientRoutes   map[string]int
+	// Number of clients with the common name UNDEF that were ignored.
+	undefClients int
+	// Number of malformed lines and entries skipped in hardened mode.
+	quarantined int
+	// Number of lines skipped because of their unsupported key, by key.
+	unsupportedKeys map[string]int
+	// Start time of the OpenVPN daemon as told by the source, and the
+	// values of the traffic counters of client statistics.
+	startTime time.Time
+	counters  map[string]float64
+	// Whether the status holds client statistics.
+	client bool
+	// Report of the read, if it is debugged.
+	debug *DebugReport
+}
+
+// The sum of the values of entries resulting in the same metric.
+type entrySum struct {
+	field  OpenvpnServerHeaderField
+	labels []string
+	value  float64
+}
+
+// Identifies the metric of an entry column with the given label values.
+// Metrics are told apart by their descriptor, as a column may be exported
+// as more than one metric.
+type entryKey struct {
+	desc   *prometheus.Desc
+	labels string
+}
+
+func newEntryKey(field OpenvpnServerHeaderField, labels []string) entryKey {
+	return entryKey{desc: field.Desc, labels: strings.Join(labels, "\xff")}
+}
+
+// Adds the value of an entry to the sum for its labels, returning whether
+// an earlier entry had the same labels.
+func (s *scrape) addToSum(field OpenvpnServerHeaderField, labels []string, value float64) bool {
+	key := newEntryKey(field, labels)
+	if sum, ok := s.sums[key]; ok {
+		if field.ValueType == prometheus.GaugeValue {
+			sum.value = math.Max(sum.value, value)
+		} else {
+			sum.value += value
+		}
+		return true
+	}
+	if s.sums == nil {
+		s.sums = map[entryKey]*entrySum{}
+	}
+	sum := &entrySum{field: field, labels: labels, value: value}
+	s.sums[key] = sum
+	s.sumOrder = append(s.sumOrder, sum)
+	return false
+}
+
+// Sends a metric for the status path, appending the custom labels.
+func (s *scrape) emit(desc *prometheus.Desc, valueType prometheus.ValueType, value float64, labelValues ...string) {
+	s.ch <- prometheus.MustNewConstMetric(desc, valueType, value, s.labels(labelValues...)...)
+}
+
+// Converts OpenVPN status information into Prometheus metrics. Unless a
+// format is configured for the status path, the format of the file is
+// detected automatically.
+func (e *OpenVPNExporter) collectStatusFromReader(s *scrape, file io.Reader) error {
 	reader := bufio.NewReader(file)
-	buf, _ := reader.Peek(18)
-	if bytes.HasPrefix(buf, []byte("TITLE,")) {
-		// Server statistics, using format version 2.
-		return e.collectServerStatusFromReader(statusPath, reader, ch, ",")
-	} else if bytes.HasPrefix(buf, []byte("TITLE\t")) {
-		// Server statistics, using format version 3. The only
-		// difference compared to version 2 is that it uses tabs
-		// instead of spaces.
-		return e.collectServerStatusFromReader(statusPath, reader, ch, "\t")
-	} else if bytes.HasPrefix(buf, []byte("OpenVPN STATISTICS")) {
-		// Client statistics.
-		return e.collectClientStatusFromReader(statusPath, reader, ch)
-	} else if bytes.HasPrefix(buf, []byte("OpenVPN CLIENT LIS")) {
-		// Server statistics, using format version 3. The only
-		// difference compared to version 2 is that it uses tabs
-		// instead of spaces.
-		return e.collectServerStatusFromReaderV4(statusPath, reader, ch)
-	} else {
-		return fmt.Errorf("unexpected file contents: %q", buf)
+	format := status.Format(s.Format)
+	if format == "" || s.Format == config.FormatAuto {
+		var err error
+		if format, err = status.DetectFormat(reader); err != nil {
+			return err
+		}
+	}
+	if s.debug != nil {
+		s.debug.Format = format
+	}
+	// Entries of server status files are converted into metrics as
+	// they are parsed, instead of keeping them all in memory.
+	visitor := status.Visitor{
+		Location:    s.location,
+		TimeLayouts: s.timeLayouts,
+		Client: func(client status.Client) error {
+			return e.collectServerEntry(s, "CLIENT_LIST", client.Line, client.Columns)
+		},
+		Route: func(route status.Route) error {
+			return e.collectServerEntry(s, "ROUTING_TABLE", route.Line, route.Columns)
+		},
+	}
+	if s.debug != nil {
+		visitor.Header = func(section string, names []string) {
+			s.debug.addSection(e.openvpnServerHeaders[section], section, names)
+		}
+	}
+	visitor.UnsupportedKey = func(line int, key string) error {
+		e.unsupportedKey(s, line, key)
+		return nil
+	}
+	if e.hardened {
+		visitor.LineError = func(err *status.LineError) error {
+			e.quarantine(s, err)
+			return nil
+		}
+	}
+	observers := s.pipeline.readObservers()
+	for _, observer := range observers {
+		observer.BeginRead(s.Path)
+	}
+	st, err := status.Walk(reader, format, visitor)
+	if err != nil {
+		return err
+	}
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	for _, observer := range observers {
+		observer.EndRead(s.Path)
 	}
+	if s.quarantined > 0 {
+		e.logger.Warn("Skipped malformed lines of status file", "status_path", s.Path, "lines", s.quarantined)
+	}
+	return e.collectStatus(s, st)
 }
 
-// Converts OpenVPN server status information into Prometheus metrics.
-func (e *OpenVPNExporter) collectServerStatusFromReaderV4(statusPath string, file io.Reader, ch chan<- prometheus.Metric) error {
-	scanner := bufio.NewScanner(file)
-	scanner.Split(bufio.ScanLines)
+// Passes the entries of a read reused from the cache through the pipeline
+// again, as if the source was read, and converts the status information.
+func (e *OpenVPNExporter) collectStatusFromCache(s *scrape, cache *parseCache) error {
+	observers := s.pipeline.readObservers()
+	for _, observer := range observers {
+		observer.BeginRead(s.Path)
+	}
+	for _, entry := range cache.entries {
+		if err := e.collectServerEntry(s, entry.entryType, entry.line, entry.columns); err != nil {
+			return err
+		}
+	}
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	for _, observer := range observers {
+		observer.EndRead(s.Path)
+	}
+	return e.collectStatus(s, cache.status)
+}
 
-	var currentSection string
-	headersFound := map[string][]string{}
-	numberConnectedClient := 0
-	recordedMetrics := map[OpenvpnServerHeaderField][]string{}
+// Converts the status information of a read that is not part of its
+// entries into Prometheus metrics.
+func (e *OpenVPNExporter) collectStatus(s *scrape, st *status.Status) error {
+	s.status = st
+	s.updateTime = st.UpdatedAt()
+	if st.Client != nil {
+		return e.collectClientStats(s, st.Client)
+	}
+	return e.collectServerStatus(s, st.Server)
+}
 
-	for scanner.Scan() {
-		line := scanner.Text()
+// Converts OpenVPN server status information into Prometheus metrics,
+// after its entries have been collected.
+func (e *OpenVPNExporter) collectServerStatus(s *scrape, st *status.ServerStatus) error {
+	if !st.UpdatedAt.IsZero() {
+		s.emit(
+			e.openvpnStatusUpdateTimeDesc,
+			prometheus.GaugeValue,
+			float64(st.UpdatedAt.Unix()))
+	}
+	if version, arch := st.Version(); version != "" {
+		s.emit(e.openvpnServerInfoDesc, prometheus.GaugeValue, 1, version, arch)
+	}
+	if s.droppedRows > 0 {
+		e.logger.Warn("Status file exceeds the row limit, ignoring entries", "status_path", s.Path, "max_rows", s.maxRows, "dropped_rows", s.droppedRows)
+	}
 
-		// Skip empty lines
-		if len(line) == 0 {
-			continue
+	for _, sum := range s.sumOrder {
+		s.ch <- prometheus.MustNewConstMetric(
+			sum.field.Desc,
+			sum.field.ValueType,
+			sum.value,
+			sum.labels...)
+	}
+
+	for _, stat := range st.GlobalStats {
+		if desc := e.globalStatDescs.get(stat.Name); desc != nil {
+			s.emit(desc, prometheus.GaugeValue, stat.Value)
 		}
+	}
 
-		// Handle section headers without comma separator
-		if !strings.Contains(line, ",") {
-			if line == "OpenVPN CLIENT LIST" {
-				currentSection = "CLIENT_LIST"
-				continue
-			} else if line == "ROUTING TABLE" {
-				currentSection = "ROUTING_TABLE"
-				continue
-			} else if line == "GLOBAL STATS" {
-				currentSection = "GLOBAL_STATS"
+	// add the number of connected client
+	for _, desc := range e.openvpnConnectedClientsDescs {
+		s.emit(
+			desc,
+			prometheus.GaugeValue,
+			float64(s.connectedClients))
+	}
+	s.emit(
+		e.openvpnClientsReceivedDesc,
+		prometheus.GaugeValue,
+		s.receivedBytes)
+	s.emit(
+		e.openvpnClientsSentDesc,
+		prometheus.GaugeValue,
+		s.sentBytes)
+	if !e.disabledEntries["CLIENT_LIST"] {
+		for _, commonName := range sortedKeys(s.clientSessions) {
+			s.emit(
+				e.openvpnClientSessionsDesc,
+				prometheus.GaugeValue,
+				float64(s.clientSessions[commonName]),
+				commonName)
+		}
+	}
+	s.emit(
+		e.openvpnRoutesDesc,
+		prometheus.GaugeValue,
+		float64(s.routes))
+	for _, commonName := range sortedKeys(s.clientRoutes) {
+		s.emit(
+			e.openvpnClientRoutesDesc,
+			prometheus.GaugeValue,
+			float64(s.clientRoutes[commonName]),
+			commonName)
+	}
+	if s.undefPolicy == UndefCount {
+		s.emit(
+			e.openvpnAuthenticatingDesc,
+			prometheus.GaugeValue,
+			float64(s.undefClients))
+	}
+	return nil
+}
+
+// Adds the value of a numeric column of an entry to a total, if present.
+func addColumn(total *float64, columns status.Columns, column string) error {
+	value, ok := columns.Get(column)
+	if !ok {
+		return nil
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return err
+	}
+	*total += n
+	return nil
+}
+
+// Passes a client list or routing table entry through the pipeline of the
+// status path, exporting the relevant columns of the entries it keeps as
+// individual metrics.
+func (e *OpenVPNExporter) collectServerEntry(s *scrape, entryType string, line int, columns status.Columns) error {
+	header := e.openvpnServerHeaders[entryType]
+	entry := Entry{
+		Type:       entryType,
+		StatusPath: s.Path,
+		Line:       line,
+		Columns:    columns,
+		LabelNames: header.LabelColumns,
+	}
+	if e.hardened {
+		if err := validateEntry(header, columns); err != nil {
+			e.quarantine(s, err)
+			s.debug.addEntry(header, &entry, DebugQuarantined, err)
+			return nil
+		}
+	}
+	// Malformed entries are left out of the cache, so that reads reusing
+	// it don't count them again.
+	if s.version != "" {
+		s.entries = append(s.entries, cachedEntry{entryType: entryType, line: line, columns: columns})
+	}
+	// Clients that haven't authenticated yet are left out before the
+	// pipeline, so that its stages don't keep track of them either.
+	if s.undefPolicy != UndefKeep && columns.Value("Common Name") == "UNDEF" {
+		if entryType == "CLIENT_LIST" {
+			s.undefClients++
+		}
+		s.debug.addEntry(header, &entry, DebugUndef, nil)
+		return nil
+	}
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	if keep, err := s.pipeline.process(&entry); err != nil || !keep {
+		if err == nil {
+			s.debug.addEntry(header, &entry, DebugFiltered, nil)
+		}
+		return err
+	}
+	// When summing, a session listed more than once is only counted
+	// once, so that its traffic isn't added up several times.
+	repeated := false
+	if entryType == "CLIENT_LIST" {
+		if s.clientSessions == nil {
+			s.clientSessions = map[string]int{}
+			s.sessionsSeen = map[string]bool{}
+		}
+		commonName := strings.ToValidUTF8(entry.Columns.Value("Common Name"), "\uFFFD")
+		session := commonName + "\xff" + entry.Columns.Value("Real Address")
+		if s.sessionsSeen[session] {
+			repeated = e.duplicatePolicy == DuplicateSum
+		} else {
+			s.sessionsSeen[session] = true
+			s.clientSessions[commonName]++
+		}
+	}
+	if entryType == "CLIENT_LIST" && !repeated {
+		s.connectedClients++
+		if err := addColumn(&s.receivedBytes, entry.Columns, "Bytes Received"); err != nil {
+			return err
+		}
+		if err := addColumn(&s.sentBytes, entry.Columns, "Bytes Sent"); err != nil {
+			return err
+		}
+	} else if entryType == "ROUTING_TABLE" {
+		s.routes++
+		if e.openvpnClientRoutesDesc != nil && !e.disabledEntries[entryType] {
+			if s.clientRoutes == nil {
+				s.clientRoutes = map[string]int{}
+			}
+			s.clientRoutes[strings.ToValidUTF8(entry.Columns.Value("Common Name"), "\uFFFD")]++
+		}
+	}
+	if e.disabledEntries[entryType] {
+		s.debug.addEntry(header, &entry, DebugDisabled, nil)
+		return nil
+	}
+	if repeated {
+		e.logger.Debug("Skipping repeated client session", "status_path", s.Path, "line", line)
+		s.debug.addEntry(header, &entry, DebugDuplicate, nil)
+		return nil
+	}
+	if s.maxRows > 0 && s.rows >= s.maxRows {
+		s.droppedRows++
+		s.debug.addEntry(header, &entry, DebugDropped, nil)
+		return nil
+	}
+	s.rows++
+	if s.recordedMetrics == nil {
+		s.recordedMetrics = map[entryKey]struct{}{}
+	}
+
+	if entryType == "CLIENT_LIST" && e.openvpnClientInfoDesc != nil {
+		e.collectClientInfo(s, entry.Columns)
+	}
+
+	labels := s.labels(entry.LabelValues...)
+	outcome := DebugExported
+	for _, metric := range header.Metrics {
+		// Columns left empty, such as timestamps derived from times
+		// that couldn't be parsed, have no metric.
+		columnValue, ok := entry.Columns.Get(metric.Column)
+		if !ok || columnValue == "" {
+			continue
+		}
+		var key entryKey
+		if e.duplicatePolicy != DuplicateSum {
+			key = newEntryKey(metric, labels)
+			if _, ok := s.recordedMetrics[key]; ok {
+				e.logger.Debug("Skipping metric entry with same labels", "status_path", s.Path, "column", metric.Column, "labels", labels)
+				outcome = DebugDuplicate
 				continue
-			} else if line == "END" {
-				break
 			}
 		}
+		value, err := strconv.ParseFloat(columnValue, 64)
+		if err != nil {
+			return err
+		}
+		if e.duplicatePolicy == DuplicateSum {
+			if s.addToSum(metric, labels, value) {
+				outcome = DebugSummed
+			}
+			continue
+		}
+		s.recordedMetrics[key] = struct{}{}
+		s.ch <- prometheus.MustNewConstMetric(
+			metric.Desc,
+			metric.ValueType,
+			value,
+			labels...)
+	}
+	s.debug.addEntry(header, &entry, outcome, nil)
+	return nil
+}
 
-		fields := strings.Split(line, ",")
+// Exports the details of a client list entry as openvpn_server_client_info.
+// Columns that older OpenVPN versions lack result in empty labels.
+func (e *OpenVPNExporter) collectClientInfo(s *scrape, columns status.Columns) {
+	labels := s.labels(
+		strings.ToValidUTF8(columns.Value("Common Name"), "\uFFFD"),
+		strings.ToValidUTF8(columns.Value("Data Channel Cipher"), "\uFFFD"),
+		strings.ToValidUTF8(columns.Value("Peer ID"), "\uFFFD"),
+		strings.ToValidUTF8(columns.Value("Username"), "\uFFFD"))
+	key := entryKey{desc: e.openvpnClientInfoDesc, labels: strings.Join(labels, "\xff")}
+	if _, ok := s.recordedMetrics[key]; ok {
+		return
+	}
+	s.recordedMetrics[key] = struct{}{}
+	s.ch <- prometheus.MustNewConstMetric(e.openvpnClientInfoDesc, prometheus.GaugeValue, 1, labels...)
+}
 
-		switch currentSection {
-		case "CLIENT_LIST":
-			if strings.HasPrefix(line, "Updated,") {
-				// Handle timestamp
-				timeStr := fields[1]
-				timeStartStats, err := parseTime(timeStr)
-				if err != nil {
-					return err
-				}
-				ch <- prometheus.MustNewConstMetric(
-					e.openvpnStatusUpdateTimeDesc,
-					prometheus.GaugeValue,
-					float64(timeStartStats),
-					statusPath)
-			} else if strings.HasPrefix(line, "Common Name,") {
-				// Store headers
-				headersFound["CLIENT_LIST"] = fields
-			} else {
-				// Handle client data
-				if header, ok := e.openvpnServerHeaders["CLIENT_LIST"]; ok {
-					numberConnectedClient++
-
-					// Create column value mapping
-					columnValues := make(map[string]string)
-					headers := headersFound["CLIENT_LIST"]
-
-					for i, value := range fields {
-						if i < len(headers) {
-							columnValues[headers[i]] = value
-						}
-					}
-
-					// Extract labels
-					labels := []string{statusPath}
-					for _, column := range header.LabelColumns {
-						labels = append(labels, columnValues[column])
-					}
-
-					log.Println("LABELS: ", labels)
-
-					// Export metrics
-					for _, metric := range header.Metrics {
-						if columnValue, ok := columnValues[metric.Column]; ok {
-							if l, _ := recordedMetrics[metric]; !subslice(labels, l) {
-								value, err := strconv.ParseFloat(columnValue, 64)
-								if err != nil {
-									return err
-								}
-								ch <- prometheus.MustNewConstMetric(
-									metric.Desc,
-									metric.ValueType,
-									value,
-									labels...)
-								recordedMetrics[metric] = append(recordedMetrics[metric], labels...)
-							} else {
-								log.Printf("Metric entry with same labels: %s, %s", metric.Column, labels)
-							}
-						}
-					}
-				}
+// Checks that the metrics of an entry can be created: the columns of
+// values need to be numbers, and the columns of labels valid UTF-8.
+func validateEntry(header OpenvpnServerHeader, columns status.Columns) error {
+	for _, metric := range header.Metrics {
+		if value, ok := columns.Get(metric.Column); ok && value != "" {
+			if _, err := strconv.ParseFloat(value, 64); err != nil {
+				return err
 			}
+		}
+	}
+	for _, column := range header.LabelColumns {
+		if !utf8.ValidString(columns.Value(column)) {
+			return fmt.Errorf("column %q is not valid UTF-8", column)
+		}
+	}
+	return nil
+}
 
-		case "ROUTING_TABLE":
-			if strings.HasPrefix(line, "Virtual Address,") {
-				headersFound["ROUTING_TABLE"] = fields
-			} else if header, ok := e.openvpnServerHeaders["ROUTING_TABLE"]; ok {
-				columnValues := make(map[string]string)
-				headers := headersFound["ROUTING_TABLE"]
-
-				for i, value := range fields {
-					if i < len(headers) {
-						columnValues[headers[i]] = value
-					}
-				}
+// Skips a malformed line or entry in hardened mode.
+func (e *OpenVPNExporter) quarantine(s *scrape, err error) {
+	s.quarantined++
+	s.debug.addQuarantined(err)
+	e.logger.Debug("Skipping malformed line of status file", "status_path", s.Path, "err", err)
+}
 
-				labels := []string{statusPath}
-				for _, column := range header.LabelColumns {
-					labels = append(labels, columnValues[column])
-				}
+// Skips a line with an unsupported key, counting it by key.
+func (e *OpenVPNExporter) unsupportedKey(s *scrape, line int, key string) {
+	if s.unsupportedKeys == nil {
+		s.unsupportedKeys = map[string]int{}
+	}
+	s.unsupportedKeys[key]++
+	s.debug.addUnsupported(line, key)
+}
 
-				for _, metric := range header.Metrics {
-					if columnValue, ok := columnValues[metric.Column]; ok {
-						if l, _ := recordedMetrics[metric]; !subslice(labels, l) {
-							value, err := strconv.ParseFloat(columnValue, 64)
-							if err != nil {
-								return err
-							}
-							ch <- prometheus.MustNewConstMetric(
-								metric.Desc,
-								metric.ValueType,
-								value,
-								labels...)
-							recordedMetrics[metric] = append(recordedMetrics[metric], labels...)
-						}
-					}
-				}
+// Maximum number of unsupported keys counted per status path, and the key
+// under which further keys are counted. Malformed status files could
+// otherwise create any number of series.
+const (
+	maxUnsupportedKeys  = 20
+	otherUnsupportedKey = "other"
+)
+
+// Interval within which each unsupported key of a status path is logged
+// at most once.
+const unsupportedKeyLogInterval = time.Hour
+
+// Adds the lines skipped because of their unsupported key during a read
+// to the totals of a target, logging keys that weren't logged recently.
+func (e *OpenVPNExporter) countUnsupportedKeys(t *target, keys map[string]int) {
+	now := e.clock.Now()
+	for key, lines := range keys {
+		key = strings.ToValidUTF8(key, "\uFFFD")
+		if _, ok := t.unsupportedKeys[key]; !ok && len(t.unsupportedKeys) >= maxUnsupportedKeys {
+			key = otherUnsupportedKey
+		}
+		if t.unsupportedKeys == nil {
+			t.unsupportedKeys = map[string]uint64{}
+			t.unsupportedKeysLogged = map[string]time.Time{}
+		}
+		t.unsupportedKeys[key] += uint64(lines)
+		if logged, ok := t.unsupportedKeysLogged[key]; ok && now.Sub(logged) < unsupportedKeyLogInterval {
+			continue
+		}
+		t.unsupportedKeysLogged[key] = now
+		e.logger.Warn("Skipped lines of status file with unsupported key", "status_path", t.Path, "key", key, "lines", lines)
+	}
+}
+
+// Converts OpenVPN client status information into Prometheus metrics.
+func (e *OpenVPNExporter) collectClientStats(s *scrape, stats *status.ClientStats) error {
+	s.client = true
+	if !stats.UpdatedAt.IsZero() {
+		s.emit(
+			e.openvpnStatusUpdateTimeDesc,
+			prometheus.GaugeValue,
+			float64(stats.UpdatedAt.Unix()))
+	}
+	for _, counter := range stats.Counters {
+		desc, ok := e.openvpnClientDescs[counter.Name]
+		if !ok && e.clientCounterDescs != nil {
+			if desc := e.clientCounterDescs.get(counter.Name); desc != nil {
+				s.emit(
+					desc,
+					prometheus.UntypedValue,
+					counter.Value)
+				continue
 			}
 		}
+		if !ok {
+			e.unsupportedKey(s, 0, counter.Name)
+			continue
+		}
+		// Traffic counters.
+		if s.counters == nil {
+			s.counters = map[string]float64{}
+		}
+		s.counters[counter.Name] = counter.Value
+		s.emit(
+			desc,
+			prometheus.CounterValue,
+			counter.Value)
+	}
+	// Ratios are only defined once data was compressed.
+	for _, ratio := range e.openvpnCompressionRatioDescs {
+		uncompressed, uncompressedOK := stats.Counter(ratio.uncompressed)
+		compressed, compressedOK := stats.Counter(ratio.compressed)
+		if uncompressedOK && compressedOK && compressed > 0 {
+			s.emit(
+				ratio.desc,
+				prometheus.GaugeValue,
+				uncompressed/compressed)
+		}
 	}
+	return nil
+}
 
-	// Add the number of connected clients metric
-	ch <- prometheus.MustNewConstMetric(
-		e.openvpnConnectedClientsDesc,
-		prometheus.GaugeValue,
-		float64(numberConnectedClient),
-		statusPath)
+func (e *OpenVPNExporter) collectStatusFromSource(ctx context.Context, s *scrape) error {
+	var modTime time.Time
+	if versioner, ok := s.source.(sources.Versioner); ok {
+		version, mt, err := versioner.Version(ctx)
+		if err != nil {
+			return err
+		}
+		s.version, s.modTime, modTime = version, mt, mt
+	}
+	if !modTime.IsZero() {
+		s.emit(
+			e.openvpnStatusFileMtimeDesc,
+			prometheus.GaugeValue,
+			float64(modTime.UnixNano())/1e9)
+	}
+	if s.cached != nil && s.version != "" && s.cached.version == s.version {
+		// Unchanged since the last read, so there is no need to parse
+		// it again.
+		if err := e.collectStatusFromCache(s, s.cached); err != nil {
+			return err
+		}
+		return e.checkAge(s, modTime)
+	}
 
-	return scanner.Err()
+	conn, err := s.source.Open(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if starter, ok := conn.(sources.Starter); ok {
+		s.startTime = starter.StartTime()
+	}
+	// Closing the source unblocks reads that would otherwise outlive
+	// the scrape, such as those from a dead socket.
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	var r io.Reader = contextReader{ctx, conn}
+	if s.debug != nil {
+		r = io.TeeReader(r, &s.debug.contents)
+	} else if e.recorder != nil {
+		var contents bytes.Buffer
+		r = io.TeeReader(r, &contents)
+		defer func() {
+			e.recorder.Record(s.Path, contents.Bytes())
+		}()
+	}
+	var limited *sizeLimitReader
+	if s.maxBytes > 0 {
+		limited = &sizeLimitReader{r: r, remaining: s.maxBytes}
+		r = limited
+	}
+	if err := e.collectStatusFromReader(s, r); err != nil {
+		if limited != nil && limited.remaining < 0 {
+			// The parser may have failed on the truncated last line
+			// before seeing the error of the reader.
+			return fmt.Errorf("%w: more than %d bytes", ErrTooLarge, s.maxBytes)
+		}
+		return err
+	}
+	return e.checkAge(s, modTime)
 }
 
-// Helper function to parse time string into Unix timestamp
-func parseTime(timeStr string) (int64, error) {
-	// Parse time string in format "2024-10-21 09:23:08"
-	t, err := time.Parse("2006-01-02 15:04:05", strings.TrimSpace(timeStr))
-	if err != nil {
+// Fails if the statistics are older than the maximum age of the status
+// path, or the default maximum age of the exporter. Sources that don't
+// contain the time at which they were updated are judged by their
+// modification time, if known. Statistics of unknown age are only stale
+// if the status path configures a maximum age of its own.
+func (e *OpenVPNExporter) checkAge(s *scrape, modTime time.Time) error {
+	updateTime := s.updateTime
+	if updateTime.IsZero() {
+		updateTime = modTime
+	}
+	maxAge := time.Duration(s.MaxAge)
+	if maxAge == 0 {
+		if updateTime.IsZero() {
+			return nil
+		}
+		maxAge = e.maxAge
+	}
+	if maxAge == 0 {
+		return nil
+	}
+	if age := e.clock.Now().Sub(updateTime); age > maxAge {
+		return fmt.Errorf("%w: last updated %s ago", ErrStale, age.Round(time.Second))
+	}
+	return nil
+}
+
+// Reads from a reader until the context is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
 		return 0, err
 	}
-	return t.Unix(), nil
-}
-
-// Converts OpenVPN server status information into Prometheus metrics.
-func (e *OpenVPNExporter) collectServerStatusFromReader(statusPath string, file io.Reader, ch chan<- prometheus.Metric, separator string) error {
-	scanner := bufio.NewScanner(file)
-	scanner.Split(bufio.ScanLines)
-	headersFound := map[string][]string{}
-	// counter of connected client
-	numberConnectedClient := 0
-
-	recordedMetrics := map[OpenvpnServerHeaderField][]string{}
-
-	for scanner.Scan() {
-		fields := strings.Split(scanner.Text(), separator)
-		if fields[0] == "END" && len(fields) == 1 {
-			// Stats footer.
-		} else if fields[0] == "GLOBAL_STATS" {
-			// Global server statistics.
-		} else if fields[0] == "HEADER" && len(fields) > 2 {
-			// Column names for CLIENT_LIST and ROUTING_TABLE.
-			headersFound[fields[1]] = fields[2:]
-		} else if fields[0] == "TIME" && len(fields) == 3 {
-			// Time at which the statistics were updated.
-			timeStartStats, err := strconv.ParseFloat(fields[2], 64)
-			if err != nil {
-				return err
-			}
-			ch <- prometheus.MustNewConstMetric(
-				e.openvpnStatusUpdateTimeDesc,
+	return r.r.Read(p)
+}
+
+// Reads from a reader, failing with ErrTooLarge once more than a given
+// number of bytes was read. Unlike io.LimitReader, this tells a source
+// that is too large apart from one that ends at the limit.
+type sizeLimitReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (r *sizeLimitReader) Read(p []byte) (int, error) {
+	if r.remaining < 0 {
+		return 0, ErrTooLarge
+	}
+	// Read one byte beyond the limit to detect sources exceeding it.
+	if int64(len(p)) > r.remaining+1 {
+		p = p[:r.remaining+1]
+	}
+	n, err := r.r.Read(p)
+	r.remaining -= int64(n)
+	if r.remaining < 0 {
+		return n + int(r.remaining), ErrTooLarge
+	}
+	return n, err
+}
+
+// Collects the metrics of a single status path, including whether
+// collection was successful.
+func (e *OpenVPNExporter) collectTarget(ctx context.Context, t *target) []prometheus.Metric {
+	if timeout := e.targetTimeout(t); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	var metrics []prometheus.Metric
+	var err error
+	now := e.clock.Now()
+	if now.Before(t.circuitOpenUntil) {
+		err = errCircuitOpen
+	} else {
+		metrics, err = e.readTarget(ctx, t)
+		e.updateCircuit(t, err, now)
+	}
+	t.scrapeDuration = e.clock.Now().Sub(now)
+
+	up := 1.0
+	if err != nil {
+		var pe *panicError
+		if errors.As(err, &pe) {
+			// Metrics sent before the panic may be incomplete.
+			metrics = nil
+			t.panics++
+			e.logger.Error("Panic while scraping status file", "status_path", t.Path, "err", err, "stack", string(pe.stack))
+		} else if errors.Is(err, ErrTooLarge) {
+			t.oversized++
+			e.logger.Error("Status file exceeds the size limit", "status_path", t.Path, "max_bytes", t.maxBytes)
+		} else if errors.Is(err, errCircuitOpen) {
+			e.logger.Debug("Skipping status file with open circuit", "status_path", t.Path, "until", t.circuitOpenUntil)
+		} else {
+			e.logger.Error("Failed to scrape status file", "status_path", t.Path, "err", err)
+		}
+		up = 0.0
+		t.updateTime = time.Time{}
+	} else {
+		t.lastSuccess = e.clock.Now()
+	}
+	lastSuccess := 0.0
+	if !t.lastSuccess.IsZero() {
+		lastSuccess = float64(t.lastSuccess.UnixNano()) / 1e9
+	}
+	circuitOpen := 0.0
+	if now.Before(t.circuitOpenUntil) {
+		circuitOpen = 1.0
+	}
+	standby := 0.0
+	if t.standby {
+		standby = 1.0
+	}
+	reason := ""
+	if err != nil {
+		reason = scrapeErrorReason(err)
+		if t.scrapeErrors == nil {
+			t.scrapeErrors = map[string]uint64{}
+		}
+		t.scrapeErrors[reason]++
+	}
+	for _, r := range scrapeErrorReasons {
+		value := 0.0
+		if r == reason {
+			value = 1.0
+		}
+		metrics = append(metrics, prometheus.MustNewConstMetric(
+			e.openvpnScrapeErrorDesc,
+			prometheus.GaugeValue,
+			value,
+			t.labels(r)...),
+			prometheus.MustNewConstMetric(
+				e.openvpnScrapeErrorsDesc,
+				prometheus.CounterValue,
+				float64(t.scrapeErrors[r]),
+				t.labels(r)...))
+	}
+	metrics = append(metrics, prometheus.MustNewConstMetric(
+		e.openvpnScrapeDurationDesc,
+		prometheus.GaugeValue,
+		t.scrapeDuration.Seconds(),
+		t.labels()...))
+	timedOut := 0.0
+	if reason == "timeout" {
+		timedOut = 1.0
+	}
+	metrics = append(metrics, prometheus.MustNewConstMetric(
+		e.openvpnScrapeTimeoutDesc,
+		prometheus.GaugeValue,
+		timedOut,
+		t.labels()...))
+	if !t.startTime.IsZero() {
+		metrics = append(metrics, prometheus.MustNewConstMetric(
+			e.openvpnStartTimeDesc,
+			prometheus.GaugeValue,
+			float64(t.startTime.UnixNano())/1e9,
+			t.labels()...))
+	}
+	if t.client {
+		if !t.connectedSince.IsZero() {
+			metrics = append(metrics, prometheus.MustNewConstMetric(
+				e.openvpnClientDurationDesc,
 				prometheus.GaugeValue,
-				timeStartStats,
-				statusPath)
-		} else if fields[0] == "TITLE" && len(fields) == 2 {
-			// OpenVPN version number.
-		} else if header, ok := e.openvpnServerHeaders[fields[0]]; ok {
-			if fields[0] == "CLIENT_LIST" {
-				numberConnectedClient++
+				e.clock.Now().Sub(t.connectedSince).Seconds(),
+				t.labels()...))
+		}
+		metrics = append(metrics, prometheus.MustNewConstMetric(
+			e.openvpnClientReconnectsDesc,
+			prometheus.CounterValue,
+			float64(t.reconnects),
+			t.labels()...))
+	}
+	keys := make([]string, 0, len(t.unsupportedKeys))
+	for key := range t.unsupportedKeys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		metrics = append(metrics, prometheus.MustNewConstMetric(
+			e.openvpnUnsupportedKeysDesc,
+			prometheus.CounterValue,
+			float64(t.unsupportedKeys[key]),
+			t.labels(key)...))
+	}
+	return append(metrics,
+		prometheus.MustNewConstMetric(
+			e.openvpnRestartsDesc,
+			prometheus.CounterValue,
+			float64(t.restarts),
+			t.labels()...),
+		prometheus.MustNewConstMetric(
+			e.openvpnUpDesc,
+			prometheus.GaugeValue,
+			up,
+			t.labels()...),
+		prometheus.MustNewConstMetric(
+			e.openvpnCollectorPanicsDesc,
+			prometheus.CounterValue,
+			float64(t.panics),
+			t.labels()...),
+		prometheus.MustNewConstMetric(
+			e.openvpnDroppedRowsDesc,
+			prometheus.CounterValue,
+			float64(t.droppedRows),
+			t.labels()...),
+		prometheus.MustNewConstMetric(
+			e.openvpnOversizedDesc,
+			prometheus.CounterValue,
+			float64(t.oversized),
+			t.labels()...),
+		prometheus.MustNewConstMetric(
+			e.openvpnQuarantinedDesc,
+			prometheus.CounterValue,
+			float64(t.quarantined),
+			t.labels()...),
+		prometheus.MustNewConstMetric(
+			e.openvpnCircuitOpenDesc,
+			prometheus.GaugeValue,
+			circuitOpen,
+			t.labels()...),
+		prometheus.MustNewConstMetric(
+			e.openvpnStandbyDesc,
+			prometheus.GaugeValue,
+			standby,
+			t.labels()...),
+		prometheus.MustNewConstMetric(
+			e.openvpnLastSuccessDesc,
+			prometheus.GaugeValue,
+			lastSuccess,
+			t.labels()...))
+}
+
+// Reported for status paths that are not read because their circuit is
+// open.
+var errCircuitOpen = errors.New("circuit open after repeated failures")
+
+// Reasons for which scrapes of status paths fail, as exported by
+// openvpn_scrape_error.
+var scrapeErrorReasons = []string{
+	"not_found", "permission", "format", "incomplete", "stale",
+	"timeout", "too_large", "circuit_open", "other",
+}
+
+// Returns the reason for which a scrape failed with the given error.
+func scrapeErrorReason(err error) string {
+	var ne net.Error
+	switch {
+	case errors.Is(err, errCircuitOpen):
+		return "circuit_open"
+	case errors.Is(err, ErrTooLarge):
+		return "too_large"
+	case errors.Is(err, ErrStale):
+		return "stale"
+	case errors.Is(err, status.ErrIncomplete):
+		return "incomplete"
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, os.ErrDeadlineExceeded),
+		errors.As(err, &ne) && ne.Timeout():
+		return "timeout"
+	case errors.Is(err, fs.ErrNotExist):
+		return "not_found"
+	case errors.Is(err, fs.ErrPermission):
+		return "permission"
+	case errors.Is(err, status.ErrUnknownFormat),
+		errors.Is(err, status.ErrHeaderMismatch),
+		errors.Is(err, status.ErrUnsupportedKey),
+		errors.Is(err, status.ErrParseLine):
+		return "format"
+	}
+	return "other"
+}
+
+// Returns the maximum duration of a read of a target, or 0 if only the
+// timeout of the whole collection applies.
+func (e *OpenVPNExporter) targetTimeout(t *target) time.Duration {
+	if t.Timeout > 0 {
+		return time.Duration(t.Timeout)
+	}
+	return e.pathTimeout
+}
+
+// Tracks consecutive failures of a target, opening its circuit once there
+// are too many of them. While the circuit is open, the target is reported
+// as down without reading it, so that a source that went away doesn't
+// slow down every scrape. Once the backoff period has passed, the target
+// is read again, and a single failure opens the circuit again.
+func (e *OpenVPNExporter) updateCircuit(t *target, err error, now time.Time) {
+	if err == nil {
+		t.failures = 0
+		return
+	}
+	t.failures++
+	if e.circuitFailures > 0 && t.failures >= e.circuitFailures {
+		t.circuitOpenUntil = now.Add(e.circuitBackoff)
+		e.logger.Warn("Opening circuit of failing status file", "status_path", t.Path, "failures", t.failures, "until", t.circuitOpenUntil)
+	}
+}
+
+// Reads a target, returning the metrics of its status. Reads that fail
+// with a transient error are retried as long as the context allows.
+func (e *OpenVPNExporter) readTarget(ctx context.Context, t *target) ([]prometheus.Metric, error) {
+	backoff := e.retryBackoff
+	for attempt := 0; ; attempt++ {
+		metrics, s, err := e.readTargetOnce(ctx, t)
+		if err == nil {
+			if t.standby {
+				t.standby = false
+				e.logger.Info("Status file of standby server appeared", "status_path", t.Path)
 			}
-			// Entry that depends on a preceding HEADERS directive.
-			columnNames, ok := headersFound[fields[0]]
-			if !ok {
-				return fmt.Errorf("%s should be preceded by HEADERS", fields[0])
+			t.droppedRows += uint64(s.droppedRows)
+			t.quarantined += uint64(s.quarantined)
+			e.countUnsupportedKeys(t, s.unsupportedKeys)
+			e.detectRestart(t, s)
+			t.updateTime = s.updateTime
+			if t.updateTime.IsZero() {
+				t.updateTime = s.modTime
 			}
-			if len(fields) != len(columnNames)+1 {
-				return fmt.Errorf("HEADER for %s describes a different number of columns", fields[0])
+			t.cache = nil
+			if s.version != "" && s.status != nil {
+				t.cache = &parseCache{version: s.version, status: s.status, entries: s.entries}
 			}
+			return metrics, nil
+		}
+		t.cache = nil
+		if t.Standby && errors.Is(err, fs.ErrNotExist) {
+			return e.standbyMetrics(t), nil
+		}
+		if attempt >= t.retries || ctx.Err() != nil || !sources.IsTransient(err) {
+			return nil, err
+		}
+		e.logger.Debug("Retrying status file after transient error", "status_path", t.Path, "attempt", attempt+1, "err", err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, err
+		}
+		backoff *= 2
+	}
+}
 
-			// Store entry values in a map indexed by column name.
-			columnValues := map[string]string{}
-			for _, column := range header.LabelColumns {
-				columnValues[column] = ""
-			}
-			for i, column := range columnNames {
-				columnValues[column] = fields[i+1]
-			}
+// Returns the metrics of a status path configured as standby whose status
+// file is missing: a server without connected clients.
+func (e *OpenVPNExporter) standbyMetrics(t *target) []prometheus.Metric {
+	if !t.standby {
+		t.standby = true
+		e.logger.Info("Status file is missing, reporting server as standby", "status_path", t.Path)
+	}
+	var metrics []prometheus.Metric
+	for _, desc := range append([]*prometheus.Desc{e.openvpnRoutesDesc}, e.openvpnConnectedClientsDescs...) {
+		metrics = append(metrics, prometheus.MustNewConstMetric(
+			desc,
+			prometheus.GaugeValue,
+			0,
+			t.labels()...))
+	}
+	return metrics
+}
 
-			// Extract columns that should act as entry labels.
-			labels := []string{statusPath}
-			for _, column := range header.LabelColumns {
-				labels = append(labels, columnValues[column])
+// Detects restarts of the OpenVPN daemon of a target after a successful
+// read. The daemon restarted if its start time advanced, as told by the
+// source or by the modification time of its pidfile, or if any of the
+// traffic counters of client statistics went backwards.
+func (e *OpenVPNExporter) detectRestart(t *target, s *scrape) {
+	startTime := s.startTime
+	if startTime.IsZero() && t.Pidfile != "" {
+		if info, err := os.Stat(t.Pidfile); err == nil {
+			startTime = info.ModTime()
+		} else {
+			e.logger.Debug("Failed to read pidfile", "status_path", t.Path, "err", err)
+		}
+	}
+	restarted := false
+	if !startTime.IsZero() {
+		if !t.startTime.IsZero() && startTime.After(t.startTime) {
+			restarted = true
+		}
+		t.startTime = startTime
+	}
+	// Server statistics have no counters.
+	if s.counters != nil {
+		for name, value := range s.counters {
+			if previous, ok := t.counters[name]; ok && value < previous {
+				restarted = true
 			}
+		}
+		t.counters = s.counters
+	}
+	if restarted {
+		t.restarts++
+		e.logger.Info("OpenVPN daemon restarted", "status_path", t.Path, "start_time", t.startTime)
+	}
+	if s.client {
+		e.detectReconnect(t, s, startTime, restarted)
+	}
+}
 
-			// Export relevant columns as individual metrics.
-			for _, metric := range header.Metrics {
-				if columnValue, ok := columnValues[metric.Column]; ok {
-					if l, _ := recordedMetrics[metric]; !subslice(labels, l) {
-						value, err := strconv.ParseFloat(columnValue, 64)
-						if err != nil {
-							return err
-						}
-						ch <- prometheus.MustNewConstMetric(
-							metric.Desc,
-							metric.ValueType,
-							value,
-							labels...)
-						recordedMetrics[metric] = append(recordedMetrics[metric], labels...)
-					} else {
-						log.Printf("Metric entry with same labels: %s, %s", metric.Column, labels)
-					}
-				}
+// Keeps track of the connection of the tunnel of a client, given the
+// start time of its daemon and whether it restarted. The management
+// interface tells when the tunnel was last connected. Otherwise, a
+// tunnel that reconnected is known to be connected since the read that
+// noticed it, and a tunnel that didn't since its daemon started.
+func (e *OpenVPNExporter) detectReconnect(t *target, s *scrape, startTime time.Time, restarted bool) {
+	t.client = true
+	if restarted {
+		t.reconnects++
+	}
+	switch {
+	case !s.startTime.IsZero():
+		t.connectedSince = s.startTime
+	case restarted:
+		t.connectedSince = e.clock.Now()
+	case t.connectedSince.IsZero():
+		t.connectedSince = startTime
+	}
+}
+
+// Reads a target once. Reading is abandoned when the context is done,
+// even if it is stuck in a read that can't be interrupted.
+func (e *OpenVPNExporter) readTargetOnce(ctx context.Context, t *target) ([]prometheus.Metric, *scrape, error) {
+	ch := make(chan prometheus.Metric)
+	done := make(chan []prometheus.Metric, 1)
+	go func() {
+		var metrics []prometheus.Metric
+		for metric := range ch {
+			metrics = append(metrics, metric)
+		}
+		done <- metrics
+	}()
+
+	s := &scrape{target: t, ch: ch, ctx: ctx, cached: t.cache}
+	result := make(chan error, 1)
+	go func() {
+		err := e.collectStatusFromSourceSafely(ctx, s)
+		close(ch)
+		result <- err
+	}()
+	var err error
+	var metrics []prometheus.Metric
+	select {
+	case err = <-result:
+		metrics = <-done
+	case <-ctx.Done():
+		err = fmt.Errorf("collection aborted: %w", ctx.Err())
+	}
+	return metrics, s, err
+}
+
+// Returns the keys of a map in order, so that the metrics derived from it
+// are sent in a stable order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// An error caused by a panic while collecting a status path.
+type panicError struct {
+	value interface{}
+	stack []byte
+}
+
+func (pe *panicError) Error() string {
+	return fmt.Sprintf("panic: %v", pe.value)
+}
+
+// Calls collectStatusFromSource, converting panics into errors. This
+// prevents a single malformed status file from crashing the exporter and
+// taking the metrics of all other status paths down with it.
+func (e *OpenVPNExporter) collectStatusFromSourceSafely(ctx context.Context, s *scrape) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &panicError{value: r, stack: debug.Stack()}
+		}
+	}()
+	return e.collectStatusFromSource(ctx, s)
+}
+
+// Returns the targets to collect for a configured status path. Sources
+// that stand for multiple sources, such as glob patterns, are expanded
+// into a target per source, which is kept for as long as the source
+// exists to preserve its state.
+func (e *OpenVPNExporter) expandTarget(ctx context.Context, t *target) []*target {
+	expander, ok := t.source.(sources.Expander)
+	if !ok {
+		return []*target{t}
+	}
+	expanded, err := expander.Expand(ctx)
+	if err != nil {
+		e.logger.Error("Failed to expand status path", "status_path", t.Path, "err", err)
+		return nil
+	}
+	// Keep the order of the metrics stable, regardless of the order in
+	// which the sources are listed.
+	sort.Slice(expanded, func(i, j int) bool {
+		return expanded[i].Name() < expanded[j].Name()
+	})
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	children := make(map[string]*target, len(expanded))
+	var targets []*target
+	for _, source := range expanded {
+		child, ok := t.children[source.Name()]
+		if !ok {
+			child = &target{
+				StatusPath:  t.StatusPath,
+				source:      source,
+				labelValues: e.childLabelValues(t, source),
+				pipeline:    t.pipeline,
+				undefPolicy: t.undefPolicy,
+				location:    t.location,
+				timeLayouts: t.timeLayouts,
+				maxRows:     t.maxRows,
+				retries:     t.retries,
+				maxBytes:    t.maxBytes,
 			}
-		} else {
-			return fmt.Errorf("unsupported key: %q", fields[0])
+			child.Path = source.Name()
 		}
+		children[source.Name()] = child
+		targets = append(targets, child)
 	}
-	// add the number of connected client
-	ch <- prometheus.MustNewConstMetric(
-		e.openvpnConnectedClientsDesc,
-		prometheus.GaugeValue,
-		float64(numberConnectedClient),
-		statusPath)
-	return scanner.Err()
+	t.children = children
+	return targets
 }
 
-// Does slice contain string
-func contains(s []string, e string) bool {
-	for _, a := range s {
-		if a == e {
-			return true
+// Returns the values of the custom labels of a source that a target
+// expanded to, of which the labels override those of the target.
+func (e *OpenVPNExporter) childLabelValues(t *target, source sources.StatusSource) []string {
+	values := append([]string(nil), t.labelValues...)
+	labels := source.Labels()
+	for i, name := range e.labelNames {
+		if value, ok := labels[name]; ok {
+			values[i] = value
 		}
 	}
-	return false
+	return values
 }
 
-// Is a sub-slice of slice
-func subslice(sub []string, main []string) bool {
-	if len(sub) > len(main) {
-		return false
+// Describe sends the descriptors of all metrics the exporter may export.
+func (e *OpenVPNExporter) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range e.descs {
+		ch <- desc
 	}
-	for _, s := range sub {
-		if !contains(main, s) {
-			return false
+}
+
+// Collect sends the configuration of all status paths, followed by their
+// metrics. Metrics are sent in a stable order: by status path in the
+// configured order, with the sources of glob patterns sorted by name, and
+// by the order of the entries within each status file.
+func (e *OpenVPNExporter) Collect(ch chan<- prometheus.Metric) {
+	for _, t := range e.targets {
+		format := t.Format
+		if format == "" {
+			format = config.FormatAuto
 		}
+		ch <- prometheus.MustNewConstMetric(
+			e.openvpnSourceInfoDesc,
+			prometheus.GaugeValue,
+			1,
+			t.Path, sources.Type(t.source), format, strconv.FormatBool(t.individuals))
+	}
+	if e.background.Load() {
+		e.snapshotMtx.Lock()
+		metrics := e.snapshot
+		e.snapshotMtx.Unlock()
+		e.sendMetrics(ch, metrics)
+		return
+	}
+
+	ctx := context.Background()
+	if e.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.timeout)
+		defer cancel()
+	}
+
+	var targets []*target
+	for _, t := range e.targets {
+		targets = append(targets, e.expandTarget(ctx, t)...)
 	}
-	return true
+
+	// Collect targets in parallel, but send their metrics in the order
+	// of the targets.
+	results := make([][]prometheus.Metric, len(targets))
+	var collected atomic.Bool
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		e.workers <- struct{}{}
+		go func(i int, t *target) {
+			defer wg.Done()
+			defer func() { <-e.workers }()
+			var ok bool
+			results[i], ok = e.refreshTarget(ctx, t)
+			if ok {
+				collected.Store(true)
+			}
+		}(i, t)
+	}
+	wg.Wait()
+	metrics, dropped := e.capClientSeries(results)
+	// Metrics reused from previous collections have been counted then.
+	if collected.Load() {
+		e.countDroppedClientSeries(dropped)
+	}
+	e.sendMetrics(ch, metrics)
 }
 
-// Converts OpenVPN client status information into Prometheus metrics.
-func (e *OpenVPNExporter) collectClientStatusFromReader(statusPath string, file io.Reader, ch chan<- prometheus.Metric) error {
-	scanner := bufio.NewScanner(file)
-	scanner.Split(bufio.ScanLines)
-	for scanner.Scan() {
-		fields := strings.Split(scanner.Text(), ",")
-		if fields[0] == "END" && len(fields) == 1 {
-			// Stats footer.
-		} else if fields[0] == "OpenVPN STATISTICS" && len(fields) == 1 {
-			// Stats header.
-		} else if fields[0] == "Updated" && len(fields) == 2 {
-			// Time at which the statistics were updated.
-			location, _ := time.LoadLocation("Local")
-			timeParser, err := time.ParseInLocation("Mon Jan 2 15:04:05 2006", fields[1], location)
-			if err != nil {
-				return err
+// Returns the metrics of the targets of a collection, without the per
+// client series beyond the maximum number of these series, and the number
+// of series dropped. Clients are dropped as a whole, in the order in which
+// they first appear, so that each exported client has all of its series.
+// Series of individual clients are told apart by their status path and
+// common name, and series without a common name by all their labels.
+func (e *OpenVPNExporter) capClientSeries(results [][]prometheus.Metric) ([]prometheus.Metric, int) {
+	var metrics []prometheus.Metric
+	if e.maxClientSeries <= 0 {
+		for _, result := range results {
+			metrics = append(metrics, result...)
+		}
+		return metrics, 0
+	}
+	var order []string
+	series := map[string]int{}
+	clients := make([][]string, len(results))
+	for i, result := range results {
+		clients[i] = make([]string, len(result))
+		for j, metric := range result {
+			if !e.clientSeriesDescs[metric.Desc()] {
+				continue
 			}
-			ch <- prometheus.MustNewConstMetric(
-				e.openvpnStatusUpdateTimeDesc,
-				prometheus.GaugeValue,
-				float64(timeParser.Unix()),
-				statusPath)
-		} else if desc, ok := e.openvpnClientDescs[fields[0]]; ok && len(fields) == 2 {
-			// Traffic counters.
-			value, err := strconv.ParseFloat(fields[1], 64)
-			if err != nil {
-				return err
+			client := clientKey(metric)
+			if _, ok := series[client]; !ok {
+				order = append(order, client)
 			}
-			ch <- prometheus.MustNewConstMetric(
-				desc,
-				prometheus.CounterValue,
-				value,
-				statusPath)
-		} else {
-			return fmt.Errorf("unsupported key: %q", fields[0])
+			series[client]++
+			clients[i][j] = client
 		}
 	}
-	return scanner.Err()
+	kept, total := map[string]bool{}, 0
+	for _, client := range order {
+		if total+series[client] > e.maxClientSeries {
+			break
+		}
+		kept[client] = true
+		total += series[client]
+	}
+	dropped := 0
+	for i, result := range results {
+		for j, metric := range result {
+			if client := clients[i][j]; client != "" && !kept[client] {
+				dropped++
+				continue
+			}
+			metrics = append(metrics, metric)
+		}
+	}
+	return metrics, dropped
 }
 
-func (e *OpenVPNExporter) collectStatusFromFile(statusPath string, ch chan<- prometheus.Metric) error {
-	conn, err := os.Open(statusPath)
-	defer conn.Close()
-	if err != nil {
-		return err
+// Returns the key of the client of a per client series.
+func clientKey(metric prometheus.Metric) string {
+	var m dto.Metric
+	if err := metric.Write(&m); err != nil {
+		return metric.Desc().String()
+	}
+	var statusPath, commonName string
+	var all []string
+	for _, label := range m.Label {
+		switch label.GetName() {
+		case "status_path":
+			statusPath = label.GetValue()
+		case "common_name":
+			commonName = label.GetValue()
+		}
+		all = append(all, label.GetName()+"="+label.GetValue())
 	}
-	return e.collectStatusFromReader(statusPath, conn, ch)
+	if commonName == "" {
+		return metric.Desc().String() + "\xff" + strings.Join(all, "\xff")
+	}
+	return statusPath + "\xff" + commonName
 }
 
-func (e *OpenVPNExporter) Describe(ch chan<- *prometheus.Desc) {
-	ch <- e.openvpnUpDesc
+// Counts the series dropped from a collection, logging that the maximum
+// was hit.
+func (e *OpenVPNExporter) countDroppedClientSeries(dropped int) {
+	if dropped == 0 {
+		return
+	}
+	e.droppedClientSeries.Add(uint64(dropped))
+	e.logger.Warn("Dropped per client series exceeding the maximum", "max_client_series", e.maxClientSeries, "dropped", dropped)
 }
 
-func (e *OpenVPNExporter) Collect(ch chan<- prometheus.Metric) {
-	for _, statusPath := range e.statusPaths {
-		err := e.collectStatusFromFile(statusPath, ch)
-		if err == nil {
-			ch <- prometheus.MustNewConstMetric(
-				e.openvpnUpDesc,
-				prometheus.GaugeValue,
-				1.0,
-				statusPath)
-		} else {
-			log.Printf("Failed to scrape showq socket: %s", err)
-			ch <- prometheus.MustNewConstMetric(
-				e.openvpnUpDesc,
-				prometheus.GaugeValue,
-				0.0,
-				statusPath)
+// Sends the metrics of a collection, followed by the number of per client
+// series dropped so far.
+func (e *OpenVPNExporter) sendMetrics(ch chan<- prometheus.Metric, metrics []prometheus.Metric) {
+	for _, metric := range metrics {
+		ch <- metric
+	}
+	ch <- prometheus.MustNewConstMetric(
+		e.droppedClientSeriesDesc,
+		prometheus.CounterValue,
+		float64(e.droppedClientSeries.Load()))
+}
+
+// CheckStale returns an error wrapping ErrStale if the statistics of any
+// status path, as last collected, are older than maxAge or of unknown age,
+// such as those of status paths that could not be read. Status paths on
+// standby are left out, as they aren't updated. It must not be called
+// while metrics are being collected.
+func (e *OpenVPNExporter) CheckStale(maxAge time.Duration) error {
+	now := e.clock.Now()
+	for _, t := range e.targets {
+		targets := []*target{t}
+		if _, ok := t.source.(sources.Expander); ok {
+			targets = nil
+			for _, name := range sortedKeys(t.children) {
+				targets = append(targets, t.children[name])
+			}
+		}
+		for _, t := range targets {
+			if t.standby {
+				continue
+			}
+			if t.updateTime.IsZero() {
+				return fmt.Errorf("%w: statistics of %s have no update time", ErrStale, t.Path)
+			}
+			if age := now.Sub(t.updateTime); age > maxAge {
+				return fmt.Errorf("%w: statistics of %s were last updated %s ago", ErrStale, t.Path, age.Round(time.Second))
+			}
 		}
 	}
+	return nil
+}
+
+// Returns the metrics of a target, collecting them unless the metrics of
+// the previous read are recent enough, and whether they were collected.
+func (e *OpenVPNExporter) refreshTarget(ctx context.Context, t *target) ([]prometheus.Metric, bool) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if t.RefreshInterval == 0 || e.clock.Now().Sub(t.lastRead) >= time.Duration(t.RefreshInterval) {
+		t.lastMetrics = e.collectTarget(ctx, t)
+		t.lastRead = e.clock.Now()
+		return t.lastMetrics, true
+	}
+	return t.lastMetrics, false
+}
+
+// Run refreshes the metrics of all status paths in the background until
+// the context is done, at the given interval or the refresh interval of
+// the status path. While it runs, Collect returns the metrics of the last
+// refresh instead of reading the status paths, so that slow sources don't
+// slow down scrapes.
+func (e *OpenVPNExporter) Run(ctx context.Context, interval time.Duration) {
+	e.background.Store(true)
+	defer e.background.Store(false)
+
+	var wg sync.WaitGroup
+	for _, t := range e.targets {
+		wg.Add(1)
+		go func(t *target) {
+			defer wg.Done()
+			targetInterval := interval
+			if t.RefreshInterval > 0 {
+				targetInterval = time.Duration(t.RefreshInterval)
+			}
+			ticker := time.NewTicker(targetInterval)
+			defer ticker.Stop()
+			for {
+				e.refreshSnapshot(ctx, t)
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}(t)
+	}
+	wg.Wait()
+}
+
+// Refresh refreshes the metrics of all status paths once, so that they are
+// served as soon as Run is started.
+func (e *OpenVPNExporter) Refresh(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, t := range e.targets {
+		wg.Add(1)
+		go func(t *target) {
+			defer wg.Done()
+			e.refreshSnapshot(ctx, t)
+		}(t)
+	}
+	wg.Wait()
+}
+
+// Collects a target and the targets it expands to, storing their metrics
+// for Collect.
+func (e *OpenVPNExporter) refreshSnapshot(ctx context.Context, t *target) {
+	if e.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.timeout)
+		defer cancel()
+	}
+	var metrics []prometheus.Metric
+	for _, child := range e.expandTarget(ctx, t) {
+		e.workers <- struct{}{}
+		metrics = append(metrics, e.collectTarget(ctx, child)...)
+		<-e.workers
+	}
+	t.mtx.Lock()
+	t.snapshot = metrics
+	t.mtx.Unlock()
+	e.updateSnapshot()
+}
+
+// Combines the metrics of all status paths as last refreshed into the
+// metrics served by Collect, counting the per client series dropped from
+// them once.
+func (e *OpenVPNExporter) updateSnapshot() {
+	e.snapshotMtx.Lock()
+	defer e.snapshotMtx.Unlock()
+	results := make([][]prometheus.Metric, len(e.targets))
+	for i, t := range e.targets {
+		t.mtx.Lock()
+		results[i] = t.snapshot
+		t.mtx.Unlock()
+	}
+	metrics, dropped := e.capClientSeries(results)
+	e.countDroppedClientSeries(dropped)
+	e.snapshot = metrics
 }
@@ -0,0 +1,205 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporters
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A status file held in memory.
+type stringSource struct {
+	name     string
+	contents string
+}
+
+func (s stringSource) Open(ctx context.Context) (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader(s.contents)), nil
+}
+
+func (s stringSource) Name() string {
+	return s.name
+}
+
+func (s stringSource) Labels() map[string]string {
+	return nil
+}
+
+// Collects the metrics of a status file held in memory.
+func collectStatus(t *testing.T, contents string, opts ...Option) string {
+	t.Helper()
+	opts = append([]Option{
+		WithSources(stringSource{name: "test.status", contents: contents}),
+		WithTimeFormat(time.UTC),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+	}, opts...)
+	exporter, err := NewOpenVPNExporter(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(gatherText(t, exporter))
+}
+
+// Checks that the output contains all given lines.
+func expectLines(t *testing.T, output string, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		if !strings.Contains(output, line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, output)
+		}
+	}
+}
+
+func TestMalformedTimesV1(t *testing.T) {
+	output := collectStatus(t, `OpenVPN CLIENT LIST
+Updated,2024-10-21 09:23:08
+Common Name,Real Address,Bytes Received,Bytes Sent,Connected Since
+alice,192.0.2.1:56180,23070,735106,2024-10-21 09:22:14
+bob,192.0.2.2:56181,1000,2000,sometime yesterday
+ROUTING TABLE
+Virtual Address,Common Name,Real Address,Last Ref
+10.8.0.3,bob,192.0.2.2:56181,not a time
+GLOBAL STATS
+Max bcast/mcast queue length,2
+END
+`)
+	expectLines(t, output,
+		`openvpn_up{status_path="test.status"} 1`,
+		`openvpn_server_connected_clients{status_path="test.status"} 2`,
+		`openvpn_server_client_received_bytes_total{common_name="bob",connection_time="sometime yesterday",real_address="192.0.2.2:56181",status_path="test.status",username="bob",virtual_address=""} 1000`,
+		`openvpn_server_client_sent_bytes_total{common_name="bob",connection_time="sometime yesterday",real_address="192.0.2.2:56181",status_path="test.status",username="bob",virtual_address=""} 2000`,
+		`openvpn_server_client_connected_since_timestamp_seconds{common_name="alice",connection_time="2024-10-21 09:22:14",real_address="192.0.2.1:56180",status_path="test.status",username="alice",virtual_address=""} 1.729502534e+09`,
+		`openvpn_server_routes{status_path="test.status"} 1`,
+	)
+	if strings.Contains(output, `openvpn_server_client_connected_since_timestamp_seconds{common_name="bob"`) {
+		t.Errorf("unexpected connection time of bob in:\n%s", output)
+	}
+	if strings.Contains(output, `openvpn_server_route_last_reference_time_seconds{`) {
+		t.Errorf("unexpected route reference time in:\n%s", output)
+	}
+}
+
+func TestMaxClientSeriesKeepsWholeClients(t *testing.T) {
+	output := collectStatus(t, `TITLE,OpenVPN 2.6.12 x86_64-pc-linux-gnu
+TIME,2024-10-21 09:23:08,1729502588
+HEADER,CLIENT_LIST,Common Name,Real Address,Virtual Address,Bytes Received,Bytes Sent,Connected Since,Connected Since (time_t),Username
+CLIENT_LIST,alice,192.0.2.1:56180,10.8.0.2,100,200,2024-10-21 09:22:14,1729502534,alice
+CLIENT_LIST,bob,192.0.2.2:56181,10.8.0.3,300,400,2024-10-21 09:22:15,1729502535,bob
+HEADER,ROUTING_TABLE,Virtual Address,Common Name,Real Address,Last Ref,Last Ref (time_t)
+ROUTING_TABLE,10.8.0.2,alice,192.0.2.1:56180,2024-10-21 09:22:48,1729502568
+ROUTING_TABLE,10.8.0.3,bob,192.0.2.2:56181,2024-10-21 09:22:49,1729502569
+GLOBAL_STATS,Max bcast/mcast queue length,0
+END
+`, WithMaxClientSeries(7))
+	// Each client has five series, so only those of alice fit.
+	expectLines(t, output,
+		`openvpn_dropped_client_series_total 5`,
+		`openvpn_server_client_sessions{common_name="alice",status_path="test.status"} 1`,
+		`openvpn_server_route_last_reference_time_seconds{common_name="alice",real_address="192.0.2.1:56180",status_path="test.status",virtual_address="10.8.0.2"} 1.729502568e+09`,
+		`openvpn_server_connected_clients{status_path="test.status"} 2`,
+	)
+	if strings.Contains(output, `common_name="bob"`) {
+		t.Errorf("unexpected series of bob in:\n%s", output)
+	}
+}
+
+func TestMetricNamesBoth(t *testing.T) {
+	contents := `TITLE,OpenVPN 2.6.12 x86_64-pc-linux-gnu
+TIME,2024-10-21 09:23:08,1729502588
+HEADER,CLIENT_LIST,Common Name,Real Address,Virtual Address,Bytes Received,Bytes Sent,Connected Since,Connected Since (time_t),Username
+CLIENT_LIST,alice,192.0.2.1:56180,10.8.0.2,100,200,2024-10-21 09:22:14,1729502534,alice-user
+HEADER,ROUTING_TABLE,Virtual Address,Common Name,Real Address,Last Ref,Last Ref (time_t)
+GLOBAL_STATS,Max bcast/mcast queue length,0
+END
+`
+	current := `openvpn_server_client_received_bytes_total{common_name="alice",connection_time="2024-10-21 09:22:14",real_address="192.0.2.1:56180",status_path="test.status",username="alice",virtual_address="10.8.0.2"} 100`
+	kumina := `openvpn_server_client_received_bytes_total{common_name="alice",connection_time="1729502534",real_address="192.0.2.1:56180",status_path="test.status",username="alice-user",virtual_address="10.8.0.2"} 100`
+
+	output := collectStatus(t, contents, WithMetricNames(MetricNamesBoth))
+	expectLines(t, output,
+		`openvpn_server_connected_clients{status_path="test.status"} 1`,
+		`openvpn_openvpn_server_connected_clients{status_path="test.status"} 1`,
+		current)
+	if strings.Contains(output, kumina) {
+		t.Errorf("unexpected label values of kumina/openvpn_exporter in:\n%s", output)
+	}
+
+	output = collectStatus(t, contents, WithMetricNames(MetricNamesKumina))
+	expectLines(t, output,
+		`openvpn_openvpn_server_connected_clients{status_path="test.status"} 1`,
+		kumina)
+	if strings.Contains(output, "\nopenvpn_server_connected_clients{") {
+		t.Errorf("unexpected current metric names in:\n%s", output)
+	}
+}
+
+// A status file held in memory that never changes, counting its reads.
+type versionedSource struct {
+	stringSource
+	opens int
+}
+
+func (s *versionedSource) Open(ctx context.Context) (io.ReadCloser, error) {
+	s.opens++
+	return s.stringSource.Open(ctx)
+}
+
+func (s *versionedSource) Version(ctx context.Context) (string, time.Time, error) {
+	return "1", time.Date(2024, time.October, 21, 9, 23, 8, 0, time.UTC), nil
+}
+
+func TestCachedReadsPassThroughPipeline(t *testing.T) {
+	source := &versionedSource{stringSource: stringSource{name: "test.status", contents: `TITLE,OpenVPN 2.6.12 x86_64-pc-linux-gnu
+TIME,2024-10-21 09:23:08,1729502588
+HEADER,CLIENT_LIST,Common Name,Real Address,Virtual Address,Bytes Received,Bytes Sent,Connected Since,Connected Since (time_t),Username
+CLIENT_LIST,alice,192.0.2.1:56180,10.8.0.2,100,200,2024-10-21 09:22:14,1729502534,alice
+HEADER,ROUTING_TABLE,Virtual Address,Common Name,Real Address,Last Ref,Last Ref (time_t)
+ROUTING_TABLE,10.8.0.2,alice,192.0.2.1:56180,2024-10-21 09:22:48,1729502568
+GLOBAL_STATS,Max bcast/mcast queue length,0
+END
+`}}
+	relabeled := 0
+	exporter, err := NewOpenVPNExporter(
+		WithSources(source),
+		WithTimeFormat(time.UTC),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+		WithPipeline(Pipeline{Relabelers: []Relabeler{RelabelerFunc(func(entry *Entry) {
+			relabeled++
+			for i, name := range entry.LabelNames {
+				if name == "Common Name" {
+					entry.LabelValues[i] = strings.ToUpper(entry.LabelValues[i])
+				}
+			}
+		})}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for read := 1; read <= 2; read++ {
+		output := string(gatherText(t, exporter))
+		expectLines(t, output,
+			`openvpn_server_client_received_bytes_total{common_name="ALICE",connection_time="2024-10-21 09:22:14",real_address="192.0.2.1:56180",status_path="test.status",username="ALICE",virtual_address="10.8.0.2"} 100`,
+			`openvpn_server_route_last_reference_time_seconds{common_name="ALICE",real_address="192.0.2.1:56180",status_path="test.status",virtual_address="10.8.0.2"} 1.729502568e+09`,
+			`openvpn_status_file_mtime_seconds{status_path="test.status"} 1.729502588e+09`)
+		if relabeled != 2*read {
+			t.Errorf("read %d: relabeled %d entries, want %d", read, relabeled, 2*read)
+		}
+	}
+	if source.opens != 1 {
+		t.Errorf("source opened %d times, want 1", source.opens)
+	}
+}
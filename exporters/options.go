@@ -0,0 +1,428 @@
+package exporters
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/kumina/openvpn_exporter/config"
+	"github.com/kumina/openvpn_exporter/sources"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Option configures an OpenVPNExporter.
+type Option func(*options)
+
+type options struct {
+	logger            *slog.Logger
+	statusPaths       []config.StatusPath
+	sources           []sources.StatusSource
+	namespace         string
+	constLabels       prometheus.Labels
+	ignoreIndividuals bool
+	commonNames       config.Filter
+	normalization     config.CommonNameNormalization
+	duplicatePolicy   DuplicatePolicy
+	undefPolicy       UndefPolicy
+	timeout           time.Duration
+	pathTimeout       time.Duration
+	maxAge            time.Duration
+	concurrency       int
+	maxRows           int
+	circuitFailures   int
+	circuitBackoff    time.Duration
+	retries           map[string]int
+	retryBackoff      time.Duration
+	maxBytes          int64
+	maxClientSeries   int
+	location          *time.Location
+	timeLayouts       []string
+	lockFiles         bool
+	clock             Clock
+	pipeline          Pipeline
+	hardened          bool
+	metricNames       MetricNames
+	disabledEntries   map[string]bool
+	unknownCounters   bool
+	recorder          Recorder
+	labelColumns      map[string][]config.LabelColumn
+	metricColumns     map[string][]config.MetricColumn
+	clientInfo        bool
+	clientRoutes      bool
+}
+
+// DuplicatePolicy determines how client list and routing table entries
+// that would result in metrics with identical labels are exported.
+type DuplicatePolicy int
+
+const (
+	// DuplicateKeepFirst exports the first entry, ignoring the others.
+	DuplicateKeepFirst DuplicatePolicy = iota
+	// DuplicateSum exports the sum of the values of all entries, or their
+	// maximum for gauges such as timestamps.
+	DuplicateSum
+)
+
+// UndefPolicy determines how clients that haven't authenticated yet, of
+// which OpenVPN lists the common name as UNDEF, are exported.
+type UndefPolicy int
+
+const (
+	// UndefExclude ignores the client list and routing table entries of
+	// UNDEF clients.
+	UndefExclude UndefPolicy = iota
+	// UndefCount ignores the entries of UNDEF clients like UndefExclude,
+	// but counts the clients in openvpn_server_clients_authenticating.
+	UndefCount
+	// UndefKeep exports UNDEF clients like any other client.
+	UndefKeep
+)
+
+// UNDEF client policies by their names in the configuration.
+var undefPolicies = map[string]UndefPolicy{
+	config.UndefClientsExclude: UndefExclude,
+	config.UndefClientsCount:   UndefCount,
+	config.UndefClientsKeep:    UndefKeep,
+}
+
+// MetricNames determines the naming scheme of exported metrics.
+type MetricNames int
+
+const (
+	// MetricNamesCurrent exports metrics under their current names.
+	MetricNamesCurrent MetricNames = iota
+	// MetricNamesKumina exports metrics under the names and with the
+	// label values of the original kumina/openvpn_exporter.
+	MetricNamesKumina
+	// MetricNamesBoth exports metrics under both their current and their
+	// legacy names. Metrics whose name didn't change keep their current
+	// label values.
+	MetricNamesBoth
+)
+
+// WithLogger sets the logger. By default, slog.Default() is used.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithStatusPaths adds status paths to collect. The source of each path
+// is selected using sources.New.
+func WithStatusPaths(statusPaths ...config.StatusPath) Option {
+	return func(o *options) {
+		o.statusPaths = append(o.statusPaths, statusPaths...)
+	}
+}
+
+// WithSources adds status sources to collect, allowing sources that
+// can't be expressed as a status path. Their name is used as the
+// status_path label.
+func WithSources(srcs ...sources.StatusSource) Option {
+	return func(o *options) {
+		o.sources = append(o.sources, srcs...)
+	}
+}
+
+// WithNamespace sets the prefix of the names of all metrics, "openvpn" by
+// default.
+func WithNamespace(namespace string) Option {
+	return func(o *options) {
+		o.namespace = namespace
+	}
+}
+
+// WithConstLabels adds labels with fixed values to all metrics.
+func WithConstLabels(labels prometheus.Labels) Option {
+	return func(o *options) {
+		o.constLabels = labels
+	}
+}
+
+// WithIgnoreIndividuals omits the labels describing individual
+// connections from client metrics, for status paths that don't configure
+// this themselves.
+func WithIgnoreIndividuals(ignoreIndividuals bool) Option {
+	return func(o *options) {
+		o.ignoreIndividuals = ignoreIndividuals
+	}
+}
+
+// WithCommonNameFilter sets the filter for the common names of which
+// metrics are exported, for status paths that don't configure one
+// themselves.
+func WithCommonNameFilter(filter config.Filter) Option {
+	return func(o *options) {
+		o.commonNames = filter
+	}
+}
+
+// WithCommonNameNormalization sets how common names are normalized before
+// they are filtered and used as label values, for status paths that don't
+// configure this themselves.
+func WithCommonNameNormalization(normalization config.CommonNameNormalization) Option {
+	return func(o *options) {
+		o.normalization = normalization
+	}
+}
+
+// WithDuplicatePolicy sets how entries with identical labels are
+// exported. By default, only the first entry is exported.
+func WithDuplicatePolicy(policy DuplicatePolicy) Option {
+	return func(o *options) {
+		o.duplicatePolicy = policy
+	}
+}
+
+// WithUndefPolicy sets how clients with the common name UNDEF are
+// exported, for status paths that don't configure this themselves. By
+// default, they are excluded.
+func WithUndefPolicy(policy UndefPolicy) Option {
+	return func(o *options) {
+		o.undefPolicy = policy
+	}
+}
+
+// WithTimeout limits the duration of a single collection of all status
+// paths. Status paths that can't be read in time are reported as down.
+// By default, there is no limit.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
+
+// WithPathTimeout limits the duration of a read of a single status path,
+// including its retries, so that a source that hangs, such as a status
+// file on an unresponsive NFS mount, doesn't use up the time of the whole
+// collection. Status paths may configure a timeout of their own. By
+// default, only the timeout of the whole collection applies.
+func WithPathTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.pathTimeout = timeout
+	}
+}
+
+// WithMaxAge reports status paths as down with the reason "stale" once
+// their statistics are older than the given age, judged by the time in
+// the status file or, lacking it, the modification time of the source.
+// Status paths may configure a maximum age of their own. By default,
+// statistics never become stale.
+func WithMaxAge(maxAge time.Duration) Option {
+	return func(o *options) {
+		o.maxAge = maxAge
+	}
+}
+
+// WithConcurrency sets the number of status paths that are collected in
+// parallel. By default, status paths are collected one at a time.
+func WithConcurrency(concurrency int) Option {
+	return func(o *options) {
+		if concurrency > 0 {
+			o.concurrency = concurrency
+		}
+	}
+}
+
+// WithMaxRows limits the number of client list and routing table entries
+// collected per read of a status path that doesn't configure a limit
+// itself, bounding the memory used for huge status files. By default,
+// there is no limit.
+func WithMaxRows(maxRows int) Option {
+	return func(o *options) {
+		o.maxRows = maxRows
+	}
+}
+
+// WithCircuitBreaker stops reading a status path for the backoff period
+// after the given number of consecutive failed reads, reporting it as
+// down right away instead. This keeps scrapes fast when a source, such as
+// an NFS share or SSH host, goes away. By default, status paths are read
+// on every collection.
+func WithCircuitBreaker(failures int, backoff time.Duration) Option {
+	return func(o *options) {
+		o.circuitFailures = failures
+		o.circuitBackoff = backoff
+	}
+}
+
+// WithRetries sets how often reads that fail with a transient error, such
+// as a reset connection, are retried, by source type (see sources.Type).
+// Retries start after the given backoff, which doubles for every further
+// retry, and stop once the collection times out. By default, reads are
+// not retried.
+func WithRetries(retries map[string]int, backoff time.Duration) Option {
+	return func(o *options) {
+		o.retries = retries
+		o.retryBackoff = backoff
+	}
+}
+
+// WithMaxBytes limits the number of bytes read per read of a status path
+// that doesn't configure a limit itself. Reads of larger sources fail
+// with ErrTooLarge, protecting against status paths pointing at the wrong
+// file, such as OpenVPN's log. By default, there is no limit.
+func WithMaxBytes(maxBytes int64) Option {
+	return func(o *options) {
+		o.maxBytes = maxBytes
+	}
+}
+
+// WithMaxClientSeries limits the number of per client series exported
+// per collection across all status paths, such as the traffic of
+// individual clients, protecting Prometheus from servers listing tens of
+// thousands of stale entries. Further series are dropped and counted in
+// openvpn_dropped_client_series_total. By default, there is no limit.
+func WithMaxClientSeries(maxClientSeries int) Option {
+	return func(o *options) {
+		o.maxClientSeries = maxClientSeries
+	}
+}
+
+// WithTimeFormat sets the time zone of human readable timestamps of
+// status files, and layouts of these timestamps as taken by time.Parse,
+// tried before those written by OpenVPN, for status paths that don't
+// configure these themselves. By default, timestamps are in the local
+// time zone.
+func WithTimeFormat(location *time.Location, layouts ...string) Option {
+	return func(o *options) {
+		o.location = location
+		o.timeLayouts = layouts
+	}
+}
+
+// WithLockFiles sets whether local status files are read while holding a
+// shared advisory lock, for status paths that don't configure this
+// themselves. By default, files are not locked.
+func WithLockFiles(lockFiles bool) Option {
+	return func(o *options) {
+		o.lockFiles = lockFiles
+	}
+}
+
+// WithClock sets the clock used to judge the age of statistics and to
+// decide when to read status paths again. By default, the system clock is
+// used.
+func WithClock(clock Clock) Option {
+	return func(o *options) {
+		o.clock = clock
+	}
+}
+
+// WithPipeline adds stages through which the client list and routing
+// table entries of all status paths pass before they are exported. They
+// are applied after the common name filter and the removal of the labels
+// of individual connections.
+func WithPipeline(pipeline Pipeline) Option {
+	return func(o *options) {
+		o.pipeline = o.pipeline.then(pipeline)
+	}
+}
+
+// WithHardenedParsing makes malformed lines and entries of status files,
+// such as those caused by common names containing separators, invalid
+// UTF-8 or numbers out of range, skip the line instead of failing the
+// read. Skipped lines are counted in
+// openvpn_collector_quarantined_rows_total. By default, a malformed line
+// fails the read of the status path.
+func WithHardenedParsing(hardened bool) Option {
+	return func(o *options) {
+		o.hardened = hardened
+	}
+}
+
+// WithMetricNames sets the naming scheme of exported metrics, allowing
+// dashboards and alerts written for kumina/openvpn_exporter to keep
+// working. By default, metrics are exported under their current names.
+func WithMetricNames(names MetricNames) Option {
+	return func(o *options) {
+		o.metricNames = names
+	}
+}
+
+// WithoutEntryMetrics disables the per entry metrics of the given entry
+// types, "CLIENT_LIST" or "ROUTING_TABLE", for servers with too many
+// clients to export metrics for each of them. Entries still pass through
+// the pipeline, and client list entries still count towards the number
+// of connected clients and their total traffic.
+func WithoutEntryMetrics(entryTypes ...string) Option {
+	return func(o *options) {
+		if o.disabledEntries == nil {
+			o.disabledEntries = map[string]bool{}
+		}
+		for _, entryType := range entryTypes {
+			o.disabledEntries[entryType] = true
+		}
+	}
+}
+
+// WithLabelColumns sets the columns of the entries of a section,
+// "CLIENT_LIST" or "ROUTING_TABLE", exported as labels, replacing the
+// default labels of the section. Labels of columns an entry lacks are
+// empty.
+func WithLabelColumns(section string, columns ...config.LabelColumn) Option {
+	return func(o *options) {
+		if o.labelColumns == nil {
+			o.labelColumns = map[string][]config.LabelColumn{}
+		}
+		o.labelColumns[section] = columns
+	}
+}
+
+// WithMetricColumns exports numeric columns of the entries of a section,
+// "CLIENT_LIST" or "ROUTING_TABLE", as metrics, in addition to those known
+// to the exporter, such as columns added by newer OpenVPN versions.
+func WithMetricColumns(section string, columns ...config.MetricColumn) Option {
+	return func(o *options) {
+		if o.metricColumns == nil {
+			o.metricColumns = map[string][]config.MetricColumn{}
+		}
+		o.metricColumns[section] = append(o.metricColumns[section], columns...)
+	}
+}
+
+// WithClientInfo sets whether the data channel cipher, peer ID and
+// username of connected clients are exported as openvpn_server_client_info.
+// By default, they aren't.
+func WithClientInfo(clientInfo bool) Option {
+	return func(o *options) {
+		o.clientInfo = clientInfo
+	}
+}
+
+// WithClientRoutes sets whether the number of routing table entries of
+// each client is exported as openvpn_server_client_routes, for finding
+// clients whose iroutes inflate the routing table. By default, only the
+// total is exported.
+func WithClientRoutes(clientRoutes bool) Option {
+	return func(o *options) {
+		o.clientRoutes = clientRoutes
+	}
+}
+
+// WithUnknownClientCounters sets whether numeric statistics of client
+// status files unknown to the exporter, such as those added by newer
+// OpenVPN versions, are exported as untyped metrics named after the
+// statistic, instead of failing the read. By default, they fail it.
+func WithUnknownClientCounters(unknownCounters bool) Option {
+	return func(o *options) {
+		o.unknownCounters = unknownCounters
+	}
+}
+
+// Recorder receives the raw contents of the reads of status paths.
+type Recorder interface {
+	// Record is called after every read of a status path, successful or
+	// not, with the contents read up to the size limit. Reads that reuse
+	// the entries of an unchanged source aren't recorded. The contents
+	// must not be retained after Record returns.
+	Record(statusPath string, contents []byte)
+}
+
+// WithRecorder passes the raw contents of all reads of status paths to a
+// recorder, allowing problems with the parsing of status files to be
+// reproduced later.
+func WithRecorder(recorder Recorder) Option {
+	return func(o *options) {
+		o.recorder = recorder
+	}
+}
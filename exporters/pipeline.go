@@ -0,0 +1,197 @@
+package exporters
+
+import (
+	"github.com/kumina/openvpn_exporter/config"
+	"github.com/kumina/openvpn_exporter/pkg/status"
+)
+
+// Client list and routing table entries pass through a pipeline of stages
+// on their way from the status file to metrics:
+//
+//	parse → enrich → filter → relabel → emit
+//
+// Parsing and emitting are done by the exporter itself. The stages in
+// between are given by a Pipeline, allowing features such as common name
+// filtering or anonymization to be added without changing the parser or
+// the conversion into metrics.
+
+// Entry is a client list or routing table entry passing through the
+// pipeline.
+type Entry struct {
+	// Type of the entry, "CLIENT_LIST" or "ROUTING_TABLE".
+	Type string
+	// Name of the status path the entry was read from.
+	StatusPath string
+	// Line number of the entry in the status file, if known.
+	Line int
+	// Columns of the entry. Enrichers may replace them, e.g. by columns
+	// created with status.NewColumns.
+	Columns status.Columns
+	// Names of the columns exported as labels, and the values of these
+	// labels. Values are taken from Columns after enrichment, and may be
+	// changed by relabelers. Names are shared between entries and must
+	// not be modified.
+	LabelNames  []string
+	LabelValues []string
+}
+
+// Enricher adds information to entries before they are filtered.
+type Enricher interface {
+	Enrich(entry *Entry) error
+}
+
+// Filter selects the entries that are exported.
+type Filter interface {
+	Keep(entry *Entry) bool
+}
+
+// Relabeler changes the label values of entries that are exported.
+type Relabeler interface {
+	Relabel(entry *Entry)
+}
+
+// ReadObserver is implemented by stages that need to know which entries
+// belong to the same read of a status path, such as to forget entries
+// that are gone. BeginRead is called before the first entry of a read,
+// and EndRead after its last entry if the read succeeded.
+type ReadObserver interface {
+	BeginRead(statusPath string)
+	EndRead(statusPath string)
+}
+
+// EnricherFunc adapts a function to the Enricher interface.
+type EnricherFunc func(entry *Entry) error
+
+func (f EnricherFunc) Enrich(entry *Entry) error {
+	return f(entry)
+}
+
+// FilterFunc adapts a function to the Filter interface.
+type FilterFunc func(entry *Entry) bool
+
+func (f FilterFunc) Keep(entry *Entry) bool {
+	return f(entry)
+}
+
+// RelabelerFunc adapts a function to the Relabeler interface.
+type RelabelerFunc func(entry *Entry)
+
+func (f RelabelerFunc) Relabel(entry *Entry) {
+	f(entry)
+}
+
+// Pipeline holds the stages entries pass through, in the order in which
+// they are applied.
+type Pipeline struct {
+	Enrichers  []Enricher
+	Filters    []Filter
+	Relabelers []Relabeler
+}
+
+// Returns a pipeline that applies the stages of p before those of q.
+func (p Pipeline) then(q Pipeline) Pipeline {
+	return Pipeline{
+		Enrichers:  append(p.Enrichers[:len(p.Enrichers):len(p.Enrichers)], q.Enrichers...),
+		Filters:    append(p.Filters[:len(p.Filters):len(p.Filters)], q.Filters...),
+		Relabelers: append(p.Relabelers[:len(p.Relabelers):len(p.Relabelers)], q.Relabelers...),
+	}
+}
+
+// Runs an entry through the stages of the pipeline, returning whether it
+// is exported.
+func (p *Pipeline) process(entry *Entry) (bool, error) {
+	for _, enricher := range p.Enrichers {
+		if err := enricher.Enrich(entry); err != nil {
+			return false, err
+		}
+	}
+	entry.LabelValues = make([]string, len(entry.LabelNames))
+	for i, name := range entry.LabelNames {
+		entry.LabelValues[i] = entry.Columns.Value(name)
+	}
+	for _, filter := range p.Filters {
+		if !filter.Keep(entry) {
+			return false, nil
+		}
+	}
+	for _, relabeler := range p.Relabelers {
+		relabeler.Relabel(entry)
+	}
+	return true, nil
+}
+
+// Returns the stages of the pipeline that observe reads.
+func (p *Pipeline) readObservers() []ReadObserver {
+	var observers []ReadObserver
+	add := func(stage interface{}) {
+		if observer, ok := stage.(ReadObserver); ok {
+			observers = append(observers, observer)
+		}
+	}
+	for _, stage := range p.Enrichers {
+		add(stage)
+	}
+	for _, stage := range p.Filters {
+		add(stage)
+	}
+	for _, stage := range p.Relabelers {
+		add(stage)
+	}
+	return observers
+}
+
+// Returns the pipeline without the stages that observe reads, as reads
+// other than those of scrapes would upset their tracking of entries.
+func (p Pipeline) withoutReadObservers() Pipeline {
+	var result Pipeline
+	for _, stage := range p.Enrichers {
+		if _, ok := stage.(ReadObserver); !ok {
+			result.Enrichers = append(result.Enrichers, stage)
+		}
+	}
+	for _, stage := range p.Filters {
+		if _, ok := stage.(ReadObserver); !ok {
+			result.Filters = append(result.Filters, stage)
+		}
+	}
+	for _, stage := range p.Relabelers {
+		if _, ok := stage.(ReadObserver); !ok {
+			result.Relabelers = append(result.Relabelers, stage)
+		}
+	}
+	return result
+}
+
+// Normalizes the common names of entries, so that filters, labels and
+// later stages see the normalized names.
+type commonNameNormalizer config.CommonNameNormalization
+
+func (n commonNameNormalizer) Enrich(entry *Entry) error {
+	commonName, ok := entry.Columns.Get("Common Name")
+	if !ok {
+		return nil
+	}
+	if normalized := config.CommonNameNormalization(n).Apply(commonName); normalized != commonName {
+		entry.Columns = entry.Columns.With("Common Name", normalized)
+	}
+	return nil
+}
+
+// Keeps the entries of which the common name passes a filter.
+type commonNameFilter config.Filter
+
+func (f commonNameFilter) Keep(entry *Entry) bool {
+	return config.Filter(f).Matches(entry.Columns.Value("Common Name"))
+}
+
+// Clears the labels that only carry information about individual
+// connections.
+type individualsRelabeler struct{}
+
+func (individualsRelabeler) Relabel(entry *Entry) {
+	for i, name := range entry.LabelNames {
+		if individualColumns[name] {
+			entry.LabelValues[i] = ""
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package exporters
+
+import (
+	"context"
+	"io"
+
+	"github.com/kumina/openvpn_exporter/sources"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// CollectFromReader converts status text into metrics, as the exporter
+// would for a status path with the given name, for programs that already
+// have the status at hand instead of a file or source to read it from.
+// Options configure the conversion like those of NewOpenVPNExporter, such
+// as the namespace, constant labels or pipeline; status paths and sources
+// given by them are ignored. Metrics about the collection itself, such as
+// openvpn_up, are left out, as a failed conversion is returned as an
+// error.
+//
+// No state is kept between calls, so stages of the pipeline that observe
+// reads only ever see a single read.
+func CollectFromReader(ctx context.Context, name string, r io.Reader, opts ...Option) ([]prometheus.Metric, error) {
+	opts = append(opts[:len(opts):len(opts)], func(o *options) {
+		o.statusPaths = nil
+		o.sources = []sources.StatusSource{readerSource{name: name, reader: r}}
+	})
+	e, err := NewOpenVPNExporter(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if e.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.timeout)
+		defer cancel()
+	}
+	metrics, _, err := e.readTargetOnce(ctx, e.targets[0])
+	if err != nil {
+		return nil, err
+	}
+	return metrics, nil
+}
+
+// A source of which the status is read from a reader given by the caller.
+type readerSource struct {
+	name   string
+	reader io.Reader
+}
+
+func (s readerSource) Open(ctx context.Context) (io.ReadCloser, error) {
+	return io.NopCloser(s.reader), nil
+}
+
+func (s readerSource) Name() string {
+	return s.name
+}
+
+func (s readerSource) Labels() map[string]string {
+	return nil
+}
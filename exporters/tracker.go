@@ -0,0 +1,107 @@
+package exporters
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Duration after which the clients of status paths that weren't read anew
+// are forgotten, such as those of files that disappeared from a glob
+// pattern.
+const clientTrackerExpiry = 10 * time.Minute
+
+// Client is a client exported by an exporter.
+type Client struct {
+	StatusPath     string
+	CommonName     string
+	VirtualAddress string
+}
+
+// ClientTracker is a pipeline stage keeping track of the clients that are
+// exported, for collectors of metrics about connected clients that
+// status files don't provide, such as their round trip time. Add it to
+// the relabelers of a pipeline, so that it sees the entries that pass
+// the filters.
+type ClientTracker struct {
+	clock Clock
+
+	mu      sync.Mutex
+	current map[string]trackedClients
+	pending map[string][]Client
+}
+
+// The clients of the last successful read of a status path.
+type trackedClients struct {
+	clients []Client
+	read    time.Time
+}
+
+// NewClientTracker creates a tracker without clients.
+func NewClientTracker() *ClientTracker {
+	return &ClientTracker{
+		clock:   systemClock{},
+		current: map[string]trackedClients{},
+		pending: map[string][]Client{},
+	}
+}
+
+func (t *ClientTracker) BeginRead(statusPath string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending[statusPath] = nil
+}
+
+// Relabel records the client of a client list entry. The entry is left
+// unchanged.
+func (t *ClientTracker) Relabel(entry *Entry) {
+	if entry.Type != "CLIENT_LIST" {
+		return
+	}
+	client := Client{
+		StatusPath:     entry.StatusPath,
+		CommonName:     entry.Columns.Value("Common Name"),
+		VirtualAddress: entry.Columns.Value("Virtual Address"),
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending[entry.StatusPath] = append(t.pending[entry.StatusPath], client)
+}
+
+func (t *ClientTracker) EndRead(statusPath string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// Status files may list the same client multiple times.
+	var clients []Client
+	seen := map[Client]bool{}
+	for _, client := range t.pending[statusPath] {
+		if !seen[client] {
+			seen[client] = true
+			clients = append(clients, client)
+		}
+	}
+	t.current[statusPath] = trackedClients{clients: clients, read: t.clock.Now()}
+	delete(t.pending, statusPath)
+}
+
+// Clients returns the clients of the last successful read of every
+// status path, ordered by status path.
+func (t *ClientTracker) Clients() []Client {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.clock.Now()
+	var paths []string
+	for path, tracked := range t.current {
+		if now.Sub(tracked.read) > clientTrackerExpiry {
+			delete(t.current, path)
+			continue
+		}
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	var clients []Client
+	for _, path := range paths {
+		clients = append(clients, t.current[path].clients...)
+	}
+	return clients
+}
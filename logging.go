@@ -0,0 +1,219 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var logMessagesSuppressed = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: "openvpn",
+	Subsystem: "exporter",
+	Name:      "log_messages_suppressed_total",
+	Help:      "Number of repeated log messages that were suppressed.",
+})
+
+// Creates the logger configured through the -log.level, -log.format and
+// -log.dedup-window flags, writing to stderr.
+func newLogger(level string, format string, dedupWindow time.Duration) (*slog.Logger, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", level)
+	}
+	options := &slog.HandlerOptions{Level: l}
+	var handler slog.Handler
+	switch format {
+	case "logfmt":
+		handler = slog.NewTextHandler(os.Stderr, options)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	default:
+		return nil, fmt.Errorf("invalid log format %q", format)
+	}
+	if dedupWindow > 0 {
+		handler = newDedupHandler(handler, dedupWindow, logMessagesSuppressed)
+	}
+	return slog.New(handler), nil
+}
+
+// Logs an error and exits.
+func fatal(logger *slog.Logger, msg string, args ...interface{}) {
+	logger.Error(msg, args...)
+	closeLogger(logger)
+	os.Exit(1)
+}
+
+// Stops the background work of the logger, if any, logging the summaries of
+// the messages suppressed so far.
+func closeLogger(logger *slog.Logger) {
+	if h, ok := logger.Handler().(*dedupHandler); ok {
+		h.Close()
+	}
+}
+
+// A slog.Handler that collapses repeated messages. The first message with
+// a given level, text and attributes is passed on, while repetitions within
+// the same window are counted and summarized in a single message once the
+// window expires. This prevents status files that trigger the same warning
+// for every client from flooding the logs on every scrape, while the same
+// warning for different status paths or errors is still logged.
+type dedupHandler struct {
+	slog.Handler
+	// The attributes and groups added through WithAttrs and WithGroup,
+	// rendered as text, which are part of the key of the messages.
+	scope string
+	state *dedupState
+}
+
+type dedupState struct {
+	window     time.Duration
+	suppressed prometheus.Counter
+	ticker     *time.Ticker
+	done       chan struct{}
+	closeOnce  sync.Once
+
+	mtx     sync.Mutex
+	entries map[dedupKey]*dedupEntry
+}
+
+type dedupKey struct {
+	level slog.Level
+	msg   string
+	attrs string
+}
+
+type dedupEntry struct {
+	start time.Time
+	count int
+	// The handler and attributes of the first message, with which the
+	// summary is logged.
+	handler slog.Handler
+	attrs   []slog.Attr
+}
+
+func newDedupHandler(handler slog.Handler, window time.Duration, suppressed prometheus.Counter) *dedupHandler {
+	state := &dedupState{
+		window:     window,
+		suppressed: suppressed,
+		ticker:     time.NewTicker(window),
+		done:       make(chan struct{}),
+		entries:    map[dedupKey]*dedupEntry{},
+	}
+	go func() {
+		for {
+			select {
+			case now := <-state.ticker.C:
+				state.flush(now, false)
+			case <-state.done:
+				return
+			}
+		}
+	}()
+	return &dedupHandler{Handler: handler, state: state}
+}
+
+// Stops flushing in the background and summarizes all messages suppressed
+// so far. Messages logged afterwards are still deduplicated, but only
+// summarized once they are repeated after their window.
+func (h *dedupHandler) Close() {
+	s := h.state
+	s.closeOnce.Do(func() {
+		s.ticker.Stop()
+		close(s.done)
+		s.flush(time.Now(), true)
+	})
+}
+
+func (h *dedupHandler) Handle(ctx context.Context, r slog.Record) error {
+	var attrs []slog.Attr
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+	key := dedupKey{level: r.Level, msg: r.Message, attrs: h.scope + renderAttrs(attrs)}
+	s := h.state
+	s.mtx.Lock()
+	entry, ok := s.entries[key]
+	if ok && r.Time.Sub(entry.start) < s.window {
+		entry.count++
+		s.mtx.Unlock()
+		s.suppressed.Inc()
+		return nil
+	}
+	s.entries[key] = &dedupEntry{start: r.Time, handler: h.Handler, attrs: attrs}
+	s.mtx.Unlock()
+
+	if ok && entry.count > 0 {
+		s.summarize(ctx, key, entry, r.Time)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *dedupHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &dedupHandler{Handler: h.Handler.WithAttrs(attrs), scope: h.scope + renderAttrs(attrs), state: h.state}
+}
+
+func (h *dedupHandler) WithGroup(name string) slog.Handler {
+	return &dedupHandler{Handler: h.Handler.WithGroup(name), scope: h.scope + name + "{", state: h.state}
+}
+
+// Renders attributes as text for use in the key of a message.
+func renderAttrs(attrs []slog.Attr) string {
+	var b strings.Builder
+	for _, a := range attrs {
+		b.WriteString(a.String())
+		b.WriteByte(' ')
+	}
+	return b.String()
+}
+
+// Logs a summary of the repetitions of a message that were suppressed,
+// along with the attributes of the message.
+func (s *dedupState) summarize(ctx context.Context, key dedupKey, entry *dedupEntry, now time.Time) {
+	r := slog.NewRecord(now, key.level, "Suppressed repeated log messages", 0)
+	r.AddAttrs(
+		slog.String("message", key.msg),
+		slog.Int("count", entry.count),
+		slog.Duration("window", s.window))
+	r.AddAttrs(entry.attrs...)
+	entry.handler.Handle(ctx, r)
+}
+
+// Summarizes and forgets messages of which the window has expired, or all
+// messages if all is set.
+func (s *dedupState) flush(now time.Time, all bool) {
+	s.mtx.Lock()
+	expired := map[dedupKey]*dedupEntry{}
+	for key, entry := range s.entries {
+		if all || now.Sub(entry.start) >= s.window {
+			expired[key] = entry
+			delete(s.entries, key)
+		}
+	}
+	s.mtx.Unlock()
+
+	for key, entry := range expired {
+		if entry.count > 0 {
+			s.summarize(context.Background(), key, entry, now)
+		}
+	}
+}
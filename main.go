@@ -14,39 +14,699 @@
 package main
 
 import (
+	"context"
 	"flag"
-	"log"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"slices"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
+	"github.com/kumina/openvpn_exporter/collectors"
+	"github.com/kumina/openvpn_exporter/config"
 	"github.com/kumina/openvpn_exporter/exporters"
+	"github.com/kumina/openvpn_exporter/pkg/status"
+	"github.com/kumina/openvpn_exporter/plugins"
+	"github.com/kumina/openvpn_exporter/probes"
+	"github.com/kumina/openvpn_exporter/proxy"
+	"github.com/kumina/openvpn_exporter/state"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// Flags configuring the exporter and its logging, shared by the exporter
+// itself and its subcommands.
+type exporterFlags struct {
+	statusPaths       *string
+	configFile        *string
+	configPaths       *string
+	mgmtPassword      *string
+	ignoreIndividuals *bool
+	cnTrim            *bool
+	cnLowercase       *bool
+	cnStripSuffixes   *string
+	lockFiles         *bool
+	hardened          *bool
+	openvpn3          *bool
+	wireguard         *bool
+	dco               *bool
+	ebpfDevices       *string
+	timeout           *time.Duration
+	pathTimeout       *time.Duration
+	statusMaxAge      *time.Duration
+	maxClientSeries   *int
+	timeZone          *string
+	timeLayouts       *string
+	concurrency       *int
+	maxRows           *int
+	maxBytes          *int64
+	circuitFailures   *int
+	circuitBackoff    *time.Duration
+	retries           *string
+	retryBackoff      *time.Duration
+	logLevel          *string
+	logFormat         *string
+	logDedupWindow    *time.Duration
+	metricNames       *string
+	disable           *string
+	aggregateOnly     *bool
+	undefClients      *string
+	duplicates        *string
+	unknownCounters   *bool
+	clientInfo        *bool
+	clientRoutes      *bool
+
+	// Configuration, once loaded.
+	config *config.Config
+}
+
+func registerExporterFlags(fs *flag.FlagSet) *exporterFlags {
+	return &exporterFlags{
+		statusPaths:       fs.String("openvpn.status_paths", "/var/log/openvpn/status.log", "Comma separated paths at which OpenVPN places its status files, each optionally followed by static labels added to its metrics, as in /run/openvpn/s1.status:name=office:region=eu."),
+		configFile:        fs.String("config.file", "", "Path to a JSON configuration file with per status path options. Status paths configured in it are used instead of -openvpn.status_paths."),
+		mgmtPassword:      fs.String("openvpn.management_password", "", "Password of the management interfaces among the status paths that don't configure one. Accepts file:, env: and exec: secret references."),
+		configPaths:       fs.String("openvpn.config_paths", "", "Comma separated paths of OpenVPN server configuration files of which the options are exported."),
+		ignoreIndividuals: fs.Bool("ignore.individuals", false, "If ignoring metrics for individuals"),
+		cnTrim:            fs.Bool("common-name.trim", false, "Remove leading and trailing whitespace from common names before they are filtered and used as label values."),
+		cnLowercase:       fs.Bool("common-name.lowercase", false, "Convert common names to lower case before they are filtered and used as label values."),
+		cnStripSuffixes:   fs.String("common-name.strip-suffixes", "", "Comma separated suffixes, such as domains, removed from common names before they are filtered and used as label values."),
+		lockFiles:         fs.Bool("openvpn.lock_status_files", false, "Take a shared advisory lock (flock) on local status files while reading them, waiting for writers holding an exclusive lock."),
+		hardened:          fs.Bool("parser.hardened", false, "Skip malformed lines of status files, counting them in openvpn_collector_quarantined_rows_total, instead of reporting the status path as down."),
+		openvpn3:          fs.Bool("collector.openvpn3", false, "Export the sessions of the openvpn3-linux client, obtained from its D-Bus session manager using busctl."),
+		ebpfDevices:       fs.String("collector.ebpf.devices", "", "Comma separated tun devices of which the traffic per virtual address is counted by an eBPF program. Requires Linux and CAP_BPF and CAP_NET_RAW, or root."),
+		dco:               fs.Bool("collector.dco", false, "Export the traffic of the devices of OpenVPN's data channel offload kernel module, obtained over rtnetlink. Requires Linux."),
+		wireguard:         fs.Bool("collector.wireguard", false, "Export the peers of the host's WireGuard interfaces, obtained using \"wg show all dump\"."),
+		timeout:           fs.Duration("collector.timeout", 10*time.Second, "Maximum duration of collecting all status paths. Status paths that can't be read in time are reported as down. 0 disables the timeout."),
+		pathTimeout:       fs.Duration("collector.path-timeout", 0, "Maximum duration of reading a single status path, including retries, for status paths without a timeout of their own. Status paths that can't be read in time are reported as down, with openvpn_scrape_timeout 1. 0 leaves only -collector.timeout."),
+		statusMaxAge:      fs.Duration("openvpn.status_max_age", 0, "Age after which the statistics of status paths without a max_age of their own are stale, judged by the time in the status file or else its modification time. Stale status paths are reported as down, with openvpn_scrape_error{reason=\"stale\"} 1. 0 disables the check."),
+		maxClientSeries:   fs.Int("openvpn.max_client_series", 0, "Maximum number of per client series exported per collection across all status paths. Clients whose series exceed it are dropped as a whole and their series counted in openvpn_dropped_client_series_total. 0 disables the limit."),
+		timeZone:          fs.String("openvpn.time_zone", "", "Time zone of human readable timestamps of status files without a time_zone of their own, as an IANA name such as Europe/Amsterdam, for status files written on servers in another time zone. Defaults to the local time zone."),
+		timeLayouts:       fs.String("openvpn.time_layouts", "", "Comma separated layouts of human readable timestamps of status files without time_layouts of their own, in the syntax of Go's time package such as 02/01/2006 15:04:05, tried before the layouts written by OpenVPN."),
+		concurrency:       fs.Int("collector.concurrency", 4, "Maximum number of status paths collected in parallel."),
+		maxRows:           fs.Int("collector.max-rows", 0, "Maximum number of client list and routing table entries collected per status path. Further entries are ignored and counted in openvpn_collector_dropped_rows_total. 0 disables the limit."),
+		maxBytes:          fs.Int64("collector.max-bytes", 64<<20, "Maximum number of bytes read per read of a status path. Larger status paths are reported as down and counted in openvpn_collector_oversized_reads_total. 0 disables the limit."),
+		circuitFailures:   fs.Int("collector.circuit-breaker.failures", 0, "Number of consecutive failed reads after which a status path is reported as down without reading it for -collector.circuit-breaker.backoff. 0 disables the circuit breaker."),
+		circuitBackoff:    fs.Duration("collector.circuit-breaker.backoff", time.Minute, "Duration for which a status path is not read once its circuit is open."),
+		retries:           fs.String("collector.retries", "file=2,http=2,ssh=1,management=2", "Number of times reads failing with a transient error, such as a reset connection, are retried within -collector.timeout, as comma separated type=count pairs. Types are file, http, ssh, exec, management and custom."),
+		retryBackoff:      fs.Duration("collector.retry-backoff", 100*time.Millisecond, "Delay before the first retry of a failed read, doubling for every further retry."),
+		logLevel:          fs.String("log.level", "info", "Only log messages with the given severity or above. One of: debug, info, warn, error."),
+		logFormat:         fs.String("log.format", "logfmt", "Output format of log messages. One of: logfmt, json."),
+		logDedupWindow:    fs.Duration("log.dedup-window", time.Minute, "Window within which repetitions of the same log message are collapsed into a single summary. 0 disables deduplication."),
+		aggregateOnly:     fs.Bool("collector.aggregate-only", false, "Export only the totals of each status path, such as the number of connected clients, their total traffic and the number of routes, without any per client metrics. Same as -collector.disable=client_list,routing_table."),
+		disable:           fs.String("collector.disable", "", "Comma separated per entry metrics not to export, keeping the number of connected clients and their total traffic. Any of: client_list, routing_table."),
+		undefClients:      fs.String("collector.undef-clients", "exclude", "Handling of clients that haven't authenticated yet, listed with the common name UNDEF. One of: exclude, count (in openvpn_server_clients_authenticating), keep (export them like other clients)."),
+		duplicates:        fs.String("collector.duplicate-entries", "first", "Handling of client list and routing table entries with the same labels, such as sessions of a common name shared with duplicate-cn when combined with -ignore.individuals. One of: first (export the first entry), sum (export the sum of their traffic)."),
+		clientRoutes:      fs.Bool("collector.client-routes", false, "Export the number of routing table entries of each client as openvpn_server_client_routes, for finding clients whose iroutes inflate the routing table."),
+		clientInfo:        fs.Bool("collector.client-info", false, "Export the data channel cipher, peer ID and username of connected clients as openvpn_server_client_info, for finding clients using weak ciphers."),
+		unknownCounters:   fs.Bool("collector.client.unknown-counters", false, "Export statistics of client status files unknown to the exporter as untyped metrics named after the statistic, instead of reporting the status path as down."),
+		metricNames:       fs.String("compat.metric-names", "current", "Names of the exported metrics. One of: current, kumina (those of kumina/openvpn_exporter), both."),
+	}
+}
+
+// Creates the logger, exiting if the logging flags are invalid.
+func (f *exporterFlags) newLogger() *slog.Logger {
+	logger, err := newLogger(*f.logLevel, *f.logFormat, *f.logDedupWindow)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
+	return logger
+}
+
+// Returns the configuration, loading the configuration file if one is
+// provided. Without status paths in the configuration file, those of
+// -openvpn.status_paths are used.
+func (f *exporterFlags) loadConfig(logger *slog.Logger) (*config.Config, error) {
+	if f.config != nil {
+		return f.config, nil
+	}
+	c := &config.Config{}
+	if *f.configFile != "" {
+		logger.Info("Loading config file", "file", *f.configFile)
+		var err error
+		if c, err = config.Load(*f.configFile); err != nil {
+			return nil, err
+		}
+	}
+	if len(c.MonthNames) > 0 {
+		months := make(map[string]time.Month, len(c.MonthNames))
+		for name, month := range c.MonthNames {
+			months[name] = time.Month(month)
+		}
+		status.AddMonthNames(months)
+	}
+	if len(c.StatusPaths) == 0 {
+		for _, path := range strings.Split(*f.statusPaths, ",") {
+			if path != "" {
+				c.StatusPaths = append(c.StatusPaths, config.ParseStatusPath(path))
+			}
+		}
+	}
+	if *f.mgmtPassword != "" {
+		for i, sp := range c.StatusPaths {
+			if sp.Password.IsEmpty() && isManagementPath(sp.Path) {
+				c.StatusPaths[i].Password = config.Secret(*f.mgmtPassword)
+			}
+		}
+	}
+	f.config = c
+	return c, nil
+}
+
+// Returns whether a status path is read from OpenVPN's management
+// interface, given by its URL or as the path of its socket.
+func isManagementPath(path string) bool {
+	if scheme, _, ok := strings.Cut(path, "://"); ok {
+		return scheme == "tcp" || scheme == "unix" || scheme == "srv+tcp"
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.Mode()&os.ModeSocket != 0
+}
+
+// Loads the configuration file again, for reloading the configuration.
+// If it fails, the previously loaded configuration is kept.
+func (f *exporterFlags) reloadConfig(logger *slog.Logger) (*config.Config, error) {
+	previous := f.config
+	f.config = nil
+	c, err := f.loadConfig(logger)
+	if err != nil {
+		f.config = previous
+		return nil, err
+	}
+	return c, nil
+}
+
+// Returns the configured status paths.
+func (f *exporterFlags) loadStatusPaths(logger *slog.Logger) ([]config.StatusPath, error) {
+	c, err := f.loadConfig(logger)
+	if err != nil {
+		return nil, err
+	}
+	return c.StatusPaths, nil
+}
+
+// Creates the collectors of all compiled in plugins, of the exec plugins
+// and probes in the configuration file and of the enabled optional
+// collectors.
+func (f *exporterFlags) newPlugins(logger *slog.Logger) ([]prometheus.Collector, error) {
+	c, err := f.loadConfig(logger)
+	if err != nil {
+		return nil, err
+	}
+	var result []prometheus.Collector
+	if *f.openvpn3 {
+		result = append(result, collectors.NewOpenVPN3Collector(logger, *f.timeout))
+	}
+	if *f.wireguard {
+		result = append(result, collectors.NewWireGuardCollector(logger, *f.timeout))
+	}
+	if *f.dco {
+		result = append(result, collectors.NewDCOCollector(logger))
+	}
+	var configFiles []collectors.ServerConfigFile
+	if *f.configPaths != "" {
+		for _, path := range strings.Split(*f.configPaths, ",") {
+			configFiles = append(configFiles, collectors.ServerConfigFile{Path: path})
+		}
+	}
+	pidfiles := map[string]string{}
+	for _, instance := range c.Instances {
+		if instance.Config != "" {
+			configFiles = append(configFiles, collectors.ServerConfigFile{Path: instance.Config, Instance: instance.Name})
+		}
+		if instance.Pidfile != "" {
+			pidfiles[instance.Name] = instance.Pidfile
+		}
+	}
+	if len(configFiles) > 0 {
+		result = append(result, collectors.NewServerConfigCollector(configFiles, logger))
+	}
+	if len(pidfiles) > 0 {
+		result = append(result, collectors.NewPidfileCollector(pidfiles, logger))
+	}
+	if *f.ebpfDevices != "" {
+		collector, err := collectors.NewEBPFCollector(strings.Split(*f.ebpfDevices, ","), logger)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, collector)
+	}
+	for _, name := range plugins.Names() {
+		collector, err := plugins.New(name, logger)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, collector)
+	}
+	for _, plugin := range c.Plugins {
+		collector, err := plugins.NewExecCollector(plugin, logger)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, collector)
+	}
+	if len(c.Gateways) > 0 {
+		result = append(result, proxy.NewCollector(c.Gateways, logger))
+	}
+	if len(c.Probes) > 0 {
+		collector, err := probes.NewCollector(c.Probes, logger)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, collector)
+	}
+	return result, nil
+}
+
+// Parses the number of retries per source type, given as comma separated
+// type=count pairs.
+func parseRetries(s string) (map[string]int, error) {
+	retries := map[string]int{}
+	if s == "" {
+		return retries, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		sourceType, count, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid retries %q: expected type=count", pair)
+		}
+		n, err := strconv.Atoi(count)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid number of retries %q for %s", count, sourceType)
+		}
+		retries[sourceType] = n
+	}
+	return retries, nil
+}
+
+// Parses the naming scheme of exported metrics.
+func parseMetricNames(s string) (exporters.MetricNames, error) {
+	switch s {
+	case "current":
+		return exporters.MetricNamesCurrent, nil
+	case "kumina":
+		return exporters.MetricNamesKumina, nil
+	case "both":
+		return exporters.MetricNamesBoth, nil
+	}
+	return 0, fmt.Errorf("invalid metric names %q: expected current, kumina or both", s)
+}
+
+// Parses the handling of clients with the common name UNDEF.
+func parseUndefPolicy(s string) (exporters.UndefPolicy, error) {
+	switch s {
+	case config.UndefClientsExclude:
+		return exporters.UndefExclude, nil
+	case config.UndefClientsCount:
+		return exporters.UndefCount, nil
+	case config.UndefClientsKeep:
+		return exporters.UndefKeep, nil
+	}
+	return 0, fmt.Errorf("invalid handling of UNDEF clients %q: expected exclude, count or keep", s)
+}
+
+// Parses the handling of entries with the same labels.
+func parseDuplicatePolicy(s string) (exporters.DuplicatePolicy, error) {
+	switch s {
+	case "first":
+		return exporters.DuplicateKeepFirst, nil
+	case "sum":
+		return exporters.DuplicateSum, nil
+	}
+	return 0, fmt.Errorf("invalid handling of duplicate entries %q: expected first or sum", s)
+}
+
+// Parses the per entry metrics not to export, returning their entry types.
+func parseDisabledEntries(s string) ([]string, error) {
+	var entryTypes []string
+	if s == "" {
+		return entryTypes, nil
+	}
+	for _, name := range strings.Split(s, ",") {
+		switch name {
+		case "client_list", "routing_table":
+			entryTypes = append(entryTypes, strings.ToUpper(name))
+		default:
+			return nil, fmt.Errorf("invalid collector to disable %q: expected client_list or routing_table", name)
+		}
+	}
+	return entryTypes, nil
+}
+
+// Creates an exporter for all configured status paths.
+func (f *exporterFlags) newExporter(logger *slog.Logger) (*exporters.OpenVPNExporter, error) {
+	statusPaths, err := f.loadStatusPaths(logger)
+	if err != nil {
+		return nil, err
+	}
+	return f.newExporterFor(logger, statusPaths)
+}
+
+// Creates an exporter for the given status paths, applying additional
+// options after those of the flags.
+func (f *exporterFlags) newExporterFor(logger *slog.Logger, statusPaths []config.StatusPath, options ...exporters.Option) (*exporters.OpenVPNExporter, error) {
+	retries, err := parseRetries(*f.retries)
+	if err != nil {
+		return nil, err
+	}
+	metricNames, err := parseMetricNames(*f.metricNames)
+	if err != nil {
+		return nil, err
+	}
+	disabled, err := parseDisabledEntries(*f.disable)
+	if err != nil {
+		return nil, err
+	}
+	if *f.aggregateOnly {
+		disabled = []string{"CLIENT_LIST", "ROUTING_TABLE"}
+	}
+	undefPolicy, err := parseUndefPolicy(*f.undefClients)
+	if err != nil {
+		return nil, err
+	}
+	duplicatePolicy, err := parseDuplicatePolicy(*f.duplicates)
+	if err != nil {
+		return nil, err
+	}
+	var location *time.Location
+	if *f.timeZone != "" {
+		if location, err = time.LoadLocation(*f.timeZone); err != nil {
+			return nil, fmt.Errorf("invalid time zone: %w", err)
+		}
+	}
+	var timeLayouts []string
+	if *f.timeLayouts != "" {
+		timeLayouts = strings.Split(*f.timeLayouts, ",")
+	}
+	cfg, err := f.loadConfig(logger)
+	if err != nil {
+		return nil, err
+	}
+	// Label and metric columns of the configuration file come before the
+	// additional options, which may override them.
+	for section, columns := range cfg.LabelColumns {
+		options = append([]exporters.Option{exporters.WithLabelColumns(section, columns...)}, options...)
+	}
+	for section, columns := range cfg.MetricColumns {
+		options = append([]exporters.Option{exporters.WithMetricColumns(section, columns...)}, options...)
+	}
+	return exporters.NewOpenVPNExporter(append([]exporters.Option{
+		exporters.WithLogger(logger),
+		exporters.WithStatusPaths(statusPaths...),
+		exporters.WithIgnoreIndividuals(*f.ignoreIndividuals),
+		exporters.WithCommonNameNormalization(f.commonNameNormalization()),
+		exporters.WithLockFiles(*f.lockFiles),
+		exporters.WithHardenedParsing(*f.hardened),
+		exporters.WithTimeout(*f.timeout),
+		exporters.WithPathTimeout(*f.pathTimeout),
+		exporters.WithMaxAge(*f.statusMaxAge),
+		exporters.WithTimeFormat(location, timeLayouts...),
+		exporters.WithConcurrency(*f.concurrency),
+		exporters.WithMaxRows(*f.maxRows),
+		exporters.WithMaxBytes(*f.maxBytes),
+		exporters.WithMaxClientSeries(*f.maxClientSeries),
+		exporters.WithCircuitBreaker(*f.circuitFailures, *f.circuitBackoff),
+		exporters.WithRetries(retries, *f.retryBackoff),
+		exporters.WithMetricNames(metricNames),
+		exporters.WithoutEntryMetrics(disabled...),
+		exporters.WithUndefPolicy(undefPolicy),
+		exporters.WithDuplicatePolicy(duplicatePolicy),
+		exporters.WithUnknownClientCounters(*f.unknownCounters),
+		exporters.WithClientInfo(*f.clientInfo),
+		exporters.WithClientRoutes(*f.clientRoutes),
+	}, options...)...)
+}
+
+// Returns the normalization of common names given by the flags.
+func (f *exporterFlags) commonNameNormalization() config.CommonNameNormalization {
+	normalization := config.CommonNameNormalization{Trim: *f.cnTrim, Lowercase: *f.cnLowercase}
+	for _, suffix := range strings.Split(*f.cnStripSuffixes, ",") {
+		if suffix != "" {
+			normalization.StripSuffixes = append(normalization.StripSuffixes, suffix)
+		}
+	}
+	return normalization
+}
+
+// Saves the state once the exporter is asked to shut down, then exits.
+func saveOnShutdown(store *state.Store, logger *slog.Logger) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+	if err := store.Save(); err != nil {
+		fatal(logger, "Failed to save state", "err", err)
+	}
+	closeLogger(logger)
+	os.Exit(0)
+}
+
+// Returns the options of an exporter of which the clients are tracked by
+// the given tracker, if any.
+func clientTrackerOptions(tracker *exporters.ClientTracker) []exporters.Option {
+	if tracker == nil {
+		return nil
+	}
+	return []exporters.Option{exporters.WithPipeline(exporters.Pipeline{Relabelers: []exporters.Relabeler{tracker}})}
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			command(os.Args[2:])
+			return
+		}
+	}
+
 	var (
-		listenAddress      = flag.String("web.listen-address", ":9176", "Address to listen on for web interface and telemetry.")
-		metricsPath        = flag.String("web.telemetry-path", "/metrics", "Path under which to expose metrics.")
-		openvpnStatusPaths = flag.String("openvpn.status_paths", "/var/log/openvpn/status.log", "Paths at which OpenVPN places its status files.")
-		ignoreIndividuals  = flag.Bool("ignore.individuals", false, "If ignoring metrics for individuals")
+		exporterFlags     = registerExporterFlags(flag.CommandLine)
+		listenAddress     = flag.String("web.listen-address", ":9176", "Address to listen on for web interface and telemetry.")
+		metricsPath       = flag.String("web.telemetry-path", "/metrics", "Path under which to expose metrics.")
+		refreshInterval   = flag.Duration("collector.refresh-interval", 0, "If non-zero, refresh status paths in the background at this interval, or their refresh_interval, and serve the cached metrics on scrapes.")
+		oneshot           = flag.Bool("oneshot", false, "Collect metrics once, write them to -oneshot.output and exit.")
+		oneshotOutput     = flag.String("oneshot.output", "-", "File to which metrics are written in one-shot mode, or - for stdout.")
+		failIfStale       = flag.Duration("fail-if-stale", 0, "In one-shot mode, exit with a non-zero status if the statistics of any status path are older than this duration.")
+		bearerToken       = flag.String("web.auth.bearer-token", "", "Bearer token required to access the web interface. Accepts file:, env: and exec: secret references.")
+		basicUsername     = flag.String("web.auth.basic-username", "", "Username required to access the web interface using basic authentication.")
+		debugStatus       = flag.Bool("web.debug-status", false, "Serve reports of how status paths are turned into metrics at /debug/status?path=..., including their raw contents.")
+		basicPasswordHash = flag.String("web.auth.basic-password-hash", "", "Hex encoded SHA-256 hash of the basic authentication password. Accepts file:, env: and exec: secret references.")
+		clientPing        = flag.Bool("collector.client-ping", false, "Ping the virtual addresses of connected clients in the background, exporting their round trip time and packet loss. Requires unprivileged ping sockets or CAP_NET_RAW.")
+		clientPingEvery   = flag.Duration("collector.client-ping.interval", 30*time.Second, "Interval between rounds of pings of connected clients.")
+		clientPingCount   = flag.Int("collector.client-ping.count", 3, "Number of echo requests sent to each client per round.")
+		clientPingRate    = flag.Float64("collector.client-ping.rate", 100, "Maximum number of echo requests sent per second.")
+		clientIdleReads   = flag.Int("collector.client-idle.reads", 0, "Number of consecutive reads without traffic after which a connected client is reported as idle. 0 disables idle detection.")
+		clientRates       = flag.Bool("collector.client-rates", false, "Export the traffic rates of connected clients in bytes per second, computed from the changes of their byte counters between reads.")
+		clientChurn       = flag.Bool("collector.client-churn", false, "Count the clients that connect and disconnect between reads of the status paths in openvpn_server_client_connects_total and openvpn_server_client_disconnects_total.")
+		conntrack         = flag.Bool("collector.conntrack", false, "Export the number of tracked connections originating from each connected client. Requires Linux and CAP_NET_ADMIN.")
+		recordDir         = flag.String("debug.record-dir", "", "Directory to which the raw contents of every read of a status path are saved, for reproducing parsing problems with the replay subcommand.")
+		recordMaxFiles    = flag.Int("debug.record-max-files", 100, "Number of recordings kept per status path in -debug.record-dir. 0 keeps all of them.")
+		stateFile         = flag.String("state.file", "", "File in which counters derived by the exporter, such as those of RADIUS accounting, are saved to survive restarts.")
+		stateInterval     = flag.Duration("state.save-interval", time.Minute, "Interval at which -state.file is saved, besides on shutdown.")
 	)
 	flag.Parse()
 
-	log.Printf("Starting OpenVPN Exporter\n")
-	log.Printf("Listen address: %v\n", *listenAddress)
-	log.Printf("Metrics path: %v\n", *metricsPath)
-	log.Printf("openvpn.status_path: %v\n", *openvpnStatusPaths)
-	log.Printf("Ignore Individuals: %v\n", *ignoreIndividuals)
+	logger := exporterFlags.newLogger()
+	logger.Info("Starting OpenVPN Exporter",
+		"listen_address", *listenAddress,
+		"metrics_path", *metricsPath,
+		"status_paths", *exporterFlags.statusPaths,
+		"ignore_individuals", *exporterFlags.ignoreIndividuals)
+
+	cfg, err := exporterFlags.loadConfig(logger)
+	if err != nil {
+		fatal(logger, "Failed to load config", "err", err)
+	}
+	// Status paths of tenants are only served at their own endpoints.
+	var statusPaths []config.StatusPath
+	tenantStatusPaths := map[string][]config.StatusPath{}
+	for _, sp := range cfg.StatusPaths {
+		if sp.Tenant == "" {
+			statusPaths = append(statusPaths, sp)
+		} else {
+			tenantStatusPaths[sp.Tenant] = append(tenantStatusPaths[sp.Tenant], sp)
+		}
+	}
+	// Collectors of metrics about connected clients get the clients of
+	// an exporter from a tracker in its pipeline. They only run while
+	// serving metrics.
+	if *clientPing && (*clientPingEvery <= 0 || *clientPingCount <= 0 || *clientPingRate <= 0) {
+		fatal(logger, "Client ping interval, count and rate must be positive")
+	}
+	var store *state.Store
+	if *stateFile != "" && !*oneshot {
+		if *stateInterval <= 0 {
+			fatal(logger, "State save interval must be positive")
+		}
+		if store, err = state.Open(*stateFile, logger); err != nil {
+			fatal(logger, "Failed to open state file", "err", err)
+		}
+	}
+	var radius *collectors.RADIUSAccounting
+	if cfg.RADIUS != nil && !*oneshot {
+		if radius, err = collectors.NewRADIUSAccounting(cfg.RADIUS, logger); err != nil {
+			fatal(logger, "Failed to create RADIUS accounting", "err", err)
+		}
+		if store != nil {
+			store.Register(radius)
+		}
+		go func() {
+			if err := radius.ListenAndServe(context.Background()); err != nil {
+				fatal(logger, "Failed to receive RADIUS accounting", "err", err)
+			}
+		}()
+		prometheus.MustRegister(radius)
+	}
+	// Quotas, bandwidth thresholds, rates and tunnels track the clients of an
+	// exporter through its pipeline as well. The state of quotas and churn
+	// is persisted per tenant.
+	newClientCollectors := func(tenant string) ([]exporters.Option, []prometheus.Collector) {
+		if *oneshot {
+			return nil, nil
+		}
+		var options []exporters.Option
+		var result []prometheus.Collector
+		if len(cfg.Quotas) > 0 {
+			key := "quotas"
+			if tenant != "" {
+				key = path.Join(key, tenant)
+			}
+			quotas := collectors.NewQuotaCollector(cfg.Quotas, key, logger)
+			if store != nil {
+				store.Register(quotas)
+			}
+			options = append(options, exporters.WithPipeline(exporters.Pipeline{Relabelers: []exporters.Relabeler{quotas}}))
+			result = append(result, quotas)
+		}
+		if len(cfg.BandwidthThresholds) > 0 {
+			bandwidth := collectors.NewBandwidthCollector(cfg.BandwidthThresholds, logger)
+			options = append(options, exporters.WithPipeline(exporters.Pipeline{Relabelers: []exporters.Relabeler{bandwidth}}))
+			result = append(result, bandwidth)
+		}
+		if *clientIdleReads > 0 {
+			idle := collectors.NewIdleCollector(*clientIdleReads)
+			options = append(options, exporters.WithPipeline(exporters.Pipeline{Relabelers: []exporters.Relabeler{idle}}))
+			result = append(result, idle)
+		}
+		if *clientRates {
+			throughput := collectors.NewThroughputCollector()
+			options = append(options, exporters.WithPipeline(exporters.Pipeline{Relabelers: []exporters.Relabeler{throughput}}))
+			result = append(result, throughput)
+		}
+		if *clientChurn {
+			key := "churn"
+			if tenant != "" {
+				key = path.Join(key, tenant)
+			}
+			churn := collectors.NewChurnCollector(key)
+			if store != nil {
+				store.Register(churn)
+			}
+			options = append(options, exporters.WithPipeline(exporters.Pipeline{Relabelers: []exporters.Relabeler{churn}}))
+			result = append(result, churn)
+		}
+		if len(cfg.Tunnels) > 0 {
+			tunnels := collectors.NewTunnelCollector(cfg.Tunnels)
+			options = append(options, exporters.WithPipeline(exporters.Pipeline{Relabelers: []exporters.Relabeler{tunnels}}))
+			result = append(result, tunnels)
+		}
+		if !*clientPing && !*conntrack && len(cfg.Accounting) == 0 && radius == nil {
+			return options, result
+		}
+		tracker := exporters.NewClientTracker()
+		if *clientPing {
+			pinger := probes.NewClientPinger(tracker, *clientPingEvery, *clientPingCount, *clientPingRate, logger)
+			go pinger.Run(context.Background())
+			result = append(result, pinger)
+		}
+		if *conntrack {
+			result = append(result, collectors.NewConntrackCollector(tracker, logger))
+		}
+		if len(cfg.Accounting) > 0 {
+			result = append(result, collectors.NewAccountingCollector(cfg.Accounting, tracker, logger, *exporterFlags.timeout))
+		}
+		if radius != nil {
+			result = append(result, collectors.NewRADIUSCollector(radius, tracker))
+		}
+		return append(options, clientTrackerOptions(tracker)...), result
+	}
+	// Reads of all exporters are recorded to the same directory, which
+	// tells status paths apart by name.
+	var recordOptions []exporters.Option
+	if *recordDir != "" {
+		recordOptions = append(recordOptions, exporters.WithRecorder(newStatusRecorder(*recordDir, *recordMaxFiles, logger)))
+	}
+	clientOptions, clientCollectors := newClientCollectors("")
+	options := append(slices.Clone(recordOptions), clientOptions...)
+	exporter, err := exporterFlags.newExporterFor(logger, statusPaths, options...)
+	if err != nil {
+		fatal(logger, "Failed to create exporter", "err", err)
+	}
+	pluginCollectors, err := exporterFlags.newPlugins(logger)
+	if err != nil {
+		fatal(logger, "Failed to create plugins", "err", err)
+	}
+	if *oneshot {
+		if err := runOneshot(exporter, pluginCollectors, *oneshotOutput, *failIfStale); err != nil {
+			fatal(logger, "One-shot collection failed", "err", err)
+		}
+		closeLogger(logger)
+		return
+	}
+	// Exporters are replaced by new ones when the configuration is
+	// reloaded.
+	reloader := newConfigReloader(exporterFlags, logger)
+	reloadable := newReloadableExporter(exporter, *refreshInterval)
+	reloader.add("", reloadable, options)
+	prometheus.MustRegister(reloadable)
+	prometheus.MustRegister(pluginCollectors...)
+	prometheus.MustRegister(logMessagesSuppressed)
+	prometheus.MustRegister(configReloadSuccessful, configReloadTime)
+	prometheus.MustRegister(clientCollectors...)
 
-	exporter, err := exporters.NewOpenVPNExporter(strings.Split(*openvpnStatusPaths, ","), *ignoreIndividuals)
+	auth, err := newWebAuth(config.Secret(*bearerToken), *basicUsername, config.Secret(*basicPasswordHash))
 	if err != nil {
-		panic(err)
+		fatal(logger, "Failed to load web authentication secrets", "err", err)
 	}
-	prometheus.MustRegister(exporter)
 
-	http.Handle(*metricsPath, promhttp.Handler())
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	http.Handle(*metricsPath, auth.handler(promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, metricsHandler(prometheus.DefaultGatherer))))
+	if *debugStatus {
+		if !auth.enabled() {
+			logger.Warn("Serving the contents of status paths at /debug/status without authentication")
+		}
+		http.Handle("/debug/status", auth.handler(debugStatusHandler(reloadable)))
+	}
+	http.Handle("/-/reload", auth.handler(reloadHandler(reloader)))
+	for _, tenant := range cfg.Tenants {
+		tenantClientOptions, tenantClientCollectors := newClientCollectors(tenant.Name)
+		tenantOptions := append(slices.Clone(recordOptions), tenantClientOptions...)
+		exporter, err := exporterFlags.newExporterFor(logger, tenantStatusPaths[tenant.Name], tenantOptions...)
+		if err != nil {
+			fatal(logger, "Failed to create exporter", "tenant", tenant.Name, "err", err)
+		}
+		tenantExporter := newReloadableExporter(exporter, *refreshInterval)
+		reloader.add(tenant.Name, tenantExporter, tenantOptions)
+		tenantAuth := auth
+		if !tenant.BearerToken.IsEmpty() || !tenant.BasicPasswordHash.IsEmpty() {
+			tenantAuth, err = newWebAuth(tenant.BearerToken, tenant.BasicUsername, tenant.BasicPasswordHash)
+			if err != nil {
+				fatal(logger, "Failed to load web authentication secrets", "tenant", tenant.Name, "err", err)
+			}
+		}
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(tenantExporter)
+		registry.MustRegister(tenantClientCollectors...)
+		http.Handle(path.Join(*metricsPath, tenant.Name), tenantAuth.handler(metricsHandler(registry)))
+		if *debugStatus {
+			http.Handle(path.Join("/debug/status", tenant.Name), tenantAuth.handler(debugStatusHandler(tenantExporter)))
+		}
+	}
+	http.Handle("/", auth.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`
 			<html>
 			<head><title>OpenVPN Exporter</title></head>
@@ -55,6 +715,13 @@ func main() {
 			<p><a href='` + *metricsPath + `'>Metrics</a></p>
 			</body>
 			</html>`))
-	})
-	log.Fatal(http.ListenAndServe(*listenAddress, nil))
+	})))
+	if store != nil {
+		go store.Run(context.Background(), *stateInterval)
+		go saveOnShutdown(store, logger)
+	}
+	go reloadOnHangup(reloader)
+	if err := http.ListenAndServe(*listenAddress, nil); err != nil {
+		fatal(logger, "Failed to run HTTP server", "err", err)
+	}
 }
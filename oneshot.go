@@ -0,0 +1,59 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/kumina/openvpn_exporter/exporters"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// Collects the metrics of the exporter and its plugins once and writes
+// them in the text exposition format to the given file, or to stdout if it
+// is "-". Files are replaced atomically, so that they can be picked up by
+// the textfile collector of the node exporter.
+//
+// If failIfStale is non-zero, an error is returned after writing the
+// metrics if the statistics of any status path of the exporter are older
+// than that.
+func runOneshot(exporter *exporters.OpenVPNExporter, plugins []prometheus.Collector, output string, failIfStale time.Duration) error {
+	registry := prometheus.NewRegistry()
+	for _, collector := range append([]prometheus.Collector{exporter}, plugins...) {
+		if err := registry.Register(collector); err != nil {
+			return err
+		}
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	for _, family := range families {
+		if _, err := expfmt.MetricFamilyToText(&buf, family); err != nil {
+			return err
+		}
+	}
+	if err := writeOutput(output, buf.Bytes()); err != nil {
+		return err
+	}
+
+	if failIfStale > 0 {
+		return exporter.CheckStale(failIfStale)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package status
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Parses OpenVPN client statistics.
+func parseClientStats(file io.Reader, v Visitor) (*ClientStats, error) {
+	stats := &ClientStats{}
+	scanner := bufio.NewScanner(file)
+	scanner.Split(bufio.ScanLines)
+	var fields []string
+	var ended bool
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		fields = splitFields(fields, scanner.Text(), ",")
+		if fields[0] == "END" && len(fields) == 1 {
+			// Stats footer.
+			ended = true
+		} else if fields[0] == "OpenVPN STATISTICS" && len(fields) == 1 {
+			// Stats header.
+		} else if fields[0] == "Updated" && len(fields) == 2 {
+			// Time at which the statistics were updated.
+			updatedAt, ok := v.parseTime(fields[1])
+			if !ok {
+				if err := v.lineError(lineNo, fmt.Errorf("invalid time %q", fields[1])); err != nil {
+					return nil, endOfStatus(scanner, ended, err)
+				}
+				continue
+			}
+			stats.UpdatedAt = updatedAt
+		} else if len(fields) == 2 {
+			// Traffic counters.
+			value, err := strconv.ParseFloat(fields[1], 64)
+			if err != nil {
+				if err := v.lineError(lineNo, err); err != nil {
+					return nil, endOfStatus(scanner, ended, err)
+				}
+				continue
+			}
+			stats.Counters = append(stats.Counters, ClientCounter{Name: fields[0], Value: value})
+		} else if err := v.unsupportedKey(lineNo, fields[0]); err != nil {
+			return nil, endOfStatus(scanner, ended, err)
+		}
+	}
+	if err := endOfStatus(scanner, ended, nil); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
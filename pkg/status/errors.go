@@ -0,0 +1,58 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package status
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	// ErrUnknownFormat is returned for files that don't have any of the
+	// supported formats.
+	ErrUnknownFormat = errors.New("unknown status file format")
+	// ErrHeaderMismatch is returned for client list and routing table
+	// entries that don't match the preceding header.
+	ErrHeaderMismatch = errors.New("entry does not match header")
+	// ErrUnsupportedKey is returned for lines of an unknown type.
+	ErrUnsupportedKey = errors.New("unsupported key")
+	// ErrParseLine matches all errors caused by the contents of a line,
+	// which are returned as a *LineError.
+	ErrParseLine = errors.New("invalid line")
+	// ErrIncomplete is returned for status files that end before their
+	// END line, such as those read while OpenVPN is rewriting them. It
+	// matches io.ErrUnexpectedEOF, as reading them again may succeed.
+	ErrIncomplete = fmt.Errorf("status ends without END line: %w", io.ErrUnexpectedEOF)
+)
+
+// LineError is returned when a line of a status file can't be parsed.
+type LineError struct {
+	// Line number, starting at 1.
+	Line int
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes errors.Is(err, ErrParseLine) match all line errors.
+func (e *LineError) Is(target error) bool {
+	return target == ErrParseLine
+}
@@ -0,0 +1,147 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package status
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Names of months in the languages of localized OpenVPN builds, which
+// write timestamps in the layout of ctime(3) with localized names of
+// weekdays and months. Abbreviations are derived from the names.
+var localizedMonths = [][12]string{
+	// English.
+	{"january", "february", "march", "april", "may", "june", "july", "august", "september", "october", "november", "december"},
+	// German.
+	{"januar", "februar", "märz", "april", "mai", "juni", "juli", "august", "september", "oktober", "november", "dezember"},
+	// Spanish.
+	{"enero", "febrero", "marzo", "abril", "mayo", "junio", "julio", "agosto", "septiembre", "octubre", "noviembre", "diciembre"},
+	// French.
+	{"janvier", "février", "mars", "avril", "mai", "juin", "juillet", "août", "septembre", "octobre", "novembre", "décembre"},
+	// Italian.
+	{"gennaio", "febbraio", "marzo", "aprile", "maggio", "giugno", "luglio", "agosto", "settembre", "ottobre", "novembre", "dicembre"},
+	// Dutch.
+	{"januari", "februari", "maart", "april", "mei", "juni", "juli", "augustus", "september", "oktober", "november", "december"},
+	// Portuguese.
+	{"janeiro", "fevereiro", "março", "abril", "maio", "junho", "julho", "agosto", "setembro", "outubro", "novembro", "dezembro"},
+	// Russian, in the nominative and the genitive case used in dates.
+	{"январь", "февраль", "март", "апрель", "май", "июнь", "июль", "август", "сентябрь", "октябрь", "ноябрь", "декабрь"},
+	{"января", "февраля", "марта", "апреля", "мая", "июня", "июля", "августа", "сентября", "октября", "ноября", "декабря"},
+}
+
+// Abbreviations of months that aren't a prefix of their name.
+var monthAbbreviations = map[string]time.Month{
+	"mrt": time.March,
+}
+
+var (
+	monthNamesMtx sync.RWMutex
+	monthNames    = buildMonthNames()
+)
+
+// Returns the names of months by their lower case name or abbreviation.
+// Abbreviations shared by different months, such as "jui" for French
+// juin and juillet, are left out.
+func buildMonthNames() map[string]time.Month {
+	names := map[string]time.Month{}
+	ambiguous := map[string]bool{}
+	add := func(name string, month time.Month) {
+		if m, ok := names[name]; ok && m != month {
+			ambiguous[name] = true
+		}
+		names[name] = month
+	}
+	for _, months := range localizedMonths {
+		for i, name := range months {
+			month := time.Month(i + 1)
+			add(name, month)
+			runes := []rune(name)
+			for _, n := range []int{3, 4} {
+				if len(runes) > n {
+					add(string(runes[:n]), month)
+				}
+			}
+		}
+	}
+	for name := range ambiguous {
+		delete(names, name)
+	}
+	for name, month := range monthAbbreviations {
+		names[name] = month
+	}
+	return names
+}
+
+// AddMonthNames adds names or abbreviations of months, such as "gen" for
+// January, to those recognized in the timestamps of localized OpenVPN
+// builds. Names are matched regardless of case.
+func AddMonthNames(names map[string]time.Month) {
+	monthNamesMtx.Lock()
+	defer monthNamesMtx.Unlock()
+	for name, month := range names {
+		monthNames[strings.ToLower(name)] = month
+	}
+}
+
+// Returns the month with the given name or abbreviation.
+func lookupMonth(name string) (time.Month, bool) {
+	monthNamesMtx.RLock()
+	defer monthNamesMtx.RUnlock()
+	month, ok := monthNames[strings.ToLower(strings.TrimRight(name, ".,"))]
+	return month, ok
+}
+
+// Parses a timestamp written with localized names, such as "Do Mär 16
+// 17:09:03 2017". The fields may come in any order: the weekday is
+// ignored, the month is looked up by its name, the time has colons, the
+// year four digits and the day one or two. Weekdays may look like months,
+// such as Spanish "mar" for Tuesday, so the last month name wins, as
+// weekdays come first.
+func parseLocalizedTime(value string, loc *time.Location) (time.Time, bool) {
+	var month time.Month
+	day, year := -1, -1
+	var clock time.Time
+	haveClock := false
+	for _, field := range strings.Fields(value) {
+		field = strings.TrimRight(field, ".,")
+		switch {
+		case strings.Contains(field, ":"):
+			t, err := time.Parse("15:04:05", field)
+			if err != nil {
+				return time.Time{}, false
+			}
+			clock, haveClock = t, true
+		case isDigits(field) && len(field) == 4:
+			year, _ = strconv.Atoi(field)
+		case isDigits(field) && len(field) <= 2:
+			day, _ = strconv.Atoi(field)
+		default:
+			if m, ok := lookupMonth(field); ok {
+				month = m
+			}
+		}
+	}
+	if month == 0 || day < 1 || day > 31 || year < 0 || !haveClock {
+		return time.Time{}, false
+	}
+	return time.Date(year, month, day, clock.Hour(), clock.Minute(), clock.Second(), 0, loc), true
+}
+
+// Returns whether a string consists of digits only.
+func isDigits(s string) bool {
+	return s != "" && strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' }) < 0
+}
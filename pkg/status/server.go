@@ -0,0 +1,452 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package status
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Layouts used by OpenVPN for human readable timestamps. Older versions
+// use the format of ctime(3), while 2.4 and later use an ISO 8601 like
+// format.
+var timeLayouts = []string{
+	"Mon Jan _2 15:04:05 2006",
+	"2006-01-02 15:04:05",
+}
+
+// Numeric layouts written by some localized builds and by tools rewriting
+// status files, tried after timeLayouts. Layouts with the month and day in
+// either order, such as 01/02/2006, are left out as they are ambiguous.
+var numericTimeLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006/01/02 15:04:05",
+	"02.01.2006 15:04:05",
+	"2.1.2006 15:04:05",
+}
+
+// Parses a human readable timestamp in the given time zone, trying the
+// given layouts before those known to be written by OpenVPN. Timestamps
+// with localized names of weekdays and months, numeric layouts and UNIX
+// timestamps are accepted as well.
+func parseTime(value string, loc *time.Location, layouts []string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, false
+	}
+	for _, layout := range layouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, true
+		}
+	}
+	// Start with the layout that is most likely to match, as failed
+	// attempts are comparatively expensive.
+	first := 0
+	if value[0] >= '0' && value[0] <= '9' {
+		first = 1
+	}
+	for i := range timeLayouts {
+		layout := timeLayouts[(first+i)%len(timeLayouts)]
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, true
+		}
+	}
+	if first == 1 {
+		for _, layout := range numericTimeLayouts {
+			if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+				return t, true
+			}
+		}
+		if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return time.Unix(seconds, 0), true
+		}
+	}
+	return parseLocalizedTime(value, loc)
+}
+
+// Parses a timestamp, preferring the UNIX timestamp column over the human
+// readable one if the status file contains both.
+func parseColumnTime(columns Columns, column string, unixColumn string, v Visitor) time.Time {
+	if value, ok := columns.Get(unixColumn); ok {
+		if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return time.Unix(seconds, 0)
+		}
+	}
+	t, _ := v.parseTime(columns.Value(column))
+	return t
+}
+
+// Parses a byte counter column, if present.
+func parseCounterColumn(columns Columns, column string, field *uint64) error {
+	value, ok := columns.Get(column)
+	if !ok {
+		return nil
+	}
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return err
+	}
+	*field = n
+	return nil
+}
+
+// Builds a client list entry from its column values.
+func newClient(columns Columns, v Visitor) (Client, error) {
+	client := Client{
+		CommonName:         columns.Value("Common Name"),
+		RealAddress:        columns.Value("Real Address"),
+		VirtualAddress:     columns.Value("Virtual Address"),
+		VirtualIPv6Address: columns.Value("Virtual IPv6 Address"),
+		ConnectedSince:     parseColumnTime(columns, "Connected Since", "Connected Since (time_t)", v),
+		Username:           columns.Value("Username"),
+		DataChannelCipher:  columns.Value("Data Channel Cipher"),
+		Columns:            columns,
+	}
+	if err := parseCounterColumn(columns, "Bytes Received", &client.BytesReceived); err != nil {
+		return Client{}, err
+	}
+	if err := parseCounterColumn(columns, "Bytes Sent", &client.BytesSent); err != nil {
+		return Client{}, err
+	}
+	// Identifiers are informational, so don't fail on unexpected values.
+	client.ClientID, _ = strconv.ParseInt(columns.Value("Client ID"), 10, 64)
+	client.PeerID, _ = strconv.ParseInt(columns.Value("Peer ID"), 10, 64)
+	return client, nil
+}
+
+// NewRoute builds a routing table entry from its column values, such as
+// those of entries passed to a Visitor.
+func NewRoute(columns Columns) Route {
+	return newRoute(columns, Visitor{})
+}
+
+// Builds a routing table entry, parsing its time as told by the visitor.
+func newRoute(columns Columns, v Visitor) Route {
+	return Route{
+		VirtualAddress: columns.Value("Virtual Address"),
+		CommonName:     columns.Value("Common Name"),
+		RealAddress:    columns.Value("Real Address"),
+		LastRef:        parseColumnTime(columns, "Last Ref", "Last Ref (time_t)", v),
+		Columns:        columns,
+	}
+}
+
+// Section oriented core of the parsers of server status files, shared by
+// all format versions. The format specific parsers only determine the
+// section and role of each line, while the core builds the entries and
+// statistics, so that new columns and statistics only need to be handled
+// once.
+type serverParser struct {
+	status    *ServerStatus
+	visitor   Visitor
+	separator string
+	// Column names of the sections with entries, by section.
+	headers map[string]*header
+}
+
+func newServerParser(v Visitor, separator string) *serverParser {
+	return &serverParser{
+		status:    &ServerStatus{},
+		visitor:   v,
+		separator: separator,
+		headers:   map[string]*header{},
+	}
+}
+
+// Sets the column names of the entries of a section.
+func (p *serverParser) header(section string, names []string) {
+	h := newHeader(names)
+	p.headers[section] = h
+	if p.visitor.Header != nil {
+		p.visitor.Header(section, h.names)
+	}
+}
+
+// Sets the time at which the statistics were updated, preferring the
+// UNIX timestamp if the line contains one. Errors are reported to the
+// visitor, and only returned if parsing should stop.
+func (p *serverParser) updated(lineNo int, value string, unixValue string) error {
+	if unixValue != "" {
+		seconds, err := strconv.ParseFloat(unixValue, 64)
+		if err != nil {
+			return p.visitor.lineError(lineNo, err)
+		}
+		p.status.UpdatedAt = time.Unix(int64(seconds), 0)
+		return nil
+	}
+	t, ok := p.visitor.parseTime(value)
+	if !ok {
+		return p.visitor.lineError(lineNo, fmt.Errorf("invalid time %q", value))
+	}
+	p.status.UpdatedAt = t
+	return nil
+}
+
+// Adds a global statistic. Statistics that aren't numeric are ignored.
+func (p *serverParser) globalStat(name string, value string) {
+	if n, err := strconv.ParseFloat(value, 64); err == nil {
+		p.status.GlobalStats = append(p.status.GlobalStats, GlobalStat{Name: name, Value: n})
+	}
+}
+
+// Passes a client list or routing table entry to the visitor, naming its
+// values after the header of the section. Errors are reported to the
+// visitor, and only returned if parsing should stop.
+func (p *serverParser) entry(lineNo int, section string, values []string) error {
+	header, ok := p.headers[section]
+	if !ok {
+		return p.visitor.lineError(lineNo, fmt.Errorf("%w: %s entry without a preceding header", ErrHeaderMismatch, section))
+	}
+	values = joinFreeText(header.names, values, p.separator)
+	if len(values) != len(header.names) {
+		return p.visitor.lineError(lineNo, fmt.Errorf("%w: header of %s describes a different number of columns", ErrHeaderMismatch, section))
+	}
+	columnValues := header.columns(values)
+	if section == "ROUTING_TABLE" {
+		route := newRoute(columnValues, p.visitor)
+		route.Line = lineNo
+		return p.visitor.route(p.status, route)
+	}
+	client, err := newClient(columnValues, p.visitor)
+	if err != nil {
+		return p.visitor.lineError(lineNo, err)
+	}
+	client.Line = lineNo
+	return p.visitor.client(p.status, client)
+}
+
+// Columns of entries holding free text, such as common names, which
+// OpenVPN writes without quoting them, even if they contain the separator.
+var freeTextColumns = map[string]bool{
+	"Common Name": true,
+	"Username":    true,
+}
+
+// Columns of entries holding integers, which tell where free text values
+// containing the separator end.
+var integerColumns = map[string]bool{
+	"Bytes Received":           true,
+	"Bytes Sent":               true,
+	"Connected Since (time_t)": true,
+	"Last Ref (time_t)":        true,
+	"Client ID":                true,
+	"Peer ID":                  true,
+}
+
+// Joins the values of an entry that has more values than its header has
+// names, as a free text value contains the separator. The surplus values
+// are joined into the one free text column for which the integer columns
+// hold integers. If there is no such column, or more than one, the values
+// are returned as they are.
+func joinFreeText(names []string, values []string, separator string) []string {
+	surplus := len(values) - len(names)
+	if surplus <= 0 {
+		return values
+	}
+	var joined []string
+	for i, name := range names {
+		if !freeTextColumns[name] {
+			continue
+		}
+		candidate := make([]string, 0, len(names))
+		candidate = append(candidate, values[:i]...)
+		candidate = append(candidate, strings.Join(values[i:i+surplus+1], separator))
+		candidate = append(candidate, values[i+surplus+1:]...)
+		if !holdsIntegers(names, candidate) {
+			continue
+		}
+		if joined != nil {
+			return values
+		}
+		joined = candidate
+	}
+	if joined == nil {
+		return values
+	}
+	return joined
+}
+
+// Returns whether the integer columns among the given ones hold integers.
+func holdsIntegers(names []string, values []string) bool {
+	for i, name := range names {
+		if !integerColumns[name] {
+			continue
+		}
+		if _, err := strconv.ParseInt(values[i], 10, 64); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Parses OpenVPN server status information, using format version 2 or 3.
+// Every line starts with a key identifying its section.
+func parseServerStatus(file io.Reader, separator string, v Visitor) (*ServerStatus, error) {
+	p := newServerParser(v, separator)
+	scanner := bufio.NewScanner(file)
+	scanner.Split(bufio.ScanLines)
+
+	var fields []string
+	var ended bool
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		fields = splitRecord(fields, scanner.Text(), separator)
+		var err error
+		switch {
+		case fields[0] == "END" && len(fields) == 1:
+			// Stats footer.
+			ended = true
+		case fields[0] == "GLOBAL_STATS":
+			// Global server statistics.
+			if len(fields) == 3 {
+				p.globalStat(fields[1], fields[2])
+			}
+		case fields[0] == "HEADER" && len(fields) > 2:
+			// Column names for CLIENT_LIST and ROUTING_TABLE.
+			p.header(fields[1], fields[2:])
+		case fields[0] == "TIME" && len(fields) == 3:
+			// Time at which the statistics were updated.
+			err = p.updated(lineNo, fields[1], fields[2])
+		case fields[0] == "TITLE" && len(fields) == 2:
+			// OpenVPN version number.
+			p.status.Title = fields[1]
+		case fields[0] == "CLIENT_LIST" || fields[0] == "ROUTING_TABLE":
+			// Entry that depends on a preceding HEADER directive.
+			err = p.entry(lineNo, fields[0], fields[1:])
+		default:
+			err = v.unsupportedKey(lineNo, fields[0])
+		}
+		if err != nil {
+			return nil, endOfStatus(scanner, ended, err)
+		}
+	}
+	if err := endOfStatus(scanner, ended, nil); err != nil {
+		return nil, err
+	}
+	return p.status, nil
+}
+
+// Sections of status files of format version 1, by their title line.
+var sectionsV1 = map[string]string{
+	"OpenVPN CLIENT LIST": "CLIENT_LIST",
+	"ROUTING TABLE":       "ROUTING_TABLE",
+	"GLOBAL STATS":        "GLOBAL_STATS",
+}
+
+// Columns of status files of format version 1 holding a time, and the
+// columns of format version 2 and 3 holding the same time as a UNIX
+// timestamp. Version 1 files lack the latter, which are derived from the
+// former, so that entries have the same columns regardless of the version.
+var unixTimeColumnsV1 = map[string]string{
+	"Connected Since": "Connected Since (time_t)",
+	"Last Ref":        "Last Ref (time_t)",
+}
+
+// Appends the UNIX timestamps of the times in the given fields. The
+// timestamps of times that can't be parsed are left empty, as the entries
+// are valid without them.
+func appendUnixTimes(fields []string, sources []int, v Visitor) []string {
+	for _, i := range sources {
+		t, ok := v.parseTime(fields[i])
+		if !ok {
+			fields = append(fields, "")
+			continue
+		}
+		fields = append(fields, strconv.FormatInt(t.Unix(), 10))
+	}
+	return fields
+}
+
+// Parses OpenVPN server status information, using format version 1. This
+// format has no keys identifying the type of each line. Instead, the
+// file is split into sections, each starting with its title line. In
+// sections with entries, the first line other than the update time names
+// the columns.
+func parseServerStatusV1(file io.Reader, v Visitor) (*ServerStatus, error) {
+	p := newServerParser(v, ",")
+	scanner := bufio.NewScanner(file)
+	scanner.Split(bufio.ScanLines)
+
+	var section string
+	var headerPending, ended bool
+	var fields []string
+	// Indices of the columns from which UNIX timestamps are derived, and
+	// the number of columns of the file, by section.
+	unixTimeSources := map[string][]int{}
+	fileColumns := map[string]int{}
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		if len(line) == 0 {
+			continue
+		}
+		if line == "END" {
+			ended = true
+			break
+		}
+		if s, ok := sectionsV1[line]; ok {
+			section = s
+			headerPending = section != "GLOBAL_STATS"
+			continue
+		}
+
+		fields = splitRecord(fields, line, ",")
+		var err error
+		switch {
+		case fields[0] == "Updated" && len(fields) == 2:
+			// Time at which the statistics were updated.
+			err = p.updated(lineNo, fields[1], "")
+		case section == "GLOBAL_STATS":
+			if len(fields) == 2 {
+				p.globalStat(fields[0], fields[1])
+			}
+		case section == "":
+			// Lines preceding the first section carry no statistics.
+		case headerPending:
+			names := append([]string(nil), fields...)
+			unixTimeSources[section] = nil
+			for i, name := range fields {
+				if unixName, ok := unixTimeColumnsV1[name]; ok && !slices.Contains(fields, unixName) {
+					names = append(names, unixName)
+					unixTimeSources[section] = append(unixTimeSources[section], i)
+				}
+			}
+			fileColumns[section] = len(fields)
+			p.header(section, names)
+			headerPending = false
+		default:
+			if header, ok := p.headers[section]; ok {
+				fields = joinFreeText(header.names[:fileColumns[section]], fields, ",")
+			}
+			if len(fields) == fileColumns[section] {
+				fields = appendUnixTimes(fields, unixTimeSources[section], p.visitor)
+			}
+			err = p.entry(lineNo, section, fields)
+		}
+		if err != nil {
+			return nil, endOfStatus(scanner, ended, err)
+		}
+	}
+	if err := endOfStatus(scanner, ended, nil); err != nil {
+		return nil, err
+	}
+	return p.status, nil
+}
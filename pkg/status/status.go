@@ -0,0 +1,435 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package status parses the files written by OpenVPN's --status option.
+// It supports client statistics and server statistics in the formats
+// selected by --status-version 1, 2 and 3.
+package status
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Format identifies the layout of a status file.
+type Format string
+
+// Supported status file formats.
+const (
+	FormatClient   Format = "client"
+	FormatServerV1 Format = "server_v1"
+	FormatServerV2 Format = "server_v2"
+	FormatServerV3 Format = "server_v3"
+)
+
+// Status is the parsed contents of a status file. Exactly one of Client
+// and Server is set, depending on the format.
+type Status struct {
+	Format Format
+	Client *ClientStats
+	Server *ServerStatus
+}
+
+// UpdatedAt returns the time at which the statistics were written, or
+// the zero time if the status file doesn't contain it.
+func (s *Status) UpdatedAt() time.Time {
+	if s.Client != nil {
+		return s.Client.UpdatedAt
+	}
+	if s.Server != nil {
+		return s.Server.UpdatedAt
+	}
+	return time.Time{}
+}
+
+// ClientStats holds the statistics written by an OpenVPN client.
+type ClientStats struct {
+	UpdatedAt time.Time
+	// Traffic counters, in the order in which they appear in the file.
+	Counters []ClientCounter
+}
+
+// ClientCounter is a single statistic of an OpenVPN client, such as
+// "TUN/TAP read bytes".
+type ClientCounter struct {
+	Name  string
+	Value float64
+}
+
+// Counter returns the value of the statistic with the given name.
+func (c *ClientStats) Counter(name string) (float64, bool) {
+	for _, counter := range c.Counters {
+		if counter.Name == name {
+			return counter.Value, true
+		}
+	}
+	return 0, false
+}
+
+// ServerStatus holds the status written by an OpenVPN server.
+type ServerStatus struct {
+	// Title line, containing the OpenVPN version. Only present in
+	// version 2 and 3 files.
+	Title        string
+	UpdatedAt    time.Time
+	ClientList   []Client
+	RoutingTable []Route
+	// Numeric global statistics, such as "Max bcast/mcast queue length".
+	GlobalStats []GlobalStat
+}
+
+// Version returns the version of OpenVPN and the platform it was built
+// for, such as "2.6.8" and "x86_64-pc-linux-gnu", as given by the title
+// line. Either is empty if the title lacks it.
+func (s *ServerStatus) Version() (version, arch string) {
+	fields := strings.Fields(s.Title)
+	if len(fields) < 2 || fields[0] != "OpenVPN" {
+		return "", ""
+	}
+	version = fields[1]
+	if len(fields) > 2 && !strings.HasPrefix(fields[2], "[") {
+		arch = fields[2]
+	}
+	return version, arch
+}
+
+// Client is an entry of the client list of a server.
+type Client struct {
+	CommonName         string
+	RealAddress        string
+	VirtualAddress     string
+	VirtualIPv6Address string
+	BytesReceived      uint64
+	BytesSent          uint64
+	ConnectedSince     time.Time
+	Username           string
+	ClientID           int64
+	PeerID             int64
+	DataChannelCipher  string
+	// Values of all columns, including columns without a dedicated
+	// field.
+	Columns Columns
+	// Line number of the entry in the status file, if parsed from one.
+	Line int
+}
+
+// Route is an entry of the routing table of a server.
+type Route struct {
+	VirtualAddress string
+	CommonName     string
+	RealAddress    string
+	LastRef        time.Time
+	// Values of all columns.
+	Columns Columns
+	// Line number of the entry in the status file, if parsed from one.
+	Line int
+}
+
+// Columns holds the values of a client list or routing table entry. The
+// column names are shared by all entries following the same header, so
+// that entries only need to store their values.
+type Columns struct {
+	header *header
+	values []string
+}
+
+// Column names of a client list or routing table, along with the index of
+// each name.
+type header struct {
+	names []string
+	index map[string]int
+}
+
+func newHeader(names []string) *header {
+	h := &header{
+		names: append([]string(nil), names...),
+		index: make(map[string]int, len(names)),
+	}
+	for i, name := range h.names {
+		h.index[name] = i
+	}
+	return h
+}
+
+// NewColumns creates an entry from column names and the corresponding
+// values. Values without a name are ignored.
+func NewColumns(names []string, values []string) Columns {
+	return newHeader(names).columns(values)
+}
+
+// Creates an entry with this header, copying the values.
+func (h *header) columns(values []string) Columns {
+	if len(values) > len(h.names) {
+		values = values[:len(h.names)]
+	}
+	return Columns{header: h, values: append([]string(nil), values...)}
+}
+
+// Get returns the value of the column with the given name.
+func (c Columns) Get(name string) (string, bool) {
+	if c.header == nil {
+		return "", false
+	}
+	i, ok := c.header.index[name]
+	if !ok || i >= len(c.values) {
+		return "", false
+	}
+	return c.values[i], true
+}
+
+// Value returns the value of the column with the given name, or the empty
+// string if there is no such column.
+func (c Columns) Value(name string) string {
+	value, _ := c.Get(name)
+	return value
+}
+
+// Names returns the names of the columns that have a value, in the order
+// in which they appear in the status file.
+func (c Columns) Names() []string {
+	if c.header == nil {
+		return nil
+	}
+	return c.header.names[:len(c.values)]
+}
+
+// With returns a copy of the columns in which the column with the given
+// name has another value, sharing the column names with c. Columns that c
+// lacks are left alone.
+func (c Columns) With(name, value string) Columns {
+	if c.header == nil {
+		return c
+	}
+	i, ok := c.header.index[name]
+	if !ok || i >= len(c.values) {
+		return c
+	}
+	values := append([]string(nil), c.values...)
+	values[i] = value
+	return Columns{header: c.header, values: values}
+}
+
+// Map returns the values of all columns, indexed by their names.
+func (c Columns) Map() map[string]string {
+	m := make(map[string]string, len(c.values))
+	for i, name := range c.Names() {
+		m[name] = c.values[i]
+	}
+	return m
+}
+
+// GlobalStat is a numeric statistic from the global section of a server
+// status file.
+type GlobalStat struct {
+	Name  string
+	Value float64
+}
+
+// DetectFormat determines the format of a status file by peeking at its
+// first bytes, without consuming them.
+func DetectFormat(reader *bufio.Reader) (Format, error) {
+	buf, _ := reader.Peek(18)
+	if bytes.HasPrefix(buf, []byte("TITLE,")) {
+		return FormatServerV2, nil
+	} else if bytes.HasPrefix(buf, []byte("TITLE\t")) {
+		return FormatServerV3, nil
+	} else if bytes.HasPrefix(buf, []byte("OpenVPN STATISTICS")) {
+		return FormatClient, nil
+	} else if bytes.HasPrefix(buf, []byte("OpenVPN CLIENT LIS")) {
+		return FormatServerV1, nil
+	}
+	return "", fmt.Errorf("%w: unexpected file contents: %q", ErrUnknownFormat, buf)
+}
+
+// Parse reads a status file, automatically detecting its format.
+func Parse(r io.Reader) (*Status, error) {
+	reader := bufio.NewReader(r)
+	format, err := DetectFormat(reader)
+	if err != nil {
+		return nil, err
+	}
+	return ParseFormat(reader, format)
+}
+
+// ParseFormat reads a status file of a known format.
+func ParseFormat(r io.Reader, format Format) (*Status, error) {
+	return Walk(r, format, Visitor{})
+}
+
+// Visitor receives the entries of a server status file while it is
+// parsed. Entries passed to a visitor function are not added to the
+// returned ServerStatus, so that large status files can be processed
+// without keeping all their entries in memory. Parsing stops if a
+// visitor function returns an error.
+type Visitor struct {
+	// Location is the time zone of human readable timestamps, which
+	// don't tell theirs, for status files written on servers in another
+	// time zone than the parser's. Defaults to the local time zone.
+	Location *time.Location
+	// TimeLayouts are layouts of human readable timestamps as taken by
+	// time.Parse, tried before the layouts known to be written by
+	// OpenVPN.
+	TimeLayouts []string
+
+	Client func(Client) error
+	Route  func(Route) error
+	// Header is called with the column names of each section of a
+	// server status file that has entries, before its entries. The
+	// names must not be modified.
+	Header func(section string, names []string)
+	// LineError is called for lines that can't be parsed, in status
+	// files of any format. Returning nil skips the line instead of
+	// failing, allowing the rest of a file with malformed lines, e.g.
+	// caused by unusual common names, to be read.
+	LineError func(*LineError) error
+	// UnsupportedKey is called for lines of a type unknown to the parser,
+	// such as those added by newer versions of OpenVPN, in client and
+	// server status files. Returning nil skips the line. Without it,
+	// these lines are reported like other lines that can't be parsed.
+	UnsupportedKey func(line int, key string) error
+}
+
+// Parses a human readable timestamp in the time zone of the visitor.
+func (v Visitor) parseTime(value string) (time.Time, bool) {
+	loc := v.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	return parseTime(value, loc, v.TimeLayouts)
+}
+
+// Reports an error caused by a line to the visitor, returning the error
+// if parsing should stop.
+func (v Visitor) lineError(line int, err error) error {
+	lineErr := &LineError{Line: line, Err: err}
+	if v.LineError != nil {
+		return v.LineError(lineErr)
+	}
+	return lineErr
+}
+
+// Reports a line of an unknown type to the visitor, returning the error
+// if parsing should stop.
+func (v Visitor) unsupportedKey(line int, key string) error {
+	if v.UnsupportedKey != nil {
+		return v.UnsupportedKey(line, key)
+	}
+	return v.lineError(line, fmt.Errorf("%w: %q", ErrUnsupportedKey, key))
+}
+
+// Passes a client list entry to the visitor, or adds it to the status.
+func (v Visitor) client(status *ServerStatus, client Client) error {
+	if v.Client != nil {
+		return v.Client(client)
+	}
+	status.ClientList = append(status.ClientList, client)
+	return nil
+}
+
+// Passes a routing table entry to the visitor, or adds it to the status.
+func (v Visitor) route(status *ServerStatus, route Route) error {
+	if v.Route != nil {
+		return v.Route(route)
+	}
+	status.RoutingTable = append(status.RoutingTable, route)
+	return nil
+}
+
+// Returns the error of a status that ended, either after all its lines were
+// read or because parsing a line failed with the given error. Statuses
+// lacking their END line were likely cut off while being rewritten, in
+// which case ErrIncomplete is returned, also instead of the error of a
+// cut off last line.
+func endOfStatus(scanner *bufio.Scanner, ended bool, err error) error {
+	if err != nil {
+		if !errors.Is(err, ErrParseLine) || scanner.Scan() || scanner.Err() != nil {
+			return err
+		}
+		return fmt.Errorf("%w: %w", ErrIncomplete, err)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if !ended {
+		return ErrIncomplete
+	}
+	return nil
+}
+
+// Walk reads a status file of a known format like ParseFormat, passing
+// the entries of server status files to the visitor.
+func Walk(r io.Reader, format Format, v Visitor) (*Status, error) {
+	status := &Status{Format: format}
+	var err error
+	switch format {
+	case FormatClient:
+		status.Client, err = parseClientStats(r, v)
+	case FormatServerV1:
+		status.Server, err = parseServerStatusV1(r, v)
+	case FormatServerV2:
+		// Format version 2 uses commas as separators.
+		status.Server, err = parseServerStatus(r, ",", v)
+	case FormatServerV3:
+		// The only difference of format version 3 compared to
+		// version 2 is that it uses tabs instead of commas.
+		status.Server, err = parseServerStatus(r, "\t", v)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// Splits a line of a server status file into fields like splitFields,
+// except that fields may be quoted as in CSV, so that common names and
+// usernames can contain separators and quotes. Lines without quotes,
+// which are all lines OpenVPN itself writes, take the fast path of
+// splitFields.
+func splitRecord(dst []string, line string, separator string) []string {
+	if !strings.Contains(line, `"`) {
+		return splitFields(dst, line, separator)
+	}
+	reader := csv.NewReader(strings.NewReader(line))
+	reader.Comma, _ = utf8.DecodeRuneInString(separator)
+	reader.LazyQuotes = true
+	reader.FieldsPerRecord = -1
+	record, err := reader.Read()
+	if err != nil {
+		return splitFields(dst, line, separator)
+	}
+	return append(dst[:0], record...)
+}
+
+// Splits a line into fields, reusing the storage of dst. Unlike
+// strings.Split, this doesn't allocate once dst has grown to the number
+// of fields of the longest line.
+func splitFields(dst []string, line string, separator string) []string {
+	dst = dst[:0]
+	for {
+		i := strings.Index(line, separator)
+		if i < 0 {
+			return append(dst, line)
+		}
+		dst = append(dst, line[:i])
+		line = line[i+len(separator):]
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package status
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Parses an example status file of the repository, of a known format.
+func benchmarkParse(b *testing.B, name string, format Format) {
+	contents, err := os.ReadFile(filepath.Join("..", "..", "examples", name))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(contents)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ParseFormat(bytes.NewReader(contents), format); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseServerV1(b *testing.B) {
+	benchmarkParse(b, "server4.status", FormatServerV1)
+}
+
+func BenchmarkParseServerV2(b *testing.B) {
+	benchmarkParse(b, "server2.status", FormatServerV2)
+}
+
+func BenchmarkParseServerV3(b *testing.B) {
+	benchmarkParse(b, "server3.status", FormatServerV3)
+}
+
+func BenchmarkParseClient(b *testing.B) {
+	benchmarkParse(b, "client.status", FormatClient)
+}
+
+// Parses the same file as BenchmarkParseServerV2, detecting its format.
+func BenchmarkParseDetect(b *testing.B) {
+	contents, err := os.ReadFile(filepath.Join("..", "..", "examples", "server2.status"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(contents)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Parse(bytes.NewReader(contents)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugins
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kumina/openvpn_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// Output formats of exec plugins.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+var pluginUpDesc = prometheus.NewDesc(
+	prometheus.BuildFQName("openvpn", "plugin", "up"),
+	"Whether running the exec plugin was successful.",
+	[]string{"plugin"}, nil)
+
+// ExecCollector runs a command on every scrape, exporting the metrics it
+// writes to its standard output. The output is either in the Prometheus
+// text format, or a JSON array of samples like:
+//
+//	[{"name": "site_firewall_bytes_total", "help": "...", "type": "counter",
+//	  "labels": {"rule": "vpn"}, "value": 1234}]
+type ExecCollector struct {
+	name    string
+	command []string
+	format  string
+	timeout time.Duration
+	logger  *slog.Logger
+}
+
+// A sample in the JSON output of an exec plugin.
+type jsonSample struct {
+	Name   string            `json:"name"`
+	Help   string            `json:"help"`
+	Type   string            `json:"type"`
+	Labels map[string]string `json:"labels"`
+	Value  float64           `json:"value"`
+}
+
+// NewExecCollector creates the collector of an exec plugin.
+func NewExecCollector(plugin config.Plugin, logger *slog.Logger) (*ExecCollector, error) {
+	if len(plugin.Command) == 0 {
+		return nil, fmt.Errorf("plugin %q has no command", plugin.Name)
+	}
+	format := plugin.Format
+	if format == "" {
+		format = FormatText
+	}
+	if format != FormatText && format != FormatJSON {
+		return nil, fmt.Errorf("plugin %q has unknown format %q", plugin.Name, format)
+	}
+	timeout := time.Duration(plugin.Timeout)
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	return &ExecCollector{
+		name:    plugin.Name,
+		command: plugin.Command,
+		format:  format,
+		timeout: timeout,
+		logger:  logger.With("plugin", plugin.Name),
+	}, nil
+}
+
+// Describe sends no descriptors, as the metrics of a plugin are only known
+// once it runs. This makes the collector unchecked.
+func (c *ExecCollector) Describe(ch chan<- *prometheus.Desc) {
+}
+
+func (c *ExecCollector) Collect(ch chan<- prometheus.Metric) {
+	metrics, err := c.run()
+	up := 1.0
+	if err != nil {
+		c.logger.Error("Failed to run plugin", "err", err)
+		up = 0.0
+	}
+	for _, metric := range metrics {
+		ch <- metric
+	}
+	ch <- prometheus.MustNewConstMetric(pluginUpDesc, prometheus.GaugeValue, up, c.name)
+}
+
+// Runs the command and converts its output into metrics.
+func (c *ExecCollector) run() ([]prometheus.Metric, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, c.command[0], c.command[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+	if c.format == FormatJSON {
+		return parseJSON(stdout.Bytes())
+	}
+	return parseText(&stdout)
+}
+
+// Converts metrics in the Prometheus text format.
+func parseText(buf *bytes.Buffer) ([]prometheus.Metric, error) {
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(buf)
+	if err != nil {
+		return nil, err
+	}
+	return ConvertFamilies(families, nil)
+}
+
+// ConvertFamilies converts parsed metric families into metrics, adding
+// the given labels to them. Labels the metrics already have are renamed
+// with an "exported_" prefix, as Prometheus does for conflicting target
+// labels.
+func ConvertFamilies(families map[string]*dto.MetricFamily, extraLabels map[string]string) ([]prometheus.Metric, error) {
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var metrics []prometheus.Metric
+	for _, name := range names {
+		family := families[name]
+		for _, m := range family.Metric {
+			labels := map[string]string{}
+			for _, label := range m.Label {
+				labels[label.GetName()] = label.GetValue()
+			}
+			for label, value := range extraLabels {
+				if existing, ok := labels[label]; ok {
+					labels["exported_"+label] = existing
+				}
+				labels[label] = value
+			}
+			desc, values := newDesc(name, family.GetHelp(), labels)
+			var metric prometheus.Metric
+			var err error
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				metric, err = prometheus.NewConstMetric(desc, prometheus.CounterValue, m.GetCounter().GetValue(), values...)
+			case dto.MetricType_GAUGE:
+				metric, err = prometheus.NewConstMetric(desc, prometheus.GaugeValue, m.GetGauge().GetValue(), values...)
+			case dto.MetricType_UNTYPED:
+				metric, err = prometheus.NewConstMetric(desc, prometheus.UntypedValue, m.GetUntyped().GetValue(), values...)
+			case dto.MetricType_SUMMARY:
+				quantiles := map[float64]float64{}
+				for _, q := range m.GetSummary().Quantile {
+					quantiles[q.GetQuantile()] = q.GetValue()
+				}
+				metric, err = prometheus.NewConstSummary(desc, m.GetSummary().GetSampleCount(), m.GetSummary().GetSampleSum(), quantiles, values...)
+			case dto.MetricType_HISTOGRAM:
+				buckets := map[float64]uint64{}
+				for _, b := range m.GetHistogram().Bucket {
+					buckets[b.GetUpperBound()] = b.GetCumulativeCount()
+				}
+				metric, err = prometheus.NewConstHistogram(desc, m.GetHistogram().GetSampleCount(), m.GetHistogram().GetSampleSum(), buckets, values...)
+			default:
+				return nil, fmt.Errorf("metric %q has unsupported type %s", name, family.GetType())
+			}
+			if err != nil {
+				return nil, err
+			}
+			metrics = append(metrics, metric)
+		}
+	}
+	return metrics, nil
+}
+
+// Converts metrics in the JSON format.
+func parseJSON(data []byte) ([]prometheus.Metric, error) {
+	var samples []jsonSample
+	if err := json.Unmarshal(data, &samples); err != nil {
+		return nil, err
+	}
+	var metrics []prometheus.Metric
+	for _, sample := range samples {
+		var valueType prometheus.ValueType
+		switch sample.Type {
+		case "counter":
+			valueType = prometheus.CounterValue
+		case "gauge":
+			valueType = prometheus.GaugeValue
+		case "", "untyped":
+			valueType = prometheus.UntypedValue
+		default:
+			return nil, fmt.Errorf("metric %q has unsupported type %q", sample.Name, sample.Type)
+		}
+		metric, err := newMetric(sample.Name, sample.Help, valueType, sample.Labels, sample.Value)
+		if err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, metric)
+	}
+	return metrics, nil
+}
+
+// Creates a metric with the given labels.
+func newMetric(name string, help string, valueType prometheus.ValueType, labels map[string]string, value float64) (prometheus.Metric, error) {
+	desc, values := newDesc(name, help, labels)
+	return prometheus.NewConstMetric(desc, valueType, value, values...)
+}
+
+// Creates the descriptor of a metric with the given labels, returning it
+// along with the values of the labels.
+func newDesc(name string, help string, labels map[string]string) (*prometheus.Desc, []string) {
+	if help == "" {
+		help = "Metric exported by an exec plugin."
+	}
+	names := make([]string, 0, len(labels))
+	for label := range labels {
+		names = append(names, label)
+	}
+	sort.Strings(names)
+	values := make([]string, 0, len(names))
+	for _, label := range names {
+		values = append(values, labels[label])
+	}
+	return prometheus.NewDesc(name, help, names, nil), values
+}
@@ -0,0 +1,76 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package plugins allows adding site specific collectors to the exporter,
+// either compiled in or as external commands.
+//
+// Compiled in plugins register themselves from an init function in a file
+// added to the main package:
+//
+//	func init() {
+//		plugins.Register("billing", func(logger *slog.Logger) (prometheus.Collector, error) {
+//			return newBillingCollector(logger), nil
+//		})
+//	}
+package plugins
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Factory creates the collector of a plugin.
+type Factory func(logger *slog.Logger) (prometheus.Collector, error)
+
+var (
+	mtx       sync.Mutex
+	factories = map[string]Factory{}
+)
+
+// Register makes a compiled in plugin available under the given name. It
+// panics if the name is already taken.
+func Register(name string, factory Factory) {
+	mtx.Lock()
+	defer mtx.Unlock()
+	if _, ok := factories[name]; ok {
+		panic(fmt.Sprintf("plugin %q registered twice", name))
+	}
+	factories[name] = factory
+}
+
+// Names returns the names of all registered plugins, in sorted order.
+func Names() []string {
+	mtx.Lock()
+	defer mtx.Unlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New creates the collector of a registered plugin.
+func New(name string, logger *slog.Logger) (prometheus.Collector, error) {
+	mtx.Lock()
+	factory, ok := factories[name]
+	mtx.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown plugin %q", name)
+	}
+	return factory(logger.With("plugin", name))
+}
@@ -0,0 +1,24 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !unix
+
+package probes
+
+import "net"
+
+// Opens a raw ICMP socket, as ping sockets aren't supported on this
+// platform.
+func listenICMP(ipv6 bool) (net.PacketConn, bool, error) {
+	return listenRawICMP(ipv6)
+}
@@ -0,0 +1,45 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build unix
+
+package probes
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// Opens an ICMP socket, preferring an unprivileged ping socket over a raw
+// one. Returns whether the socket is a ping socket.
+func listenICMP(ipv6 bool) (net.PacketConn, bool, error) {
+	family, proto, sa := syscall.AF_INET, syscall.IPPROTO_ICMP, syscall.Sockaddr(&syscall.SockaddrInet4{})
+	if ipv6 {
+		family, proto, sa = syscall.AF_INET6, syscall.IPPROTO_ICMPV6, &syscall.SockaddrInet6{}
+	}
+	if fd, err := syscall.Socket(family, syscall.SOCK_DGRAM, proto); err == nil {
+		if err := syscall.Bind(fd, sa); err != nil {
+			syscall.Close(fd)
+		} else {
+			syscall.CloseOnExec(fd)
+			f := os.NewFile(uintptr(fd), "icmp")
+			conn, err := net.FilePacketConn(f)
+			f.Close()
+			if err == nil {
+				return conn, true, nil
+			}
+		}
+	}
+	return listenRawICMP(ipv6)
+}
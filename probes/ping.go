@@ -0,0 +1,338 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package probes
+
+import (
+	"context"
+	"encoding/binary"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/kumina/openvpn_exporter/exporters"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Types of ICMP messages.
+const (
+	icmpv4EchoRequest = 8
+	icmpv4EchoReply   = 0
+	icmpv6EchoRequest = 128
+	icmpv6EchoReply   = 129
+)
+
+// Duration for which replies are awaited after the last echo request of a
+// round has been sent.
+const pingReplyTimeout = time.Second
+
+var (
+	clientPingRTTDesc = prometheus.NewDesc(
+		prometheus.BuildFQName("openvpn", "server", "client_ping_rtt_seconds"),
+		"Average round trip time of the echo replies of a client's virtual address during the last round of pings, in seconds.",
+		[]string{"status_path", "common_name", "virtual_address"}, nil)
+	clientPingLossDesc = prometheus.NewDesc(
+		prometheus.BuildFQName("openvpn", "server", "client_ping_loss_ratio"),
+		"Fraction of the echo requests to a client's virtual address that weren't answered during the last round of pings.",
+		[]string{"status_path", "common_name", "virtual_address"}, nil)
+)
+
+// ClientPinger pings the virtual addresses of connected clients in the
+// background, measuring the quality of their tunnels. The clients are
+// those of a tracker in the pipeline of an exporter.
+//
+// Pinging requires an ICMP socket. Unprivileged ping sockets are used
+// where the system allows them (on Linux, if the group of the process is
+// in net.ipv4.ping_group_range), and raw sockets otherwise, which require
+// CAP_NET_RAW.
+type ClientPinger struct {
+	// Interval between rounds of pings, and the number of echo requests
+	// sent to each client per round.
+	Interval time.Duration
+	Count    int
+	// Maximum number of echo requests sent per second.
+	Rate float64
+
+	clients *exporters.ClientTracker
+	logger  *slog.Logger
+
+	mu      sync.Mutex
+	results map[exporters.Client]pingResult
+	pending map[pingKey]*pendingPing
+	seq     uint16
+	conns   [2]*icmpConn
+	failed  [2]bool
+}
+
+type pingResult struct {
+	sent     int
+	received int
+	rtt      time.Duration
+}
+
+// An echo request awaiting its reply, identified by its sequence number
+// and destination.
+type pingKey struct {
+	seq     uint16
+	address string
+}
+
+type pendingPing struct {
+	target   exporters.Client
+	sent     time.Time
+	received bool
+	rtt      time.Duration
+}
+
+// An ICMP socket, and whether it is a ping socket, of which the kernel
+// takes care of the identifier of echo requests.
+type icmpConn struct {
+	conn net.PacketConn
+	ping bool
+	ipv6 bool
+	id   uint16
+}
+
+// NewClientPinger creates a pinger sending count echo requests to each
+// tracked client every interval, at no more than rate requests per
+// second.
+func NewClientPinger(clients *exporters.ClientTracker, interval time.Duration, count int, rate float64, logger *slog.Logger) *ClientPinger {
+	return &ClientPinger{
+		Interval: interval,
+		Count:    count,
+		Rate:     rate,
+		clients:  clients,
+		logger:   logger,
+		results:  map[exporters.Client]pingResult{},
+		pending:  map[pingKey]*pendingPing{},
+	}
+}
+
+// Run pings the tracked clients every interval until the context is done.
+func (p *ClientPinger) Run(ctx context.Context) {
+	defer func() {
+		for _, c := range p.conns {
+			if c != nil {
+				c.conn.Close()
+			}
+		}
+	}()
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+	for {
+		p.round(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Pings all tracked clients Count times and replaces the results of the
+// previous round. Clients of which the virtual address isn't an IP
+// address, such as those of bridged servers, are skipped.
+func (p *ClientPinger) round(ctx context.Context) {
+	var targets []exporters.Client
+	for _, client := range p.clients.Clients() {
+		if net.ParseIP(client.VirtualAddress) != nil {
+			targets = append(targets, client)
+		}
+	}
+
+	results := map[exporters.Client]pingResult{}
+	pace := time.Duration(float64(time.Second) / p.Rate)
+	for i := 0; i < p.Count; i++ {
+		for _, target := range targets {
+			ip := net.ParseIP(target.VirtualAddress)
+			conn := p.conn(ip.To4() == nil)
+			if conn == nil {
+				continue
+			}
+			if err := p.send(conn, target, ip); err != nil {
+				p.logger.Debug("Failed to ping client", "virtual_address", target.VirtualAddress, "err", err)
+				continue
+			}
+			result := results[target]
+			result.sent++
+			results[target] = result
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(pace):
+			}
+		}
+	}
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(pingReplyTimeout):
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, pending := range p.pending {
+		if pending.received {
+			result := results[pending.target]
+			result.received++
+			result.rtt += pending.rtt
+			results[pending.target] = result
+		}
+	}
+	p.pending = map[pingKey]*pendingPing{}
+	p.results = results
+}
+
+// Returns the ICMP socket for IPv4 or IPv6, opening it if needed. Failures
+// to open a socket are only logged once, as they are usually caused by
+// missing permissions.
+func (p *ClientPinger) conn(ipv6 bool) *icmpConn {
+	family := 0
+	if ipv6 {
+		family = 1
+	}
+	if p.conns[family] != nil {
+		return p.conns[family]
+	}
+	conn, ping, err := listenICMP(ipv6)
+	if err != nil {
+		if !p.failed[family] {
+			p.logger.Warn("Cannot open ICMP socket to ping clients. Allow unprivileged ping sockets through net.ipv4.ping_group_range or grant CAP_NET_RAW", "ipv6", ipv6, "err", err)
+			p.failed[family] = true
+		}
+		return nil
+	}
+	c := &icmpConn{conn: conn, ping: ping, ipv6: ipv6, id: uint16(time.Now().UnixNano())}
+	p.conns[family] = c
+	go p.receive(c)
+	return c
+}
+
+// Sends an echo request to a target.
+func (p *ClientPinger) send(c *icmpConn, target exporters.Client, ip net.IP) error {
+	p.mu.Lock()
+	p.seq++
+	seq := p.seq
+	p.pending[pingKey{seq, ip.String()}] = &pendingPing{target: target, sent: time.Now()}
+	p.mu.Unlock()
+
+	message := make([]byte, 16)
+	message[0] = icmpv4EchoRequest
+	if c.ipv6 {
+		message[0] = icmpv6EchoRequest
+	}
+	binary.BigEndian.PutUint16(message[4:6], c.id)
+	binary.BigEndian.PutUint16(message[6:8], seq)
+	if !c.ipv6 {
+		// The kernel computes the checksum of ICMPv6 messages.
+		binary.BigEndian.PutUint16(message[2:4], icmpChecksum(message))
+	}
+	var addr net.Addr = &net.IPAddr{IP: ip}
+	if c.ping {
+		addr = &net.UDPAddr{IP: ip}
+	}
+	_, err := c.conn.WriteTo(message, addr)
+	return err
+}
+
+// Receives echo replies until the socket is closed.
+func (p *ClientPinger) receive(c *icmpConn) {
+	reply := make([]byte, 1500)
+	for {
+		n, addr, err := c.conn.ReadFrom(reply)
+		if err != nil {
+			return
+		}
+		received := time.Now()
+		replyType := byte(icmpv4EchoReply)
+		if c.ipv6 {
+			replyType = icmpv6EchoReply
+		}
+		// Raw sockets receive the replies to the echo requests of all
+		// processes, which are told apart by their identifier.
+		if n < 8 || reply[0] != replyType || (!c.ping && binary.BigEndian.Uint16(reply[4:6]) != c.id) {
+			continue
+		}
+		var ip net.IP
+		switch addr := addr.(type) {
+		case *net.UDPAddr:
+			ip = addr.IP
+		case *net.IPAddr:
+			ip = addr.IP
+		}
+		p.mu.Lock()
+		if pending, ok := p.pending[pingKey{binary.BigEndian.Uint16(reply[6:8]), ip.String()}]; ok && !pending.received {
+			pending.received = true
+			pending.rtt = received.Sub(pending.sent)
+		}
+		p.mu.Unlock()
+	}
+}
+
+// Computes the Internet checksum of an ICMPv4 message.
+func icmpChecksum(message []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(message); i += 2 {
+		sum += uint32(message[i])<<8 | uint32(message[i+1])
+	}
+	if len(message)%2 == 1 {
+		sum += uint32(message[len(message)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+func (p *ClientPinger) Describe(ch chan<- *prometheus.Desc) {
+	ch <- clientPingRTTDesc
+	ch <- clientPingLossDesc
+}
+
+// Collect exports the results of the last round for the clients that are
+// still connected.
+func (p *ClientPinger) Collect(ch chan<- prometheus.Metric) {
+	clients := p.clients.Clients()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, target := range clients {
+		result, ok := p.results[target]
+		if !ok || result.sent == 0 {
+			continue
+		}
+		labels := []string{target.StatusPath, target.CommonName, target.VirtualAddress}
+		if result.received > 0 {
+			ch <- prometheus.MustNewConstMetric(
+				clientPingRTTDesc,
+				prometheus.GaugeValue,
+				(result.rtt / time.Duration(result.received)).Seconds(),
+				labels...)
+		}
+		ch <- prometheus.MustNewConstMetric(
+			clientPingLossDesc,
+			prometheus.GaugeValue,
+			1-float64(result.received)/float64(result.sent),
+			labels...)
+	}
+}
+
+// Opens a raw ICMP socket.
+func listenRawICMP(ipv6 bool) (net.PacketConn, bool, error) {
+	network := "ip4:icmp"
+	if ipv6 {
+		network = "ip6:ipv6-icmp"
+	}
+	conn, err := net.ListenPacket(network, "")
+	return conn, false, err
+}
@@ -0,0 +1,144 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package probes actively checks whether OpenVPN servers can be reached,
+// catching servers of which the status file is fresh while their
+// listener is wedged or firewalled.
+package probes
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/kumina/openvpn_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	probeSuccessDesc = prometheus.NewDesc(
+		prometheus.BuildFQName("openvpn", "probe", "success"),
+		"Whether the probe of an OpenVPN server succeeded.",
+		[]string{"probe"}, nil)
+	probeDurationDesc = prometheus.NewDesc(
+		prometheus.BuildFQName("openvpn", "probe", "duration_seconds"),
+		"Duration of the probe of an OpenVPN server, in seconds.",
+		[]string{"probe"}, nil)
+)
+
+// Prober checks whether a server can be reached, returning an error if
+// it can't.
+type Prober interface {
+	Probe(ctx context.Context) error
+}
+
+// A configured probe.
+type probe struct {
+	name    string
+	prober  Prober
+	timeout time.Duration
+}
+
+// Collector runs probes in parallel on every scrape.
+type Collector struct {
+	probes []probe
+	logger *slog.Logger
+}
+
+// NewCollector creates a collector running the configured probes.
+func NewCollector(probes []config.Probe, logger *slog.Logger) (*Collector, error) {
+	c := &Collector{logger: logger}
+	for _, p := range probes {
+		prober, err := newProber(p)
+		if err != nil {
+			return nil, err
+		}
+		timeout := time.Duration(p.Timeout)
+		if timeout == 0 {
+			timeout = 5 * time.Second
+		}
+		name := p.Name
+		if name == "" {
+			name = p.Address
+		}
+		c.probes = append(c.probes, probe{name: name, prober: prober, timeout: timeout})
+	}
+	return c, nil
+}
+
+func newProber(p config.Probe) (Prober, error) {
+	switch p.Protocol {
+	case config.ProbeTCP:
+		return &TCPProber{Address: p.Address}, nil
+	case config.ProbeUDP:
+		prober := &UDPProber{Address: p.Address, KeyDirection: -1, Digest: p.Auth}
+		if p.KeyDirection != nil {
+			prober.KeyDirection = *p.KeyDirection
+		}
+		keyFile := p.TLSAuth
+		if p.TLSCrypt != "" {
+			keyFile, prober.TLSCrypt = p.TLSCrypt, true
+		}
+		if keyFile != "" {
+			key, err := ReadStaticKey(keyFile)
+			if err != nil {
+				return nil, fmt.Errorf("probe %q: %w", p.Name, err)
+			}
+			prober.Key = key
+		}
+		if _, err := prober.hardReset(time.Now()); err != nil {
+			return nil, fmt.Errorf("probe %q: %w", p.Name, err)
+		}
+		return prober, nil
+	default:
+		return nil, fmt.Errorf("probe %q has unknown protocol %q", p.Name, p.Protocol)
+	}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- probeSuccessDesc
+	ch <- probeDurationDesc
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	type result struct {
+		err      error
+		duration time.Duration
+	}
+	results := make([]result, len(c.probes))
+	var wg sync.WaitGroup
+	for i, p := range c.probes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+			defer cancel()
+			start := time.Now()
+			err := p.prober.Probe(ctx)
+			results[i] = result{err: err, duration: time.Since(start)}
+		}()
+	}
+	wg.Wait()
+
+	for i, p := range c.probes {
+		success := 1.0
+		if err := results[i].err; err != nil {
+			c.logger.Debug("Probe failed", "probe", p.name, "err", err)
+			success = 0.0
+		}
+		ch <- prometheus.MustNewConstMetric(probeSuccessDesc, prometheus.GaugeValue, success, p.name)
+		ch <- prometheus.MustNewConstMetric(probeDurationDesc, prometheus.GaugeValue, results[i].duration.Seconds(), p.name)
+	}
+}
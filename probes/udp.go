@@ -0,0 +1,213 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package probes
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Opcodes of OpenVPN's control channel packets, in the upper five bits of
+// the first byte of a packet.
+const (
+	opcodeHardResetClientV2 = 7
+	opcodeHardResetServerV2 = 8
+)
+
+// Digests supported for the HMAC of tls-auth, by their name in OpenVPN's
+// --auth option.
+var tlsAuthDigests = map[string]func() hash.Hash{
+	"SHA1":   sha1.New,
+	"SHA256": sha256.New,
+	"SHA512": sha512.New,
+}
+
+// UDPProber checks whether a server listening on UDP responds to the
+// start of a handshake. It sends the hard reset a client starts its
+// session with, signed with the tls-auth key or wrapped with the
+// tls-crypt key of the server if it uses one, and waits for the server's
+// reset. Servers with tls-auth or tls-crypt silently drop packets that
+// aren't properly signed, so without the key the probe can only succeed
+// against servers that use neither.
+//
+// The server keeps the half-open session until its handshake window
+// expires.
+type UDPProber struct {
+	Address string
+	// Static key of --tls-auth or --tls-crypt, if any, and whether it is
+	// used for tls-crypt.
+	Key      *StaticKey
+	TLSCrypt bool
+	// Key direction of the client, 0 or 1, or -1 if the key is used in
+	// both directions. Ignored for tls-crypt, which always uses 1.
+	KeyDirection int
+	// Digest of the tls-auth HMAC, as given by --auth. Defaults to SHA1.
+	Digest string
+}
+
+// StaticKey is an OpenVPN static key as generated by --genkey: four 64
+// byte keys, a cipher and an HMAC key for either direction.
+type StaticKey [256]byte
+
+// Cipher and HMAC key of a direction.
+func (k *StaticKey) cipherKey(direction int) []byte {
+	return k[direction*128 : direction*128+64]
+}
+
+func (k *StaticKey) hmacKey(direction int) []byte {
+	return k[direction*128+64 : direction*128+128]
+}
+
+// ReadStaticKey reads an OpenVPN static key file.
+func ReadStaticKey(filename string) (*StaticKey, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var encoded strings.Builder
+	inKey := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "-----BEGIN OpenVPN Static key V1-----":
+			inKey = true
+		case line == "-----END OpenVPN Static key V1-----":
+			inKey = false
+		case inKey:
+			encoded.WriteString(line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	decoded, err := hex.DecodeString(encoded.String())
+	if err != nil {
+		return nil, fmt.Errorf("reading static key %s: %w", filename, err)
+	}
+	var key StaticKey
+	if len(decoded) != len(key) {
+		return nil, fmt.Errorf("reading static key %s: expected %d bytes, got %d", filename, len(key), len(decoded))
+	}
+	copy(key[:], decoded)
+	return &key, nil
+}
+
+func (p *UDPProber) Probe(ctx context.Context) error {
+	packet, err := p.hardReset(time.Now())
+	if err != nil {
+		return err
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", p.Address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Write(packet); err != nil {
+		return err
+	}
+	reply := make([]byte, 1500)
+	for {
+		n, err := conn.Read(reply)
+		if err != nil {
+			return fmt.Errorf("waiting for reset of server: %w", err)
+		}
+		if n > 0 && reply[0]>>3 == opcodeHardResetServerV2 {
+			return nil
+		}
+	}
+}
+
+// Builds the hard reset starting a session. On the wire, control channel
+// packets consist of:
+//
+//	opcode/key ID | session ID | [HMAC | packet ID | time] | ACKs | message packet ID
+//
+// With tls-auth, the HMAC covers the packet ID and time, followed by the
+// rest of the packet without the HMAC. With tls-crypt, the HMAC is
+// followed by the packet ID and time, and the ACKs and message packet ID
+// are encrypted.
+func (p *UDPProber) hardReset(now time.Time) ([]byte, error) {
+	header := make([]byte, 9)
+	header[0] = opcodeHardResetClientV2 << 3
+	if _, err := rand.Read(header[1:]); err != nil {
+		return nil, err
+	}
+	replay := make([]byte, 8)
+	binary.BigEndian.PutUint32(replay[0:4], 1)
+	binary.BigEndian.PutUint32(replay[4:8], uint32(now.Unix()))
+	// No ACKs and a message packet ID of 0.
+	body := []byte{0, 0, 0, 0, 0}
+
+	switch {
+	case p.Key == nil:
+		return append(header, body...), nil
+	case p.TLSCrypt:
+		mac := hmac.New(sha256.New, p.Key.hmacKey(1)[:32])
+		mac.Write(header)
+		mac.Write(replay)
+		mac.Write(body)
+		tag := mac.Sum(nil)
+		block, err := aes.NewCipher(p.Key.cipherKey(1)[:32])
+		if err != nil {
+			return nil, err
+		}
+		encrypted := make([]byte, len(body))
+		cipher.NewCTR(block, tag[:aes.BlockSize]).XORKeyStream(encrypted, body)
+		return bytes.Join([][]byte{header, replay, tag, encrypted}, nil), nil
+	default:
+		digest := p.Digest
+		if digest == "" {
+			digest = "SHA1"
+		}
+		newHash, ok := tlsAuthDigests[strings.ToUpper(digest)]
+		if !ok {
+			return nil, fmt.Errorf("unsupported digest %q", digest)
+		}
+		direction := p.KeyDirection
+		if direction < 0 {
+			direction = 0
+		} else if direction > 1 {
+			return nil, errors.New("key direction must be 0 or 1")
+		}
+		key := p.Key.hmacKey(direction)[:newHash().Size()]
+		mac := hmac.New(newHash, key)
+		mac.Write(replay)
+		mac.Write(header)
+		mac.Write(body)
+		return bytes.Join([][]byte{header, mac.Sum(nil), replay, body}, nil), nil
+	}
+}
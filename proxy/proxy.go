@@ -0,0 +1,164 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package proxy aggregates the metrics of downstream exporters, for hub
+// sites that Prometheus can reach while the edge gateways behind them
+// can't be scraped directly.
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/kumina/openvpn_exporter/config"
+	"github.com/kumina/openvpn_exporter/plugins"
+	"github.com/kumina/openvpn_exporter/sources"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// Label added to the metrics of gateways.
+const gatewayLabel = "gateway"
+
+var (
+	gatewayUpDesc = prometheus.NewDesc(
+		prometheus.BuildFQName("openvpn", "gateway", "up"),
+		"Whether the metrics of a downstream exporter could be scraped.",
+		[]string{gatewayLabel}, nil)
+	gatewayDurationDesc = prometheus.NewDesc(
+		prometheus.BuildFQName("openvpn", "gateway", "scrape_duration_seconds"),
+		"Duration of the scrape of a downstream exporter, in seconds.",
+		[]string{gatewayLabel}, nil)
+)
+
+// A configured gateway.
+type gateway struct {
+	name    string
+	source  *sources.HTTPSource
+	timeout time.Duration
+}
+
+// Collector scrapes downstream exporters in parallel on every scrape,
+// serving their metrics with a gateway label added. Labels named gateway
+// that the metrics already have, such as those of other hubs, are renamed
+// to exported_gateway.
+type Collector struct {
+	gateways []gateway
+	logger   *slog.Logger
+}
+
+// NewCollector creates a collector for the configured gateways.
+func NewCollector(gateways []config.Gateway, logger *slog.Logger) *Collector {
+	c := &Collector{logger: logger}
+	for _, g := range gateways {
+		timeout := time.Duration(g.Timeout)
+		if timeout == 0 {
+			timeout = 10 * time.Second
+		}
+		c.gateways = append(c.gateways, gateway{
+			name: g.Name,
+			source: &sources.HTTPSource{
+				URL:         g.URL,
+				Username:    g.Username,
+				Password:    g.Password,
+				BearerToken: g.BearerToken,
+			},
+			timeout: timeout,
+		})
+	}
+	return c
+}
+
+// Describe sends no descriptors, as the metrics of the gateways are only
+// known once they are scraped. This makes the collector unchecked.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	type result struct {
+		families map[string]*dto.MetricFamily
+		err      error
+		duration time.Duration
+	}
+	results := make([]result, len(c.gateways))
+	var wg sync.WaitGroup
+	for i, g := range c.gateways {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			families, err := g.scrape()
+			results[i] = result{families: families, err: err, duration: time.Since(start)}
+		}()
+	}
+	wg.Wait()
+
+	// Metrics of the same name need the same help and type. Gateways
+	// running other versions may differ, in which case the first gateway
+	// providing a metric wins.
+	helps := map[string]string{}
+	types := map[string]dto.MetricType{}
+	for i, g := range c.gateways {
+		up := 1.0
+		if err := results[i].err; err != nil {
+			c.logger.Error("Failed to scrape gateway", "gateway", g.name, "err", err)
+			up = 0.0
+		}
+		for name, family := range results[i].families {
+			if t, ok := types[name]; !ok {
+				helps[name], types[name] = family.GetHelp(), family.GetType()
+			} else if t != family.GetType() {
+				c.logger.Warn("Dropping metric of gateway with conflicting type", "gateway", g.name, "metric", name)
+				delete(results[i].families, name)
+				continue
+			}
+			family.Help = stringPointer(helps[name])
+		}
+		metrics, err := plugins.ConvertFamilies(results[i].families, map[string]string{gatewayLabel: g.name})
+		if err != nil {
+			c.logger.Error("Failed to convert metrics of gateway", "gateway", g.name, "err", err)
+			up = 0.0
+		}
+		for _, metric := range metrics {
+			ch <- metric
+		}
+		ch <- prometheus.MustNewConstMetric(gatewayUpDesc, prometheus.GaugeValue, up, g.name)
+		ch <- prometheus.MustNewConstMetric(gatewayDurationDesc, prometheus.GaugeValue, results[i].duration.Seconds(), g.name)
+	}
+}
+
+// Scrapes the metrics of a gateway, in the text format that exporters
+// serve by default.
+func (g *gateway) scrape() (map[string]*dto.MetricFamily, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), g.timeout)
+	defer cancel()
+	body, err := g.source.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(body)
+	if err != nil {
+		return nil, fmt.Errorf("parsing metrics: %w", err)
+	}
+	return families, nil
+}
+
+func stringPointer(s string) *string {
+	return &s
+}
@@ -0,0 +1,201 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kumina/openvpn_exporter/config"
+	"github.com/kumina/openvpn_exporter/exporters"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// Layout of the names of recorded files, which sort by the time at which
+// they were recorded.
+const recordingLayout = "20060102T150405.000000000Z"
+
+// Saves the raw contents of the reads of status paths to a directory, so
+// that problems with parsing them can be reproduced with the "replay"
+// subcommand. The contents of each status path are saved to a directory
+// named after the escaped status path, keeping its most recent files.
+type statusRecorder struct {
+	dir      string
+	maxFiles int
+	logger   *slog.Logger
+
+	mu sync.Mutex
+}
+
+func newStatusRecorder(dir string, maxFiles int, logger *slog.Logger) *statusRecorder {
+	return &statusRecorder{dir: dir, maxFiles: maxFiles, logger: logger}
+}
+
+func (r *statusRecorder) Record(statusPath string, contents []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// Recordings hold the addresses of clients, so they are only
+	// readable by the exporter's user.
+	dir := filepath.Join(r.dir, url.PathEscape(statusPath))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		r.logger.Error("Failed to record status", "status_path", statusPath, "err", err)
+		return
+	}
+	name := filepath.Join(dir, time.Now().UTC().Format(recordingLayout)+".status")
+	if err := os.WriteFile(name, contents, 0600); err != nil {
+		r.logger.Error("Failed to record status", "status_path", statusPath, "err", err)
+		return
+	}
+	if r.maxFiles <= 0 {
+		return
+	}
+	recordings, err := listRecordings(dir)
+	if err != nil {
+		r.logger.Error("Failed to rotate recorded statuses", "status_path", statusPath, "err", err)
+		return
+	}
+	for len(recordings) > r.maxFiles {
+		if err := os.Remove(recordings[0].file); err != nil {
+			r.logger.Error("Failed to rotate recorded statuses", "status_path", statusPath, "err", err)
+			return
+		}
+		recordings = recordings[1:]
+	}
+}
+
+// A recorded read of a status path.
+type recording struct {
+	file string
+	time time.Time
+}
+
+// Returns the recordings of a status path, oldest first.
+func listRecordings(dir string) ([]recording, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var recordings []recording
+	for _, entry := range entries {
+		t, err := time.Parse(recordingLayout, strings.TrimSuffix(entry.Name(), ".status"))
+		if entry.IsDir() || err != nil {
+			continue
+		}
+		recordings = append(recordings, recording{file: filepath.Join(dir, entry.Name()), time: t})
+	}
+	sort.Slice(recordings, func(i, j int) bool {
+		return recordings[i].time.Before(recordings[j].time)
+	})
+	return recordings, nil
+}
+
+// A clock stopped at the time of a recording.
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+// Implements the "replay" subcommand, which collects the status files
+// recorded with -debug.record-dir as the exporter configured by the given
+// flags would have when they were read, and writes the resulting metrics.
+// Recordings of configured status paths are read with the options of
+// these.
+func runReplayCommand(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	exporterFlags := registerExporterFlags(fs)
+	dir := fs.String("dir", "", "Directory of the recordings, as given to -debug.record-dir.")
+	statusPath := fs.String("status-path", "", "If set, only replay the recordings of this status path.")
+	out := fs.String("out", "-", "File to which the metrics are written, or - for stdout.")
+	fs.Parse(args)
+
+	logger := exporterFlags.newLogger()
+	defer closeLogger(logger)
+	if *dir == "" {
+		fatal(logger, "Missing -dir")
+	}
+	statusPaths, err := exporterFlags.loadStatusPaths(logger)
+	if err != nil {
+		fatal(logger, "Failed to load status paths", "err", err)
+	}
+	entries, err := os.ReadDir(*dir)
+	if err != nil {
+		fatal(logger, "Failed to read recordings", "err", err)
+	}
+
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		name, err := url.PathUnescape(entry.Name())
+		if !entry.IsDir() || err != nil || (*statusPath != "" && name != *statusPath) {
+			continue
+		}
+		recordings, err := listRecordings(filepath.Join(*dir, entry.Name()))
+		if err != nil {
+			fatal(logger, "Failed to read recordings", "status_path", name, "err", err)
+		}
+		sp := replayStatusPath(statusPaths, name)
+		for _, rec := range recordings {
+			// The recording is read as a local file with the options of
+			// the status path, at the time it was recorded.
+			sp.Path = rec.file
+			exporter, err := exporterFlags.newExporterFor(logger, []config.StatusPath{sp}, exporters.WithClock(fixedClock(rec.time)))
+			if err != nil {
+				fatal(logger, "Failed to create exporter", "status_path", name, "err", err)
+			}
+			registry := prometheus.NewRegistry()
+			registry.MustRegister(exporter)
+			families, err := registry.Gather()
+			if err != nil {
+				fatal(logger, "Failed to collect recording", "file", rec.file, "err", err)
+			}
+			fmt.Fprintf(&buf, "# Recording of %s at %s: %s\n", name, rec.time.Format(time.RFC3339Nano), rec.file)
+			for _, family := range families {
+				if _, err := expfmt.MetricFamilyToText(&buf, family); err != nil {
+					fatal(logger, "Failed to format metrics", "err", err)
+				}
+			}
+		}
+	}
+	if err := writeOutput(*out, buf.Bytes()); err != nil {
+		fatal(logger, "Failed to write metrics", "err", err)
+	}
+}
+
+// Returns the options of the configured status path that a recorded
+// status path belongs to, which is either the status path itself or a glob
+// pattern matching it. Recordings of other status paths use the default
+// options.
+func replayStatusPath(statusPaths []config.StatusPath, name string) config.StatusPath {
+	for _, sp := range statusPaths {
+		if sp.Path == name {
+			return sp
+		}
+	}
+	for _, sp := range statusPaths {
+		if matched, _ := filepath.Match(sp.Path, name); matched {
+			return sp
+		}
+	}
+	return config.StatusPath{}
+}
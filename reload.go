@@ -0,0 +1,207 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/kumina/openvpn_exporter/config"
+	"github.com/kumina/openvpn_exporter/exporters"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	configReloadSuccessful = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "openvpn",
+		Subsystem: "exporter",
+		Name:      "config_last_reload_successful",
+		Help:      "Whether the last reload of the configuration succeeded.",
+	})
+	configReloadTime = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "openvpn",
+		Subsystem: "exporter",
+		Name:      "config_last_reload_success_timestamp_seconds",
+		Help:      "UNIX timestamp of the last successful load of the configuration.",
+	})
+)
+
+// An exporter that is replaced by a new one when the configuration is
+// reloaded. Scrapes in flight finish with the exporter they started with.
+type reloadableExporter struct {
+	refreshInterval time.Duration
+
+	mtx      sync.RWMutex
+	exporter *exporters.OpenVPNExporter
+	// Stops the background refresh of the exporter.
+	stop context.CancelFunc
+}
+
+func newReloadableExporter(exporter *exporters.OpenVPNExporter, refreshInterval time.Duration) *reloadableExporter {
+	r := &reloadableExporter{refreshInterval: refreshInterval}
+	r.set(exporter)
+	return r
+}
+
+// Replaces the exporter, moving the background refresh over to the new
+// one. A new exporter replacing another one is refreshed first, so that
+// scrapes don't get empty metrics in the meantime.
+func (r *reloadableExporter) set(exporter *exporters.OpenVPNExporter) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if r.refreshInterval > 0 {
+		if r.current() != nil {
+			exporter.Refresh(ctx)
+		}
+		go exporter.Run(ctx, r.refreshInterval)
+	}
+	r.mtx.Lock()
+	stop := r.stop
+	r.exporter, r.stop = exporter, cancel
+	r.mtx.Unlock()
+	if stop != nil {
+		stop()
+	}
+}
+
+func (r *reloadableExporter) current() *exporters.OpenVPNExporter {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	return r.exporter
+}
+
+// Describe sends no descriptors, making the exporter an unchecked
+// collector, as the labels of its metrics may change with reloads.
+func (r *reloadableExporter) Describe(ch chan<- *prometheus.Desc) {}
+
+func (r *reloadableExporter) Collect(ch chan<- prometheus.Metric) {
+	r.current().Collect(ch)
+}
+
+func (r *reloadableExporter) Debug(ctx context.Context, statusPath string) (*exporters.DebugReport, error) {
+	return r.current().Debug(ctx, statusPath)
+}
+
+// Reloads the configuration file, replacing the exporters of the default
+// endpoint and of the tenants with ones for the reloaded status paths.
+// Other parts of the configuration, such as tenants, plugins and quotas,
+// only take effect after a restart.
+type configReloader struct {
+	flags  *exporterFlags
+	logger *slog.Logger
+
+	mtx sync.Mutex
+	// Exporters by the name of their tenant, "" for the default endpoint,
+	// and the options they are created with besides those of the flags.
+	exporters map[string]*reloadableExporter
+	options   map[string][]exporters.Option
+}
+
+func newConfigReloader(flags *exporterFlags, logger *slog.Logger) *configReloader {
+	configReloadSuccessful.Set(1)
+	configReloadTime.SetToCurrentTime()
+	return &configReloader{
+		flags:     flags,
+		logger:    logger,
+		exporters: map[string]*reloadableExporter{},
+		options:   map[string][]exporters.Option{},
+	}
+}
+
+// Adds the exporter of a tenant, created with the given options.
+func (c *configReloader) add(tenant string, exporter *reloadableExporter, options []exporters.Option) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.exporters[tenant] = exporter
+	c.options[tenant] = options
+}
+
+// Reloads the configuration. If it is invalid, the exporters are left
+// alone.
+func (c *configReloader) reload() error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	err := c.reloadLocked()
+	if err != nil {
+		configReloadSuccessful.Set(0)
+		c.logger.Error("Failed to reload configuration", "err", err)
+		return err
+	}
+	configReloadSuccessful.Set(1)
+	configReloadTime.SetToCurrentTime()
+	return nil
+}
+
+func (c *configReloader) reloadLocked() error {
+	cfg, err := c.flags.reloadConfig(c.logger)
+	if err != nil {
+		return err
+	}
+	statusPaths := map[string][]config.StatusPath{}
+	for _, sp := range cfg.StatusPaths {
+		if _, ok := c.exporters[sp.Tenant]; !ok {
+			c.logger.Warn("Ignoring status path of tenant added since the start", "status_path", sp.Path, "tenant", sp.Tenant)
+			continue
+		}
+		statusPaths[sp.Tenant] = append(statusPaths[sp.Tenant], sp)
+	}
+	// Create all exporters before replacing any, so that a failure leaves
+	// all of them alone.
+	created := map[string]*exporters.OpenVPNExporter{}
+	for tenant := range c.exporters {
+		exporter, err := c.flags.newExporterFor(c.logger, statusPaths[tenant], c.options[tenant]...)
+		if err != nil {
+			if tenant != "" {
+				return fmt.Errorf("creating exporter of tenant %q: %w", tenant, err)
+			}
+			return fmt.Errorf("creating exporter: %w", err)
+		}
+		created[tenant] = exporter
+	}
+	for tenant, exporter := range created {
+		c.exporters[tenant].set(exporter)
+	}
+	c.logger.Info("Reloaded configuration", "status_paths", len(cfg.StatusPaths))
+	return nil
+}
+
+// Reloads the configuration on POST or PUT requests.
+func reloadHandler(reloader *configReloader) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodPut {
+			w.Header().Set("Allow", "POST, PUT")
+			http.Error(w, "reloading requires a POST or PUT request", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := reloader.reload(); err != nil {
+			http.Error(w, fmt.Sprintf("failed to reload configuration: %v", err), http.StatusInternalServerError)
+		}
+	})
+}
+
+// Reloads the configuration whenever the exporter receives SIGHUP.
+func reloadOnHangup(reloader *configReloader) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		reloader.logger.Info("Reloading configuration on SIGHUP")
+		reloader.reload()
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kumina/openvpn_exporter/config"
+	"github.com/kumina/openvpn_exporter/exporters"
+)
+
+// A Prometheus alerting or recording rule.
+type rule struct {
+	alert       string
+	record      string
+	expr        string
+	duration    time.Duration
+	labels      map[string]string
+	annotations map[string]string
+}
+
+// Implements the "rules" subcommand, which writes Prometheus alerting and
+// recording rules for the metrics of the exporter as configured by the
+// given flags.
+func runRulesCommand(args []string) {
+	fs := flag.NewFlagSet("rules", flag.ExitOnError)
+	exporterFlags := registerExporterFlags(fs)
+	out := fs.String("out", "-", "File to which the rules are written, or - for stdout.")
+	group := fs.String("group", "openvpn", "Name of the rule group.")
+	staleThreshold := fs.Duration("stale-threshold", 5*time.Minute, "Age after which statistics are considered stale, for status paths without max_age.")
+	maxClients := fs.Int("max-clients", 0, "Number of clients at which the address pool or max-clients limit is exhausted. 0 disables the pool exhaustion alert.")
+	poolWarning := fs.Float64("pool-warning-ratio", 0.9, "Fraction of -max-clients at which the pool exhaustion alert fires.")
+	expectedClients := fs.String("expected-clients", "", "Comma separated common names of clients that should always be connected.")
+	severity := fs.String("severity", "warning", "Value of the severity label of alerts.")
+	fs.Parse(args)
+
+	logger := exporterFlags.newLogger()
+	defer closeLogger(logger)
+	statusPaths, err := exporterFlags.loadStatusPaths(logger)
+	if err != nil {
+		fatal(logger, "Failed to load status paths", "err", err)
+	}
+	exporter, err := exporterFlags.newExporter(logger)
+	if err != nil {
+		fatal(logger, "Failed to create exporter", "err", err)
+	}
+
+	var clients []string
+	if *expectedClients != "" {
+		clients = strings.Split(*expectedClients, ",")
+	}
+	rules := buildRules(exporter.MetricInfos(), statusPaths, *staleThreshold, *maxClients, *poolWarning, clients, *severity)
+	if err := writeOutput(*out, formatRules(*group, rules)); err != nil {
+		fatal(logger, "Failed to write rules", "err", err)
+	}
+}
+
+func buildRules(metrics []exporters.MetricInfo, statusPaths []config.StatusPath, staleThreshold time.Duration, maxClients int, poolWarning float64, expectedClients []string, severity string) []rule {
+	exported := map[string]bool{}
+	for _, metric := range metrics {
+		exported[metric.Name] = true
+	}
+	alertLabels := map[string]string{"severity": severity}
+
+	var rules []rule
+	if exported["openvpn_server_client_received_bytes_total"] {
+		rules = append(rules,
+			rule{
+				record: "status_path:openvpn_server_client_received_bytes:rate5m",
+				expr:   "sum by (status_path) (rate(openvpn_server_client_received_bytes_total[5m]))",
+			},
+			rule{
+				record: "status_path:openvpn_server_client_sent_bytes:rate5m",
+				expr:   "sum by (status_path) (rate(openvpn_server_client_sent_bytes_total[5m]))",
+			})
+	}
+
+	rules = append(rules, rule{
+		alert:    "OpenVPNDown",
+		expr:     "openvpn_up == 0",
+		duration: 5 * time.Minute,
+		labels:   alertLabels,
+		annotations: map[string]string{
+			"summary": "OpenVPN status {{ $labels.status_path }} could not be scraped.",
+			"reason":  `{{ range printf "openvpn_scrape_error{status_path=%q} == 1" $labels.status_path | query }}{{ .Labels.reason }}{{ end }}`,
+		},
+	})
+	if exported["openvpn_server_restarts_total"] {
+		rules = append(rules, rule{
+			alert:       "OpenVPNRestarted",
+			expr:        "increase(openvpn_server_restarts_total[15m]) > 0",
+			labels:      alertLabels,
+			annotations: map[string]string{"summary": "OpenVPN daemon of {{ $labels.status_path }} restarted."},
+		})
+	}
+
+	// Status paths with their own staleness threshold get a dedicated
+	// alert, while all others share the default threshold.
+	var custom []string
+	for _, sp := range statusPaths {
+		if sp.MaxAge == 0 {
+			continue
+		}
+		custom = append(custom, sp.Path)
+		rules = append(rules, rule{
+			alert:       "OpenVPNStatusStale",
+			expr:        fmt.Sprintf("time() - openvpn_status_update_time_seconds{status_path=%q} > %d", sp.Path, int64(time.Duration(sp.MaxAge).Seconds())),
+			duration:    5 * time.Minute,
+			labels:      alertLabels,
+			annotations: map[string]string{"summary": "OpenVPN status {{ $labels.status_path }} has not been updated for {{ $value | humanizeDuration }}."},
+		})
+	}
+	selector := ""
+	if len(custom) > 0 {
+		quoted := make([]string, 0, len(custom))
+		for _, path := range custom {
+			quoted = append(quoted, regexp.QuoteMeta(path))
+		}
+		selector = fmt.Sprintf("{status_path!~%q}", strings.Join(quoted, "|"))
+	}
+	rules = append(rules, rule{
+		alert:       "OpenVPNStatusStale",
+		expr:        fmt.Sprintf("time() - openvpn_status_update_time_seconds%s > %d", selector, int64(staleThreshold.Seconds())),
+		duration:    5 * time.Minute,
+		labels:      alertLabels,
+		annotations: map[string]string{"summary": "OpenVPN status {{ $labels.status_path }} has not been updated for {{ $value | humanizeDuration }}."},
+	})
+
+	for _, cn := range expectedClients {
+		rules = append(rules, rule{
+			alert:       "OpenVPNClientMissing",
+			expr:        fmt.Sprintf("absent(openvpn_server_client_received_bytes_total{common_name=%q})", cn),
+			duration:    10 * time.Minute,
+			labels:      map[string]string{"severity": severity, "common_name": cn},
+			annotations: map[string]string{"summary": fmt.Sprintf("OpenVPN client %s is not connected.", cn)},
+		})
+	}
+
+	if maxClients > 0 {
+		// Exporters using the names of kumina/openvpn_exporter only
+		// export the doubly prefixed name.
+		connectedClients := "openvpn_server_connected_clients"
+		if !exported[connectedClients] {
+			connectedClients = "openvpn_openvpn_server_connected_clients"
+		}
+		rules = append(rules, rule{
+			alert:       "OpenVPNClientPoolExhausted",
+			expr:        fmt.Sprintf("%s >= %g", connectedClients, float64(maxClients)*poolWarning),
+			duration:    15 * time.Minute,
+			labels:      alertLabels,
+			annotations: map[string]string{"summary": fmt.Sprintf("OpenVPN server {{ $labels.status_path }} has {{ $value }} of %d clients connected.", maxClients)},
+		})
+	}
+	return rules
+}
+
+// Formats rules as a Prometheus rule file. Strings are written as JSON,
+// which is valid YAML and avoids having to deal with YAML quoting rules.
+func formatRules(group string, rules []rule) []byte {
+	var buf bytes.Buffer
+	quote := func(s string) string {
+		var quoted bytes.Buffer
+		encoder := json.NewEncoder(&quoted)
+		encoder.SetEscapeHTML(false)
+		encoder.Encode(s)
+		return strings.TrimSuffix(quoted.String(), "\n")
+	}
+	writeMap := func(name string, m map[string]string) {
+		if len(m) == 0 {
+			return
+		}
+		fmt.Fprintf(&buf, "    %s:\n", name)
+		keys := make([]string, 0, len(m))
+		for key := range m {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(&buf, "      %s: %s\n", key, quote(m[key]))
+		}
+	}
+
+	fmt.Fprintf(&buf, "groups:\n- name: %s\n  rules:\n", quote(group))
+	for _, r := range rules {
+		if r.alert != "" {
+			fmt.Fprintf(&buf, "  - alert: %s\n", r.alert)
+		} else {
+			fmt.Fprintf(&buf, "  - record: %s\n", r.record)
+		}
+		fmt.Fprintf(&buf, "    expr: %s\n", quote(r.expr))
+		if r.duration > 0 {
+			fmt.Fprintf(&buf, "    for: %s\n", formatPromDuration(r.duration))
+		}
+		writeMap("labels", r.labels)
+		writeMap("annotations", r.annotations)
+	}
+	return buf.Bytes()
+}
+
+// Formats a duration in the syntax used by Prometheus, e.g. "5m".
+func formatPromDuration(d time.Duration) string {
+	if d%time.Hour == 0 {
+		return fmt.Sprintf("%dh", d/time.Hour)
+	}
+	if d%time.Minute == 0 {
+		return fmt.Sprintf("%dm", d/time.Minute)
+	}
+	return fmt.Sprintf("%ds", d/time.Second)
+}
@@ -0,0 +1,192 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kumina/openvpn_exporter/config"
+)
+
+// A client connected to the simulated server.
+type simulatedClient struct {
+	commonName     string
+	realAddress    string
+	virtualAddress string
+	connectedSince time.Time
+	bytesReceived  uint64
+	bytesSent      uint64
+	clientID       int
+}
+
+// State of a simulated OpenVPN server or client.
+type simulation struct {
+	rng          *rand.Rand
+	format       string
+	routes       int
+	clients      []*simulatedClient
+	nextClientID int
+	// Traffic counters of a simulated OpenVPN client.
+	clientCounters map[string]uint64
+}
+
+// Implements the "simulate" subcommand, which writes synthetic status
+// files for benchmarking the exporter. With -interval, the file is
+// rewritten periodically with growing traffic counters and connecting
+// and disconnecting clients.
+func runSimulateCommand(args []string) {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	format := fs.String("format", config.FormatServerV2, "Format of the status file. One of: client, server_v1, server_v2, server_v3.")
+	clients := fs.Int("clients", 100, "Number of connected clients.")
+	routes := fs.Int("routes", 0, "Number of routing table entries. Defaults to one per client.")
+	out := fs.String("out", "-", "File to which the status is written, or - for stdout.")
+	interval := fs.Duration("interval", 0, "Interval at which the status file is rewritten. 0 writes it once.")
+	churn := fs.Float64("churn", 0.01, "Fraction of clients that reconnect on every rewrite.")
+	seed := fs.Int64("seed", 1, "Seed of the random number generator.")
+	fs.Parse(args)
+
+	switch *format {
+	case config.FormatClient, config.FormatServerV1, config.FormatServerV2, config.FormatServerV3:
+	default:
+		fmt.Fprintf(os.Stderr, "Unsupported format %q\n", *format)
+		os.Exit(1)
+	}
+	if *routes == 0 {
+		*routes = *clients
+	}
+
+	s := &simulation{
+		rng:            rand.New(rand.NewSource(*seed)),
+		format:         *format,
+		routes:         *routes,
+		clientCounters: map[string]uint64{},
+	}
+	now := time.Now()
+	for i := 0; i < *clients; i++ {
+		s.connect(now.Add(-time.Duration(s.rng.Int63n(int64(24 * time.Hour)))))
+	}
+
+	for {
+		if err := writeOutput(*out, s.render(time.Now())); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if *interval == 0 || *out == "-" {
+			return
+		}
+		time.Sleep(*interval)
+		s.step(time.Now(), *interval, *churn)
+	}
+}
+
+// Adds a newly connected client.
+func (s *simulation) connect(since time.Time) {
+	s.nextClientID++
+	id := s.nextClientID
+	s.clients = append(s.clients, &simulatedClient{
+		commonName:     fmt.Sprintf("client%05d", id),
+		realAddress:    fmt.Sprintf("198.51.%d.%d:%d", s.rng.Intn(256), s.rng.Intn(256), 1024+s.rng.Intn(64511)),
+		virtualAddress: fmt.Sprintf("10.%d.%d.%d", 8+id/65536, (id/256)%256, id%256),
+		connectedSince: since,
+		clientID:       id,
+	})
+}
+
+// Advances the simulation: traffic is added to all counters and a
+// fraction of the clients is replaced by new ones.
+func (s *simulation) step(now time.Time, elapsed time.Duration, churn float64) {
+	seconds := uint64(elapsed.Seconds()) + 1
+	for _, c := range s.clients {
+		c.bytesReceived += seconds * uint64(s.rng.Intn(100000))
+		c.bytesSent += seconds * uint64(s.rng.Intn(500000))
+	}
+	for _, key := range []string{"TUN/TAP read bytes", "TUN/TAP write bytes", "TCP/UDP read bytes", "TCP/UDP write bytes", "Auth read bytes"} {
+		s.clientCounters[key] += seconds * uint64(s.rng.Intn(1000000))
+	}
+
+	reconnects := int(float64(len(s.clients)) * churn)
+	for i := 0; i < reconnects && len(s.clients) > 0; i++ {
+		n := s.rng.Intn(len(s.clients))
+		s.clients = append(s.clients[:n], s.clients[n+1:]...)
+		s.connect(now)
+	}
+}
+
+// Renders the status file in the configured format.
+func (s *simulation) render(now time.Time) []byte {
+	var buf bytes.Buffer
+	switch s.format {
+	case config.FormatClient:
+		fmt.Fprintf(&buf, "OpenVPN STATISTICS\nUpdated,%s\n", now.Format("Mon Jan 2 15:04:05 2006"))
+		for _, key := range []string{"TUN/TAP read bytes", "TUN/TAP write bytes", "TCP/UDP read bytes", "TCP/UDP write bytes", "Auth read bytes"} {
+			fmt.Fprintf(&buf, "%s,%d\n", key, s.clientCounters[key])
+		}
+		buf.WriteString("END\n")
+	case config.FormatServerV1:
+		fmt.Fprintf(&buf, "OpenVPN CLIENT LIST\nUpdated,%s\n", now.Format("2006-01-02 15:04:05"))
+		buf.WriteString("Common Name,Real Address,Bytes Received,Bytes Sent,Connected Since\n")
+		for _, c := range s.clients {
+			fmt.Fprintf(&buf, "%s,%s,%d,%d,%s\n", c.commonName, c.realAddress, c.bytesReceived, c.bytesSent, c.connectedSince.Format("2006-01-02 15:04:05"))
+		}
+		buf.WriteString("ROUTING TABLE\nVirtual Address,Common Name,Real Address,Last Ref\n")
+		for i := 0; i < s.routes && len(s.clients) > 0; i++ {
+			c := s.clients[i%len(s.clients)]
+			fmt.Fprintf(&buf, "%s,%s,%s,%s\n", s.routeAddress(c, i), c.commonName, c.realAddress, now.Add(-time.Duration(s.rng.Intn(600))*time.Second).Format("2006-01-02 15:04:05"))
+		}
+		buf.WriteString("GLOBAL STATS\nMax bcast/mcast queue length,0\nEND\n")
+	default:
+		sep := ","
+		if s.format == config.FormatServerV3 {
+			sep = "\t"
+		}
+		line := func(fields ...interface{}) {
+			strs := make([]string, len(fields))
+			for i, field := range fields {
+				strs[i] = fmt.Sprint(field)
+			}
+			buf.WriteString(strings.Join(strs, sep) + "\n")
+		}
+		line("TITLE", "OpenVPN 2.6.8 x86_64-pc-linux-gnu [SSL (OpenSSL)] [LZO] [LZ4] [EPOLL] [MH/PKTINFO] [AEAD] [DCO]")
+		line("TIME", now.Format("2006-01-02 15:04:05"), now.Unix())
+		line("HEADER", "CLIENT_LIST", "Common Name", "Real Address", "Virtual Address", "Virtual IPv6 Address", "Bytes Received", "Bytes Sent", "Connected Since", "Connected Since (time_t)", "Username", "Client ID", "Peer ID", "Data Channel Cipher")
+		for _, c := range s.clients {
+			line("CLIENT_LIST", c.commonName, c.realAddress, c.virtualAddress, "", c.bytesReceived, c.bytesSent, c.connectedSince.Format("2006-01-02 15:04:05"), c.connectedSince.Unix(), "UNDEF", c.clientID, c.clientID, "AES-256-GCM")
+		}
+		line("HEADER", "ROUTING_TABLE", "Virtual Address", "Common Name", "Real Address", "Last Ref", "Last Ref (time_t)")
+		for i := 0; i < s.routes && len(s.clients) > 0; i++ {
+			c := s.clients[i%len(s.clients)]
+			lastRef := now.Add(-time.Duration(s.rng.Intn(600)) * time.Second)
+			line("ROUTING_TABLE", s.routeAddress(c, i), c.commonName, c.realAddress, lastRef.Format("2006-01-02 15:04:05"), lastRef.Unix())
+		}
+		line("GLOBAL_STATS", "Max bcast/mcast queue length", 0)
+		line("END")
+	}
+	return buf.Bytes()
+}
+
+// Returns the address of the i-th route. The first route of every client
+// is its virtual address, while additional routes are iroute subnets.
+func (s *simulation) routeAddress(c *simulatedClient, i int) string {
+	if i < len(s.clients) {
+		return c.virtualAddress
+	}
+	n := i - len(s.clients)
+	return fmt.Sprintf("172.%d.%d.0/24", 16+(n/256)%16, n%256)
+}
@@ -0,0 +1,177 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sources
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kumina/openvpn_exporter/config"
+)
+
+// Keys of the metadata of Consul services pointing at the status of an
+// OpenVPN server.
+const (
+	// Any status path, such as a URL of the status file.
+	ConsulMetaStatusPath = "openvpn_status_path"
+	// Address of the management interface, host:port.
+	ConsulMetaManagement = "openvpn_management"
+)
+
+// ConsulSource stands for the instances of a service registered in the
+// Consul catalog, of which the passing ones are collected as separate
+// sources. The source of an instance is given by its metadata, either a
+// status path (openvpn_status_path) or the address of the management
+// interface (openvpn_management). Without either, the address and port of
+// the service are taken to be those of the management interface.
+//
+// Sources of instances are labeled with the Consul node as "consul_node",
+// and with the tags of the service, joined by commas and enclosed in
+// commas, as "consul_tags". The catalog is queried again once the
+// discovery interval passed.
+type ConsulSource struct {
+	name string
+	// Consul HTTP API used to query the service, such as
+	// http://localhost:8500/v1/health/service/openvpn?passing=true.
+	API HTTPSource
+	// Options of the sources of the instances, such as their credentials.
+	template config.StatusPath
+	cache    discoveryCache
+}
+
+// Creates the source of a Consul service given by a status path of the form
+// consul://host:port/service?tag=...&dc=....
+func newConsulSource(sp config.StatusPath, u *url.URL) (*ConsulSource, error) {
+	service := strings.Trim(u.Path, "/")
+	if service == "" || strings.Contains(service, "/") {
+		return nil, fmt.Errorf("no Consul service in status path %q", sp.Path)
+	}
+	api := url.URL{Scheme: "http", Host: u.Host, Path: path.Join("/v1/health/service", service)}
+	if u.Scheme == "consul+https" {
+		api.Scheme = "https"
+	}
+	query := u.Query()
+	query.Set("passing", "true")
+	api.RawQuery = query.Encode()
+
+	labels := map[string]string{"consul_node": "", "consul_tags": ""}
+	for name, value := range sp.Labels {
+		labels[name] = value
+	}
+	// The ACL token is only sent to Consul.
+	template := sp
+	template.BearerToken = ""
+	return &ConsulSource{
+		name:     sp.Path,
+		API:      HTTPSource{URL: api.String(), BearerToken: sp.BearerToken, labels: labels},
+		template: template,
+		cache:    newDiscoveryCache(time.Duration(sp.DiscoveryInterval)),
+	}, nil
+}
+
+func (s *ConsulSource) Expand(ctx context.Context) ([]StatusSource, error) {
+	return s.cache.get(ctx, s.query)
+}
+
+// An entry of the health endpoint of the Consul API.
+type consulServiceEntry struct {
+	Node struct {
+		Node    string
+		Address string
+	}
+	Service struct {
+		Address string
+		Port    int
+		Tags    []string
+		Meta    map[string]string
+	}
+}
+
+// Queries the passing instances of the service, returning their sources.
+func (s *ConsulSource) query(ctx context.Context) ([]StatusSource, error) {
+	body, err := s.API.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	var entries []consulServiceEntry
+	if err := json.NewDecoder(body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("decoding Consul services: %w", err)
+	}
+
+	var sources []StatusSource
+	seen := map[string]bool{}
+	for _, entry := range entries {
+		sp := s.template
+		switch {
+		case entry.Service.Meta[ConsulMetaStatusPath] != "":
+			sp.Path = entry.Service.Meta[ConsulMetaStatusPath]
+		case entry.Service.Meta[ConsulMetaManagement] != "":
+			sp.Path = "tcp://" + entry.Service.Meta[ConsulMetaManagement]
+		default:
+			address := entry.Service.Address
+			if address == "" {
+				address = entry.Node.Address
+			}
+			sp.Path = "tcp://" + net.JoinHostPort(address, strconv.Itoa(entry.Service.Port))
+		}
+		// Instances registered multiple times are only collected once.
+		if seen[sp.Path] {
+			continue
+		}
+		seen[sp.Path] = true
+
+		tags := append([]string(nil), entry.Service.Tags...)
+		sort.Strings(tags)
+		sp.Labels = map[string]string{
+			"consul_node": entry.Node.Node,
+			"consul_tags": "," + strings.Join(tags, ",") + ",",
+		}
+		if len(tags) == 0 {
+			sp.Labels["consul_tags"] = ""
+		}
+		for name, value := range s.template.Labels {
+			sp.Labels[name] = value
+		}
+		source, err := New(sp)
+		if err != nil {
+			return nil, fmt.Errorf("Consul service on node %q: %w", entry.Node.Node, err)
+		}
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
+
+// Open reads the source of the only instance of the service, failing
+// unless there is exactly one.
+func (s *ConsulSource) Open(ctx context.Context) (io.ReadCloser, error) {
+	return openSingle(ctx, s, fmt.Sprintf("Consul service %q", s.name))
+}
+
+func (s *ConsulSource) Name() string {
+	return s.name
+}
+
+func (s *ConsulSource) Labels() map[string]string {
+	return s.API.labels
+}
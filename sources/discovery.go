@@ -0,0 +1,79 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sources
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Interval at which sources are discovered again, unless configured
+// otherwise.
+const defaultDiscoveryInterval = time.Minute
+
+// Caches the sources found by a discovery mechanism, such as DNS or
+// Consul, for an interval. If discovery fails, the sources of the
+// previous discovery are kept.
+type discoveryCache struct {
+	interval time.Duration
+
+	mu         sync.Mutex
+	sources    []StatusSource
+	discovered time.Time
+}
+
+func newDiscoveryCache(interval time.Duration) discoveryCache {
+	if interval == 0 {
+		interval = defaultDiscoveryInterval
+	}
+	return discoveryCache{interval: interval}
+}
+
+// Returns the cached sources, discovering them anew once the interval
+// passed.
+func (c *discoveryCache) get(ctx context.Context, discover func(ctx context.Context) ([]StatusSource, error)) ([]StatusSource, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sources != nil && time.Since(c.discovered) < c.interval {
+		return c.sources, nil
+	}
+	sources, err := discover(ctx)
+	if err != nil {
+		if c.sources != nil {
+			return c.sources, nil
+		}
+		return nil, err
+	}
+	if sources == nil {
+		sources = []StatusSource{}
+	}
+	c.sources, c.discovered = sources, time.Now()
+	return sources, nil
+}
+
+// Opens the only source found by an expander, failing unless there is
+// exactly one.
+func openSingle(ctx context.Context, e Expander, what string) (io.ReadCloser, error) {
+	sources, err := e.Expand(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(sources) != 1 {
+		return nil, fmt.Errorf("%s has %d sources instead of one", what, len(sources))
+	}
+	return sources[0].Open(ctx)
+}
@@ -0,0 +1,89 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sources
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"syscall"
+)
+
+// TransientError marks an error of a source as likely to go away when
+// reading the source again shortly after, such as an overloaded server.
+type TransientError struct {
+	Err error
+}
+
+func (e *TransientError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TransientError) Unwrap() error {
+	return e.Err
+}
+
+// Error of an unexpected HTTP response. Missing resources and denied
+// access match fs.ErrNotExist and fs.ErrPermission, like the errors of
+// local files.
+type httpStatusError struct {
+	code int
+	err  error
+}
+
+func (e *httpStatusError) Error() string {
+	return e.err.Error()
+}
+
+func (e *httpStatusError) Unwrap() error {
+	return e.err
+}
+
+func (e *httpStatusError) Is(target error) bool {
+	switch target {
+	case fs.ErrNotExist:
+		return e.code == http.StatusNotFound
+	case fs.ErrPermission:
+		return e.code == http.StatusUnauthorized || e.code == http.StatusForbidden
+	}
+	return false
+}
+
+// IsTransient returns whether reading a source again may succeed where it
+// failed with the given error. This is the case for errors marked as
+// TransientError, interrupted connections, timeouts of individual
+// operations and sources that ended unexpectedly, such as a status file
+// that is being rewritten. Other errors, such as missing files or denied
+// permissions, are permanent.
+func IsTransient(err error) bool {
+	var te *TransientError
+	if errors.As(err, &te) {
+		return true
+	}
+	switch {
+	case errors.Is(err, syscall.EAGAIN),
+		errors.Is(err, syscall.EINTR),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.ECONNABORTED),
+		errors.Is(err, syscall.EPIPE),
+		errors.Is(err, syscall.ESTALE),
+		errors.Is(err, io.ErrUnexpectedEOF):
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
@@ -0,0 +1,90 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sources
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// ExecSource obtains the status from the standard output of a command.
+type ExecSource struct {
+	Command []string
+	name    string
+	labels  map[string]string
+}
+
+func (s *ExecSource) Open(ctx context.Context) (io.ReadCloser, error) {
+	return runCommand(ctx, s.Command)
+}
+
+func (s *ExecSource) Name() string {
+	return s.name
+}
+
+func (s *ExecSource) Labels() map[string]string {
+	return s.labels
+}
+
+// SSHSource reads a status file on a remote host, using the ssh command.
+// Authentication is left to ssh, so keys, agents and ~/.ssh/config are
+// honored.
+type SSHSource struct {
+	Host         string
+	User         string
+	Path         string
+	IdentityFile string
+	name         string
+	labels       map[string]string
+}
+
+func (s *SSHSource) Open(ctx context.Context) (io.ReadCloser, error) {
+	args := []string{"ssh", "-o", "BatchMode=yes"}
+	if s.IdentityFile != "" {
+		args = append(args, "-i", s.IdentityFile)
+	}
+	host := s.Host
+	if i := strings.LastIndex(host, ":"); i > 0 && !strings.HasSuffix(host, "]") {
+		args = append(args, "-p", host[i+1:])
+		host = host[:i]
+	}
+	if s.User != "" {
+		host = s.User + "@" + host
+	}
+	return runCommand(ctx, append(args, host, "cat", s.Path))
+}
+
+func (s *SSHSource) Name() string {
+	return s.name
+}
+
+func (s *SSHSource) Labels() map[string]string {
+	return s.labels
+}
+
+// Runs a command to completion, returning its standard output.
+func runCommand(ctx context.Context, args []string) (io.ReadCloser, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("running %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return io.NopCloser(&stdout), nil
+}
@@ -0,0 +1,122 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sources
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FileSource reads a status file from the local file system.
+type FileSource struct {
+	Path string
+	// Whether to take a shared advisory lock (flock) on the file while
+	// reading it, waiting for writers holding an exclusive lock.
+	Lock   bool
+	labels map[string]string
+}
+
+// NewFileSource creates a source for a local status file.
+func NewFileSource(path string, labels map[string]string) *FileSource {
+	return &FileSource{Path: path, labels: labels}
+}
+
+func (s *FileSource) Open(ctx context.Context) (io.ReadCloser, error) {
+	return openFile(ctx, s.Path, s.Lock)
+}
+
+// Opens a file, locking it if requested.
+func openFile(ctx context.Context, path string, lock bool) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if lock {
+		if err := lockFile(ctx, f); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// Version returns the modification time and size of the file.
+func (s *FileSource) Version(ctx context.Context) (string, time.Time, error) {
+	info, err := os.Stat(s.Path)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return fmt.Sprintf("%d-%d", info.ModTime().UnixNano(), info.Size()), info.ModTime(), nil
+}
+
+func (s *FileSource) Name() string {
+	return s.Path
+}
+
+func (s *FileSource) Labels() map[string]string {
+	return s.labels
+}
+
+// GlobSource stands for all local status files matching a pattern, such
+// as /run/openvpn/*.status. Each file is collected as a separate source.
+type GlobSource struct {
+	Pattern string
+	// Whether to lock the matching files while reading them. See
+	// FileSource.
+	Lock   bool
+	labels map[string]string
+}
+
+func (s *GlobSource) Expand(ctx context.Context) ([]StatusSource, error) {
+	matches, err := filepath.Glob(s.Pattern)
+	if err != nil {
+		return nil, err
+	}
+	var sources []StatusSource
+	for _, match := range matches {
+		sources = append(sources, &FileSource{Path: match, Lock: s.Lock, labels: s.labels})
+	}
+	return sources, nil
+}
+
+// Open opens the file matching the pattern, failing unless there is
+// exactly one.
+func (s *GlobSource) Open(ctx context.Context) (io.ReadCloser, error) {
+	matches, err := filepath.Glob(s.Pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) != 1 {
+		return nil, fmt.Errorf("pattern %q matches %d files instead of one", s.Pattern, len(matches))
+	}
+	return openFile(ctx, matches[0], s.Lock)
+}
+
+func (s *GlobSource) Name() string {
+	return s.Pattern
+}
+
+func (s *GlobSource) Labels() map[string]string {
+	return s.labels
+}
+
+// Returns whether a path contains glob metacharacters.
+func isGlob(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
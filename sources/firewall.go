@@ -0,0 +1,210 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sources
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Kinds of firewalls supported by FirewallSource.
+const (
+	FirewallPfSense  = "pfsense"
+	FirewallOPNsense = "opnsense"
+)
+
+// API endpoints listing the OpenVPN sessions of firewalls, relative to
+// their base URL.
+var firewallAPIPaths = map[string]string{
+	// Provided by the pfSense REST API package.
+	FirewallPfSense:  "/api/v2/status/openvpn/servers",
+	FirewallOPNsense: "/api/openvpn/service/search_sessions",
+}
+
+// FirewallSource fetches the OpenVPN sessions of a pfSense or OPNsense
+// firewall through its HTTPS API, allowing appliances to be monitored
+// without shell access. The sessions are presented as a status file of
+// version 3.
+type FirewallSource struct {
+	// Kind of firewall, FirewallPfSense or FirewallOPNsense.
+	Kind string
+	// API is used to fetch the sessions. Its URL is that of the API
+	// endpoint.
+	API  HTTPSource
+	name string
+}
+
+func (s *FirewallSource) Open(ctx context.Context) (io.ReadCloser, error) {
+	body, err := s.API.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	var clients []sessionClient
+	var routes []sessionRoute
+	switch s.Kind {
+	case FirewallPfSense:
+		clients, routes, err = decodePfSenseSessions(body)
+	case FirewallOPNsense:
+		clients, err = decodeOPNsenseSessions(body)
+	default:
+		err = fmt.Errorf("unsupported firewall %q", s.Kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(sessionStatus(s.Kind+" API", time.Now(), clients, routes))), nil
+}
+
+func (s *FirewallSource) Name() string {
+	return s.name
+}
+
+func (s *FirewallSource) Labels() map[string]string {
+	return s.API.labels
+}
+
+// A JSON value that may be a string or a number, as firewalls aren't
+// consistent in the types of the values they return.
+type jsonValue string
+
+func (v *jsonValue) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*v = ""
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*v = jsonValue(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return err
+	}
+	*v = jsonValue(n)
+	return nil
+}
+
+// Decodes the response of the pfSense REST API, which lists the clients
+// and routes of every server.
+func decodePfSenseSessions(r io.Reader) ([]sessionClient, []sessionRoute, error) {
+	var response struct {
+		Data []struct {
+			Conns []struct {
+				CommonName      jsonValue `json:"common_name"`
+				RemoteHost      jsonValue `json:"remote_host"`
+				VirtualAddr     jsonValue `json:"virtual_addr"`
+				VirtualAddr6    jsonValue `json:"virtual_addr6"`
+				BytesRecv       jsonValue `json:"bytes_recv"`
+				BytesSent       jsonValue `json:"bytes_sent"`
+				ConnectTime     jsonValue `json:"connect_time"`
+				ConnectTimeUnix jsonValue `json:"connect_time_unix"`
+				UserName        jsonValue `json:"user_name"`
+				ClientID        jsonValue `json:"client_id"`
+				PeerID          jsonValue `json:"peer_id"`
+				Cipher          jsonValue `json:"cipher"`
+			} `json:"conns"`
+			Routes []struct {
+				CommonName  jsonValue `json:"common_name"`
+				RemoteHost  jsonValue `json:"remote_host"`
+				VirtualAddr jsonValue `json:"virtual_addr"`
+				LastTime    jsonValue `json:"last_time"`
+			} `json:"routes"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(r).Decode(&response); err != nil {
+		return nil, nil, fmt.Errorf("decoding pfSense response: %w", err)
+	}
+	var clients []sessionClient
+	var routes []sessionRoute
+	for _, server := range response.Data {
+		for _, conn := range server.Conns {
+			clients = append(clients, sessionClient{
+				CommonName:         string(conn.CommonName),
+				RealAddress:        string(conn.RemoteHost),
+				VirtualAddress:     string(conn.VirtualAddr),
+				VirtualIPv6Address: string(conn.VirtualAddr6),
+				BytesReceived:      string(conn.BytesRecv),
+				BytesSent:          string(conn.BytesSent),
+				ConnectedSince:     string(conn.ConnectTime),
+				ConnectedSinceUnix: string(conn.ConnectTimeUnix),
+				Username:           string(conn.UserName),
+				ClientID:           string(conn.ClientID),
+				PeerID:             string(conn.PeerID),
+				Cipher:             string(conn.Cipher),
+			})
+		}
+		for _, route := range server.Routes {
+			routes = append(routes, sessionRoute{
+				VirtualAddress: string(route.VirtualAddr),
+				CommonName:     string(route.CommonName),
+				RealAddress:    string(route.RemoteHost),
+				LastRef:        string(route.LastTime),
+			})
+		}
+	}
+	return clients, routes, nil
+}
+
+// Decodes the response of the OPNsense API, which lists the clients of
+// all servers, along with the servers themselves. OPNsense doesn't
+// provide routing tables.
+func decodeOPNsenseSessions(r io.Reader) ([]sessionClient, error) {
+	var response struct {
+		Rows []struct {
+			CommonName         jsonValue `json:"common_name"`
+			RealAddress        jsonValue `json:"real_address"`
+			VirtualAddress     jsonValue `json:"virtual_address"`
+			VirtualIPv6Address jsonValue `json:"virtual_ipv6_address"`
+			BytesReceived      jsonValue `json:"bytes_received"`
+			BytesSent          jsonValue `json:"bytes_sent"`
+			ConnectedSince     jsonValue `json:"connected_since"`
+			ConnectedSinceUnix jsonValue `json:"connected_since__time_t_"`
+			Username           jsonValue `json:"username"`
+			ClientID           jsonValue `json:"client_id"`
+			PeerID             jsonValue `json:"peer_id"`
+			Cipher             jsonValue `json:"data_channel_cipher"`
+		} `json:"rows"`
+	}
+	if err := json.NewDecoder(r).Decode(&response); err != nil {
+		return nil, fmt.Errorf("decoding OPNsense response: %w", err)
+	}
+	var clients []sessionClient
+	for _, row := range response.Rows {
+		// Rows of servers have no common name.
+		if row.CommonName == "" {
+			continue
+		}
+		clients = append(clients, sessionClient{
+			CommonName:         string(row.CommonName),
+			RealAddress:        string(row.RealAddress),
+			VirtualAddress:     string(row.VirtualAddress),
+			VirtualIPv6Address: string(row.VirtualIPv6Address),
+			BytesReceived:      string(row.BytesReceived),
+			BytesSent:          string(row.BytesSent),
+			ConnectedSince:     string(row.ConnectedSince),
+			ConnectedSinceUnix: string(row.ConnectedSinceUnix),
+			Username:           string(row.Username),
+			ClientID:           string(row.ClientID),
+			PeerID:             string(row.PeerID),
+			Cipher:             string(row.Cipher),
+		})
+	}
+	return clients, nil
+}
@@ -0,0 +1,82 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sources
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/kumina/openvpn_exporter/config"
+)
+
+// HTTPSource fetches a status file over HTTP, e.g. from a web server on
+// the VPN host that serves its status directory.
+type HTTPSource struct {
+	URL         string
+	Username    string
+	Password    config.Secret
+	BearerToken config.Secret
+	Client      *http.Client
+	labels      map[string]string
+}
+
+func (s *HTTPSource) Open(ctx context.Context) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, s.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if !s.BearerToken.IsEmpty() {
+		token, err := s.BearerToken.Resolve()
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if s.Username != "" {
+		password, err := s.Password.Resolve()
+		if err != nil {
+			return nil, err
+		}
+		req.SetBasicAuth(s.Username, password)
+	}
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		err := &httpStatusError{code: resp.StatusCode, err: fmt.Errorf("unexpected HTTP status: %s", resp.Status)}
+		switch resp.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return nil, &TransientError{Err: err}
+		}
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *HTTPSource) Name() string {
+	return s.URL
+}
+
+func (s *HTTPSource) Labels() map[string]string {
+	return s.labels
+}
@@ -0,0 +1,167 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sources
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kumina/openvpn_exporter/config"
+)
+
+// ManagementSource obtains the status from OpenVPN's management interface
+// by issuing the "status 2" command, or "status 3" if StatusVersion is 3,
+// removing the need for OpenVPN to write a status file. The time at which
+// the daemon entered its current state, obtained with the "state" command,
+// is provided as its start time while it is connected.
+type ManagementSource struct {
+	Network       string
+	Address       string
+	Password      config.Secret
+	StatusVersion int
+	name          string
+	labels        map[string]string
+}
+
+func (s *ManagementSource) Open(ctx context.Context) (io.ReadCloser, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, s.Network, s.Address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// The interface either greets with a real-time notification or asks
+	// for a password, with a prompt that is not terminated by a newline.
+	reader := bufio.NewReader(conn)
+	prompt, err := reader.Peek(len(managementPasswordPrompt))
+	if err != nil {
+		return nil, fmt.Errorf("reading greeting: %w", err)
+	}
+	if string(prompt) == managementPasswordPrompt {
+		if s.Password.IsEmpty() {
+			return nil, errors.New("management interface asks for a password, but none is configured")
+		}
+		password, err := s.Password.Resolve()
+		if err != nil {
+			return nil, err
+		}
+		reader.Discard(len(prompt))
+		if _, err := fmt.Fprintf(conn, "%s\n", password); err != nil {
+			return nil, err
+		}
+	}
+	version := s.StatusVersion
+	if version == 0 {
+		version = 2
+	}
+	if _, err := fmt.Fprintf(conn, "status %d\n", version); err != nil {
+		return nil, err
+	}
+
+	// Skip the greeting and other real-time notifications, which start
+	// with ">", and read the status up to its END line.
+	var status bytes.Buffer
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("reading status: %w", err)
+		}
+		switch {
+		case strings.HasPrefix(line, ">"):
+			continue
+		case strings.HasPrefix(line, "SUCCESS: password is correct"):
+			continue
+		case strings.HasPrefix(line, "ERROR:"):
+			return nil, fmt.Errorf("management interface: %s", strings.TrimSpace(line))
+		}
+		status.WriteString(line)
+		if strings.TrimSpace(line) == "END" {
+			break
+		}
+	}
+
+	// The state is optional; a failure to obtain it leaves the start
+	// time unknown.
+	result := &managementStatus{ReadCloser: io.NopCloser(&status)}
+	if _, err := io.WriteString(conn, "state\n"); err == nil {
+		result.startTime = readManagementState(reader)
+	}
+	io.WriteString(conn, "quit\n")
+	return result, nil
+}
+
+// Prompt of management interfaces protected by a password.
+const managementPasswordPrompt = "ENTER PASSWORD:"
+
+// The status read from the management interface, along with the start
+// time of the daemon.
+type managementStatus struct {
+	io.ReadCloser
+	startTime time.Time
+}
+
+func (s *managementStatus) StartTime() time.Time {
+	return s.startTime
+}
+
+// Reads the response to the "state" command, returning the time at which
+// the daemon was connected. The response is a line like
+//
+//	1700000000,CONNECTED,SUCCESS,10.8.0.1,,,,
+//
+// followed by END. Servers enter the CONNECTED state once they completed
+// their initialization, and leave it when they restart.
+func readManagementState(reader *bufio.Reader) time.Time {
+	var startTime time.Time
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return time.Time{}
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, ">"):
+			continue
+		case strings.HasPrefix(line, "ERROR:"), line == "END":
+			return startTime
+		}
+		fields := strings.Split(line, ",")
+		if len(fields) < 2 || fields[1] != "CONNECTED" {
+			continue
+		}
+		if seconds, err := strconv.ParseInt(fields[0], 10, 64); err == nil {
+			startTime = time.Unix(seconds, 0)
+		}
+	}
+}
+
+func (s *ManagementSource) Name() string {
+	return s.name
+}
+
+func (s *ManagementSource) Labels() map[string]string {
+	return s.labels
+}
@@ -0,0 +1,340 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sources
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kumina/openvpn_exporter/config"
+)
+
+// RouterOSSource fetches the OpenVPN sessions of a MikroTik router through
+// the RouterOS API, allowing routers terminating OVPN tunnels to be
+// monitored like OpenVPN servers. The sessions are presented as a status
+// file of version 3.
+type RouterOSSource struct {
+	// Address of the API service, as host:port.
+	Address  string
+	TLS      bool
+	Username string
+	Password config.Secret
+	name     string
+	labels   map[string]string
+}
+
+// A sentence of the RouterOS API: a reply word such as "!re" or "!done",
+// followed by attributes.
+type routerOSSentence struct {
+	reply      string
+	attributes map[string]string
+}
+
+// A connection to the RouterOS API.
+type routerOSConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func (s *RouterOSSource) Open(ctx context.Context) (io.ReadCloser, error) {
+	var conn net.Conn
+	var err error
+	if s.TLS {
+		host, _, _ := net.SplitHostPort(s.Address)
+		dialer := tls.Dialer{Config: &tls.Config{ServerName: host}}
+		conn, err = dialer.DialContext(ctx, "tcp", s.Address)
+	} else {
+		var dialer net.Dialer
+		conn, err = dialer.DialContext(ctx, "tcp", s.Address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	c := &routerOSConn{conn: conn, reader: bufio.NewReader(conn)}
+
+	password, err := s.Password.Resolve()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.login(s.Username, password); err != nil {
+		return nil, err
+	}
+	active, err := c.run("/ppp/active/print", "?service=ovpn")
+	if err != nil {
+		return nil, err
+	}
+	interfaces, err := c.run("/interface/ovpn-server/print")
+	if err != nil {
+		return nil, err
+	}
+	stats, err := c.run("/interface/print", "=stats", "?type=ovpn-in")
+	if err != nil {
+		return nil, err
+	}
+
+	// Sessions are joined with their dynamic interfaces by user and
+	// remote address, and interfaces with their statistics by name.
+	type session struct{ user, address string }
+	interfaceNames := map[session]string{}
+	for _, i := range interfaces {
+		interfaceNames[session{i["user"], i["client-address"]}] = i["name"]
+	}
+	interfaceStats := map[string]map[string]string{}
+	for _, i := range stats {
+		interfaceStats[i["name"]] = i
+	}
+	now := time.Now()
+	var clients []sessionClient
+	for _, a := range active {
+		client := sessionClient{
+			CommonName:     a["name"],
+			RealAddress:    a["caller-id"],
+			VirtualAddress: a["address"],
+			Username:       a["name"],
+		}
+		client.Cipher, _, _ = strings.Cut(a["encoding"], "/")
+		if uptime, ok := parseRouterOSDuration(a["uptime"]); ok {
+			since := now.Add(-uptime).Truncate(time.Second)
+			client.ConnectedSince = since.Format("Mon Jan _2 15:04:05 2006")
+			client.ConnectedSinceUnix = strconv.FormatInt(since.Unix(), 10)
+		}
+		if i, ok := interfaceStats[interfaceNames[session{a["name"], a["caller-id"]}]]; ok {
+			client.BytesReceived = i["rx-byte"]
+			client.BytesSent = i["tx-byte"]
+		}
+		clients = append(clients, client)
+	}
+	return io.NopCloser(bytes.NewReader(sessionStatus("RouterOS API", now, clients, nil))), nil
+}
+
+func (s *RouterOSSource) Name() string {
+	return s.name
+}
+
+func (s *RouterOSSource) Labels() map[string]string {
+	return s.labels
+}
+
+// Logs in, using the challenge response method of RouterOS versions
+// before 6.43 if the router asks for it.
+func (c *routerOSConn) login(username, password string) error {
+	reply, err := c.call("/login", "=name="+username, "=password="+password)
+	if err != nil {
+		return fmt.Errorf("logging in: %w", err)
+	}
+	challenge, ok := reply.attributes["ret"]
+	if !ok {
+		return nil
+	}
+	salt, err := hex.DecodeString(challenge)
+	if err != nil {
+		return fmt.Errorf("logging in: invalid challenge %q", challenge)
+	}
+	hash := md5.Sum(append(append([]byte{0}, password...), salt...))
+	if _, err := c.call("/login", "=name="+username, "=response=00"+hex.EncodeToString(hash[:])); err != nil {
+		return fmt.Errorf("logging in: %w", err)
+	}
+	return nil
+}
+
+// Runs a command, returning the attributes of the items it replied with.
+func (c *routerOSConn) run(words ...string) ([]map[string]string, error) {
+	if err := c.write(words); err != nil {
+		return nil, err
+	}
+	var items []map[string]string
+	for {
+		sentence, err := c.read()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", words[0], err)
+		}
+		switch sentence.reply {
+		case "!re":
+			items = append(items, sentence.attributes)
+		case "!done", "!empty":
+			// Newer versions reply with !empty followed by !done
+			// when there are no items.
+			if sentence.reply == "!done" {
+				return items, nil
+			}
+		default:
+			return nil, fmt.Errorf("%s: %w", words[0], sentence.err())
+		}
+	}
+}
+
+// Runs a command that replies with a single sentence.
+func (c *routerOSConn) call(words ...string) (*routerOSSentence, error) {
+	if err := c.write(words); err != nil {
+		return nil, err
+	}
+	sentence, err := c.read()
+	if err != nil {
+		return nil, err
+	}
+	if sentence.reply != "!done" {
+		return nil, sentence.err()
+	}
+	return sentence, nil
+}
+
+func (s *routerOSSentence) err() error {
+	if message, ok := s.attributes["message"]; ok {
+		return fmt.Errorf("%s %s", s.reply, message)
+	}
+	return fmt.Errorf("unexpected reply %s", s.reply)
+}
+
+// Writes a sentence: each word prefixed by its length, followed by an
+// empty word.
+func (c *routerOSConn) write(words []string) error {
+	var buf bytes.Buffer
+	for _, word := range append(words, "") {
+		n := len(word)
+		switch {
+		case n < 0x80:
+			buf.WriteByte(byte(n))
+		case n < 0x4000:
+			buf.Write([]byte{byte(n>>8) | 0x80, byte(n)})
+		case n < 0x200000:
+			buf.Write([]byte{byte(n>>16) | 0xc0, byte(n >> 8), byte(n)})
+		case n < 0x10000000:
+			buf.Write([]byte{byte(n>>24) | 0xe0, byte(n >> 16), byte(n >> 8), byte(n)})
+		default:
+			buf.Write([]byte{0xf0, byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)})
+		}
+		buf.WriteString(word)
+	}
+	_, err := c.conn.Write(buf.Bytes())
+	return err
+}
+
+// Reads a sentence, skipping the tags and other words that aren't
+// attributes.
+func (c *routerOSConn) read() (*routerOSSentence, error) {
+	sentence := &routerOSSentence{attributes: map[string]string{}}
+	for {
+		word, err := c.readWord()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		switch {
+		case word == "":
+			if sentence.reply == "" {
+				return nil, errors.New("empty sentence")
+			}
+			return sentence, nil
+		case sentence.reply == "":
+			sentence.reply = word
+		case strings.HasPrefix(word, "="):
+			name, value, _ := strings.Cut(word[1:], "=")
+			sentence.attributes[name] = value
+		}
+	}
+}
+
+func (c *routerOSConn) readWord() (string, error) {
+	first, err := c.reader.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	// The number of leading one bits of the first byte gives the number
+	// of bytes following it that make up the length.
+	var n, extra int
+	switch {
+	case first&0x80 == 0:
+		n = int(first)
+	case first&0xc0 == 0x80:
+		n, extra = int(first&0x3f), 1
+	case first&0xe0 == 0xc0:
+		n, extra = int(first&0x1f), 2
+	case first&0xf0 == 0xe0:
+		n, extra = int(first&0x0f), 3
+	case first == 0xf0:
+		extra = 4
+	default:
+		return "", fmt.Errorf("invalid word length %#x", first)
+	}
+	for ; extra > 0; extra-- {
+		b, err := c.reader.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		n = n<<8 | int(b)
+	}
+	word := make([]byte, n)
+	if _, err := io.ReadFull(c.reader, word); err != nil {
+		return "", err
+	}
+	return string(word), nil
+}
+
+// Parses a duration as given by RouterOS, such as "1w2d3h4m5s" or, in
+// older versions, "2d03:04:05".
+func parseRouterOSDuration(value string) (time.Duration, bool) {
+	var d time.Duration
+	if value == "" {
+		return 0, false
+	}
+	if days, clock, ok := strings.Cut(value, "d"); ok && strings.Contains(clock, ":") {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, false
+		}
+		d, value = time.Duration(n)*24*time.Hour, clock
+	}
+	if parts := strings.Split(value, ":"); len(parts) == 3 {
+		for i, unit := range []time.Duration{time.Hour, time.Minute, time.Second} {
+			n, err := strconv.Atoi(parts[i])
+			if err != nil {
+				return 0, false
+			}
+			d += time.Duration(n) * unit
+		}
+		return d, true
+	}
+	units := map[byte]time.Duration{'w': 7 * 24 * time.Hour, 'd': 24 * time.Hour, 'h': time.Hour, 'm': time.Minute, 's': time.Second}
+	start := 0
+	for i := 0; i < len(value); i++ {
+		if value[i] >= '0' && value[i] <= '9' {
+			continue
+		}
+		unit, ok := units[value[i]]
+		n, err := strconv.Atoi(value[start:i])
+		if !ok || err != nil {
+			return 0, false
+		}
+		d += time.Duration(n) * unit
+		start = i + 1
+	}
+	return d, start == len(value)
+}
@@ -0,0 +1,329 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sources
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kumina/openvpn_exporter/config"
+)
+
+// SHA-256 hash of an empty request body.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3Source reads a status file stored as an object in Amazon S3 or a
+// compatible object storage, such as MinIO or Google Cloud Storage, for
+// servers that upload their status files to a central bucket.
+//
+// Requests are signed with AWS Signature Version 4, using the given
+// credentials or those of the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN environment variables. Without credentials, requests
+// are sent unsigned, as for public buckets.
+type S3Source struct {
+	name   string
+	Bucket string
+	Key    string
+	// Base URL of the object storage, such as https://minio:9000 or
+	// https://storage.googleapis.com, addressing buckets by path. Amazon
+	// S3 in the region is used if unset, addressing buckets by host name.
+	Endpoint string
+	Region   string
+	// Credentials: the access key ID and the secret access key, or HMAC
+	// key for Google Cloud Storage.
+	AccessKeyID     string
+	SecretAccessKey config.Secret
+	SessionToken    string
+	Client          *http.Client
+	labels          map[string]string
+}
+
+// Creates the source of a status path of the form
+// s3://bucket/key?region=...&endpoint=..., or the source of a glob pattern
+// if the key contains one.
+func newS3Source(sp config.StatusPath, u *url.URL) (StatusSource, error) {
+	key := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || key == "" {
+		return nil, fmt.Errorf("no bucket or key in status path %q", sp.Path)
+	}
+	query := u.Query()
+	s := &S3Source{
+		name:            sp.Path,
+		Bucket:          u.Host,
+		Key:             key,
+		Endpoint:        strings.TrimSuffix(query.Get("endpoint"), "/"),
+		Region:          query.Get("region"),
+		AccessKeyID:     sp.Username,
+		SecretAccessKey: sp.Password,
+		labels:          sp.Labels,
+	}
+	if s.Region == "" {
+		s.Region = os.Getenv("AWS_REGION")
+	}
+	if s.Region == "" {
+		s.Region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if s.Region == "" {
+		s.Region = "us-east-1"
+	}
+	if s.AccessKeyID == "" {
+		s.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		s.SecretAccessKey = config.Secret(os.Getenv("AWS_SECRET_ACCESS_KEY"))
+		s.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if isGlob(key) {
+		return &S3GlobSource{Pattern: key, template: *s}, nil
+	}
+	return s, nil
+}
+
+func (s *S3Source) Open(ctx context.Context) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, s.Key, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Version returns the ETag of the object, along with the time at which it
+// was last modified.
+func (s *S3Source) Version(ctx context.Context) (string, time.Time, error) {
+	resp, err := s.do(ctx, http.MethodHead, s.Key, nil)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	resp.Body.Close()
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return resp.Header.Get("ETag"), modTime, nil
+}
+
+func (s *S3Source) Name() string {
+	return s.name
+}
+
+func (s *S3Source) Labels() map[string]string {
+	return s.labels
+}
+
+// Sends a signed request for an object, or for the bucket if the key is
+// empty, failing unless it succeeds.
+func (s *S3Source) do(ctx context.Context, method string, key string, query url.Values) (*http.Response, error) {
+	var u *url.URL
+	if s.Endpoint == "" {
+		u = &url.URL{Scheme: "https", Host: fmt.Sprintf("%s.s3.%s.amazonaws.com", s.Bucket, s.Region), Path: "/" + key}
+	} else {
+		var err error
+		if u, err = url.Parse(s.Endpoint); err != nil {
+			return nil, fmt.Errorf("invalid S3 endpoint: %w", err)
+		}
+		u.Path = path.Join(u.Path, s.Bucket) + "/" + key
+	}
+	u.RawPath = s3EscapePath(u.Path)
+	u.RawQuery = s3CanonicalQuery(query)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if s.AccessKeyID != "" {
+		secret, err := s.SecretAccessKey.Resolve()
+		if err != nil {
+			return nil, err
+		}
+		s.sign(req, secret, time.Now().UTC())
+	}
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		err := &httpStatusError{code: resp.StatusCode, err: fmt.Errorf("unexpected HTTP status of s3://%s/%s: %s", s.Bucket, key, resp.Status)}
+		switch resp.StatusCode {
+		case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return nil, &TransientError{Err: err}
+		}
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Signs a request without body with AWS Signature Version 4.
+func (s *S3Source) sign(req *http.Request, secret string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(req.Header.Get(name))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		emptyPayloadHash,
+	}, "\n")
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, s.Region)
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(hash[:])}, "\n")
+
+	key := []byte("AWS4" + secret)
+	for _, part := range []string{date, s.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// Escapes a string as required by AWS Signature Version 4, leaving only
+// unreserved characters, and slashes if requested, unescaped.
+func s3Escape(s string, keepSlashes bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '.', c == '_', c == '~':
+			b.WriteByte(c)
+		case c == '/' && keepSlashes:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func s3EscapePath(p string) string {
+	return s3Escape(p, true)
+}
+
+// Returns the query parameters in canonical form, sorted by name.
+func s3CanonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var params []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			params = append(params, s3Escape(name, false)+"="+s3Escape(value, false))
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// S3GlobSource stands for all objects of a bucket of which the keys match
+// a pattern, such as s3://bucket/status/*.status. Each object is collected
+// as a separate source.
+type S3GlobSource struct {
+	Pattern  string
+	template S3Source
+}
+
+// The result of listing the objects of a bucket.
+type s3ListResult struct {
+	Contents []struct {
+		Key string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+func (s *S3GlobSource) Expand(ctx context.Context) ([]StatusSource, error) {
+	// Only objects starting with the part of the pattern before the
+	// first metacharacter are listed.
+	prefix := s.Pattern[:strings.IndexAny(s.Pattern, "*?[")]
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	var sources []StatusSource
+	for {
+		resp, err := s.template.do(ctx, http.MethodGet, "", query)
+		if err != nil {
+			return nil, err
+		}
+		var result s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("decoding objects of bucket %s: %w", s.template.Bucket, err)
+		}
+		for _, object := range result.Contents {
+			if ok, _ := path.Match(s.Pattern, object.Key); !ok {
+				continue
+			}
+			source := s.template
+			source.name = fmt.Sprintf("s3://%s/%s", source.Bucket, object.Key)
+			source.Key = object.Key
+			sources = append(sources, &source)
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return sources, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+// Open reads the object matching the pattern, failing unless there is
+// exactly one.
+func (s *S3GlobSource) Open(ctx context.Context) (io.ReadCloser, error) {
+	return openSingle(ctx, s, fmt.Sprintf("pattern %q", s.template.name))
+}
+
+func (s *S3GlobSource) Name() string {
+	return s.template.name
+}
+
+func (s *S3GlobSource) Labels() map[string]string {
+	return s.template.labels
+}
@@ -0,0 +1,120 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sources
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A client connected to a device that doesn't write status files itself,
+// and the routes to it.
+type sessionClient struct {
+	CommonName         string
+	RealAddress        string
+	VirtualAddress     string
+	VirtualIPv6Address string
+	BytesReceived      string
+	BytesSent          string
+	ConnectedSince     string
+	ConnectedSinceUnix string
+	Username           string
+	ClientID           string
+	PeerID             string
+	Cipher             string
+}
+
+type sessionRoute struct {
+	VirtualAddress string
+	CommonName     string
+	RealAddress    string
+	LastRef        string
+	LastRefUnix    string
+}
+
+// Writes the sessions of a device as a status file of version 3, so that
+// they can be read like those of OpenVPN itself.
+func sessionStatus(title string, now time.Time, clients []sessionClient, routes []sessionRoute) []byte {
+	var buf bytes.Buffer
+	line := func(fields ...string) {
+		for i, field := range fields {
+			if i > 0 {
+				buf.WriteByte('\t')
+			}
+			// Keep values from breaking the structure of the file,
+			// quoting those with separators or quotes as in CSV.
+			field = strings.Map(func(r rune) rune {
+				if r == '\n' || r == '\r' {
+					return ' '
+				}
+				return r
+			}, field)
+			if strings.ContainsAny(field, "\t\"") {
+				field = `"` + strings.ReplaceAll(field, `"`, `""`) + `"`
+			}
+			buf.WriteString(field)
+		}
+		buf.WriteByte('\n')
+	}
+	line("TITLE", title)
+	line("TIME", now.Format("Mon Jan _2 15:04:05 2006"), fmt.Sprint(now.Unix()))
+	line("HEADER", "CLIENT_LIST", "Common Name", "Real Address", "Virtual Address", "Virtual IPv6 Address",
+		"Bytes Received", "Bytes Sent", "Connected Since", "Connected Since (time_t)", "Username",
+		"Client ID", "Peer ID", "Data Channel Cipher")
+	for _, c := range clients {
+		line("CLIENT_LIST", c.CommonName, c.RealAddress, c.VirtualAddress, c.VirtualIPv6Address,
+			orZero(c.BytesReceived), orZero(c.BytesSent), c.ConnectedSince, c.ConnectedSinceUnix, c.Username,
+			c.ClientID, c.PeerID, c.Cipher)
+	}
+	line("HEADER", "ROUTING_TABLE", "Virtual Address", "Common Name", "Real Address", "Last Ref", "Last Ref (time_t)")
+	for _, r := range routes {
+		lastRefUnix := r.LastRefUnix
+		if lastRefUnix == "" {
+			var ok bool
+			if lastRefUnix, ok = unixTime(r.LastRef); !ok {
+				// The time of the last reference is what routes are
+				// exported for.
+				continue
+			}
+		}
+		line("ROUTING_TABLE", r.VirtualAddress, r.CommonName, r.RealAddress, r.LastRef, lastRefUnix)
+	}
+	line("END")
+	return buf.Bytes()
+}
+
+// Converts a time given by a firewall, either as a UNIX timestamp or in
+// one of the layouts used by OpenVPN, into a UNIX timestamp.
+func unixTime(value string) (string, bool) {
+	if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return value, true
+	}
+	for _, layout := range []string{"Mon Jan _2 15:04:05 2006", "2006-01-02 15:04:05"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return strconv.FormatInt(t.Unix(), 10), true
+		}
+	}
+	return "", false
+}
+
+// Returns "0" for missing counters.
+func orZero(value string) string {
+	if value == "" {
+		return "0"
+	}
+	return value
+}
@@ -0,0 +1,232 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sources provides access to OpenVPN status information, whether
+// it is stored in a local file, served over HTTP, stored on a remote host
+// or obtained from OpenVPN's management interface.
+package sources
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kumina/openvpn_exporter/config"
+)
+
+// StatusSource provides the contents of an OpenVPN status file.
+type StatusSource interface {
+	// Open returns a reader for the current status. The caller is
+	// responsible for closing it.
+	Open(ctx context.Context) (io.ReadCloser, error)
+	// Name identifies the source. It is used as the status_path label.
+	Name() string
+	// Labels returns static labels to attach to the source's metrics.
+	Labels() map[string]string
+}
+
+// Expander is implemented by sources that stand for a set of sources that
+// may change over time, such as glob patterns. Such sources are expanded
+// on every collection. Labels of the expanded sources override those of
+// the expander, for the label names that the expander has.
+type Expander interface {
+	Expand(ctx context.Context) ([]StatusSource, error)
+}
+
+// Versioner is implemented by sources that can tell whether their
+// contents changed without reading them, allowing the exporter to skip
+// parsing unchanged status files.
+type Versioner interface {
+	// Version returns a value that changes whenever the contents of the
+	// source change, along with the time of the last change.
+	Version(ctx context.Context) (string, time.Time, error)
+}
+
+// Starter is implemented by the readers returned by sources that know
+// when the OpenVPN daemon started, such as the management interface. The
+// start time is zero if it is unknown.
+type Starter interface {
+	StartTime() time.Time
+}
+
+// New creates the source for a configured status path. The kind of
+// source is selected based on the path:
+//
+//	/path/to/file, file:///path   file
+//	/path/to/*.status            glob of files
+//	http://..., https://...      HTTP
+//	ssh://user@host:port/path    file on a remote host, read through ssh
+//	exec:command args            standard output of a command
+//	tcp://host:port              management interface over TCP
+//	unix:///path/to/socket       management interface over a Unix socket
+//	pfsense+https://host         sessions of a pfSense firewall
+//	opnsense+https://host        sessions of an OPNsense firewall
+//	routeros://host:port         sessions of a MikroTik router
+//	routeros+tls://host:port     the same, over TLS
+//	srv+tcp://record             management interfaces of DNS SRV records
+//	srv+http://record/path       HTTP, at the targets of DNS SRV records
+//	consul://host:port/service   instances of a service in Consul
+//	s3://bucket/key              object in S3 or compatible storage
+//	s3://bucket/prefix/*.status  glob of objects
+//
+// Sources of firewalls and routers are labeled with their host name as
+// "firewall" or "router", unless the status path configures that label
+// itself.
+func New(sp config.StatusPath) (StatusSource, error) {
+	path := sp.Path
+	lock := sp.Lock != nil && *sp.Lock
+	switch {
+	case strings.HasPrefix(path, "exec:"):
+		args := strings.Fields(strings.TrimPrefix(path, "exec:"))
+		if len(args) == 0 {
+			return nil, fmt.Errorf("empty command in status path %q", path)
+		}
+		return &ExecSource{name: path, labels: sp.Labels, Command: args}, nil
+	case !strings.Contains(path, "://"):
+		if isGlob(path) {
+			return &GlobSource{Pattern: path, Lock: lock, labels: sp.Labels}, nil
+		}
+		// OpenVPN's management interface may listen on a socket instead
+		// of writing a status file.
+		if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			return &ManagementSource{name: path, Network: "unix", Address: path, Password: sp.Password, StatusVersion: 2, labels: sp.Labels}, nil
+		}
+		return &FileSource{Path: path, Lock: lock, labels: sp.Labels}, nil
+	}
+
+	u, err := url.Parse(path)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "file":
+		if isGlob(u.Path) {
+			return &GlobSource{Pattern: u.Path, Lock: lock, labels: sp.Labels}, nil
+		}
+		return &FileSource{Path: u.Path, Lock: lock, labels: sp.Labels}, nil
+	case "http", "https":
+		return &HTTPSource{
+			URL:         path,
+			Username:    sp.Username,
+			Password:    sp.Password,
+			BearerToken: sp.BearerToken,
+			labels:      sp.Labels,
+		}, nil
+	case "ssh":
+		return &SSHSource{
+			name:         path,
+			Host:         u.Host,
+			User:         u.User.Username(),
+			Path:         u.Path,
+			IdentityFile: sp.SSHIdentityFile,
+			labels:       sp.Labels,
+		}, nil
+	case FirewallPfSense + "+http", FirewallPfSense + "+https", FirewallOPNsense + "+http", FirewallOPNsense + "+https":
+		kind, scheme, _ := strings.Cut(u.Scheme, "+")
+		labels := map[string]string{"firewall": u.Hostname()}
+		for name, value := range sp.Labels {
+			labels[name] = value
+		}
+		base := *u
+		base.Scheme = scheme
+		return &FirewallSource{
+			Kind: kind,
+			API: HTTPSource{
+				URL:         strings.TrimSuffix(base.String(), "/") + firewallAPIPaths[kind],
+				Username:    sp.Username,
+				Password:    sp.Password,
+				BearerToken: sp.BearerToken,
+				labels:      labels,
+			},
+			name: path,
+		}, nil
+	case "routeros", "routeros+tls":
+		tls := u.Scheme == "routeros+tls"
+		address := u.Host
+		if u.Port() == "" {
+			port := "8728"
+			if tls {
+				port = "8729"
+			}
+			address = net.JoinHostPort(u.Hostname(), port)
+		}
+		labels := map[string]string{"router": u.Hostname()}
+		for name, value := range sp.Labels {
+			labels[name] = value
+		}
+		return &RouterOSSource{
+			name:     path,
+			Address:  address,
+			TLS:      tls,
+			Username: sp.Username,
+			Password: sp.Password,
+			labels:   labels,
+		}, nil
+	case "srv+tcp", "srv+http", "srv+https":
+		if u.Host == "" {
+			return nil, fmt.Errorf("no SRV record in status path %q", path)
+		}
+		return newSRVSource(sp, u), nil
+	case "consul", "consul+https":
+		return newConsulSource(sp, u)
+	case "s3":
+		return newS3Source(sp, u)
+	case "tcp", "unix":
+		address := u.Host
+		if u.Scheme == "unix" {
+			address = u.Path
+		}
+		version := 2
+		if v := u.Query().Get("status"); v != "" {
+			if version, err = strconv.Atoi(v); err != nil || (version != 2 && version != 3) {
+				return nil, fmt.Errorf("invalid status version %q in status path %q: expected 2 or 3", v, path)
+			}
+		}
+		return &ManagementSource{name: path, Network: u.Scheme, Address: address, Password: sp.Password, StatusVersion: version, labels: sp.Labels}, nil
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q in status path %q", u.Scheme, path)
+	}
+}
+
+// Type returns the kind of a source: "file" (including glob patterns),
+// "http" (including firewalls), "ssh", "exec" or "management" (including
+// routers). SRV records have the type of the sources of their targets,
+// while Consul services and S3 objects are of type "http".
+// Sources provided by other packages have type "custom".
+func Type(s StatusSource) string {
+	switch s := s.(type) {
+	case *FileSource, *GlobSource:
+		return "file"
+	case *HTTPSource, *FirewallSource, *ConsulSource, *S3Source, *S3GlobSource:
+		return "http"
+	case *SSHSource:
+		return "ssh"
+	case *ExecSource:
+		return "exec"
+	case *ManagementSource, *RouterOSSource:
+		return "management"
+	case *SRVSource:
+		if s.Scheme == "tcp" {
+			return "management"
+		}
+		return "http"
+	default:
+		return "custom"
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sources
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kumina/openvpn_exporter/config"
+)
+
+// SRVSource stands for the sources found in the DNS SRV records of a
+// name, such as _openvpn-mgmt._tcp.example.com, allowing servers to be
+// added and removed through DNS. Each target of the records is collected
+// as a separate source: the management interface at its host and port, or
+// a status file served over HTTP at its host and port.
+//
+// Records are looked up again once the discovery interval passed. If a
+// lookup fails, the sources of the previous lookup are kept.
+type SRVSource struct {
+	name string
+	// Name of which the SRV records are looked up.
+	Record string
+	// Kind of source at the targets, "tcp" for the management interface,
+	// or "http" or "https" for status files at Path.
+	Scheme string
+	Path   string
+	// Options of the sources of the targets, such as their credentials.
+	template config.StatusPath
+	cache    discoveryCache
+}
+
+// Creates the source of the SRV records of a status path of the form
+// srv+scheme://record/path.
+func newSRVSource(sp config.StatusPath, u *url.URL) *SRVSource {
+	return &SRVSource{
+		name:     sp.Path,
+		Record:   u.Host,
+		Scheme:   strings.TrimPrefix(u.Scheme, "srv+"),
+		Path:     u.RequestURI(),
+		template: sp,
+		cache:    newDiscoveryCache(time.Duration(sp.DiscoveryInterval)),
+	}
+}
+
+func (s *SRVSource) Expand(ctx context.Context) ([]StatusSource, error) {
+	return s.cache.get(ctx, s.lookup)
+}
+
+// Looks up the records, returning the sources of their targets.
+func (s *SRVSource) lookup(ctx context.Context) ([]StatusSource, error) {
+	_, records, err := net.DefaultResolver.LookupSRV(ctx, "", "", s.Record)
+	if err != nil {
+		return nil, err
+	}
+	var sources []StatusSource
+	for _, record := range records {
+		address := net.JoinHostPort(strings.TrimSuffix(record.Target, "."), strconv.Itoa(int(record.Port)))
+		sp := s.template
+		sp.Path = fmt.Sprintf("%s://%s", s.Scheme, address)
+		if s.Scheme != "tcp" {
+			sp.Path += s.Path
+		}
+		source, err := New(sp)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
+
+// Open reads the source of the only target of the records, failing unless
+// there is exactly one.
+func (s *SRVSource) Open(ctx context.Context) (io.ReadCloser, error) {
+	return openSingle(ctx, s, fmt.Sprintf("SRV record %q", s.Record))
+}
+
+func (s *SRVSource) Name() string {
+	return s.name
+}
+
+func (s *SRVSource) Labels() map[string]string {
+	return s.template.Labels
+}
@@ -0,0 +1,132 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package state persists the counters that the exporter derives itself,
+// such as those of RADIUS accounting, so that they survive restarts of
+// the exporter instead of resetting and breaking increase() and rate()
+// windows.
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Persistent is implemented by components of which the state is
+// persisted. The state is stored under the component's key as JSON.
+type Persistent interface {
+	StateKey() string
+	MarshalState() ([]byte, error)
+	UnmarshalState(data []byte) error
+}
+
+// Store holds the state of components in a JSON file.
+type Store struct {
+	path   string
+	logger *slog.Logger
+
+	mu         sync.Mutex
+	saved      map[string]json.RawMessage
+	components []Persistent
+}
+
+// Open opens the state file at the given path. A missing file holds no
+// state.
+func Open(path string, logger *slog.Logger) (*Store, error) {
+	s := &Store{path: path, logger: logger, saved: map[string]json.RawMessage{}}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.saved); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Register restores the saved state of a component, if any, and includes
+// the component in further saves. State that can't be restored is
+// logged and discarded, as the component then starts from scratch like
+// it would without a state file.
+func (s *Store) Register(p Persistent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if data, ok := s.saved[p.StateKey()]; ok {
+		if err := p.UnmarshalState(data); err != nil {
+			s.logger.Warn("Discarding saved state", "key", p.StateKey(), "err", err)
+		}
+	}
+	s.components = append(s.components, p)
+}
+
+// Save writes the state of all registered components. The file is
+// replaced atomically, so that a crash while saving leaves the previous
+// state. The saved state of components that weren't registered is kept.
+func (s *Store) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range s.components {
+		data, err := p.MarshalState()
+		if err != nil {
+			return fmt.Errorf("saving state of %s: %w", p.StateKey(), err)
+		}
+		s.saved[p.StateKey()] = data
+	}
+	data, err := json.Marshal(s.saved)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(s.path), "."+filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.path)
+}
+
+// Run saves the state at the given interval until the context is done.
+// Saving once more on shutdown is up to the caller.
+func (s *Store) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Save(); err != nil {
+				s.logger.Error("Failed to save state", "err", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,283 @@
+// Copyright 2017 Kumina, https://kumina.nl/
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/kumina/openvpn_exporter/config"
+	"github.com/kumina/openvpn_exporter/exporters"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// webAuth restricts access to the exporter's HTTP endpoints. Requests are
+// accepted if they carry the configured bearer token, or basic
+// authentication credentials whose password matches the configured
+// SHA-256 hash. If neither is configured, all requests are accepted.
+type webAuth struct {
+	bearerToken       string
+	basicUsername     string
+	basicPasswordHash []byte
+}
+
+func newWebAuth(bearerToken config.Secret, basicUsername string, basicPasswordHash config.Secret) (*webAuth, error) {
+	a := &webAuth{basicUsername: basicUsername}
+	if !bearerToken.IsEmpty() {
+		token, err := bearerToken.Resolve()
+		if err != nil {
+			return nil, err
+		}
+		a.bearerToken = token
+	}
+	if !basicPasswordHash.IsEmpty() {
+		hash, err := basicPasswordHash.Resolve()
+		if err != nil {
+			return nil, err
+		}
+		a.basicPasswordHash, err = hex.DecodeString(strings.TrimSpace(hash))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+func (a *webAuth) enabled() bool {
+	return a.bearerToken != "" || a.basicPasswordHash != nil
+}
+
+func (a *webAuth) authorized(r *http.Request) bool {
+	if a.bearerToken != "" {
+		if token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); token != r.Header.Get("Authorization") {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(a.bearerToken)) == 1 {
+				return true
+			}
+		}
+	}
+	if a.basicPasswordHash != nil {
+		if username, password, ok := r.BasicAuth(); ok && username == a.basicUsername {
+			hash := sha256.Sum256([]byte(password))
+			if subtle.ConstantTimeCompare(hash[:], a.basicPasswordHash) == 1 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Wraps a handler, rejecting requests that fail authentication.
+func (a *webAuth) handler(next http.Handler) http.Handler {
+	if !a.enabled() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.authorized(r) {
+			if a.basicPasswordHash != nil {
+				w.Header().Set("WWW-Authenticate", `Basic realm="OpenVPN Exporter"`)
+			}
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Labels that only carry information about individual connections,
+// omitted from requests with individuals=false.
+var individualLabels = []string{"connection_time", "real_address", "virtual_address"}
+
+// Serves the metrics of a gatherer. Requests may omit labels with the
+// omit_labels and individuals query parameters, allowing scrape jobs to
+// select the level of detail they need.
+func metricsHandler(gatherer prometheus.Gatherer) http.Handler {
+	handler := promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		omit, err := parseLabelSelection(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(omit) == 0 {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		promhttp.HandlerFor(labelSelection{gatherer, omit}, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	})
+}
+
+// Parses the labels to omit from the query parameters of a request.
+func parseLabelSelection(query url.Values) (map[string]bool, error) {
+	omit := map[string]bool{}
+	for _, value := range query["omit_labels"] {
+		for _, label := range strings.Split(value, ",") {
+			if label = strings.TrimSpace(label); label != "" {
+				omit[label] = true
+			}
+		}
+	}
+	if value := query.Get("individuals"); value != "" {
+		individuals, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid individuals %q: expected true or false", value)
+		}
+		if !individuals {
+			for _, label := range individualLabels {
+				omit[label] = true
+			}
+		}
+	}
+	return omit, nil
+}
+
+// Gathers metrics without the given labels. Metrics that become identical
+// are merged: counters and untyped metrics are summed, the maximum of
+// gauges is kept, and of summaries and histograms the first.
+type labelSelection struct {
+	prometheus.Gatherer
+	omit map[string]bool
+}
+
+func (s labelSelection) Gather() ([]*dto.MetricFamily, error) {
+	families, err := s.Gatherer.Gather()
+	for _, family := range families {
+		merged := map[string]*dto.Metric{}
+		var metrics []*dto.Metric
+		for _, metric := range family.Metric {
+			var labels []*dto.LabelPair
+			var key strings.Builder
+			for _, label := range metric.Label {
+				if s.omit[label.GetName()] {
+					continue
+				}
+				labels = append(labels, label)
+				key.WriteString(label.GetName())
+				key.WriteByte(0)
+				key.WriteString(label.GetValue())
+				key.WriteByte(0)
+			}
+			metric.Label = labels
+			first, ok := merged[key.String()]
+			if !ok {
+				merged[key.String()] = metric
+				metrics = append(metrics, metric)
+				continue
+			}
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				*first.Counter.Value += metric.Counter.GetValue()
+			case dto.MetricType_UNTYPED:
+				*first.Untyped.Value += metric.Untyped.GetValue()
+			case dto.MetricType_GAUGE:
+				if metric.Gauge.GetValue() > first.Gauge.GetValue() {
+					*first.Gauge.Value = metric.Gauge.GetValue()
+				}
+			}
+		}
+		family.Metric = metrics
+	}
+	return families, err
+}
+
+// Serves reports of how the status path given by the path query parameter
+// is turned into metrics by an exporter, for debugging missing metrics.
+// The reports include the raw contents of the status path.
+func debugStatusHandler(exporter interface {
+	Debug(ctx context.Context, statusPath string) (*exporters.DebugReport, error)
+}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		statusPath := r.URL.Query().Get("path")
+		if statusPath == "" {
+			http.Error(w, "missing path query parameter", http.StatusBadRequest)
+			return
+		}
+		report, err := exporter.Debug(r.Context(), statusPath)
+		if errors.Is(err, exporters.ErrUnknownStatusPath) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		writeDebugReport(w, report)
+	})
+}
+
+// Writes a debug report in a human readable form.
+func writeDebugReport(w io.Writer, report *exporters.DebugReport) {
+	fmt.Fprintf(w, "Status path: %s\n", report.StatusPath)
+	fmt.Fprintf(w, "Format: %s\n", report.Format)
+	fmt.Fprintf(w, "Metrics: %d\n", report.Metrics)
+	if report.Err != nil {
+		fmt.Fprintf(w, "Error: %v\n", report.Err)
+	}
+
+	for _, section := range report.Sections {
+		fmt.Fprintf(w, "\nSection %s\n", section.Name)
+		fmt.Fprintf(w, "  Columns: %s\n", strings.Join(section.Columns, ", "))
+		labels := make([]string, len(section.LabelNames))
+		for i, name := range section.LabelNames {
+			labels[i] = fmt.Sprintf("%s=%q", name, section.LabelColumns[i])
+		}
+		fmt.Fprintf(w, "  Labels: %s\n", strings.Join(labels, " "))
+		fmt.Fprintf(w, "  Metric columns: %s\n", strings.Join(section.MetricColumns, ", "))
+		if len(section.MissingColumns) > 0 {
+			fmt.Fprintf(w, "  Missing columns: %s\n", strings.Join(section.MissingColumns, ", "))
+		}
+	}
+
+	if len(report.Entries) > 0 {
+		fmt.Fprintf(w, "\nEntries:\n")
+	}
+	for _, entry := range report.Entries {
+		labels := make([]string, len(entry.LabelNames))
+		for i, name := range entry.LabelNames {
+			labels[i] = fmt.Sprintf("%s=%q", name, entry.LabelValues[i])
+		}
+		fmt.Fprintf(w, "  line %d %s %s: %s", entry.Line, entry.Type, entry.Outcome, strings.Join(labels, " "))
+		if entry.Err != nil {
+			fmt.Fprintf(w, " (%v)", entry.Err)
+		}
+		fmt.Fprintln(w)
+	}
+
+	if len(report.Quarantined) > 0 {
+		fmt.Fprintf(w, "\nQuarantined:\n")
+	}
+	for _, quarantined := range report.Quarantined {
+		fmt.Fprintf(w, "  %s\n", quarantined)
+	}
+
+	if len(report.Unsupported) > 0 {
+		fmt.Fprintf(w, "\nUnsupported keys:\n")
+	}
+	for _, unsupported := range report.Unsupported {
+		fmt.Fprintf(w, "  %s\n", unsupported)
+	}
+
+	fmt.Fprintf(w, "\nContents (%d bytes):\n", len(report.Contents))
+	w.Write(report.Contents)
+}